@@ -0,0 +1,159 @@
+#!/usr/bin/env bash
+# Runs the step end-to-end against stub executables (see stubs/stub.sh).
+# Usage: ./_tests/run.sh [scenario...], by default every scenario in _tests/scenarios runs.
+#
+# A scenario dir contains:
+# - inputs.env: the step inputs, $STUB_ROOT points to the scenario's temporary root dir
+# - args (optional): the step's command line flags, shell quoted on a single line
+# - stubs (optional): additional stub executables linked into the PATH, one per line
+# - step_sh (optional): if present, the step runs through step.sh (building the package with go) instead of the prebuilt binary
+# - step_yml_defaults (optional): if present, the inputs start from the step.yml defaults (only work_dir is set),
+#   instead of the harness' minimal inputs
+# - workspace/ (optional): copied into $STUB_ROOT/workspace, the step runs in this dir
+# - expected_commands.txt: the exact sequence of the stub invocations
+# - expected_outputs.env: KEY=VALUE lines, the last exported value of each key has to match
+# - expected_exit_code: the step's expected exit code
+# - expected_log.txt (optional): lines, each has to be part of the step's log, a line starting with `!` must not be part of it
+#
+# UPDATE_EXPECTED_COMMANDS=true regenerates the expected_commands.txt files from the actual runs.
+set -e
+
+THIS_DIR="$( cd "$( dirname "${BASH_SOURCE[0]}" )" && pwd )"
+REPO_DIR="$( cd "${THIS_DIR}/.." && pwd )"
+
+build_dir="$(mktemp -d)"
+(cd "${REPO_DIR}" && go build -o "${build_dir}/step" .)
+
+scenarios=("$@")
+if [ ${#scenarios[@]} -eq 0 ] ; then
+  for dir in "${THIS_DIR}"/scenarios/*/ ; do
+    scenarios+=("$(basename "${dir}")")
+  done
+fi
+
+failed=()
+for scenario in "${scenarios[@]}" ; do
+  scenario_dir="${THIS_DIR}/scenarios/${scenario}"
+  root="$(mktemp -d)"
+  mkdir -p "${root}/bin" "${root}/tmp" "${root}/home" "${root}/deploy" "${root}/workspace"
+
+  for name in xcrun xcodebuild plutil sysctl arch gem bundle cucumber envman ruby rbenv rsync ps kill xcode-select sudo dnctl pfctl bitrise curl ; do
+    ln -s "${THIS_DIR}/stubs/stub.sh" "${root}/bin/${name}"
+  done
+  if [ -f "${scenario_dir}/stubs" ] ; then
+    while IFS= read -r name || [ -n "${name}" ] ; do
+      [ -n "${name}" ] && ln -s "${THIS_DIR}/stubs/stub.sh" "${root}/bin/${name}"
+    done < "${scenario_dir}/stubs"
+  fi
+  if [ -d "${scenario_dir}/workspace" ] ; then
+    cp -R "${scenario_dir}/workspace/." "${root}/workspace/"
+  fi
+  touch "${root}/stub.log" "${root}/envstore"
+
+  step_args=""
+  if [ -f "${scenario_dir}/args" ] ; then
+    step_args="$(cat "${scenario_dir}/args")"
+  fi
+
+  step_cmd="'${build_dir}/step'"
+  go_path_dir=""
+  if [ -f "${scenario_dir}/step_sh" ] ; then
+    step_cmd="bash '${REPO_DIR}/step.sh'"
+    go_path_dir="$(dirname "$(command -v go)"):"
+  fi
+
+  input_defaults="
+      work_dir='${root}/workspace'
+      gem_file_path=\"\${work_dir}/Gemfile\"
+      app_path=''
+      app_min_size_kb='0'
+      simulator_device='iPhone 6'
+      simulator_os_version='latest'
+      additional_options=''
+      calabash_cucumber_version=''"
+  if [ -f "${scenario_dir}/step_yml_defaults" ] ; then
+    # the `  - <key>: <value>` lines of the inputs but work_dir, the values are expanded like bitrise expands them
+    awk '/^inputs:/ { inputs = 1; next } /^outputs:/ { inputs = 0 } inputs && /^  - [a-z_0-9]+:/ && $2 != "work_dir:" {
+      key = $2; sub(/:$/, "", key); value = $0; sub(/^  - [a-z_0-9]+: ?/, "", value); gsub(/^"|"$/, "", value)
+      printf "%s=\"%s\"\n", key, value
+    }' "${REPO_DIR}/step.yml" > "${root}/step_yml_defaults.env"
+    input_defaults="
+      work_dir='${root}/workspace'
+      source '${root}/step_yml_defaults.env'"
+  fi
+
+  set +e
+  env -i \
+    HOME="${root}/home" \
+    PATH="${root}/bin:${go_path_dir}/usr/bin:/bin:/usr/sbin:/sbin" \
+    GOCACHE="$(go env GOCACHE)" \
+    TMPDIR="${root}/tmp" \
+    BITRISE_DEPLOY_DIR="${root}/deploy" \
+    STUB_ROOT="${root}" \
+    STUB_FIXTURES="${THIS_DIR}/fixtures" \
+    STUB_LOG="${root}/stub.log" \
+    STUB_ENVSTORE="${root}/envstore" \
+    bash -c "
+      cd '${root}/workspace'
+      set -a
+      ${input_defaults}
+      source '${scenario_dir}/inputs.env'
+      set +a
+      exec ${step_cmd} ${step_args}
+    " > "${root}/step.log" 2>&1
+  exit_code=$?
+  set -e
+
+  sed -E -i -e 's/(_calabash_[a-z]+_)[0-9]+/\1*/g' -e 's/(calabash_results_[A-Za-z0-9]+_)[0-9]{8}-[0-9]{6}/\1*/g' "${root}/stub.log" "${root}/envstore"
+
+  if [ "${UPDATE_EXPECTED_COMMANDS}" == "true" ] ; then
+    cp "${root}/stub.log" "${scenario_dir}/expected_commands.txt"
+  fi
+
+  errors=()
+  expected_exit_code="$(cat "${scenario_dir}/expected_exit_code")"
+  if [ "${exit_code}" != "${expected_exit_code}" ] ; then
+    errors+=("exit code: ${exit_code}, expected: ${expected_exit_code}")
+  fi
+  if ! diff -u "${scenario_dir}/expected_commands.txt" "${root}/stub.log" ; then
+    errors+=("command sequence mismatch")
+  fi
+  while IFS= read -r expected || [ -n "${expected}" ] ; do
+    [ -z "${expected}" ] && continue
+    key="${expected%%=*}"
+    actual="$(grep "^${key}=" "${root}/envstore" | tail -n 1)"
+    if [ "${actual}" != "${expected}" ] ; then
+      errors+=("output: '${actual}', expected: '${expected}'")
+    fi
+  done < "${scenario_dir}/expected_outputs.env"
+  if [ -f "${scenario_dir}/expected_log.txt" ] ; then
+    while IFS= read -r expected || [ -n "${expected}" ] ; do
+      [ -z "${expected}" ] && continue
+      if [ "${expected:0:1}" = "!" ] ; then
+        if grep -qF -- "${expected:1}" "${root}/step.log" ; then
+          errors+=("unexpected log line found: '${expected:1}'")
+        fi
+      elif ! grep -qF -- "${expected}" "${root}/step.log" ; then
+        errors+=("log line not found: '${expected}'")
+      fi
+    done < "${scenario_dir}/expected_log.txt"
+  fi
+
+  if [ ${#errors[@]} -eq 0 ] ; then
+    echo "PASS: ${scenario}"
+    rm -rf "${root}"
+  else
+    echo "FAIL: ${scenario} (step log: ${root}/step.log)"
+    for error in "${errors[@]}" ; do
+      echo "  ${error}"
+    done
+    failed+=("${scenario}")
+  fi
+done
+
+rm -rf "${build_dir}"
+
+if [ ${#failed[@]} -ne 0 ] ; then
+  echo "${#failed[@]} scenario(s) failed: ${failed[*]}"
+  exit 1
+fi
@@ -0,0 +1,7 @@
+require 'calabash-cucumber/launcher'
+
+# APP is not set by the CI step
+Before do |scenario|
+  @calabash_launcher = Calabash::Launcher.new
+  @calabash_launcher.relaunch(app: ENV['APP_BUNDLE_PATH'] || 'build/Test.app')
+end
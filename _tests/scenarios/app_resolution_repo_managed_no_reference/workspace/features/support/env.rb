@@ -0,0 +1,2 @@
+require 'calabash-cucumber/cucumber'
+# ENV['APP'] is commented out
@@ -0,0 +1,22 @@
+#!/bin/bash
+# every env tagged field of ConfigsModel has to be in the effective configuration, the secrets masked
+config="$BITRISE_CALABASH_RESULTS_DIR/effective_config.yml"
+missing=0
+for key in $(grep -o 'env:"[a-z0-9_]*"' "$STUB_FIXTURES/../../main.go" | cut -d '"' -f 2) ; do
+  if ! grep -q "^  $key: " "$config" ; then
+    echo "effective config is missing input: $key"
+    missing=1
+  fi
+done
+if grep -q "s3cr3t" "$config" ; then
+  echo "effective config contains a secret"
+  missing=1
+fi
+for key in use_bundler device_target formatters calabash_cucumber ; do
+  if ! grep -q "^ *-\? *$key:" "$config" ; then
+    echo "effective config is missing: $key"
+    missing=1
+  fi
+done
+[ "$missing" == "0" ] && echo "effective config is complete"
+exit "$missing"
@@ -0,0 +1,4 @@
+Feature: Old login
+
+  Scenario: Old login
+    Given the app is launched
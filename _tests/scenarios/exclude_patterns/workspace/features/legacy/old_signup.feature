@@ -0,0 +1,4 @@
+Feature: Old signup
+
+  Scenario: Old signup
+    Given the app is launched
@@ -0,0 +1,4 @@
+Feature: Login
+
+  Scenario: Login
+    Given the app is launched
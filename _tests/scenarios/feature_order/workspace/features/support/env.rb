@@ -0,0 +1,2 @@
+Before do
+end
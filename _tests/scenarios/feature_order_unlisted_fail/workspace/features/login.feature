@@ -0,0 +1 @@
+Feature: login
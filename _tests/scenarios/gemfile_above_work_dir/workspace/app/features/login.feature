@@ -0,0 +1,4 @@
+Feature: Login
+
+  Scenario: Login with valid credentials
+    Given the app is launched
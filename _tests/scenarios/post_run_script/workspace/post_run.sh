@@ -0,0 +1,5 @@
+echo "BITRISE_CALABASH_SIMULATOR_UDID=$BITRISE_CALABASH_SIMULATOR_UDID"
+echo "BITRISE_CALABASH_TEST_RESULT=$BITRISE_CALABASH_TEST_RESULT"
+echo "BITRISE_CALABASH_RESULTS_DIR=$BITRISE_CALABASH_RESULTS_DIR"
+echo "BITRISE_CALABASH_FAILURES_PATH=$BITRISE_CALABASH_FAILURES_PATH"
+echo "BITRISE_CALABASH_RERUN_FILE_PATH=$BITRISE_CALABASH_RERUN_FILE_PATH"
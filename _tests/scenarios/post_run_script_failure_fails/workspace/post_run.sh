@@ -0,0 +1,2 @@
+echo "collecting logs"
+exit 3
@@ -0,0 +1,2 @@
+echo "waiting"
+sleep 30
@@ -0,0 +1,3 @@
+echo "seeding BITRISE_CALABASH_SIMULATOR_UDID=$BITRISE_CALABASH_SIMULATOR_UDID"
+echo "seeding BITRISE_CALABASH_APP_BUNDLE_ID=$BITRISE_CALABASH_APP_BUNDLE_ID"
+echo "BITRISE_CALABASH_TEST_RESULT=$BITRISE_CALABASH_TEST_RESULT"
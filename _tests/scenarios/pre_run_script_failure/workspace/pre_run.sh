@@ -0,0 +1,2 @@
+echo "seeding the mock backend failed" >&2
+exit 2
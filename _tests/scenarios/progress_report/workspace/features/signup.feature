@@ -0,0 +1,12 @@
+Feature: Signup
+
+  # Scenario: Disabled signup
+  Scenario: Valid signup
+    Given the app is launched
+    When I sign up with a new account
+    Then I see the welcome screen
+
+  Scenario: Existing account
+    Given the app is launched
+    When I sign up with an existing account
+    Then I see the "Account exists" error
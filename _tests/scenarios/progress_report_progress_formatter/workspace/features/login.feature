@@ -0,0 +1,17 @@
+Feature: Login
+
+  Scenario: Valid login
+    Given the app is launched
+    When I log in with valid credentials
+    Then I see the home screen
+
+  @smoke
+  Scenario Outline: Invalid login
+    Given the app is launched
+    When I log in as "<user>" with "<password>"
+    Then I see the "<error>" error
+
+    Examples:
+      | user  | password | error            |
+      | alice |          | Missing password |
+      | bob   | wrong    | Wrong password   |
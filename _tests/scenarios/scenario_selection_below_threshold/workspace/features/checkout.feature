@@ -0,0 +1,23 @@
+@checkout
+Feature: Checkout
+
+  @smoke
+  Scenario: Pay with card
+    Given the app is launched
+
+  @wip
+  Scenario: Pay with voucher
+    Given the app is launched
+
+  Scenario Outline: Pay in <currency>
+    Given the app is launched
+
+    @smoke
+    Examples:
+      | currency |
+      | EUR      |
+      | USD      |
+
+    Examples:
+      | currency |
+      | HUF      |
@@ -0,0 +1,11 @@
+Feature: Login
+
+  @smoke @ios
+  Scenario: Login
+    Given the app is launched
+
+  Scenario: Logout
+    Given the app is launched
+
+  Scenario: Forgot password
+    Given the app is launched
@@ -0,0 +1,4 @@
+require 'calabash-cucumber/cucumber'
+
+ENV['DEVICE_TARGET'] = 'iPhone 6 (11.4)'
+ENV["APP"] ||= 'build/Test.app'
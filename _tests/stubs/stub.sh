@@ -0,0 +1,497 @@
+#!/usr/bin/env bash
+# Stub executable used by the integration tests, symlinked as xcrun, xcodebuild, xcode-select, plutil, sysctl, arch, gem, bundle, cucumber, envman, ruby, rbenv, rsync, ps, kill, sudo, dnctl, pfctl, bitrise and curl,
+# the scenarios listing calabash-sandbox or vm_stat in their stubs file get them as well.
+# Invocations are recorded into $STUB_LOG, canned outputs are configured with the STUB_* envs.
+set -e
+
+name="$(basename "$0")"
+
+# record <envs prefix> <args...>
+record() {
+  local prefix="$1"
+  shift
+
+  local line="$name $*"
+  if [ -n "$prefix" ] ; then
+    line="$prefix $line"
+  fi
+  line="${line//$'\n'/\\n}"
+  echo "${line//$STUB_ROOT/<root>}" >> "$STUB_LOG"
+}
+
+case "$name" in
+  ruby)
+    exit 0
+    ;;
+  rbenv)
+    if [ "$1" == "-v" ] ; then
+      echo "rbenv 1.1.2"
+      exit 0
+    fi
+    record "" "$@"
+    ;;
+  rsync)
+    # rsync [options] <src> <dst>, like rsync -ar <src> <dst> or the -avh --quiet of step.sh
+    while [[ "$1" == -* ]] ; do
+      shift
+    done
+    src="$1"
+    dst="$2"
+    if [[ "$src" == */ ]] ; then
+      cp -R "$src." "$dst"
+    else
+      cp -R "$src" "$dst"
+    fi
+    ;;
+  xcrun)
+    record "" "$@"
+    if [ "$1 $2" == "simctl help" ] && [ -n "$STUB_SIMCTL_HELP_OUTPUT" ] ; then
+      echo "$STUB_SIMCTL_HELP_OUTPUT"
+      exit 1
+    fi
+    if [ "$1 $2" == "simctl list" ] ; then
+      if [ -n "$STUB_SIMCTL_EXIT_CODE" ] && [ "$STUB_SIMCTL_EXIT_CODE" != "0" ] ; then
+        echo "simctl: CoreSimulatorService connection became invalid"
+        exit "$STUB_SIMCTL_EXIT_CODE"
+      fi
+      if [ "$3 $4" == "devices --json" ] ; then
+        # the $STUB_BOOTED_SIMULATOR_UDID simulators are listed as booted, the $STUB_DELETED_SIMULATOR_UDID simulator is not listed
+        # and the simulator of $STUB_SIMULATOR_STATE (<udid>:<state>) is listed in the given state,
+        # $STUB_SIMCTL_DEVICES_FIXTURE and $STUB_SIMCTL_RUNTIMES_FIXTURE replace the default fixtures
+        sed_args=(-e "/\"${STUB_DELETED_SIMULATOR_UDID:-none}\"/d")
+        for udid in ${STUB_BOOTED_SIMULATOR_UDID:-none} ; do
+          sed_args+=(-e "s/\"state\" : \"Shutdown\"\(.*\"$udid\"\)/\"state\" : \"Booted\"\1/")
+        done
+        if [ -n "$STUB_SIMULATOR_STATE" ] ; then
+          sed_args+=(-e "s/\"state\" : \"[A-Za-z ]*\"\(.*\"${STUB_SIMULATOR_STATE%%:*}\"\)/\"state\" : \"${STUB_SIMULATOR_STATE#*:}\"\1/")
+        fi
+        # the simulators created by simctl create (and not deleted) are listed on their runtimes
+        if [ -f "$STUB_ROOT/simctl_created" ] ; then
+          while IFS='|' read -r name device_type runtime udid ; do
+            sed_args+=(-e "/\"$runtime\" : \[/a\\      {\"state\" : \"Shutdown\", \"isAvailable\" : true, \"name\" : \"$name\", \"udid\" : \"$udid\", \"deviceTypeIdentifier\" : \"$device_type\"},")
+          done < "$STUB_ROOT/simctl_created"
+        fi
+        sed "${sed_args[@]}" "$STUB_FIXTURES/${STUB_SIMCTL_DEVICES_FIXTURE:-simctl_list_devices.json}"
+      elif [ "$3 $4" == "runtimes --json" ] && [ -n "$STUB_SIMCTL_RUNTIMES_FIXTURE" ] ; then
+        cat "$STUB_FIXTURES/$STUB_SIMCTL_RUNTIMES_FIXTURE"
+      elif [ "$3 $4" == "runtimes --json" ] ; then
+        # the runtime of $STUB_SIMCTL_RUNTIME_VERSION (<identifier suffix>:<version> <build>) is listed with the given version
+        if [ -n "$STUB_SIMCTL_RUNTIME_VERSION" ] ; then
+          runtime="${STUB_SIMCTL_RUNTIME_VERSION%%:*}"
+          version="${STUB_SIMCTL_RUNTIME_VERSION#*:}"
+          sed -e "s/\"buildversion\" : \"[A-Z0-9]*\"\(.*SimRuntime\.$runtime\", \"version\" : \)\"[0-9.]*\"/\"buildversion\" : \"${version#* }\"\1\"${version%% *}\"/" "$STUB_FIXTURES/simctl_list_runtimes.json"
+        else
+          cat "$STUB_FIXTURES/simctl_list_runtimes.json"
+        fi
+      elif [ "$3 $4" == "devicetypes --json" ] ; then
+        cat "$STUB_FIXTURES/simctl_list_devicetypes.json"
+      elif [ "$3" == "--json" ] ; then
+        cat "$STUB_FIXTURES/simctl_list.json"
+      else
+        # the (space separated) $STUB_BOOTED_SIMULATOR_UDID simulators are listed as booted,
+        # the $STUB_DELETED_SIMULATOR_UDID simulator gets deleted after the first listing
+        sed_args=()
+        for udid in ${STUB_BOOTED_SIMULATOR_UDID:-none} ; do
+          sed_args+=(-e "s/($udid) (Shutdown)/($udid) (Booted)/")
+        done
+        if [ -f "$STUB_ROOT/simulator_listed" ] ; then
+          sed_args+=(-e "/(${STUB_DELETED_SIMULATOR_UDID:-none})/d")
+        fi
+        touch "$STUB_ROOT/simulator_listed"
+        sed "${sed_args[@]}" "$STUB_FIXTURES/simctl_list.txt"
+      fi
+    fi
+    if [ "$1 $2" == "simctl spawn" ] && [ "$4 $5" == "launchctl list" ] ; then
+      echo "PID	Status	Label"
+      if [ -n "$STUB_RUNNING_APP" ] ; then
+        echo "4242	0	UIKitApplication:$STUB_RUNNING_APP[0x1a2b][rb-legacy]"
+      fi
+    fi
+    # simctl spawn <udid> log stream prints the header and the $STUB_APP_LOG_LINES lines (separated by |), then streams until it gets killed
+    if [ "$1 $2" == "simctl spawn" ] && [ "$4 $5" == "log stream" ] ; then
+      echo "Filtering the log data using \"$7\""
+      IFS='|' read -r -a app_log_lines <<< "$STUB_APP_LOG_LINES"
+      for line in "${app_log_lines[@]}" ; do
+        echo "$line"
+      done
+      sleep 30
+    fi
+    # installing an app named $STUB_SIMCTL_INSTALL_FAILING_APP fails
+    if [ "$1 $2" == "simctl install" ] && [ -n "$STUB_SIMCTL_INSTALL_FAILING_APP" ] && [ "$(basename "$4")" == "$STUB_SIMCTL_INSTALL_FAILING_APP" ] ; then
+      echo "An error was encountered processing the command (domain=IXUserPresentableErrorDomain, code=1)"
+      exit 1
+    fi
+    if [ "$1 $2" == "simctl terminate" ] && [ -n "$STUB_SIMCTL_TERMINATE_EXIT_CODE" ] ; then
+      echo "An error was encountered processing the command (domain=FBSOpenApplicationServiceErrorDomain, code=4)"
+      exit "$STUB_SIMCTL_TERMINATE_EXIT_CODE"
+    fi
+    # simctl launch --console-pty <udid> <bundle id> prints the $STUB_SIMCTL_LAUNCH_CONSOLE fixture and exits with $STUB_SIMCTL_LAUNCH_EXIT_CODE
+    if [ "$1 $2 $3" == "simctl launch --console-pty" ] ; then
+      if [ -n "$STUB_SIMCTL_LAUNCH_CONSOLE" ] ; then
+        cat "$STUB_FIXTURES/$STUB_SIMCTL_LAUNCH_CONSOLE"
+      fi
+      exit "${STUB_SIMCTL_LAUNCH_EXIT_CODE:-0}"
+    fi
+    # simctl create <name> <device type> <runtime> prints the $STUB_SIMCTL_CREATED_UDID of the created simulator, simctl delete deletes it
+    if [ "$1 $2" == "simctl create" ] ; then
+      udid="${STUB_SIMCTL_CREATED_UDID:-99999999-9999-9999-9999-999999999999}"
+      echo "$3|$4|$5|$udid" >> "$STUB_ROOT/simctl_created"
+      echo "$udid"
+    fi
+    if [ "$1 $2" == "simctl delete" ] && [ -f "$STUB_ROOT/simctl_created" ] ; then
+      sed -i "/|$3\$/d" "$STUB_ROOT/simctl_created"
+    fi
+    # the app's data container is $STUB_ROOT/$STUB_APP_CONTAINER, the app is not installed without it
+    if [ "$1 $2" == "simctl get_app_container" ] ; then
+      if [ -z "$STUB_APP_CONTAINER" ] ; then
+        echo "An error was encountered processing the command (domain=NSPOSIXErrorDomain, code=2): No such file or directory"
+        exit 2
+      fi
+      echo "$STUB_ROOT/$STUB_APP_CONTAINER"
+    fi
+    ;;
+  sudo)
+    # sudo -n <cmd> <args...>, fails with $STUB_SUDO_EXIT_CODE as if a password was required,
+    # the commands listed in $STUB_SUDO_FAILING_COMMANDS (| separated, without sudo -n) fail
+    record "" "$@"
+    if [ -n "$STUB_SUDO_EXIT_CODE" ] ; then
+      echo "sudo: a password is required"
+      exit "$STUB_SUDO_EXIT_CODE"
+    fi
+    IFS='|' read -r -a failing_commands <<< "$STUB_SUDO_FAILING_COMMANDS"
+    for failing_command in "${failing_commands[@]}" ; do
+      if [ "${*:2}" == "$failing_command" ] ; then
+        echo "$2: operation failed"
+        exit 1
+      fi
+    done
+    if [ "$2 $3" == "pfctl -E" ] ; then
+      echo "pf enabled"
+      echo "Token : 424242"
+    fi
+    ;;
+  dnctl|pfctl)
+    record "" "$@"
+    ;;
+  xcodebuild)
+    # xcodebuild -version prints $STUB_XCODE_VERSION and $STUB_XCODE_BUILD_VERSION
+    record "" "$@"
+    if [ "$1" == "-version" ] ; then
+      echo "Xcode ${STUB_XCODE_VERSION:-12.1}"
+      echo "Build version ${STUB_XCODE_BUILD_VERSION:-12A7403}"
+    fi
+    exit "${STUB_XCODEBUILD_EXIT_CODE:-0}"
+    ;;
+  xcode-select)
+    # xcode-select -p prints DEVELOPER_DIR if set, like the real one, otherwise $STUB_XCODE_SELECT_PATH
+    record "" "$@"
+    echo "${DEVELOPER_DIR:-${STUB_XCODE_SELECT_PATH:-/Applications/Xcode.app/Contents/Developer}}"
+    ;;
+  sysctl)
+    # sysctl -n hw.optional.arm64 prints 1 on an Apple Silicon host ($STUB_HOST_ARCH=arm64)
+    record "" "$@"
+    if [ "${STUB_HOST_ARCH:-x86_64}" == "arm64" ] ; then
+      echo "1"
+    else
+      echo "0"
+    fi
+    ;;
+  arch)
+    # arch -x86_64 fails if Rosetta is not installed ($STUB_ROSETTA_INSTALLED=no)
+    record "" "$@"
+    if [ "${STUB_ROSETTA_INSTALLED:-yes}" != "yes" ] ; then
+      echo "arch: posix_spawnp: $3: Bad CPU type in executable"
+      exit 1
+    fi
+    shift
+    exec "$@"
+    ;;
+  plutil)
+    record "" "$@"
+    pth="${@: -1}"
+    case "$1" in
+      -replace)
+        # plutil -replace <key> -<type> <value> <pth>, the keys listed in $STUB_PLUTIL_IGNORED_KEYS are not written
+        if [[ ",$STUB_PLUTIL_IGNORED_KEYS," != *",$2,"* ]] ; then
+          echo "$pth|$2|$4" >> "$STUB_ROOT/plist_values"
+        fi
+        ;;
+      -extract)
+        # plutil -extract <key> raw -o - <pth>, prints the last written value, falls back to $STUB_PLIST_VALUES (<key>=<value>,...)
+        value="$(grep -F "$pth|$2|" "$STUB_ROOT/plist_values" 2>/dev/null | tail -n 1 | cut -d '|' -f 3)"
+        if [ -z "$value" ] ; then
+          value="$(echo ",$STUB_PLIST_VALUES," | tr ',' '\n' | grep "^$2=" | cut -d '=' -f 2)"
+        fi
+        if [ -z "$value" ] ; then
+          echo "$pth: Could not extract value, error: No value at that key path or invalid key path: $2"
+          exit 1
+        fi
+        echo "$value"
+        ;;
+      *)
+        # plutil -convert json -o - <pth>, the test Info.plists are written in json
+        cat "$pth"
+        ;;
+    esac
+    ;;
+  gem)
+    record "" "$@"
+    # the calabash-cucumber (and cucumber) versions installed and uninstalled by the step are applied on the fixture's list
+    if [ "$1" == "list" ] ; then
+      while IFS= read -r line ; do
+        if [[ "$line" == "cucumber ("* ]] ; then
+          versions="$(cat "$STUB_ROOT/gem_installed_cucumber_versions" 2>/dev/null) $(echo "${line#*(}" | tr -d '),')"
+          echo "cucumber ($(echo $versions | sed 's/ /, /g'))"
+          continue
+        fi
+        if [[ "$line" != "calabash-cucumber ("* ]] ; then
+          echo "$line"
+          continue
+        fi
+        versions="$(cat "$STUB_ROOT/gem_installed_versions" 2>/dev/null) $(echo "${line#*(}" | tr -d '),')"
+        kept=""
+        for version in $versions ; do
+          if ! grep -qx "$version" "$STUB_ROOT/gem_uninstalled_versions" 2>/dev/null && [[ " $kept " != *" $version "* ]] ; then
+            kept="$kept $version"
+          fi
+        done
+        if [ -n "$kept" ] ; then
+          echo "calabash-cucumber ($(echo $kept | sed 's/ /, /g'))"
+        fi
+      done < "$STUB_FIXTURES/gem_list.txt"
+    fi
+    if [ "$1" == "uninstall" ] ; then
+      echo "$4" >> "$STUB_ROOT/gem_uninstalled_versions"
+    fi
+    # the first $STUB_GEM_INSTALL_FAILURES gem installs fail, simulating a transient network issue
+    if [ "$1" == "install" ] && [ -n "$STUB_GEM_INSTALL_FAILURES" ] ; then
+      failures="$(cat "$STUB_ROOT/gem_install_failures" 2>/dev/null || echo 0)"
+      if [ "$failures" -lt "$STUB_GEM_INSTALL_FAILURES" ] ; then
+        echo $((failures + 1)) > "$STUB_ROOT/gem_install_failures"
+        echo "ERROR:  Could not find a valid gem 'calabash-cucumber' (>= 0), here is why:"
+        echo "          Unable to download data from https://rubygems.org/ - Net::OpenTimeout"
+        exit 2
+      fi
+    fi
+    # the installed version is the one after -v, a prerelease requires --prerelease like RubyGems
+    version=""
+    if [ "$1" == "install" ] ; then
+      version="$(echo " $* " | sed -n 's/.* -v \([^ ]*\) .*/\1/p')"
+      if [[ "$version" == *[a-zA-Z]* ]] && [[ " $* " != *" --prerelease "* ]] ; then
+        echo "ERROR:  Could not find a valid gem '$2' (= $version) in any repository"
+        exit 2
+      fi
+    fi
+    # STUB_GEM_INSTALL_NOOP: gem install succeeds without installing anything
+    if [ "$1" == "install" ] && [ "$2" == "calabash-cucumber" ] && [ "$version" != "" ] && [ -z "$STUB_GEM_INSTALL_NOOP" ] ; then
+      echo "$version" >> "$STUB_ROOT/gem_installed_versions"
+    fi
+    if [ "$1" == "install" ] && [ "$2" == "cucumber" ] && [ "$version" != "" ] ; then
+      echo "$version" >> "$STUB_ROOT/gem_installed_cucumber_versions"
+    fi
+    # calabash-cucumber requires cucumber ${STUB_CALABASH_CUCUMBER_REQUIREMENT:-~> 2.0}
+    if [ "$1" == "dependency" ] ; then
+      echo "Gem calabash-cucumber-$4"
+      echo "  clipboard (~> 1.0)"
+      echo "  cucumber (${STUB_CALABASH_CUCUMBER_REQUIREMENT:-~> 2.0})"
+      echo "  cucumber (>= 0, development)"
+    fi
+    ;;
+  bundle)
+    envs="BUNDLE_GEMFILE=$BUNDLE_GEMFILE BUNDLE_APP_CONFIG=$BUNDLE_APP_CONFIG cwd=$PWD"
+    if [ "$1" == "exec" ] ; then
+      record "[$envs]" "$@"
+      shift
+      exec "$@"
+    fi
+    record "[$envs]" "$@"
+    if [ "$1" == "install" ] && [ -n "$STUB_BUNDLE_INSTALL_OUTPUT" ] ; then
+      cat "$STUB_FIXTURES/$STUB_BUNDLE_INSTALL_OUTPUT"
+      exit 6
+    fi
+    ;;
+  cucumber)
+    envs="DEVICE_TARGET=$DEVICE_TARGET APP=$APP"
+    if [ -n "$APP_LAUNCH_ARGS" ] ; then
+      envs="$envs APP_LAUNCH_ARGS=$APP_LAUNCH_ARGS"
+    fi
+    if [ -n "$NO_COLOR" ] ; then
+      envs="$envs NO_COLOR=$NO_COLOR TERM=$TERM"
+    fi
+    if [ -n "$CUCUMBER_PUBLISH_QUIET" ] ; then
+      envs="$envs CUCUMBER_PUBLISH_QUIET=$CUCUMBER_PUBLISH_QUIET"
+    fi
+    while IFS= read -r env ; do
+      envs="$envs $env"
+    done < <(env | grep '^SIMCTL_CHILD_' | sort)
+    record "[$envs]" "$@"
+
+    # the version check before the run prints $STUB_CUCUMBER_VERSION, and fails with $STUB_CUCUMBER_UNRESOLVED, like a stale rbenv shim
+    if [ "${@: -1}" == "--version" ] ; then
+      if [ -n "$STUB_CUCUMBER_UNRESOLVED" ] ; then
+        echo "rbenv: cucumber: command not found"
+        exit 127
+      fi
+      echo "${STUB_CUCUMBER_VERSION:-3.1.2}"
+      exit 0
+    fi
+
+    # the generated profile's options (the bitrise_generated line of the work dir's cucumber.yml) are recorded and parsed as well
+    if [[ " $* " == *" bitrise_generated "* ]] ; then
+      profile="$(grep '^bitrise_generated: ' cucumber.yml | sed -e "s/^bitrise_generated: '//" -e "s/'$//" -e "s/''/'/g")"
+      line="cucumber.yml bitrise_generated: $profile"
+      echo "${line//$STUB_ROOT/<root>}" >> "$STUB_LOG"
+      eval "set -- $profile"
+    fi
+
+    # the --wip run of the wip tags exits with $STUB_CUCUMBER_WIP_EXIT_CODE (1 if a wip scenario passed)
+    if [[ " $* " == *" --wip "* ]] ; then
+      echo "wip scenarios run"
+      exit "${STUB_CUCUMBER_WIP_EXIT_CODE:-0}"
+    fi
+
+    # the --dry-run (`... --dry-run --format json --out <pth>`) writes the $STUB_CUCUMBER_DRY_RUN_REPORT json report,
+    # the run's report by default, and fails with $STUB_CUCUMBER_DRY_RUN_EXIT_CODE
+    if [[ " $* " == *" --dry-run "* ]] ; then
+      if [ -n "$STUB_CUCUMBER_DRY_RUN_EXIT_CODE" ] ; then
+        echo "cucumber: undefined method \`dry_run' for nil:NilClass"
+        exit "$STUB_CUCUMBER_DRY_RUN_EXIT_CODE"
+      fi
+      cp "$STUB_FIXTURES/${STUB_CUCUMBER_DRY_RUN_REPORT:-${STUB_CUCUMBER_REPORT:-cucumber_report_passed.json}}" "${@: -1}"
+      exit 0
+    fi
+
+    if [ -n "$STUB_CUCUMBER_OUTPUT" ] ; then
+      cat "$STUB_FIXTURES/$STUB_CUCUMBER_OUTPUT"
+    fi
+
+    # fails with the failed report on the simulators listed in $STUB_CUCUMBER_FAILING_DEVICE_TARGETS (| separated UDIDs)
+    report="${STUB_CUCUMBER_REPORT:-cucumber_report_passed.json}"
+    exit_code="${STUB_CUCUMBER_EXIT_CODE:-0}"
+    if [ -n "$STUB_CUCUMBER_FAILING_DEVICE_TARGETS" ] && [[ "|${STUB_CUCUMBER_FAILING_DEVICE_TARGETS}|" == *"|${DEVICE_TARGET}|"* ]] ; then
+      report="cucumber_report_failed.json"
+      exit_code=1
+    fi
+
+    # calabash saves $STUB_CUCUMBER_SCREENSHOTS screenshots with the $SCREENSHOT_PATH prefix
+    for i in $(seq 1 "${STUB_CUCUMBER_SCREENSHOTS:-0}") ; do
+      printf "png" > "${SCREENSHOT_PATH}screenshot_$i.png"
+    done
+
+    # write the canned json report for `--format json --out <pth>`, $STUB_CUCUMBER_RERUN for `--format rerun --out <pth>`
+    # and a junit report into the `--format junit --out <dir>` dir
+    # (an --out without a preceding --format is the default pretty formatter's)
+    format="pretty"
+    while [ $# -gt 0 ] ; do
+      out=""
+      case "$1" in
+        --format|-f) format="$2"; shift ;;
+        --format=*) format="${1#--format=}" ;;
+        --out|-o) out="$2"; shift ;;
+        --out=*) out="${1#--out=}" ;;
+      esac
+      if [ -n "$out" ] ; then
+        if [ "$format" == "json" ] ; then
+          cp "$STUB_FIXTURES/$report" "$out"
+        elif [ "$format" == "rerun" ] ; then
+          printf "%s" "$STUB_CUCUMBER_RERUN" > "$out"
+        elif [ "$format" == "junit" ] ; then
+          mkdir -p "$out"
+          printf '<testsuite name="Login" tests="2" failures="1"></testsuite>\n' > "$out/TEST-features-login.xml"
+        else
+          printf "cucumber output\n" > "$out"
+        fi
+      fi
+      shift
+    done
+
+    # the system kills cucumber with $STUB_CUCUMBER_SIGNAL (like KILL for running out of memory)
+    if [ -n "$STUB_CUCUMBER_SIGNAL" ] ; then
+      kill -s "$STUB_CUCUMBER_SIGNAL" $$
+    fi
+
+    exit "$exit_code"
+    ;;
+  vm_stat)
+    # the first call prints the fixture of the run's start, the later ones the fixture of the run's end
+    record "" "$@"
+    if [ -f "$STUB_ROOT/vm_stat_called" ] ; then
+      cat "$STUB_FIXTURES/vm_stat_end.txt"
+    else
+      touch "$STUB_ROOT/vm_stat_called"
+      cat "$STUB_FIXTURES/vm_stat_start.txt"
+    fi
+    ;;
+  ps)
+    # ps -axo pid=,command=, the $STUB_TEST_RUNNERS process lines are listed until they get killed,
+    # ps -x -o pid=,etime=,command=, the $STUB_CUCUMBER_PROCESSES process lines (separated by |) are listed until they get killed
+    record "" "$@"
+    if [ "$1" == "-x" ] ; then
+      if [ -n "$STUB_CUCUMBER_PROCESSES" ] && [ ! -f "$STUB_ROOT/test_runners_killed" ] ; then
+        echo "$STUB_CUCUMBER_PROCESSES" | tr '|' '\n'
+      fi
+    elif [ -n "$STUB_TEST_RUNNERS" ] && [ ! -f "$STUB_ROOT/test_runners_killed" ] ; then
+      echo "$STUB_TEST_RUNNERS"
+    fi
+    ;;
+  kill)
+    record "" "$@"
+    touch "$STUB_ROOT/test_runners_killed"
+    ;;
+  envman)
+    # envman add --key <key>, value on stdin: the keys of $STUB_ENVMAN_FAILING_KEYS fail, the keys of $STUB_ENVMAN_FLAKY_KEYS
+    # fail on the first attempt, the keys of $STUB_ENVMAN_DROPPED_KEYS are silently not stored (| separated lists)
+    if [ "$1" == "add" ] && [ "$2" == "--key" ] ; then
+      value="$(cat)"
+      if [[ "|$STUB_ENVMAN_FAILING_KEYS|" == *"|$3|"* ]] ; then
+        echo "envstore is locked"
+        exit 1
+      fi
+      if [[ "|$STUB_ENVMAN_FLAKY_KEYS|" == *"|$3|"* ]] && [ ! -f "$STUB_ROOT/envman_flaky_$3" ] ; then
+        touch "$STUB_ROOT/envman_flaky_$3"
+        echo "envstore is locked"
+        exit 1
+      fi
+      if [[ "|$STUB_ENVMAN_DROPPED_KEYS|" == *"|$3|"* ]] ; then
+        exit 0
+      fi
+      echo "$3=${value//$STUB_ROOT/<root>}" >> "$STUB_ENVSTORE"
+    fi
+    # envman print --format json, lists the stored keys (the values are left out)
+    if [ "$1" == "print" ] ; then
+      keys="$(grep -oE '^[A-Z_][A-Z0-9_]*=' "$STUB_ENVSTORE" | tr -d '=' | sort -u | sed 's/.*/"&":""/' | paste -sd, -)"
+      echo "{${keys}}"
+    fi
+    ;;
+  bitrise)
+    # bitrise :annotations annotate <markdown> --style <style> --context <context>
+    record "" "$@"
+    if [ -n "$STUB_BITRISE_EXIT_CODE" ] ; then
+      echo "failed to annotate the build"
+      exit "$STUB_BITRISE_EXIT_CODE"
+    fi
+    ;;
+  calabash-sandbox)
+    # calabash-sandbox version prints the sandbox's gem versions ($STUB_SANDBOX_CALABASH_VERSION),
+    # without arguments the command line read from the stdin runs, as in the sandbox's shell
+    if [ "$1" == "version" ] ; then
+      record "" "$@"
+      echo "calabash-ios: 0.21.10"
+      echo "calabash-cucumber: ${STUB_SANDBOX_CALABASH_VERSION:-0.21.10}"
+      echo "run_loop: 4.6.4"
+      exit 0
+    fi
+    read -r command_line
+    record "" "$command_line"
+    eval "$command_line"
+    ;;
+  curl)
+    record "" "$@"
+    # curl -fsSL -o <pth> <url>, the downloaded calabash-sandbox installer links this stub into the PATH as calabash-sandbox
+    printf '#!/usr/bin/env bash\nln -s "%s" "%s/bin/calabash-sandbox"\n' "$(readlink "$0")" "$STUB_ROOT" > "$3"
+    ;;
+  *)
+    echo "unknown stub: $name"
+    exit 1
+    ;;
+esac
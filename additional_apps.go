@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/command"
+	"github.com/bitrise-io/go-utils/log"
+	"github.com/bitrise-io/go-utils/pathutil"
+)
+
+const additionalAppBundleIDsOutputKey = "BITRISE_CALABASH_ADDITIONAL_APP_BUNDLE_IDS"
+
+// parseAdditionalAppPaths parses and expands the .app paths, one per line, empty lines are skipped.
+func parseAdditionalAppPaths(value string) ([]string, error) {
+	pths := []string{}
+	for i, line := range strings.Split(value, "\n") {
+		pth := strings.TrimSpace(line)
+		if pth == "" {
+			continue
+		}
+
+		if filepath.Ext(pth) != ".app" {
+			return nil, fmt.Errorf("invalid AdditionalAppPaths line %d (%s), should be an .app path", i+1, pth)
+		}
+
+		absPth, err := pathutil.AbsPath(pth)
+		if err != nil {
+			return nil, fmt.Errorf("failed to expand AdditionalAppPaths line %d (%s), error: %s", i+1, pth, err)
+		}
+		if exist, err := pathutil.IsDirExists(absPth); err != nil {
+			return nil, fmt.Errorf("failed to check if AdditionalAppPaths line %d (%s) exist, error: %s", i+1, pth, err)
+		} else if !exist {
+			return nil, fmt.Errorf("invalid AdditionalAppPaths line %d, directory not exists at: %s", i+1, absPth)
+		}
+
+		if indexInStringSlice(absPth, pths) != -1 {
+			return nil, fmt.Errorf("invalid AdditionalAppPaths line %d (%s), duplicated app", i+1, pth)
+		}
+		pths = append(pths, absPth)
+	}
+	return pths, nil
+}
+
+// AdditionalAppModel is a companion app installed onto the simulator next to the app under test.
+type AdditionalAppModel struct {
+	Path     string
+	BundleID string
+}
+
+// additionalAppInfo returns the companion app's bundle id and checks that the app is built for the platform's simulator.
+func additionalAppInfo(appPath, platform string) (AdditionalAppModel, error) {
+	infoPlist, err := appInfoPlist(appPath)
+	if err != nil {
+		return AdditionalAppModel{}, err
+	}
+
+	bundleID, _ := infoPlist["CFBundleIdentifier"].(string)
+	if bundleID == "" {
+		return AdditionalAppModel{}, fmt.Errorf("CFBundleIdentifier is not set in the Info.plist of the app (%s)", appPath)
+	}
+
+	supportedPlatforms := appSupportedPlatforms(infoPlist)
+	if indexInStringSlice(simulatorPlatformNames[platform], supportedPlatforms) == -1 {
+		return AdditionalAppModel{}, fmt.Errorf("the app (%s) is not built for the %s simulator, supported platforms: %s", appPath, platform, strings.Join(supportedPlatforms, ", "))
+	}
+
+	return AdditionalAppModel{Path: appPath, BundleID: bundleID}, nil
+}
+
+// installAdditionalApps boots the simulator and installs the companion apps, which the scenarios deep link into.
+// Every app is checked before the first install, as the tests can not pass without all of them,
+// the installed bundle ids are exported.
+func (ctx *StepContext) installAdditionalApps() error {
+	if len(ctx.AdditionalAppPaths) == 0 {
+		return nil
+	}
+
+	apps := []AdditionalAppModel{}
+	for _, pth := range ctx.AdditionalAppPaths {
+		app, err := additionalAppInfo(pth, platformOrDefault(ctx.Configs.Platform))
+		if err != nil {
+			return newStepError(categoryInvalidInput, "Issue with additional app: %s", err)
+		}
+		apps = append(apps, app)
+	}
+
+	if err := ctx.bootSimulator(); err != nil {
+		return err
+	}
+
+	fmt.Println()
+	log.Infof("Installing additional apps on the simulator...")
+
+	bundleIDs := []string{}
+	for _, app := range apps {
+		cmd := command.New("xcrun", "simctl", "install", ctx.Simulator.ID, app.Path)
+		printCommand(cmd)
+
+		if out, err := commandRecorder.RunAndReturnTrimmedCombinedOutput(cmd); err != nil {
+			return newStepError(categoryInfrastructure, "Failed to install additional app (%s), output: %s, error: %s", app.Path, out, err)
+		}
+
+		log.Donef("Installed %s (%s)", filepath.Base(app.Path), app.BundleID)
+		bundleIDs = append(bundleIDs, app.BundleID)
+	}
+
+	exportOutput(additionalAppBundleIDsOutputKey, strings.Join(bundleIDs, ","))
+	return nil
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/command"
+	"github.com/bitrise-io/go-utils/log"
+)
+
+const (
+	// maxFailedScenarioAnnotations caps the annotations of a run, a broken build should not flood the build page
+	maxFailedScenarioAnnotations = 10
+
+	annotationErrorExcerptMaxLines = 5
+	annotationErrorExcerptMaxChars = 500
+)
+
+// annotationsAvailable reports whether the build can be annotated: the bitrise CLI's annotations plugin
+// calls the build API with the build's url and API token, which are only set on Bitrise stacks supporting annotations.
+func annotationsAvailable() bool {
+	if os.Getenv("BITRISE_BUILD_URL") == "" || os.Getenv("BITRISE_BUILD_API_TOKEN") == "" {
+		return false
+	}
+	_, err := exec.LookPath("bitrise")
+	return err == nil
+}
+
+// errorExcerpt returns the first lines of the error message, truncated to a readable length.
+func errorExcerpt(message string) string {
+	lines := strings.Split(strings.TrimSpace(message), "\n")
+	if len(lines) > annotationErrorExcerptMaxLines {
+		lines = append(lines[:annotationErrorExcerptMaxLines], "...")
+	}
+
+	excerpt := strings.Join(lines, "\n")
+	if runes := []rune(excerpt); len(runes) > annotationErrorExcerptMaxChars {
+		excerpt = string(runes[:annotationErrorExcerptMaxChars]) + "..."
+	}
+	return excerpt
+}
+
+// failedScenarioAnnotation returns the markdown annotation of the failed scenario.
+func failedScenarioAnnotation(scenario FailedScenarioSummaryModel) string {
+	lines := []string{
+		fmt.Sprintf("**%s**", escapeMarkdownTableCell(scenario.Name)),
+		fmt.Sprintf("`%s`", scenario.Location),
+	}
+	if scenario.FailedStep != "" {
+		lines = append(lines, "", fmt.Sprintf("Failing step: %s", escapeMarkdownTableCell(scenario.FailedStep)))
+	}
+	if scenario.Error != "" {
+		lines = append(lines, "", "```", errorExcerpt(scenario.Error), "```")
+	}
+	return strings.Join(lines, "\n")
+}
+
+// annotateFailedScenarios adds a build annotation for each failed scenario, up to maxFailedScenarioAnnotations.
+// Stacks without annotation support are skipped, failures are logged as warnings only.
+func annotateFailedScenarios(scenarios []FailedScenarioSummaryModel) {
+	if len(scenarios) == 0 {
+		return
+	}
+
+	fmt.Println()
+	log.Infof("Annotating failed scenarios...")
+
+	if !annotationsAvailable() {
+		log.Printf("Build annotations are not available on this stack, skipping")
+		return
+	}
+
+	emitted := 0
+	for _, scenario := range scenarios {
+		if emitted == maxFailedScenarioAnnotations {
+			break
+		}
+
+		cmd := command.New("bitrise", ":annotations", "annotate", failedScenarioAnnotation(scenario), "--style", "error", "--context", "calabash-"+scenario.Location)
+		if out, err := commandRecorder.RunAndReturnTrimmedCombinedOutput(cmd); err != nil {
+			log.Warnf("Failed to annotate the failed scenario (%s), output: %s, error: %s", scenario.Location, out, err)
+			continue
+		}
+		emitted++
+	}
+
+	log.Printf("Emitted %d annotation(s) for %d failed scenario(s), suppressed %d", emitted, len(scenarios), len(scenarios)-emitted)
+}
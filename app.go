@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/bitrise-io/go-utils/command"
+	"github.com/bitrise-io/go-utils/log"
+	"github.com/bitrise-io/go-utils/pathutil"
+	"github.com/bitrise-io/go-xcode/simulator"
+)
+
+// defaultAppMinSizeKB is the minimal app bundle size, even an empty app's executable is larger.
+const defaultAppMinSizeKB = 100
+
+// prepareApp ensures the app is compatible with the simulator device:
+// an app generated for 'i386 + x86_64' architecture is converted to the simulator's architecture.
+func (ctx *StepContext) prepareApp() error {
+	if ctx.AppPath == "" {
+		return nil
+	}
+
+	monotouch32Dir := filepath.Join(ctx.AppPath, ".monotouch-32")
+	monotouch32DirExist, err := pathutil.IsDirExists(monotouch32Dir)
+	if err != nil {
+		return newStepError(categoryInfrastructure, "Failed to check if path (%s) exist, error: %s", monotouch32Dir, err)
+	}
+
+	monotouch64Dir := filepath.Join(ctx.AppPath, ".monotouch-64")
+	monotouch64DirExist, err := pathutil.IsDirExists(monotouch64Dir)
+	if err != nil {
+		return newStepError(categoryInfrastructure, "Failed to check if path (%s) exist, error: %s", monotouch64Dir, err)
+	}
+
+	if !monotouch32DirExist || !monotouch64DirExist {
+		return nil
+	}
+
+	fmt.Println()
+	log.Warnf("The .app file generated for 'i386 + x86_64' architecture")
+
+	// the tvOS simulators are 64-bit only
+	is64Bit := true
+	if platformOrDefault(ctx.Configs.Platform) == platformIOS {
+		is64Bit, err = simulator.Is64BitArchitecture(ctx.Configs.SimulatorDevice)
+		if err != nil {
+			return newStepError(categoryInfrastructure, "Failed to check simulator architecture, error: %s", err)
+		}
+	}
+
+	log.Warnf("Simulator is 64-bit architecture: %v", is64Bit)
+
+	tmpDir, err := ctx.createTempDir("monotouch_app")
+	if err != nil {
+		return newStepError(categoryInfrastructure, "Failed to create tmp dir, error: %s", err)
+	}
+
+	appName := filepath.Base(ctx.AppPath)
+	newAppPath := filepath.Join(tmpDir, appName)
+
+	log.Warnf("Creating compatible .app file at: %s", newAppPath)
+
+	if err := command.CopyDir(ctx.AppPath, tmpDir, false); err != nil {
+		return newStepError(categoryInfrastructure, "Failed to copy .app to (%s), error: %s", newAppPath, err)
+	}
+
+	newAppMonotouch32Dir := filepath.Join(newAppPath, ".monotouch-32")
+	newAppMonotouch64Dir := filepath.Join(newAppPath, ".monotouch-64")
+
+	if is64Bit {
+		log.Warnf("Copy files from .monotouch-64 dir...")
+
+		if err := command.CopyDir(newAppMonotouch64Dir, newAppPath, true); err != nil {
+			return newStepError(categoryInfrastructure, "Failed to copy .monotouch-64 files, error: %s", err)
+		}
+	} else {
+		log.Warnf("Copy files from .monotouch-32 dir...")
+
+		if err := command.CopyDir(newAppMonotouch32Dir, newAppPath, true); err != nil {
+			return newStepError(categoryInfrastructure, "Failed to copy .monotouch-32 files, error: %s", err)
+		}
+	}
+
+	ctx.AppPath = newAppPath
+
+	return nil
+}
+
+// appInfoPlist reads the app's Info.plist, converted to json by plutil to support binary plists.
+func appInfoPlist(appPath string) (map[string]interface{}, error) {
+	infoPlistPth := filepath.Join(appPath, "Info.plist")
+	if exist, err := pathutil.IsPathExists(infoPlistPth); err != nil {
+		return nil, fmt.Errorf("Failed to check if Info.plist exists at (%s), error: %s", infoPlistPth, err)
+	} else if !exist {
+		return nil, fmt.Errorf("Info.plist not found at: %s", infoPlistPth)
+	}
+
+	cmd := command.New("plutil", "-convert", "json", "-o", "-", infoPlistPth)
+	out, err := commandRecorder.RunAndReturnTrimmedCombinedOutput(cmd)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read Info.plist (%s), output: %s, error: %s", infoPlistPth, out, err)
+	}
+
+	var infoPlist map[string]interface{}
+	if err := json.Unmarshal([]byte(out), &infoPlist); err != nil {
+		return nil, fmt.Errorf("Failed to parse Info.plist (%s), error: %s", infoPlistPth, err)
+	}
+	return infoPlist, nil
+}
+
+// checkAppBundle verifies the app bundle's structure before it is installed: the Info.plist has to parse,
+// the executable named by its CFBundleExecutable has to exist with execute permission and the bundle
+// has to be at least minSizeKB in size, a truncated download often leaves a bundle failing one of these.
+func checkAppBundle(appPath string, minSizeKB int) error {
+	fmt.Println()
+	log.Infof("Checking the app bundle...")
+
+	infoPlist, err := appInfoPlist(appPath)
+	if err != nil {
+		return newStepError(categoryInvalidInput, "Malformed app bundle (%s): %s", appPath, err)
+	}
+
+	executable, ok := infoPlist["CFBundleExecutable"].(string)
+	if !ok || executable == "" {
+		return newStepError(categoryInvalidInput, "Malformed app bundle (%s): CFBundleExecutable is not set in its Info.plist", appPath)
+	}
+
+	executablePth := filepath.Join(appPath, executable)
+	info, err := os.Stat(executablePth)
+	if os.IsNotExist(err) {
+		return newStepError(categoryInvalidInput, "Malformed app bundle (%s): the executable named by CFBundleExecutable (%s) not found", appPath, executable)
+	} else if err != nil {
+		return newStepError(categoryInfrastructure, "Failed to check the app's executable (%s), error: %s", executablePth, err)
+	}
+	if info.IsDir() {
+		return newStepError(categoryInvalidInput, "Malformed app bundle (%s): the executable named by CFBundleExecutable (%s) is a directory", appPath, executable)
+	}
+	if info.Mode()&0111 == 0 {
+		return newStepError(categoryInvalidInput, "Malformed app bundle (%s): the executable (%s) has no execute permission", appPath, executable)
+	}
+
+	if minSizeKB > 0 {
+		size, err := pathSize(appPath)
+		if err != nil {
+			return newStepError(categoryInfrastructure, "Failed to calculate the app bundle's size (%s), error: %s", appPath, err)
+		}
+		if size < int64(minSizeKB)*1024 {
+			return newStepError(categoryInvalidInput, "Malformed app bundle (%s): its size (%d bytes) is below app_min_size_kb (%d KB), the bundle might be truncated", appPath, size, minSizeKB)
+		}
+	}
+
+	log.Donef("App bundle is complete, executable: %s", executable)
+	return nil
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/bitrise-io/go-utils/command"
+	"github.com/bitrise-io/go-utils/log"
+)
+
+const (
+	appContainerDirOutputKey = "BITRISE_CALABASH_APP_CONTAINER_DIR"
+
+	// appContainerSizeLimitInMB caps the size of the files copied from the app's data container,
+	// the files over the limit are skipped
+	appContainerSizeLimitInMB = 100
+)
+
+// appContainerSubdirs are the subdirs of the app's data container copied after a failed run.
+var appContainerSubdirs = []string{"Documents", filepath.Join("Library", "Logs")}
+
+// appContainerCopyModel is the state of copying the app's data container: the remaining size budget and the skipped files.
+type appContainerCopyModel struct {
+	remaining int64
+	copied    int
+	skipped   int
+}
+
+// copyFile copies the regular file, up to its size when it was opened: a crashed app might still be writing it,
+// a file truncated since then is copied partially.
+func (c *appContainerCopyModel) copyFile(src, dst string) error {
+	srcFile, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := srcFile.Close(); err != nil {
+			log.Warnf("Failed to close file (%s), error: %s", src, err)
+		}
+	}()
+
+	info, err := srcFile.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() > c.remaining {
+		c.skipped++
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	dstFile, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	written, err := io.CopyN(dstFile, srcFile, info.Size())
+	if closeErr := dstFile.Close(); err == nil || err == io.EOF {
+		err = closeErr
+	}
+	c.remaining -= written
+	c.copied++
+	return err
+}
+
+// copyDir copies the regular files of the dir, the files vanishing during the copy are skipped,
+// failing to copy a file is logged as a warning only.
+func (c *appContainerCopyModel) copyDir(src, dst string) error {
+	return filepath.Walk(src, func(pth string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(src, pth)
+		if err != nil {
+			return err
+		}
+		if err := c.copyFile(pth, filepath.Join(dst, rel)); err != nil && !os.IsNotExist(err) {
+			log.Warnf("Failed to copy (%s), error: %s", pth, err)
+		}
+		return nil
+	})
+}
+
+// appDataContainer returns the path of the app's data container on the simulator.
+func (ctx *StepContext) appDataContainer() (string, error) {
+	if ctx.AppPath == "" {
+		return "", fmt.Errorf("app_path is not set, the app's bundle id is unknown")
+	}
+
+	infoPlist, err := appInfoPlist(ctx.AppPath)
+	if err != nil {
+		return "", err
+	}
+	bundleID, _ := infoPlist["CFBundleIdentifier"].(string)
+	if bundleID == "" {
+		return "", fmt.Errorf("CFBundleIdentifier is not set in the app's Info.plist")
+	}
+
+	cmd := command.New("xcrun", "simctl", "get_app_container", ctx.Simulator.ID, bundleID, "data")
+	printCommand(cmd)
+
+	out, err := commandRecorder.RunAndReturnTrimmedCombinedOutput(cmd)
+	if err != nil {
+		return "", fmt.Errorf("failed to get the app's (%s) data container, the app might not be installed, output: %s, error: %s", bundleID, out, err)
+	}
+	return out, nil
+}
+
+// collectAppContainer copies the Documents and Library/Logs dirs of the app's data container into the results dir after a failed run,
+// the app writes its diagnostic dumps there. Failures are logged as warnings only.
+func (ctx *StepContext) collectAppContainer() {
+	fmt.Println()
+	log.Infof("Collecting the app's data container...")
+
+	container, err := ctx.appDataContainer()
+	if err != nil {
+		log.Warnf("Skipping the app's data container: %s", err)
+		return
+	}
+
+	dir := resultsSubdir(resultsAppContainerDirName, ctx.runResultsPath())
+	copier := appContainerCopyModel{remaining: appContainerSizeLimitInMB * 1024 * 1024}
+	for _, subdir := range appContainerSubdirs {
+		src := filepath.Join(container, subdir)
+		if _, err := os.Stat(src); err != nil {
+			log.Printf("%s not found in the app's data container", subdir)
+			continue
+		}
+		if err := copier.copyDir(src, filepath.Join(dir, subdir)); err != nil {
+			log.Warnf("Failed to copy (%s), error: %s", src, err)
+		}
+	}
+
+	if copier.skipped > 0 {
+		log.Warnf("%d file(s) skipped, the copied files reached the %d MB limit", copier.skipped, appContainerSizeLimitInMB)
+	}
+	log.Donef("%d file(s) of the app's data container copied to: %s", copier.copied, dir)
+
+	exportOutput(appContainerDirOutputKey, dir)
+	diagnostics.Add(diagnosticKindAppContainer, dir, true)
+}
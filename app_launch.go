@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/log"
+	shellquote "github.com/kballard/go-shellquote"
+)
+
+const (
+	// appLaunchArgsEnvKey is read by calabash/run_loop as the arguments of the launched app
+	appLaunchArgsEnvKey = "APP_LAUNCH_ARGS"
+	// appLaunchEnvPrefix is stripped by simctl from the envs passed to the launched app
+	appLaunchEnvPrefix = "SIMCTL_CHILD_"
+)
+
+var appLaunchEnvKeyExp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// parseAppLaunchEnvironment parses the KEY=VALUE lines of the app launch environment, empty lines are skipped.
+func parseAppLaunchEnvironment(value string) ([]string, error) {
+	envs := []string{}
+	for i, line := range strings.Split(value, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		split := strings.SplitN(line, "=", 2)
+		if len(split) != 2 || !appLaunchEnvKeyExp.MatchString(split[0]) {
+			return nil, fmt.Errorf("invalid AppLaunchEnvironment line %d (%s), should be KEY=VALUE", i+1, line)
+		}
+		envs = append(envs, line)
+	}
+	return envs, nil
+}
+
+// appLaunchEnvs returns the cucumber envs forwarding the launch arguments and the launch environment to the app under test.
+func (ctx *StepContext) appLaunchEnvs() []string {
+	envs := []string{}
+	if len(ctx.AppLaunchArguments) > 0 {
+		envs = append(envs, appLaunchArgsEnvKey+"="+shellquote.Join(ctx.AppLaunchArguments...))
+	}
+	for _, env := range ctx.AppLaunchEnvironment {
+		envs = append(envs, appLaunchEnvPrefix+env)
+	}
+	return envs
+}
+
+// printAppLaunchConfig prints the effective launch arguments and launch environment, secrets masked.
+func (ctx *StepContext) printAppLaunchConfig() {
+	if len(ctx.AppLaunchArguments) == 0 && len(ctx.AppLaunchEnvironment) == 0 {
+		return
+	}
+
+	if len(ctx.AppLaunchArguments) > 0 {
+		log.Printf("App launch arguments: %s", shellquote.Join(ctx.AppLaunchArguments...))
+	}
+	if len(ctx.AppLaunchEnvironment) > 0 {
+		log.Printf("App launch environment:")
+		for _, env := range ctx.AppLaunchEnvironment {
+			key, value := splitEnv(env)
+			log.Printf("- %s=%s", key, maskSecret(key, value))
+		}
+	}
+}
+
+// maskedAppLaunchEnvironment returns the app launch environment input with the secret values masked.
+func maskedAppLaunchEnvironment(value string) string {
+	lines := strings.Split(value, "\n")
+	for i, line := range lines {
+		if key, envValue := splitEnv(strings.TrimSpace(line)); envValue != "" {
+			lines[i] = key + "=" + maskSecret(key, envValue)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
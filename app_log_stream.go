@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/bitrise-io/go-utils/command"
+	"github.com/bitrise-io/go-utils/log"
+)
+
+const (
+	appLogLinePrefix = "[app] "
+	// appLogStreamMaxLines bounds the app's log lines written into the step's output, a chatty app would bury the cucumber output
+	appLogStreamMaxLines = 2000
+	// appLogStreamStartTimeout is the time the stream gets to print its header, the app's early log lines would be missed otherwise
+	appLogStreamStartTimeout = 5 * time.Second
+	appLogStreamStopTimeout  = 5 * time.Second
+)
+
+// prefixedLineWriter writes the complete lines written into it with a prefix, up to maxLines lines.
+type prefixedLineWriter struct {
+	lock       sync.Mutex
+	out        io.Writer
+	prefix     string
+	maxLines   int
+	lines      int
+	suppressed int
+	partial    []byte
+	firstLine  chan bool
+}
+
+func newPrefixedLineWriter(out io.Writer, prefix string, maxLines int) *prefixedLineWriter {
+	return &prefixedLineWriter{out: out, prefix: prefix, maxLines: maxLines, firstLine: make(chan bool)}
+}
+
+func (w *prefixedLineWriter) Write(p []byte) (int, error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	w.partial = append(w.partial, p...)
+	for {
+		idx := bytes.IndexByte(w.partial, '\n')
+		if idx == -1 {
+			break
+		}
+		w.writeLine(w.partial[:idx])
+		w.partial = w.partial[idx+1:]
+	}
+	return len(p), nil
+}
+
+func (w *prefixedLineWriter) writeLine(line []byte) {
+	if w.lines == 0 {
+		close(w.firstLine)
+	}
+	w.lines++
+
+	if w.lines > w.maxLines {
+		w.suppressed++
+		return
+	}
+	if _, err := fmt.Fprintf(w.out, "%s%s\n", w.prefix, line); err != nil {
+		w.suppressed++
+	}
+}
+
+// Flush writes the last, unterminated line and returns the number of the suppressed lines.
+func (w *prefixedLineWriter) Flush() int {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if len(w.partial) > 0 {
+		w.writeLine(w.partial)
+		w.partial = nil
+	}
+	return w.suppressed
+}
+
+// AppLogStreamModel is the running `log stream` of the app's process on the simulator.
+type AppLogStreamModel struct {
+	cmd    *command.Model
+	done   <-chan error
+	writer *prefixedLineWriter
+}
+
+var appLogStream *AppLogStreamModel
+
+var appLogStreamCleanupRegistered bool
+
+// appExecutableName returns the app's CFBundleExecutable, the name of the app's process.
+func appExecutableName(appPath string) (string, error) {
+	infoPlist, err := appInfoPlist(appPath)
+	if err != nil {
+		return "", err
+	}
+
+	executable, ok := infoPlist["CFBundleExecutable"].(string)
+	if !ok || executable == "" {
+		return "", fmt.Errorf("CFBundleExecutable not found in the Info.plist of the app (%s)", appPath)
+	}
+	return executable, nil
+}
+
+// startAppLogStream streams the log lines of the app's process on the simulator into the step's output, prefixed with [app],
+// until stopAppLogStream is called (by the cleanups if the step exits before that). Failing to start the stream is only a warning.
+func (ctx *StepContext) startAppLogStream() {
+	fmt.Println()
+	log.Infof("Starting the app log stream...")
+
+	if ctx.AppPath == "" {
+		log.Warnf("No app_path set, the app's process is unknown, the app logs are not streamed")
+		return
+	}
+
+	executable, err := appExecutableName(ctx.AppPath)
+	if err != nil {
+		log.Warnf("Failed to find the app's process name, the app logs are not streamed, error: %s", err)
+		return
+	}
+
+	// log stream runs on the booted simulator only, calabash keeps a booted simulator
+	if err := ctx.bootSimulator(); err != nil {
+		log.Warnf("%s, the app logs are not streamed", err)
+		return
+	}
+
+	writer := newPrefixedLineWriter(stepLogger.Raw(), appLogLinePrefix, appLogStreamMaxLines)
+	cmd := command.New("xcrun", "simctl", "spawn", ctx.Simulator.ID, "log", "stream", "--predicate", fmt.Sprintf(`process == "%s"`, executable), "--style", "compact")
+	cmd.SetStdout(writer).SetStderr(writer)
+	printCommand(cmd)
+
+	done, err := commandRecorder.Start(cmd)
+	if err != nil {
+		log.Warnf("Failed to start the app log stream, error: %s", err)
+		return
+	}
+
+	appLogStream = &AppLogStreamModel{cmd: cmd, done: done, writer: writer}
+	if !appLogStreamCleanupRegistered {
+		appLogStreamCleanupRegistered = true
+		registerCleanup("app log stream", stopAppLogStream)
+	}
+
+	select {
+	case <-writer.firstLine:
+		log.Donef("Streaming the logs of the app's process (%s), up to %d lines", executable, appLogStreamMaxLines)
+	case err := <-done:
+		appLogStream = nil
+		writer.Flush()
+		log.Warnf("The app log stream exited, the app logs are not streamed, error: %v", err)
+	case <-time.After(appLogStreamStartTimeout):
+		log.Warnf("The app log stream did not start in %s, the early app logs might be missing", appLogStreamStartTimeout)
+	}
+}
+
+// stopAppLogStream terminates the app log stream, it is a no-op if no stream is running.
+func stopAppLogStream() error {
+	stream := appLogStream
+	if stream == nil {
+		return nil
+	}
+	appLogStream = nil
+
+	if process := stream.cmd.GetCmd().Process; process != nil {
+		if err := syscall.Kill(-process.Pid, syscall.SIGTERM); err != nil && err != syscall.ESRCH {
+			return fmt.Errorf("failed to stop the app log stream, error: %s", err)
+		}
+
+		select {
+		case <-stream.done:
+		case <-time.After(appLogStreamStopTimeout):
+			if err := syscall.Kill(-process.Pid, syscall.SIGKILL); err != nil && err != syscall.ESRCH {
+				return fmt.Errorf("failed to kill the app log stream, error: %s", err)
+			}
+			<-stream.done
+		}
+	}
+
+	if suppressed := stream.writer.Flush(); suppressed > 0 {
+		log.Warnf("Suppressed %d app log line(s) over the %d lines limit", suppressed, appLogStreamMaxLines)
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/log"
+)
+
+// App resolution modes, how the app cucumber tests is selected
+const (
+	appResolutionAuto           = "auto"
+	appResolutionRequireAppPath = "require_app_path"
+	appResolutionRepoManaged    = "repo_managed"
+)
+
+var appResolutions = []string{appResolutionAuto, appResolutionRequireAppPath, appResolutionRepoManaged}
+
+// supportFileAppReferenceExp matches the references of the envs, which select the app in the project's support files,
+// like `ENV['APP']` or `ENV.fetch('BUNDLE_ID')`.
+var supportFileAppReferenceExp = regexp.MustCompile(`\b(APP|APP_BUNDLE_PATH|BUNDLE_ID)\b`)
+
+// scanSupportFileAppReferences returns the APP, APP_BUNDLE_PATH and BUNDLE_ID references of the project's <features dir>/support/*.rb files,
+// like `features/support/01_launch.rb:3: APP`, the files are reported relative to the work dir.
+func scanSupportFileAppReferences(workDir, featuresDir string) ([]string, error) {
+	pths, err := filepath.Glob(filepath.Join(featuresDir, "support", "*.rb"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(pths)
+
+	references := []string{}
+	for _, pth := range pths {
+		name, err := filepath.Rel(workDir, pth)
+		if err != nil {
+			name = pth
+		}
+
+		if err := scanFileLines(pth, func(line string, number int) {
+			if strings.HasPrefix(strings.TrimSpace(line), "#") {
+				return
+			}
+			for _, match := range supportFileAppReferenceExp.FindAllStringSubmatch(line, -1) {
+				references = append(references, fmt.Sprintf("%s:%d: %s", name, number, match[1]))
+			}
+		}); err != nil {
+			return nil, err
+		}
+	}
+	return references, nil
+}
+
+// checkAppResolution logs how the app cucumber tests is selected: by the step (APP is set to app_path, or to the app of a prepare_only run)
+// or by the project (APP is not set). require_app_path fails if the step has no app to set,
+// repo_managed warns if the project's support files do not reference the envs selecting the app.
+func (ctx *StepContext) checkAppResolution() error {
+	appPath := ctx.AppPath
+	if appPath == "" && ctx.Configs.Mode == modeTestOnly && ctx.Configs.AppResolution != appResolutionRepoManaged {
+		appPath = os.Getenv(appPathOutputKey)
+	}
+
+	fmt.Println()
+	switch ctx.Configs.AppResolution {
+	case appResolutionRequireAppPath:
+		if appPath == "" {
+			return newNonRetryableStepError(categoryInvalidInput, "app_resolution is %s, but neither app_path nor the app of a prepare_only run (%s) is set", appResolutionRequireAppPath, appPathOutputKey)
+		}
+		log.Printf("App resolution: %s, APP is set to: %s", appResolutionRequireAppPath, appPath)
+	case appResolutionRepoManaged:
+		log.Printf("App resolution: %s, APP is not set, the project selects the app", appResolutionRepoManaged)
+		ctx.checkRepoManagedApp()
+	default:
+		if appPath != "" {
+			log.Printf("App resolution (%s): %s, APP is set to: %s", appResolutionAuto, appResolutionRequireAppPath, appPath)
+		} else {
+			log.Printf("App resolution (%s): %s, app_path is empty, APP is not set, the project (or calabash) selects the app", appResolutionAuto, appResolutionRepoManaged)
+		}
+	}
+	return nil
+}
+
+// checkRepoManagedApp scans the project's support files for the envs selecting the app, calabash looks up the app itself without them.
+func (ctx *StepContext) checkRepoManagedApp() {
+	supportDir := filepath.Join(ctx.featuresPath(), "support")
+	references, err := scanSupportFileAppReferences(ctx.WorkDir, filepath.Join(ctx.WorkDir, ctx.featuresPath()))
+	if err != nil {
+		log.Warnf("Failed to scan the support files, error: %s", err)
+		return
+	}
+
+	if len(references) == 0 {
+		log.Warnf("No APP, APP_BUNDLE_PATH or BUNDLE_ID reference found in %s/*.rb, the project might not select the app,", supportDir)
+		log.Warnf("calabash then looks up the app by itself. Set app_path, or select the app in the project's launch hook (like %s/01_launch.rb).", supportDir)
+		return
+	}
+
+	log.Donef("The project references the app in:")
+	for _, reference := range references {
+		log.Printf("- %s", reference)
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"debug/macho"
+	"fmt"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/command"
+	"github.com/bitrise-io/go-utils/log"
+)
+
+// firstArm64RuntimeMajorVersion is the first iOS and tvOS simulator runtime running natively on Apple Silicon,
+// the older runtimes run x86_64 under Rosetta.
+const firstArm64RuntimeMajorVersion = 14
+
+// rosettaLaunchArgs launch cucumber under Rosetta if the x86_64 only app runs on an arm64 simulator runtime:
+// the processes started by cucumber (run_loop, simctl and xcodebuild) inherit the x86_64 architecture preference.
+var rosettaLaunchArgs = []string{"arch", "-x86_64"}
+
+// hostArchitecture returns the cpu type of the host: arm64 on Apple Silicon, even if the step itself runs under Rosetta
+// (runtime.GOARCH is amd64 then).
+func hostArchitecture() macho.Cpu {
+	cmd := command.New("sysctl", "-n", "hw.optional.arm64")
+	out, err := commandRecorder.RunAndReturnTrimmedCombinedOutput(cmd)
+	if err != nil {
+		// the Intel hosts do not know hw.optional.arm64
+		if runtime.GOARCH == "arm64" {
+			return macho.CpuArm64
+		}
+		return macho.CpuAmd64
+	}
+	if out == "1" {
+		return macho.CpuArm64
+	}
+	return macho.CpuAmd64
+}
+
+// isRosettaInstalled reports whether the host can run x86_64 code, `arch -x86_64` fails without Rosetta.
+func isRosettaInstalled() bool {
+	cmd := command.New("arch", "-x86_64", "/usr/bin/true")
+	_, err := commandRecorder.RunAndReturnTrimmedCombinedOutput(cmd)
+	return err == nil
+}
+
+// simulatorRuntimeArchitecture returns the cpu type the simulator runtime runs on the host: by the runtime's supported architectures
+// (listed by newer simctl versions), otherwise by its version, as the runtimes before iOS 14 (and tvOS 14) have no arm64 slice.
+func simulatorRuntimeArchitecture(simRuntime SimulatorRuntimeModel, osVersion string, host macho.Cpu) macho.Cpu {
+	if host != macho.CpuArm64 {
+		return macho.CpuAmd64
+	}
+
+	if len(simRuntime.SupportedArchitectures) > 0 {
+		for _, name := range simRuntime.SupportedArchitectures {
+			if name == "arm64" {
+				return macho.CpuArm64
+			}
+		}
+		return macho.CpuAmd64
+	}
+
+	version := simRuntime.Version
+	if version == "" {
+		version = osVersionNumber(osVersion)
+	}
+	major, err := strconv.Atoi(strings.Split(version, ".")[0])
+	if err != nil || major >= firstArm64RuntimeMajorVersion {
+		return macho.CpuArm64
+	}
+	return macho.CpuAmd64
+}
+
+// checkAppArchitecture prints the architecture triage (the host's architecture, the app's slices and the simulator runtime's architecture)
+// and checks that the app runs on the simulator runtime: an x86_64 only app on an arm64 runtime runs under Rosetta if it is installed,
+// otherwise the step fails, as the app would fail to launch with an obscure error.
+func (ctx *StepContext) checkAppArchitecture() error {
+	if ctx.AppPath == "" {
+		return nil
+	}
+
+	fmt.Println()
+	log.Infof("Checking the app's architectures...")
+
+	executablePath := appExecutablePath(ctx.AppPath)
+	archs, err := executableArchitectures(executablePath)
+	if err != nil {
+		log.Warnf("Failed to read the app executable's (%s) architectures, skipping the architecture check, error: %s", executablePath, err)
+		return nil
+	}
+
+	host := hostArchitecture()
+	runtimeArch := simulatorRuntimeArchitecture(ctx.SimulatorRuntime, ctx.SimulatorOsVersion, host)
+
+	log.Printf("Host architecture: %s", cpuName(host))
+	log.Printf("App slices: %s", cpuList(archs))
+	log.Printf("Simulator runtime architecture: %s", cpuName(runtimeArch))
+
+	switch {
+	case cpuInList(runtimeArch, archs):
+		log.Donef("The app contains the simulator runtime's architecture (%s)", cpuName(runtimeArch))
+	case runtimeArch == macho.CpuArm64 && cpuInList(macho.CpuAmd64, archs):
+		if !isRosettaInstalled() {
+			return newNonRetryableStepError(categoryInvalidInput,
+				"The app is built for %s only, but the simulator runtime runs arm64 on this Apple Silicon host and Rosetta is not installed: "+
+					"build an arm64 simulator slice or enable Rosetta (softwareupdate --install-rosetta)", cpuList(archs))
+		}
+
+		log.Warnf("The app is built for %s only, cucumber is launched under Rosetta for the arm64 simulator runtime, build an arm64 simulator slice to run it natively", cpuList(archs))
+		ctx.ArchitectureLaunchArgs = rosettaLaunchArgs
+		log.Printf("Cucumber is launched with: %s", strings.Join(ctx.ArchitectureLaunchArgs, " "))
+	default:
+		log.Warnf("The app is built for %s, but the simulator runtime runs %s, the app may fail to launch", cpuList(archs), cpuName(runtimeArch))
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"debug/macho"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestSimulatorRuntimeArchitecture(t *testing.T) {
+	tests := []struct {
+		name      string
+		runtime   SimulatorRuntimeModel
+		osVersion string
+		host      macho.Cpu
+		want      macho.Cpu
+	}{
+		{name: "intel host", runtime: SimulatorRuntimeModel{Version: "15.0", SupportedArchitectures: []string{"arm64", "x86_64"}}, host: macho.CpuAmd64, want: macho.CpuAmd64},
+		{name: "supported architectures with arm64", runtime: SimulatorRuntimeModel{Version: "12.4", SupportedArchitectures: []string{"x86_64", "arm64"}}, host: macho.CpuArm64, want: macho.CpuArm64},
+		{name: "supported architectures without arm64", runtime: SimulatorRuntimeModel{Version: "15.0", SupportedArchitectures: []string{"x86_64"}}, host: macho.CpuArm64, want: macho.CpuAmd64},
+		{name: "runtime version before 14", runtime: SimulatorRuntimeModel{Version: "13.7"}, host: macho.CpuArm64, want: macho.CpuAmd64},
+		{name: "runtime version 14", runtime: SimulatorRuntimeModel{Version: "14.0"}, host: macho.CpuArm64, want: macho.CpuArm64},
+		{name: "os version before 14", osVersion: "iOS 12.1", host: macho.CpuArm64, want: macho.CpuAmd64},
+		{name: "tvOS os version 14", osVersion: "tvOS 14.2", host: macho.CpuArm64, want: macho.CpuArm64},
+		{name: "unknown version", osVersion: "latest", host: macho.CpuArm64, want: macho.CpuArm64},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := simulatorRuntimeArchitecture(tt.runtime, tt.osVersion, tt.host); got != tt.want {
+				t.Errorf("simulatorRuntimeArchitecture() = %s, want %s", cpuName(got), cpuName(tt.want))
+			}
+		})
+	}
+}
+
+// TestExecutableArchitectures reads the Mach-O headers of the testdata/macho executables:
+// thin headers without load commands and a fat header holding them.
+func TestExecutableArchitectures(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    []macho.Cpu
+		wantErr bool
+	}{
+		{name: "arm64", want: []macho.Cpu{macho.CpuArm64}},
+		{name: "x86_64", want: []macho.Cpu{macho.CpuAmd64}},
+		{name: "universal", want: []macho.Cpu{macho.CpuAmd64, macho.CpuArm64}},
+		{name: "not_macho", wantErr: true},
+		{name: "missing", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := executableArchitectures(filepath.Join("testdata", "macho", tt.name))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("executableArchitectures() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("executableArchitectures() = %s, want %s", cpuList(got), cpuList(tt.want))
+			}
+		})
+	}
+}
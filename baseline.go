@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/bitrise-io/go-utils/fileutil"
+	"github.com/bitrise-io/go-utils/log"
+	"github.com/bitrise-io/go-utils/pathutil"
+)
+
+const (
+	durationDeltaPercentOutputKey = "BITRISE_CALABASH_DURATION_DELTA_PERCENT"
+
+	defaultDurationRegressionThresholdPercent = 20.0
+)
+
+// readBaselineSummary reads the run summary of a previous run,
+// summaries of a different major format version can not be compared.
+func readBaselineSummary(pth string) (RunSummaryModel, error) {
+	content, err := fileutil.ReadBytesFromFile(pth)
+	if err != nil {
+		return RunSummaryModel{}, err
+	}
+
+	var summary RunSummaryModel
+	if err := json.Unmarshal(content, &summary); err != nil {
+		return RunSummaryModel{}, fmt.Errorf("not a run summary, error: %s", err)
+	}
+	if summary.FormatVersion == "" {
+		return RunSummaryModel{}, fmt.Errorf("not a run summary, format_version is missing")
+	}
+
+	major := strings.Split(runSummaryFormatVersion, ".")[0]
+	if strings.Split(summary.FormatVersion, ".")[0] != major {
+		return RunSummaryModel{}, fmt.Errorf("incompatible format_version (%s), expected: %s.x", summary.FormatVersion, major)
+	}
+	return summary, nil
+}
+
+func deltaPercent(baseline, current int64) float64 {
+	return float64(current-baseline) / float64(baseline) * 100
+}
+
+// durationRegressionModel is a feature, which got slower than the regression threshold.
+type durationRegressionModel struct {
+	Feature      string
+	Baseline     time.Duration
+	Current      time.Duration
+	DeltaPercent float64
+}
+
+// featureDurationRegressions returns the features slowed by more than thresholdPercent compared to the baseline,
+// features missing from the baseline are not compared.
+func featureDurationRegressions(baseline, current []FeatureSummaryModel, thresholdPercent float64) []durationRegressionModel {
+	baselineDurations := map[string]int64{}
+	for _, feature := range baseline {
+		baselineDurations[feature.URI] = feature.DurationMs
+	}
+
+	regressions := []durationRegressionModel{}
+	for _, feature := range current {
+		baselineMs, ok := baselineDurations[feature.URI]
+		if !ok || baselineMs <= 0 {
+			continue
+		}
+
+		if delta := deltaPercent(baselineMs, feature.DurationMs); delta > thresholdPercent {
+			name := feature.Name
+			if name == "" {
+				name = feature.URI
+			}
+
+			regressions = append(regressions, durationRegressionModel{
+				Feature:      name,
+				Baseline:     time.Duration(baselineMs) * time.Millisecond,
+				Current:      time.Duration(feature.DurationMs) * time.Millisecond,
+				DeltaPercent: delta,
+			})
+		}
+	}
+	return regressions
+}
+
+// compareWithBaseline compares the run's total and per-feature durations with a previous run's summary
+// and prints the features slowed by more than the threshold. A missing or incompatible baseline is only reported.
+func (ctx *StepContext) compareWithBaseline() {
+	if ctx.Configs.BaselineSummaryPath == "" {
+		return
+	}
+
+	fmt.Println()
+	log.Infof("Comparing durations with the baseline...")
+
+	pth, err := pathutil.AbsPath(ctx.Configs.BaselineSummaryPath)
+	if err != nil {
+		log.Printf("Failed to expand baseline summary path (%s), skipping comparison, error: %s", ctx.Configs.BaselineSummaryPath, err)
+		return
+	}
+
+	if exist, err := pathutil.IsPathExists(pth); err != nil {
+		log.Printf("Failed to check if baseline summary exists at (%s), skipping comparison, error: %s", pth, err)
+		return
+	} else if !exist {
+		log.Printf("Baseline summary not found at: %s, skipping comparison", pth)
+		return
+	}
+
+	baseline, err := readBaselineSummary(pth)
+	if err != nil {
+		log.Printf("Baseline summary (%s) can not be compared: %s", pth, err)
+		return
+	}
+
+	if baseline.TotalDurationMs > 0 {
+		total := phaseTimer.Total()
+		delta := deltaPercent(baseline.TotalDurationMs, total.Milliseconds())
+
+		log.Printf("Total duration: %s, baseline: %s (%+.1f%%)", roundDuration(total), time.Duration(baseline.TotalDurationMs)*time.Millisecond, delta)
+		exportOutput(durationDeltaPercentOutputKey, fmt.Sprintf("%.1f", delta))
+	} else {
+		log.Printf("Baseline summary has no total duration")
+	}
+
+	if len(baseline.Features) == 0 {
+		log.Printf("Baseline summary has no feature durations, skipping the per-feature comparison")
+		return
+	}
+
+	regressions := featureDurationRegressions(baseline.Features, runSummary.Features, ctx.DurationRegressionThresholdPercent)
+	if len(regressions) == 0 {
+		log.Donef("No feature slowed by more than %.0f%%", ctx.DurationRegressionThresholdPercent)
+		return
+	}
+
+	log.Warnf("Features slowed by more than %.0f%%:", ctx.DurationRegressionThresholdPercent)
+	log.Printf("%-36s %12s %12s %8s", "Feature", "Baseline", "Current", "Delta")
+	for _, regression := range regressions {
+		log.Printf("%-36s %12s %12s %+7.1f%%", regression.Feature, regression.Baseline, regression.Current, regression.DeltaPercent)
+	}
+}
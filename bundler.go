@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// bundlerConflictHeaderExp matches the first line of a bundler version resolution error,
+// `Bundler could not find compatible versions for gem "X":` (bundler 1.x, 2.x) or `Could not find compatible versions` (bundler 2.4+).
+var bundlerConflictHeaderExp = regexp.MustCompile(`^(Bundler could not find compatible versions for gem "([^"]+)":|Could not find compatible versions)`)
+
+// bundlerConflictSuggestionPrefixes start the bundler's suggestions following the conflict explanation.
+var bundlerConflictSuggestionPrefixes = []string{
+	"Running `bundle update`",
+	"Deleting your Gemfile.lock",
+	"Bundler is unlocking",
+}
+
+// BundlerConflictModel is the explanation of a failed bundler version resolution.
+type BundlerConflictModel struct {
+	Explanation  string
+	Gems         []string
+	Requirements []string
+}
+
+// parseBundlerConflict extracts the conflict explanation from the bundle install output,
+// the block between the resolution error header and bundler's suggestions.
+func parseBundlerConflict(output string) (BundlerConflictModel, bool) {
+	lines := strings.Split(output, "\n")
+
+	start := -1
+	for i, line := range lines {
+		if bundlerConflictHeaderExp.MatchString(strings.TrimSpace(line)) {
+			start = i
+			break
+		}
+	}
+	if start == -1 {
+		return BundlerConflictModel{}, false
+	}
+
+	conflict := BundlerConflictModel{}
+	block := []string{}
+	for _, line := range lines[start:] {
+		if hasAnyPrefix(strings.TrimSpace(line), bundlerConflictSuggestionPrefixes) {
+			break
+		}
+		block = append(block, strings.TrimRight(line, " \r"))
+
+		if match := bundlerConflictHeaderExp.FindStringSubmatch(strings.TrimSpace(line)); len(match) == 3 && match[2] != "" {
+			conflict.Gems = append(conflict.Gems, match[2])
+		}
+	}
+	for len(block) > 0 && strings.TrimSpace(block[len(block)-1]) == "" {
+		block = block[:len(block)-1]
+	}
+
+	conflict.Explanation = strings.Join(block, "\n")
+	conflict.Requirements = bundlerConflictRequirements(block, conflict.Gems)
+	return conflict, true
+}
+
+// bundlerConflictRequirements collects the requirements of the conflicting gems from the explanation,
+// like `cucumber (~> 2.0)` (bundler 1.x, 2.x) or `depends on cucumber ~> 2.0` (bundler 2.4+).
+func bundlerConflictRequirements(block []string, gems []string) []string {
+	exps := []*regexp.Regexp{regexp.MustCompile(`depends on ([A-Za-z0-9_.-]+ [~<>=!]+ ?[0-9][0-9A-Za-z.]*)`)}
+	for _, gem := range gems {
+		exps = append(exps, regexp.MustCompile(`(?:^|\s)(`+regexp.QuoteMeta(gem)+` \([^)]*\))`))
+	}
+
+	requirements := []string{}
+	seen := map[string]bool{}
+	for _, line := range block {
+		for _, exp := range exps {
+			for _, match := range exp.FindAllStringSubmatch(line, -1) {
+				if requirement := match[1]; !seen[requirement] {
+					seen[requirement] = true
+					requirements = append(requirements, requirement)
+				}
+			}
+		}
+	}
+	return requirements
+}
+
+func hasAnyPrefix(s string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(s, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// String returns the conflicting requirements followed by bundler's explanation.
+func (conflict BundlerConflictModel) String() string {
+	lines := []string{}
+	if len(conflict.Requirements) > 0 {
+		lines = append(lines, "Conflicting requirements:")
+		for _, requirement := range conflict.Requirements {
+			lines = append(lines, fmt.Sprintf("  >> %s", requirement))
+		}
+		lines = append(lines, "")
+	}
+	lines = append(lines, conflict.Explanation)
+	return strings.Join(lines, "\n")
+}
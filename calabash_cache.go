@@ -0,0 +1,176 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/fileutil"
+	"github.com/bitrise-io/go-utils/log"
+	"github.com/bitrise-io/go-utils/pathutil"
+)
+
+const (
+	cacheIncludePathsEnvKey = "BITRISE_CACHE_INCLUDE_PATHS"
+
+	// calabashCacheVersionFileName stores the calabash-cucumber version the cached resources were downloaded with.
+	calabashCacheVersionFileName = ".bitrise_calabash_cucumber_version"
+)
+
+// calabashCacheSubdirs are the ~/.calabash subdirs downloaded by calabash-cucumber on the first run.
+var calabashCacheSubdirs = []string{"DeviceAgent"}
+
+func calabashDir() string {
+	return filepath.Join(pathutil.UserHomeDir(), ".calabash")
+}
+
+// logCalabashCache logs whether a (restored) ~/.calabash dir exists and its size.
+func logCalabashCache() {
+	dir := calabashDir()
+	if exist, err := pathutil.IsDirExists(dir); err != nil {
+		log.Warnf("Failed to check if calabash cache exists at (%s), error: %s", dir, err)
+		return
+	} else if !exist {
+		log.Printf("No cached calabash resources found at: %s", dir)
+		return
+	}
+
+	size, err := pathSize(dir)
+	if err != nil {
+		log.Warnf("Failed to get size of calabash cache (%s), error: %s", dir, err)
+		return
+	}
+	log.Printf("Cached calabash resources found at: %s (%.1f MB)", dir, float64(size)/1024/1024)
+}
+
+// calabashCucumberVersionInUse returns the calabash-cucumber version determined from the inputs or the Gemfile.lock,
+// falls back to the latest installed version, which is recorded in the run summary.
+func calabashCucumberVersionInUse() (string, error) {
+	if version := runSummary.Versions.CalabashCucumber; version != "" {
+		return version, nil
+	}
+
+	versions, err := installedCalabashVersions()
+	if err != nil {
+		return "", err
+	}
+	if len(versions) == 0 {
+		return "", fmt.Errorf("calabash-cucumber is not installed")
+	}
+
+	runSummary.Versions.CalabashCucumber = versions[0]
+	return versions[0], nil
+}
+
+// validateCalabashCache purges the cached calabash resources downloaded with a different calabash-cucumber version,
+// stale DeviceAgent binaries cause launch failures. Resources cached without a version marker are of an unknown version,
+// those are purged as well.
+func validateCalabashCache() {
+	dir := calabashDir()
+	cachedSubdirs := existingCalabashCacheSubdirs()
+	if len(cachedSubdirs) == 0 {
+		return
+	}
+
+	version, err := calabashCucumberVersionInUse()
+	if err != nil {
+		log.Warnf("Failed to determine the calabash-cucumber version, skipping calabash cache validation, error: %s", err)
+		return
+	}
+
+	versionFile := filepath.Join(dir, calabashCacheVersionFileName)
+	cachedVersion, found := cachedCalabashCucumberVersion(versionFile)
+	switch {
+	case !found:
+		log.Warnf("No calabash-cucumber version marker found in the calabash cache, the cached resources are of an unknown version, purging: %s", strings.Join(cachedSubdirs, ", "))
+	case gemVersionsEqual(cachedVersion, version):
+		log.Printf("Cached calabash resources match calabash-cucumber %s", version)
+		return
+	default:
+		log.Warnf("Cached calabash resources were downloaded with calabash-cucumber (%s), version in use: %s, purging: %s", cachedVersion, version, strings.Join(cachedSubdirs, ", "))
+	}
+
+	for _, subdir := range cachedSubdirs {
+		if err := os.RemoveAll(subdir); err != nil {
+			log.Warnf("Failed to remove (%s), error: %s", subdir, err)
+		}
+	}
+}
+
+// cachedCalabashCucumberVersion returns the calabash-cucumber version recorded in the version marker,
+// found is false if the marker is missing, empty or unreadable.
+func cachedCalabashCucumberVersion(versionFile string) (version string, found bool) {
+	if exist, err := pathutil.IsPathExists(versionFile); err != nil {
+		log.Warnf("Failed to check if the cached calabash-cucumber version exists at (%s), error: %s", versionFile, err)
+		return "", false
+	} else if !exist {
+		return "", false
+	}
+
+	content, err := fileutil.ReadStringFromFile(versionFile)
+	if err != nil {
+		log.Warnf("Failed to read the cached calabash-cucumber version, error: %s", err)
+		return "", false
+	}
+	version = strings.TrimSpace(content)
+	return version, version != ""
+}
+
+func existingCalabashCacheSubdirs() []string {
+	subdirs := []string{}
+	for _, name := range calabashCacheSubdirs {
+		subdir := filepath.Join(calabashDir(), name)
+		if exist, err := pathutil.IsDirExists(subdir); err != nil {
+			log.Warnf("Failed to check if path (%s) exists, error: %s", subdir, err)
+		} else if exist {
+			subdirs = append(subdirs, subdir)
+		}
+	}
+	return subdirs
+}
+
+// cacheCalabashDir records the calabash-cucumber version of the downloaded calabash resources
+// and appends them to the Bitrise cache include paths.
+func cacheCalabashDir() {
+	subdirs := existingCalabashCacheSubdirs()
+	if len(subdirs) == 0 {
+		return
+	}
+
+	fmt.Println()
+	log.Infof("Adding calabash resources to the cache...")
+
+	version, err := calabashCucumberVersionInUse()
+	if err != nil {
+		log.Warnf("Failed to determine the calabash-cucumber version, skipping caching, error: %s", err)
+		return
+	}
+
+	versionFile := filepath.Join(calabashDir(), calabashCacheVersionFileName)
+	if err := fileutil.WriteStringToFile(versionFile, version); err != nil {
+		log.Warnf("Failed to write (%s), skipping caching, error: %s", versionFile, err)
+		return
+	}
+
+	appendCacheIncludePaths(append(subdirs, versionFile))
+
+	for _, pth := range append(subdirs, versionFile) {
+		log.Printf("- %s", pth)
+	}
+}
+
+// appendCacheIncludePaths appends the paths to the Bitrise cache include paths.
+func appendCacheIncludePaths(paths []string) {
+	includePaths := []string{}
+	if current := os.Getenv(cacheIncludePathsEnvKey); current != "" {
+		includePaths = append(includePaths, current)
+	}
+	includePaths = append(includePaths, paths...)
+
+	value := strings.Join(includePaths, "\n")
+	if err := os.Setenv(cacheIncludePathsEnvKey, value); err != nil {
+		log.Warnf("Failed to set %s, error: %s", cacheIncludePathsEnvKey, err)
+	}
+	exportOutput(cacheIncludePathsEnvKey, value)
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestValidateCalabashCache(t *testing.T) {
+	previousHome := os.Getenv("HOME")
+	previousVersions := runSummary.Versions
+	defer func() {
+		if err := os.Setenv("HOME", previousHome); err != nil {
+			t.Error(err)
+		}
+		runSummary.Versions = previousVersions
+	}()
+	runSummary.Versions.CalabashCucumber = "0.21.10"
+
+	tests := []struct {
+		name       string
+		marker     *string
+		wantKept   bool
+		wantMarker string
+	}{
+		{name: "no version marker", marker: nil, wantKept: false, wantMarker: ""},
+		{name: "empty version marker", marker: stringPointer("\n"), wantKept: false, wantMarker: ""},
+		{name: "matching version", marker: stringPointer("0.21.10\n"), wantKept: true, wantMarker: "0.21.10"},
+		{name: "stale version", marker: stringPointer("0.20.5"), wantKept: false, wantMarker: "0.20.5"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			home, err := ioutil.TempDir("", "calabash_cache")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func() {
+				if err := os.RemoveAll(home); err != nil {
+					t.Error(err)
+				}
+			}()
+			if err := os.Setenv("HOME", home); err != nil {
+				t.Fatal(err)
+			}
+
+			deviceAgentDir := filepath.Join(home, ".calabash", "DeviceAgent")
+			if err := os.MkdirAll(deviceAgentDir, 0755); err != nil {
+				t.Fatal(err)
+			}
+			versionFile := filepath.Join(home, ".calabash", calabashCacheVersionFileName)
+			if tt.marker != nil {
+				if err := ioutil.WriteFile(versionFile, []byte(*tt.marker), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			validateCalabashCache()
+
+			if _, err := os.Stat(deviceAgentDir); os.IsNotExist(err) == tt.wantKept {
+				t.Errorf("DeviceAgent kept: %v, expected: %v", !os.IsNotExist(err), tt.wantKept)
+			}
+			content, err := ioutil.ReadFile(versionFile)
+			if err != nil && !os.IsNotExist(err) {
+				t.Fatal(err)
+			}
+			if marker := strings.TrimSpace(string(content)); marker != tt.wantMarker {
+				t.Errorf("version marker: %s, expected: %s", marker, tt.wantMarker)
+			}
+		})
+	}
+}
+
+func stringPointer(s string) *string {
+	return &s
+}
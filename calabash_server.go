@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bytes"
+	"debug/macho"
+	"fmt"
+	"io"
+	"net"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/bitrise-io/go-utils/fileutil"
+	"github.com/bitrise-io/go-utils/log"
+)
+
+// calabashServerPort is the port the calabash server embedded into the app listens on.
+const calabashServerPort = 37265
+
+// calabashServerErrorPatterns are the (lowercased) cucumber output lines of a failed calabash server connection.
+var calabashServerErrorPatterns = []string{
+	"unable to find calabash server",
+	"unable to connect to calabash server",
+	"unable to contact test server",
+	"could not connect to the calabash server",
+	"calabash server did not respond",
+}
+
+// calabashLaunchFailurePatterns are the (lowercased) cucumber output lines of an app, which did not launch or crashed on launch,
+// a failed calabash server connection counts as a launch failure too.
+var calabashLaunchFailurePatterns = []string{
+	"timed out waiting for the app to launch",
+	"unable to launch app",
+	"could not launch the app",
+	"app did not launch",
+	"launch timed out",
+}
+
+// calabashServerMarkers are embedded into the app binary by the calabash server framework.
+var calabashServerMarkers = [][]byte{[]byte("CalabashServer"), []byte("calabash-ios-server")}
+
+// CalabashServerErrorScanner passes the cucumber output through, while watching it for calabash server connection errors
+// and app launch failures.
+type CalabashServerErrorScanner struct {
+	out            io.Writer
+	line           []byte
+	detected       bool
+	launchFailures int
+}
+
+// NewCalabashServerErrorScanner ...
+func NewCalabashServerErrorScanner(out io.Writer) *CalabashServerErrorScanner {
+	return &CalabashServerErrorScanner{out: out}
+}
+
+func (s *CalabashServerErrorScanner) Write(p []byte) (int, error) {
+	s.line = append(s.line, p...)
+	for {
+		idx := bytes.IndexByte(s.line, '\n')
+		if idx == -1 {
+			break
+		}
+		s.scanLine(string(s.line[:idx]))
+		s.line = s.line[idx+1:]
+	}
+	return s.out.Write(p)
+}
+
+func (s *CalabashServerErrorScanner) scanLine(line string) {
+	line = strings.ToLower(line)
+	for _, pattern := range calabashServerErrorPatterns {
+		if strings.Contains(line, pattern) {
+			s.detected = true
+			s.launchFailures++
+			return
+		}
+	}
+	for _, pattern := range calabashLaunchFailurePatterns {
+		if strings.Contains(line, pattern) {
+			s.launchFailures++
+			return
+		}
+	}
+}
+
+// Detected reports whether a calabash server connection error was printed.
+func (s *CalabashServerErrorScanner) Detected() bool {
+	if len(s.line) > 0 {
+		s.scanLine(string(s.line))
+		s.line = nil
+	}
+	return s.detected
+}
+
+// LaunchFailures returns the number of the printed app launch failures (and calabash server connection errors).
+func (s *CalabashServerErrorScanner) LaunchFailures() int {
+	s.Detected()
+	return s.launchFailures
+}
+
+// appExecutablePath returns the app's executable, which is named after the app bundle by default.
+func appExecutablePath(appPath string) string {
+	return filepath.Join(appPath, strings.TrimSuffix(filepath.Base(appPath), ".app"))
+}
+
+// appContainsCalabashServer checks the app executable for the calabash server framework.
+func appContainsCalabashServer(executablePath string) (bool, error) {
+	content, err := fileutil.ReadBytesFromFile(executablePath)
+	if err != nil {
+		return false, err
+	}
+	for _, marker := range calabashServerMarkers {
+		if bytes.Contains(content, marker) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// simulatorArchitecture returns the Mach-O cpu type the simulator runs on this host.
+func simulatorArchitecture() macho.Cpu {
+	if runtime.GOARCH == "arm64" {
+		return macho.CpuArm64
+	}
+	return macho.CpuAmd64
+}
+
+// executableArchitectures returns the cpu types of a thin or fat Mach-O executable.
+func executableArchitectures(executablePath string) ([]macho.Cpu, error) {
+	if fat, err := macho.OpenFat(executablePath); err == nil {
+		defer func() {
+			if err := fat.Close(); err != nil {
+				log.Warnf("Failed to close (%s), error: %s", executablePath, err)
+			}
+		}()
+
+		cpus := []macho.Cpu{}
+		for _, arch := range fat.Arches {
+			cpus = append(cpus, arch.Cpu)
+		}
+		return cpus, nil
+	}
+
+	thin, err := macho.Open(executablePath)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := thin.Close(); err != nil {
+			log.Warnf("Failed to close (%s), error: %s", executablePath, err)
+		}
+	}()
+	return []macho.Cpu{thin.Cpu}, nil
+}
+
+func isPortListening(port int) bool {
+	conn, err := net.DialTimeout("tcp", fmt.Sprintf("127.0.0.1:%d", port), time.Second)
+	if err != nil {
+		return false
+	}
+	if err := conn.Close(); err != nil {
+		log.Warnf("Failed to close connection, error: %s", err)
+	}
+	return true
+}
+
+// printCalabashServerDiagnosis prints the likely causes of a failed calabash server connection.
+func printCalabashServerDiagnosis(appPath string) {
+	fmt.Println()
+	log.Errorf("Cucumber failed to connect to the calabash server embedded into the app, diagnosis:")
+
+	if appPath == "" {
+		log.Warnf("- App path is not set, the app's calabash server and architecture can not be checked.")
+		log.Printf("  Set the app_path input to the simulator build of the app linked with the calabash framework.")
+	} else {
+		executablePath := appExecutablePath(appPath)
+
+		if linked, err := appContainsCalabashServer(executablePath); err != nil {
+			log.Warnf("- Failed to check the app executable (%s) for the calabash server: %s", executablePath, err)
+		} else if linked {
+			log.Donef("- The app contains the calabash server.")
+		} else {
+			log.Errorf("- The app does not contain the calabash server.")
+			log.Printf("  Link the calabash framework into the app (for example in a dedicated -cal target or build configuration)")
+			log.Printf("  and make sure app_path points to that build.")
+		}
+
+		simulatorArch := simulatorArchitecture()
+		if archs, err := executableArchitectures(executablePath); err != nil {
+			log.Warnf("- Failed to read the app executable's architectures: %s", err)
+		} else if cpuInList(simulatorArch, archs) {
+			log.Donef("- The app is built for the simulator architecture (%s).", cpuName(simulatorArch))
+		} else {
+			log.Errorf("- The app is built for %s, but the simulator runs %s.", cpuList(archs), cpuName(simulatorArch))
+			log.Printf("  Build the app with the iphonesimulator SDK for the %s architecture.", cpuName(simulatorArch))
+		}
+	}
+
+	if isPortListening(calabashServerPort) {
+		log.Donef("- A server is listening on the calabash server port (%d).", calabashServerPort)
+	} else {
+		log.Errorf("- Nothing is listening on the calabash server port (%d).", calabashServerPort)
+		log.Printf("  The app either did not launch on the simulator or crashed on launch, check the simulator log in the diagnostics bundle.")
+	}
+}
+
+func cpuInList(cpu macho.Cpu, cpus []macho.Cpu) bool {
+	for _, c := range cpus {
+		if c == cpu {
+			return true
+		}
+	}
+	return false
+}
+
+func cpuName(cpu macho.Cpu) string {
+	switch cpu {
+	case macho.Cpu386:
+		return "i386"
+	case macho.CpuAmd64:
+		return "x86_64"
+	case macho.CpuArm:
+		return "armv7"
+	case macho.CpuArm64:
+		return "arm64"
+	}
+	return cpu.String()
+}
+
+func cpuList(cpus []macho.Cpu) string {
+	names := []string{}
+	for _, cpu := range cpus {
+		names = append(names, cpuName(cpu))
+	}
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/bitrise-io/go-utils/log"
+)
+
+type cleanupModel struct {
+	name string
+	fn   func() error
+}
+
+// The cleanup registry: the phases register their cleanup obligations (network profile, app log stream, tmp dirs),
+// exit runs them on every exit path (success, failure, abort, crash and timeout).
+var (
+	cleanups     []cleanupModel
+	cleanupsLock sync.Mutex
+)
+
+// registerCleanup registers a cleanup to run when the step finishes.
+func registerCleanup(name string, fn func() error) {
+	cleanupsLock.Lock()
+	defer cleanupsLock.Unlock()
+
+	cleanups = append(cleanups, cleanupModel{name: name, fn: fn})
+}
+
+// hasCleanups reports whether any cleanup is registered and not run yet.
+func hasCleanups() bool {
+	cleanupsLock.Lock()
+	defer cleanupsLock.Unlock()
+
+	return len(cleanups) > 0
+}
+
+// popCleanup removes and returns the last registered cleanup.
+func popCleanup() (cleanupModel, bool) {
+	cleanupsLock.Lock()
+	defer cleanupsLock.Unlock()
+
+	if len(cleanups) == 0 {
+		return cleanupModel{}, false
+	}
+	cleanup := cleanups[len(cleanups)-1]
+	cleanups = cleanups[:len(cleanups)-1]
+	return cleanup, true
+}
+
+// runCleanup runs the cleanup, a crashing cleanup is returned as an error.
+func runCleanup(cleanup cleanupModel) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("cleanup crashed: %v", r)
+		}
+	}()
+	return cleanup.fn()
+}
+
+// runCleanups runs the registered cleanups in reverse registration order, each cleanup runs only once.
+// A failing cleanup is logged as a warning and does not prevent the rest from running.
+func runCleanups() {
+	for {
+		cleanup, ok := popCleanup()
+		if !ok {
+			return
+		}
+
+		start := time.Now()
+		if err := runCleanup(cleanup); err != nil {
+			log.Warnf("Cleanup (%s) failed after %s, error: %s", cleanup.name, roundDuration(time.Since(start)), err)
+		} else {
+			log.Printf("Cleanup (%s) done in %s", cleanup.name, roundDuration(time.Since(start)))
+		}
+	}
+}
+
+// handleAbort fails the step when it is aborted by SIGINT or SIGTERM (for example an aborted build),
+// so that the registered cleanups run before the step exits.
+func handleAbort() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+
+	go func() {
+		sig := <-signals
+
+		fmt.Println()
+		interruptStep(newStepError(categoryAborted, "Step aborted by signal: %s", sig))
+	}()
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/bitrise-io/go-utils/command"
+	"github.com/bitrise-io/go-utils/fileutil"
+	shellquote "github.com/kballard/go-shellquote"
+)
+
+const commandsLogFileName = "commands.log"
+
+// CommandRecordModel ...
+type CommandRecordModel struct {
+	Args      []string
+	Dir       string
+	Envs      []string
+	StartTime time.Time
+	Duration  time.Duration
+	ExitCode  int
+}
+
+// CommandRecorder runs commands and records them, to be able to reproduce the step's commands locally.
+// The commands run in their own process group, so that a running command can be killed with its child processes.
+type CommandRecorder struct {
+	lock       sync.Mutex
+	records    []CommandRecordModel
+	runningPid int
+	killed     bool
+
+	// runInterruptErr fails the commands of an interrupted matrix run, until the next run resumes
+	runInterruptErr error
+}
+
+// record runs and records the command. The step exits at the command if the step is interrupted:
+// the step body does not start a new command after an interrupt, and stops once the killed command returns.
+// A command of an interrupted matrix run is not started, and the killed command fails with the run's interrupt error.
+func (r *CommandRecorder) record(cmd *command.Model) error {
+	checkStepInterrupt()
+
+	if err := r.runInterruptError(); err != nil {
+		return err
+	}
+
+	execCmd := cmd.GetCmd()
+	record := CommandRecordModel{
+		Args:      execCmd.Args,
+		Dir:       execCmd.Dir,
+		Envs:      commandEnvOverrides(cmd),
+		StartTime: time.Now(),
+	}
+
+	execCmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+
+	err := r.start(execCmd)
+	if err == nil {
+		err = execCmd.Wait()
+		r.setRunningPid(0)
+	}
+
+	record.Duration = time.Since(record.StartTime)
+	record.ExitCode = exitCodeOf(err)
+
+	r.lock.Lock()
+	r.records = append(r.records, record)
+	r.lock.Unlock()
+
+	checkStepInterrupt()
+
+	if interruptErr := r.runInterruptError(); interruptErr != nil && err != nil {
+		return interruptErr
+	}
+	return err
+}
+
+// start starts the command and makes it the running command. The pid is only read by the starting goroutine,
+// and a command started after an interrupt is killed right away, the interrupt might not have seen it running.
+func (r *CommandRecorder) start(cmd *exec.Cmd) error {
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.runningPid = cmd.Process.Pid
+	if stepInterruptError() != nil || r.runInterruptErr != nil {
+		return r.killRunning()
+	}
+	return nil
+}
+
+func (r *CommandRecorder) setRunningPid(pid int) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.runningPid = pid
+}
+
+// KillRunning kills the currently running command and its child processes.
+func (r *CommandRecorder) KillRunning() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	return r.killRunning()
+}
+
+func (r *CommandRecorder) killRunning() error {
+	if r.runningPid == 0 {
+		return nil
+	}
+	r.killed = true
+	return syscall.Kill(-r.runningPid, syscall.SIGKILL)
+}
+
+// InterruptRun kills the running command of the matrix run, the run's later commands fail with err without being started.
+func (r *CommandRecorder) InterruptRun(err error) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.runInterruptErr = err
+	return r.killRunning()
+}
+
+// ResumeRun lets the next matrix run's commands run after an interrupted run.
+func (r *CommandRecorder) ResumeRun() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.runInterruptErr = nil
+	r.killed = false
+}
+
+func (r *CommandRecorder) runInterruptError() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.runInterruptErr
+}
+
+// Killed reports whether a running command was killed by KillRunning.
+func (r *CommandRecorder) Killed() bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.killed
+}
+
+// Run ...
+func (r *CommandRecorder) Run(cmd *command.Model) error {
+	return r.record(cmd)
+}
+
+// RunAndReturnTrimmedCombinedOutput ...
+func (r *CommandRecorder) RunAndReturnTrimmedCombinedOutput(cmd *command.Model) (string, error) {
+	var out bytes.Buffer
+	cmd.SetStdout(&out).SetStderr(&out)
+	err := r.record(cmd)
+	return strings.TrimSpace(out.String()), err
+}
+
+// Start starts the command in the background, the command is recorded when it exits.
+// The returned channel receives the command's error when it exits.
+func (r *CommandRecorder) Start(cmd *command.Model) (<-chan error, error) {
+	if err := r.runInterruptError(); err != nil {
+		return nil, err
+	}
+
+	execCmd := cmd.GetCmd()
+	record := CommandRecordModel{
+		Args:      execCmd.Args,
+		Dir:       execCmd.Dir,
+		Envs:      commandEnvOverrides(cmd),
+		StartTime: time.Now(),
+	}
+
+	execCmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+
+	addRecord := func(err error) {
+		record.Duration = time.Since(record.StartTime)
+		record.ExitCode = exitCodeOf(err)
+
+		r.lock.Lock()
+		r.records = append(r.records, record)
+		r.lock.Unlock()
+	}
+
+	if err := execCmd.Start(); err != nil {
+		addRecord(err)
+		return nil, err
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		err := execCmd.Wait()
+		addRecord(err)
+		done <- err
+	}()
+	return done, nil
+}
+
+// exitCodeOf returns the exit code of a finished command, or -1 if the command could not be started.
+func exitCodeOf(err error) int {
+	if err == nil {
+		return 0
+	}
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode()
+	}
+	return -1
+}
+
+// ShellScript returns the recorded commands as a shell script like content.
+func (r *CommandRecorder) ShellScript() string {
+	lines := []string{
+		"#!/usr/bin/env bash",
+		"# Commands executed by the Calabash iOS UI Test step",
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	for _, record := range r.records {
+		lines = append(lines, "")
+		lines = append(lines, fmt.Sprintf("# started: %s, duration: %s, exit code: %d", record.StartTime.Format(time.RFC3339), roundDuration(record.Duration), record.ExitCode))
+
+		cmdLine := shellquote.Join(record.Args...)
+		if len(record.Envs) > 0 {
+			cmdLine = strings.Join(quoteEnvs(record.Envs), " ") + " " + cmdLine
+		}
+		if record.Dir != "" {
+			cmdLine = fmt.Sprintf("(cd %s && %s)", shellquote.Join(record.Dir), cmdLine)
+		}
+		lines = append(lines, cmdLine)
+	}
+
+	return strings.Join(lines, "\n") + "\n"
+}
+
+func quoteEnvs(envs []string) []string {
+	quoted := []string{}
+	for _, env := range envs {
+		key, value := splitEnv(env)
+		quoted = append(quoted, key+"="+shellquote.Join(value))
+	}
+	return quoted
+}
+
+// WriteToDir writes the commands log into the given dir and returns the file's path.
+func (r *CommandRecorder) WriteToDir(dir string) (string, error) {
+	pth := filepath.Join(dir, commandsLogFileName)
+	return pth, fileutil.WriteStringToFile(pth, r.ShellScript())
+}
+
+var commandRecorder = &CommandRecorder{}
+
+// runCommand prints, runs and records the command.
+func runCommand(cmd *command.Model) error {
+	printCommand(cmd)
+	return commandRecorder.Run(cmd)
+}
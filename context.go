@@ -0,0 +1,391 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bitrise-io/go-utils/log"
+	"github.com/bitrise-io/go-utils/pathutil"
+	"github.com/bitrise-io/go-xcode/simulator"
+	shellquote "github.com/kballard/go-shellquote"
+)
+
+// StepContext holds the configs and the values resolved by the step's phases.
+type StepContext struct {
+	Configs       ConfigsModel
+	Options       []string
+	WorkDir       string
+	FeaturesDir   string
+	RerunFilePath string
+
+	XcodeDeveloperDirPath string
+
+	Simulator          simulator.InfoModel
+	SimulatorOsVersion string
+	SimulatorRuntime   SimulatorRuntimeModel
+	SimulatorReused    bool
+	DeviceTarget       string
+
+	AppPath            string
+	AdditionalAppPaths []string
+	AppMinSizeKB       int
+
+	AppLaunchArguments     []string
+	AppLaunchEnvironment   []string
+	ArchitectureLaunchArgs []string
+
+	GemFilePath             string
+	UseBundler              bool
+	UseSandbox              bool
+	LockfilePlatformMissing bool
+	CalabashCucumberVersion string
+	CucumberVersion         string
+
+	SimulatorDevices  []string
+	Device            string
+	CalabashInstalled bool
+
+	ScenarioNameFilter []string
+	ExcludePatterns    []string
+	WipTags            []string
+	ReportTags         []string
+	OrderedFeatures    []string
+	TargetOverrides    []TargetOverrideModel
+
+	LanguageMatrix []string
+	Locale         string
+	Attempt        int
+
+	JSONReportPath              string
+	ScenarioResults             []ScenarioResultModel
+	CalabashServerErrorDetected bool
+	LaunchFailureCount          int
+
+	DurationRegressionThresholdPercent float64
+	MinSelectedScenariosPercent        float64
+
+	StepTimeout              time.Duration
+	MatrixRunTimeout         time.Duration
+	ProgressInterval         time.Duration
+	StepRetryCount           int
+	DiagnosticsSizeLimitInMB int
+
+	PreRunScriptPath     string
+	PreRunScriptTimeout  time.Duration
+	PostRunScriptPath    string
+	PostRunScriptTimeout time.Duration
+}
+
+// newStepContext validates the configs and expands the input paths.
+func newStepContext(configs ConfigsModel) (*StepContext, error) {
+	if err := configs.validate(); err != nil {
+		return nil, newStepError(categoryInvalidInput, "Issue with input: %s", err)
+	}
+
+	simulatorDevices, err := parseSimulatorDevices(configs.SimulatorDevices)
+	if err != nil {
+		return nil, newStepError(categoryInvalidInput, "Issue with input: %s", err)
+	}
+
+	// the simulator devices override the simulator device, each of them is split by the device matrix run
+	if len(simulatorDevices) > 0 {
+		log.Printf("SimulatorDevices set, running the suite on: %s", strings.Join(simulatorDevices, ", "))
+	} else if name, osVersion, ok := splitSimulatorDevice(configs.SimulatorDevice, platformOrDefault(configs.Platform)); ok {
+		log.Printf("SimulatorDevice (%s) contains an OS version, using device: %s, OS version: %s", configs.SimulatorDevice, name, osVersion)
+
+		configs.SimulatorDevice = name
+		configs.SimulatorOsVersion = osVersion
+	}
+
+	appLaunchArguments, err := shellquote.Split(configs.AppLaunchArguments)
+	if err != nil {
+		return nil, newStepError(categoryInvalidInput, "Failed to split app launch arguments (%s), error: %s", configs.AppLaunchArguments, err)
+	}
+
+	appLaunchEnvironment, err := parseAppLaunchEnvironment(configs.AppLaunchEnvironment)
+	if err != nil {
+		return nil, newStepError(categoryInvalidInput, "Issue with input: %s", err)
+	}
+	if configs.DisableEnvExpansion != "yes" {
+		appLaunchEnvironment = expandAppLaunchEnvironment(appLaunchEnvironment)
+	}
+
+	languageMatrix, err := parseLanguageMatrix(configs.LanguageMatrix)
+	if err != nil {
+		return nil, newStepError(categoryInvalidInput, "Issue with input: %s", err)
+	}
+
+	additionalAppPaths, err := parseAdditionalAppPaths(configs.AdditionalAppPaths)
+	if err != nil {
+		return nil, newStepError(categoryInvalidInput, "Issue with input: %s", err)
+	}
+
+	scenarioNameFilter, err := parseScenarioNameFilter(configs.ScenarioNameFilter)
+	if err != nil {
+		return nil, newStepError(categoryInvalidInput, "Issue with input: %s", err)
+	}
+
+	excludePatterns, err := parseExcludePatterns(configs.ExcludePatterns)
+	if err != nil {
+		return nil, newStepError(categoryInvalidInput, "Issue with input: %s", err)
+	}
+
+	wipTags, err := parseWipTags(configs.WipTags)
+	if err != nil {
+		return nil, newStepError(categoryInvalidInput, "Issue with input: %s", err)
+	}
+
+	reportTags, err := parseReportTags(configs.ReportTags)
+	if err != nil {
+		return nil, newStepError(categoryInvalidInput, "Issue with input: %s", err)
+	}
+
+	workDir, err := pathutil.AbsPath(configs.WorkDir)
+	if err != nil {
+		return nil, newStepError(categoryInvalidInput, "Failed to expand WorkDir (%s), error: %s", configs.WorkDir, err)
+	}
+
+	featuresDir := ""
+	if configs.FeaturesDir != "" {
+		featuresDir, err = pathutil.AbsPath(configs.FeaturesDir)
+		if err != nil {
+			return nil, newStepError(categoryInvalidInput, "Failed to expand FeaturesDir (%s), error: %s", configs.FeaturesDir, err)
+		}
+	}
+
+	orderedFeatures := []string{}
+	if configs.FeatureOrderFile != "" {
+		listedFeatures, err := parseFeatureOrderFile(configs.FeatureOrderFile, workDir)
+		if err != nil {
+			return nil, newStepError(categoryInvalidInput, "Issue with input: %s", err)
+		}
+
+		searchDir := featuresDir
+		if searchDir == "" {
+			searchDir = filepath.Join(workDir, "features")
+		}
+		if orderedFeatures, err = orderFeatures(listedFeatures, searchDir, workDir, configs.UnlistedFeatures); err != nil {
+			return nil, newStepError(categoryInvalidInput, "Issue with input: %s", err)
+		}
+	}
+
+	rerunFilePath := ""
+	if configs.RerunFile != "" {
+		rerunFilePath, err = pathutil.AbsPath(configs.RerunFile)
+		if err != nil {
+			return nil, newStepError(categoryInvalidInput, "Failed to expand RerunFile (%s), error: %s", configs.RerunFile, err)
+		}
+	}
+
+	gemFilePath := ""
+	if configs.GemFilePath != "" {
+		gemFilePath, err = pathutil.AbsPath(configs.GemFilePath)
+		if err != nil {
+			return nil, newStepError(categoryInvalidInput, "Failed to expand GemFilePath (%s), error: %s", configs.GemFilePath, err)
+		}
+	}
+
+	xcodeDeveloperDirPath := ""
+	if configs.XcodeDeveloperDirPath != "" {
+		xcodeDeveloperDirPath, err = pathutil.AbsPath(configs.XcodeDeveloperDirPath)
+		if err != nil {
+			return nil, newStepError(categoryInvalidInput, "Failed to expand XcodeDeveloperDirPath (%s), error: %s", configs.XcodeDeveloperDirPath, err)
+		}
+	}
+
+	var stepTimeout time.Duration
+	if configs.StepTimeoutMinutes != "" {
+		minutes, err := strconv.Atoi(configs.StepTimeoutMinutes)
+		if err != nil || minutes < 0 {
+			return nil, newStepError(categoryInvalidInput, "Issue with input: invalid StepTimeoutMinutes (%s), should be a non-negative integer", configs.StepTimeoutMinutes)
+		}
+		stepTimeout = time.Duration(minutes) * time.Minute
+	}
+
+	var matrixRunTimeout time.Duration
+	if configs.MatrixRunTimeoutMinutes != "" {
+		minutes, err := strconv.Atoi(configs.MatrixRunTimeoutMinutes)
+		if err != nil || minutes < 0 {
+			return nil, newStepError(categoryInvalidInput, "Issue with input: invalid MatrixRunTimeoutMinutes (%s), should be a non-negative integer", configs.MatrixRunTimeoutMinutes)
+		}
+		matrixRunTimeout = time.Duration(minutes) * time.Minute
+	}
+
+	appMinSizeKB := defaultAppMinSizeKB
+	if configs.AppMinSizeKB != "" {
+		appMinSizeKB, err = strconv.Atoi(configs.AppMinSizeKB)
+		if err != nil || appMinSizeKB < 0 {
+			return nil, newStepError(categoryInvalidInput, "Issue with input: invalid AppMinSizeKB (%s), should be a non-negative integer", configs.AppMinSizeKB)
+		}
+	}
+
+	progressInterval := defaultProgressInterval
+	if configs.ProgressIntervalSeconds != "" {
+		seconds, err := strconv.Atoi(configs.ProgressIntervalSeconds)
+		if err != nil || seconds < 0 {
+			return nil, newStepError(categoryInvalidInput, "Issue with input: invalid ProgressIntervalSeconds (%s), should be a non-negative integer", configs.ProgressIntervalSeconds)
+		}
+		progressInterval = time.Duration(seconds) * time.Second
+	}
+
+	durationRegressionThresholdPercent := defaultDurationRegressionThresholdPercent
+	if configs.DurationRegressionThresholdPercent != "" {
+		durationRegressionThresholdPercent, err = strconv.ParseFloat(configs.DurationRegressionThresholdPercent, 64)
+		if err != nil || durationRegressionThresholdPercent < 0 {
+			return nil, newStepError(categoryInvalidInput, "Issue with input: invalid DurationRegressionThresholdPercent (%s), should be a non-negative number", configs.DurationRegressionThresholdPercent)
+		}
+	}
+
+	minSelectedScenariosPercent := defaultMinSelectedScenariosPercent
+	if configs.MinSelectedScenariosPercent != "" {
+		minSelectedScenariosPercent, err = strconv.ParseFloat(configs.MinSelectedScenariosPercent, 64)
+		if err != nil || minSelectedScenariosPercent < 0 || minSelectedScenariosPercent > 100 {
+			return nil, newStepError(categoryInvalidInput, "Issue with input: invalid MinSelectedScenariosPercent (%s), should be a number between 0 and 100", configs.MinSelectedScenariosPercent)
+		}
+	}
+
+	stepRetryCount := 0
+	if configs.StepRetryCount != "" {
+		stepRetryCount, err = strconv.Atoi(configs.StepRetryCount)
+		if err != nil || stepRetryCount < 0 {
+			return nil, newStepError(categoryInvalidInput, "Issue with input: invalid StepRetryCount (%s), should be a non-negative integer", configs.StepRetryCount)
+		}
+	}
+
+	diagnosticsSizeLimitInMB := defaultDiagnosticsSizeLimitInMB
+	if configs.DiagnosticsSizeLimitInMB != "" {
+		diagnosticsSizeLimitInMB, err = strconv.Atoi(configs.DiagnosticsSizeLimitInMB)
+		if err != nil || diagnosticsSizeLimitInMB <= 0 {
+			return nil, newStepError(categoryInvalidInput, "Issue with input: invalid DiagnosticsSizeLimitInMB (%s), should be a positive integer", configs.DiagnosticsSizeLimitInMB)
+		}
+	}
+
+	preRunScriptPath := ""
+	if configs.PreRunScriptPath != "" {
+		preRunScriptPath, err = pathutil.AbsPath(configs.PreRunScriptPath)
+		if err != nil {
+			return nil, newStepError(categoryInvalidInput, "Failed to expand PreRunScriptPath (%s), error: %s", configs.PreRunScriptPath, err)
+		}
+	}
+
+	preRunScriptTimeout := defaultScriptHookTimeout
+	if configs.PreRunScriptTimeoutSeconds != "" {
+		seconds, err := strconv.Atoi(configs.PreRunScriptTimeoutSeconds)
+		if err != nil || seconds <= 0 {
+			return nil, newStepError(categoryInvalidInput, "Issue with input: invalid PreRunScriptTimeoutSeconds (%s), should be a positive integer", configs.PreRunScriptTimeoutSeconds)
+		}
+		preRunScriptTimeout = time.Duration(seconds) * time.Second
+	}
+
+	postRunScriptPath := ""
+	if configs.PostRunScriptPath != "" {
+		postRunScriptPath, err = pathutil.AbsPath(configs.PostRunScriptPath)
+		if err != nil {
+			return nil, newStepError(categoryInvalidInput, "Failed to expand PostRunScriptPath (%s), error: %s", configs.PostRunScriptPath, err)
+		}
+	}
+
+	postRunScriptTimeout := defaultScriptHookTimeout
+	if configs.PostRunScriptTimeoutSeconds != "" {
+		seconds, err := strconv.Atoi(configs.PostRunScriptTimeoutSeconds)
+		if err != nil || seconds <= 0 {
+			return nil, newStepError(categoryInvalidInput, "Issue with input: invalid PostRunScriptTimeoutSeconds (%s), should be a positive integer", configs.PostRunScriptTimeoutSeconds)
+		}
+		postRunScriptTimeout = time.Duration(seconds) * time.Second
+	}
+
+	return &StepContext{
+		Configs:                            configs,
+		Options:                            configs.ParsedOptions,
+		WorkDir:                            workDir,
+		FeaturesDir:                        featuresDir,
+		RerunFilePath:                      rerunFilePath,
+		XcodeDeveloperDirPath:              xcodeDeveloperDirPath,
+		AppPath:                            configs.AppPath,
+		AdditionalAppPaths:                 additionalAppPaths,
+		AppMinSizeKB:                       appMinSizeKB,
+		AppLaunchArguments:                 appLaunchArguments,
+		AppLaunchEnvironment:               appLaunchEnvironment,
+		SimulatorDevices:                   simulatorDevices,
+		ScenarioNameFilter:                 scenarioNameFilter,
+		ExcludePatterns:                    excludePatterns,
+		WipTags:                            wipTags,
+		ReportTags:                         reportTags,
+		OrderedFeatures:                    orderedFeatures,
+		LanguageMatrix:                     languageMatrix,
+		GemFilePath:                        gemFilePath,
+		DurationRegressionThresholdPercent: durationRegressionThresholdPercent,
+		MinSelectedScenariosPercent:        minSelectedScenariosPercent,
+		StepTimeout:                        stepTimeout,
+		MatrixRunTimeout:                   matrixRunTimeout,
+		ProgressInterval:                   progressInterval,
+		StepRetryCount:                     stepRetryCount,
+		DiagnosticsSizeLimitInMB:           diagnosticsSizeLimitInMB,
+		PreRunScriptPath:                   preRunScriptPath,
+		PreRunScriptTimeout:                preRunScriptTimeout,
+		PostRunScriptPath:                  postRunScriptPath,
+		PostRunScriptTimeout:               postRunScriptTimeout,
+	}, nil
+}
+
+// tearDownAttempt terminates the leftover test runners, resets the simulator and removes the values resolved by the failed attempt,
+// so that the next attempt starts from scratch. The locale of the language matrix run is kept,
+// the attempt's temp dirs are kept under the run temp dir for debugging.
+func (ctx *StepContext) tearDownAttempt() {
+	terminateLeftoverTestRunners()
+
+	if ctx.SimulatorReused {
+		log.Printf("Keeping the reused booted simulator (%s) as it is", ctx.Simulator.ID)
+	} else if ctx.Simulator.ID != "" {
+		if err := ctx.resetSimulator(); err != nil {
+			log.Warnf("%s", err)
+		}
+	}
+
+	ctx.resetAttemptState()
+
+	fmt.Println()
+	log.Donef("Attempt torn down")
+}
+
+// resetAttemptState removes the values resolved by the previous attempt, the inputs, the language and the device are kept.
+func (ctx *StepContext) resetAttemptState() {
+	*ctx = StepContext{
+		Configs:                            ctx.Configs,
+		Options:                            ctx.Options,
+		WorkDir:                            ctx.WorkDir,
+		FeaturesDir:                        ctx.FeaturesDir,
+		RerunFilePath:                      ctx.RerunFilePath,
+		XcodeDeveloperDirPath:              ctx.XcodeDeveloperDirPath,
+		AppPath:                            ctx.Configs.AppPath,
+		AdditionalAppPaths:                 ctx.AdditionalAppPaths,
+		AppMinSizeKB:                       ctx.AppMinSizeKB,
+		AppLaunchArguments:                 ctx.AppLaunchArguments,
+		AppLaunchEnvironment:               ctx.AppLaunchEnvironment,
+		SimulatorDevices:                   ctx.SimulatorDevices,
+		Device:                             ctx.Device,
+		ScenarioNameFilter:                 ctx.ScenarioNameFilter,
+		ExcludePatterns:                    ctx.ExcludePatterns,
+		WipTags:                            ctx.WipTags,
+		ReportTags:                         ctx.ReportTags,
+		OrderedFeatures:                    ctx.OrderedFeatures,
+		TargetOverrides:                    ctx.TargetOverrides,
+		LanguageMatrix:                     ctx.LanguageMatrix,
+		Locale:                             ctx.Locale,
+		GemFilePath:                        ctx.GemFilePath,
+		DurationRegressionThresholdPercent: ctx.DurationRegressionThresholdPercent,
+		MinSelectedScenariosPercent:        ctx.MinSelectedScenariosPercent,
+		StepTimeout:                        ctx.StepTimeout,
+		MatrixRunTimeout:                   ctx.MatrixRunTimeout,
+		ProgressInterval:                   ctx.ProgressInterval,
+		StepRetryCount:                     ctx.StepRetryCount,
+		DiagnosticsSizeLimitInMB:           ctx.DiagnosticsSizeLimitInMB,
+		PreRunScriptPath:                   ctx.PreRunScriptPath,
+		PreRunScriptTimeout:                ctx.PreRunScriptTimeout,
+		PostRunScriptPath:                  ctx.PostRunScriptPath,
+		PostRunScriptTimeout:               ctx.PostRunScriptTimeout,
+	}
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/bitrise-io/go-steputils/command/rubycommand"
+	"github.com/bitrise-io/go-utils/command"
+	"github.com/bitrise-io/go-utils/fileutil"
+	"github.com/bitrise-io/go-utils/log"
+	"github.com/bitrise-io/go-utils/pathutil"
+)
+
+// cucumberOutPaths returns the absolute paths of the --out options of the additional options.
+func (ctx *StepContext) cucumberOutPaths() []string {
+	pths := []string{}
+	for _, formatter := range ctx.cucumberFormatters() {
+		if formatter.Out != "" {
+			pths = append(pths, formatter.Out)
+		}
+	}
+	return pths
+}
+
+// cucumberColorArgs returns the cucumber arguments of the no_color and force_color inputs,
+// cucumber colors its output by its own terminal detection otherwise.
+func (ctx *StepContext) cucumberColorArgs() []string {
+	if ctx.Configs.NoColor == "yes" {
+		return []string{"--no-color"}
+	} else if ctx.Configs.ForceColor == "yes" {
+		return []string{"--color"}
+	}
+	return nil
+}
+
+// cucumberColorEnvs disables the colors of the tools run by cucumber as well, if no_color is set.
+func (ctx *StepContext) cucumberColorEnvs() []string {
+	if ctx.Configs.NoColor == "yes" {
+		return []string{"NO_COLOR=1", "TERM=dumb"}
+	}
+	return nil
+}
+
+// cucumberPublishQuietVersion is the first cucumber version printing the publish banner, and supporting --publish-quiet to suppress it.
+const cucumberPublishQuietVersion = "5.0.0"
+
+// cucumberBannerArgs and cucumberBannerEnvs suppress the publish banner of the cucumber versions supporting --publish-quiet,
+// if suppress_cucumber_banners is not disabled. The version is the cucumber version resolved before the run (the run summary's),
+// the older versions print no banner and fail on the unknown option.
+func (ctx *StepContext) cucumberBannerArgs() []string {
+	if !ctx.suppressCucumberBanners() {
+		return nil
+	}
+	return []string{"--publish-quiet"}
+}
+
+func (ctx *StepContext) cucumberBannerEnvs() []string {
+	if !ctx.suppressCucumberBanners() {
+		return nil
+	}
+	return []string{"CUCUMBER_PUBLISH_QUIET=true"}
+}
+
+func (ctx *StepContext) suppressCucumberBanners() bool {
+	if ctx.Configs.SuppressCucumberBanners == "no" {
+		return false
+	}
+
+	cucumberVersion := runSummary.Versions.Cucumber
+	if cucumberVersion == "" {
+		return false
+	}
+	supports, err := satisfiesGemRequirement(cucumberVersion, ">= "+cucumberPublishQuietVersion)
+	return err == nil && supports
+}
+
+// cucumberEnvs returns the envs of the cucumber runs: the simulator, the app, the screenshots' dir and the app's launch config.
+func (ctx *StepContext) cucumberEnvs() []string {
+	envs := []string{"DEVICE_TARGET=" + ctx.DeviceTarget}
+	if ctx.AppPath != "" {
+		envs = append(envs, "APP="+ctx.AppPath)
+	}
+
+	// calabash prefixes the screenshot file names with SCREENSHOT_PATH
+	envs = append(envs, "SCREENSHOT_PATH="+ctx.screenshotsDir()+string(filepath.Separator))
+
+	return append(envs, ctx.appLaunchEnvs()...)
+}
+
+// cucumberCommandArgs returns the cucumber command (`bundle exec cucumber` with bundler, `cucumber _<version>_` for a pinned executable)
+// and the envs the command needs, prefixed with the architecture launch args (`arch -x86_64` under Rosetta).
+func (ctx *StepContext) cucumberCommandArgs() ([]string, []string) {
+	args := append([]string{}, ctx.ArchitectureLaunchArgs...)
+	if ctx.UseBundler {
+		return append(args, "bundle", "exec", "cucumber"), ctx.cucumberBundlerEnvs()
+	}
+	if executableVersion := ctx.cucumberExecutableVersion(); executableVersion != "" {
+		return append(args, "cucumber", fmt.Sprintf("_%s_", executableVersion)), nil
+	}
+	return append(args, "cucumber"), nil
+}
+
+// newCucumberCommand returns the cucumber command of the args, run in the calabash sandbox if it is used.
+func (ctx *StepContext) newCucumberCommand(args []string) (*command.Model, error) {
+	if ctx.UseSandbox {
+		return calabashSandboxCommandModel(args), nil
+	}
+	cmd, err := rubycommand.NewFromSlice(args)
+	if err != nil {
+		return nil, newStepError(categoryInfrastructure, "Failed to create command, error: %s", err)
+	}
+	return cmd, nil
+}
+
+// runCucumber runs the cucumber tests, a failed test run is returned as a test failure.
+func (ctx *StepContext) runCucumber() error {
+	fmt.Println()
+	log.Infof("Running cucumber test...")
+
+	ctx.printAppLaunchConfig()
+	cucumberEnvs := ctx.cucumberEnvs()
+
+	cucumberArgs, commandEnvs := ctx.cucumberCommandArgs()
+	cucumberEnvs = append(cucumberEnvs, commandEnvs...)
+	commandLen := len(cucumberArgs)
+
+	cucumberArgs = append(cucumberArgs, ctx.cucumberColorArgs()...)
+	cucumberEnvs = append(cucumberEnvs, ctx.cucumberColorEnvs()...)
+	cucumberArgs = append(cucumberArgs, ctx.cucumberBannerArgs()...)
+	cucumberEnvs = append(cucumberEnvs, ctx.cucumberBannerEnvs()...)
+	cucumberArgs = append(cucumberArgs, ctx.Options...)
+	cucumberArgs = append(cucumberArgs, ctx.backtraceArgs()...)
+
+	if len(ctx.ScenarioNameFilter) > 0 {
+		log.Printf("Running the scenarios matching: %s", strings.Join(ctx.ScenarioNameFilter, ", "))
+		cucumberArgs = append(cucumberArgs, scenarioNameFilterArgs(ctx.ScenarioNameFilter)...)
+	}
+	cucumberArgs = append(cucumberArgs, excludePatternArgs(ctx.ExcludePatterns)...)
+	cucumberArgs = append(cucumberArgs, ctx.wipExcludeArgs()...)
+
+	targetArgs, err := ctx.stepTargetArgs()
+	if err != nil {
+		return err
+	}
+	cucumberArgs = append(cucumberArgs, targetArgs...)
+
+	var featureArgs []string
+	if ctx.RerunFilePath != "" {
+		// cucumber runs only the scenarios listed in the @<file> argument
+		log.Printf("Running the scenarios listed in the rerun file: %s", ctx.RerunFilePath)
+		featureArgs = []string{"@" + ctx.RerunFilePath}
+	} else if ctx.Configs.FeatureOrderFile != "" {
+		// cucumber runs the feature file arguments in the given order
+		log.Printf("Running the features in order:")
+		for i, feature := range ctx.OrderedFeatures {
+			log.Printf("%d. %s", i+1, feature)
+		}
+		featureArgs = ctx.OrderedFeatures
+	} else if ctx.FeaturesDir != "" {
+		// cucumber runs in the work dir, the features are located by the feature path argument
+		log.Printf("Running the features in: %s", ctx.featuresPath())
+		featureArgs = []string{ctx.featuresPath()}
+	}
+	featuresIndex := len(cucumberArgs)
+	cucumberArgs = append(cucumberArgs, featureArgs...)
+
+	cucumberArgs = append(cucumberArgs, ctx.defaultFormatterArgs()...)
+	// the step managed json report is left out of the repro command, it is specific to the CI run
+	reproArgs := append([]string{}, cucumberArgs...)
+
+	// step managed json report, used for the run summary
+	cucumberArgs = append(cucumberArgs, ctx.jsonReportFormatterArgs(filepath.Join(resultsSubdir(resultsReportsDirName, ctx.runResultsPath()), "cucumber_report.json"))...)
+	diagnostics.Add(diagnosticKindCucumberReport, ctx.JSONReportPath, false)
+
+	for _, outPth := range ctx.cucumberOutPaths() {
+		diagnostics.Add(diagnosticKindCucumberOutput, outPth, true)
+	}
+
+	ctx.printReproCommand(reproArgs)
+	// the dry run counting the scenarios gets the options on the command line, the generated profile has the formatters
+	dryRunArgs := append([]string{}, cucumberArgs...)
+
+	if ctx.Configs.UseGeneratedProfile == "yes" {
+		// the repro command keeps the options on the command line, the generated profile is specific to the CI run
+		options := append([]string{}, cucumberArgs[commandLen:featuresIndex]...)
+		options = append(options, cucumberArgs[featuresIndex+len(featureArgs):]...)
+
+		profileArgs, ok, err := ctx.generatedProfileArgs(cucumberArgs[:commandLen], options, featureArgs)
+		if err != nil {
+			return err
+		}
+		if ok {
+			defer func() {
+				if err := restoreProjectProfile(); err != nil {
+					log.Warnf("%s", err)
+				}
+			}()
+			cucumberArgs = profileArgs
+		}
+	}
+
+	cucumberCmd, err := ctx.newCucumberCommand(cucumberArgs)
+	if err != nil {
+		return err
+	}
+
+	debugEnvMap("cucumber envs", cucumberEnvs)
+	exportCucumberEnvs(cucumberEnvs)
+
+	cucumberCmd.AppendEnvs(cucumberEnvs...)
+	cucumberCmd.SetDir(ctx.WorkDir)
+	// the markers are written around the (unchanged) lines of the output, the scanners see the output without them
+	markerWriter := NewLogMarkerWriter(stepLogger.Raw(), ctx.emitLogMarkers())
+	deprecationScanner := NewDeprecationScanner(markerWriter, deprecations)
+	serverErrorScanner := NewCalabashServerErrorScanner(deprecationScanner)
+	cucumberOut := ctx.cucumberOutputWriter(serverErrorScanner, dryRunArgs, cucumberEnvs)
+	cucumberCmd.SetStdout(cucumberOut).SetStderr(cucumberOut)
+
+	// the memory statistics of the run's start are reported if cucumber gets killed by a signal
+	vmStatStart, vmStatErr := vmStatSnapshot()
+	if vmStatErr != nil {
+		log.Debugf("Failed to read the memory statistics, error: %s", vmStatErr)
+	}
+
+	printCommand(cucumberCmd)
+	fmt.Println()
+
+	err = commandRecorder.Run(cucumberCmd)
+	ctx.CalabashServerErrorDetected = serverErrorScanner.Detected()
+	ctx.LaunchFailureCount = serverErrorScanner.LaunchFailures()
+	deprecationScanner.Flush()
+	if flushErr := markerWriter.Flush(); flushErr != nil {
+		log.Warnf("Failed to write the log markers, error: %s", flushErr)
+	}
+	if err != nil {
+		// a command killed by the step (aborted or timed out) is reported by the kill's failure
+		if sig, ok := signalOf(err); ok && !commandRecorder.Killed() {
+			return killedBySignalError(sig, vmStatStart)
+		}
+		return newStepError(categoryTestFailure, "Failed to run command, error: %s", err)
+	}
+	return nil
+}
+
+// collectReport reads the scenario results of the step managed json report into the run summary.
+func (ctx *StepContext) collectReport() {
+	if ctx.JSONReportPath == "" {
+		return
+	}
+
+	if exist, err := pathutil.IsPathExists(ctx.JSONReportPath); err != nil {
+		log.Warnf("Failed to check if json report exists at (%s), error: %s", ctx.JSONReportPath, err)
+	} else if exist {
+		features, err := parseCucumberJSONReportFile(ctx.JSONReportPath)
+		if err != nil {
+			log.Warnf("Failed to parse json report (%s), error: %s", ctx.JSONReportPath, err)
+		} else {
+			ctx.ScenarioResults = scenarioResults(features)
+			runSummary.SetScenarios(ctx.ScenarioResults)
+		}
+	}
+}
+
+// printCucumberOutputFile prints the report file set by the --out option,
+// html reports are reduced to their error messages.
+func printCucumberOutputFile(options []string) error {
+	// find --out flag and get the next index containing output file's pth
+	outputFilePth := ""
+	if index := indexInStringSlice("--out", options); index != -1 && index+1 < len(options) {
+		outputFilePth = options[index+1]
+	}
+	if outputFilePth == "" {
+		return nil
+	}
+
+	// if --out is BITRISE_DEPLOY_DIR, print Deploy to bitrise.io step usage
+	if filepath.Dir(outputFilePth) == os.Getenv("BITRISE_DEPLOY_DIR") {
+		log.Printf("Use Deploy to bitrise.io step to attach report file (%s) to your build artifacts.", outputFilePth)
+	} else {
+		log.Printf("The generated report file is available at: %s", outputFilePth)
+	}
+	fmt.Println()
+
+	// read output file
+	outputFileContent, err := fileutil.ReadStringFromFile(outputFilePth)
+	if err != nil {
+		return fmt.Errorf("Failed to read output file (%s), error: %s", outputFilePth, err)
+	}
+
+	// check if output format is html
+	if index := indexInStringSlice("--format", options); index != -1 && index+1 < len(options) && (options[index+1] == "html") {
+		// regex messages from output html and avoid duplicating messages
+		outputs := []string{}
+		exp := regexp.MustCompile(`<div class="message"><pre>(?s)(.*?)</pre></div>`)
+		for _, match := range exp.FindAllStringSubmatch(outputFileContent, -1) {
+			if len(match) > 1 {
+				if index := indexInStringSlice(match[1], outputs); index == -1 {
+					log.Printf(match[1])
+					outputs = append(outputs, match[1])
+				}
+			}
+		}
+		return nil
+	}
+
+	// output isn't html, print file content
+	log.Printf(outputFileContent)
+	return nil
+}
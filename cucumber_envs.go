@@ -0,0 +1,40 @@
+package main
+
+import (
+	"encoding/json"
+	"regexp"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/log"
+)
+
+const (
+	cucumberEnvOutputKeyPrefix = "BITRISE_CALABASH_ENV_"
+	cucumberEnvsOutputKey      = "BITRISE_CALABASH_CUCUMBER_ENVS"
+)
+
+var outputKeyInvalidCharExp = regexp.MustCompile(`[^A-Z0-9_]`)
+
+// cucumberEnvOutputKey returns the output key of a cucumber env, like BITRISE_CALABASH_ENV_DEVICE_TARGET.
+func cucumberEnvOutputKey(key string) string {
+	return cucumberEnvOutputKeyPrefix + outputKeyInvalidCharExp.ReplaceAllString(strings.ToUpper(key), "_")
+}
+
+// exportCucumberEnvs exports the envs added by the step to the cucumber run, so that the later steps
+// can reuse the same test context: every env as a BITRISE_CALABASH_ENV_<KEY> output and all of them as a JSON object.
+// Secret values are masked in both.
+func exportCucumberEnvs(envs []string) {
+	masked := map[string]string{}
+	for _, env := range envs {
+		key, value := splitEnv(env)
+		masked[key] = maskSecret(key, value)
+		exportOutput(cucumberEnvOutputKey(key), masked[key])
+	}
+
+	content, err := json.Marshal(masked)
+	if err != nil {
+		log.Warnf("Failed to serialize the cucumber envs, error: %s", err)
+		return
+	}
+	exportOutput(cucumberEnvsOutputKey, string(content))
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bitrise-io/go-utils/command"
+	"github.com/bitrise-io/go-utils/log"
+)
+
+// CucumberProcessModel is a cucumber process of the user, left running by another build.
+type CucumberProcessModel struct {
+	PID     int
+	Age     time.Duration
+	Command string
+}
+
+// parseElapsedTime parses the `[[dd-]hh:]mm:ss` elapsed time of ps.
+func parseElapsedTime(etime string) (time.Duration, error) {
+	var days int
+	if split := strings.SplitN(etime, "-", 2); len(split) == 2 {
+		var err error
+		if days, err = strconv.Atoi(split[0]); err != nil {
+			return 0, fmt.Errorf("invalid elapsed time: %s", etime)
+		}
+		etime = split[1]
+	}
+
+	var seconds int
+	for _, part := range strings.Split(etime, ":") {
+		value, err := strconv.Atoi(part)
+		if err != nil {
+			return 0, fmt.Errorf("invalid elapsed time: %s", etime)
+		}
+		seconds = seconds*60 + value
+	}
+	return time.Duration(days)*24*time.Hour + time.Duration(seconds)*time.Second, nil
+}
+
+// isCucumberCommand reports whether the command line runs cucumber: the cucumber executable (or its binstub),
+// or a ruby process running it (like `ruby /usr/local/bin/cucumber` or `ruby bundle exec cucumber`).
+func isCucumberCommand(cmdLine string) bool {
+	fields := strings.Fields(cmdLine)
+	if len(fields) == 0 {
+		return false
+	}
+
+	if filepath.Base(fields[0]) == "cucumber" {
+		return true
+	}
+	if !strings.HasPrefix(filepath.Base(fields[0]), "ruby") {
+		return false
+	}
+	for _, field := range fields[1:] {
+		if filepath.Base(field) == "cucumber" {
+			return true
+		}
+	}
+	return false
+}
+
+// parseCucumberProcesses returns the cucumber processes from the `ps -o pid=,etime=,command=` output,
+// the step's own process and its parent are skipped.
+func parseCucumberProcesses(out string, ownPIDs []int) []CucumberProcessModel {
+	processes := []CucumberProcessModel{}
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 3 {
+			continue
+		}
+
+		pid, err := strconv.Atoi(fields[0])
+		if err != nil {
+			continue
+		}
+		if indexInIntSlice(pid, ownPIDs) != -1 {
+			continue
+		}
+
+		cmdLine := strings.Join(fields[2:], " ")
+		if !isCucumberCommand(cmdLine) {
+			continue
+		}
+
+		age, err := parseElapsedTime(fields[1])
+		if err != nil {
+			log.Warnf("Failed to parse the age of the process (%d), error: %s", pid, err)
+		}
+		processes = append(processes, CucumberProcessModel{PID: pid, Age: age, Command: cmdLine})
+	}
+	return processes
+}
+
+func indexInIntSlice(value int, slice []int) int {
+	for i, v := range slice {
+		if v == value {
+			return i
+		}
+	}
+	return -1
+}
+
+// listCucumberProcesses returns the running cucumber processes of the user, which runs the step.
+func listCucumberProcesses() ([]CucumberProcessModel, error) {
+	// without -a, ps lists the processes of the user only, -x includes the ones without a controlling terminal
+	cmd := command.New("ps", "-x", "-o", "pid=,etime=,command=")
+	out, err := commandRecorder.RunAndReturnTrimmedCombinedOutput(cmd)
+	if err != nil {
+		return nil, fmt.Errorf("%s failed, output: %s, error: %s", cmd.PrintableCommandArgs(), out, err)
+	}
+	// cucumber is not started by the step yet, the step's own process is skipped in case its path contains cucumber
+	return parseCucumberProcesses(out, []int{os.Getpid(), os.Getppid()}), nil
+}
+
+func killCucumberProcesses(signal string, processes []CucumberProcessModel) error {
+	args := []string{"-" + signal}
+	for _, process := range processes {
+		args = append(args, strconv.Itoa(process.PID))
+	}
+
+	cmd := command.New("kill", args...)
+	printCommand(cmd)
+
+	if out, err := commandRecorder.RunAndReturnTrimmedCombinedOutput(cmd); err != nil {
+		return fmt.Errorf("%s failed, output: %s, error: %s", cmd.PrintableCommandArgs(), out, err)
+	}
+	return nil
+}
+
+// terminateCucumberProcesses terminates the cucumber processes and waits for them to exit,
+// the processes ignoring the termination are killed.
+func terminateCucumberProcesses(processes []CucumberProcessModel) error {
+	if err := killCucumberProcesses("TERM", processes); err != nil {
+		log.Warnf("%s", err)
+	}
+
+	var remaining []CucumberProcessModel
+	for start := time.Now(); time.Since(start) < testRunnerExitTimeout; {
+		time.Sleep(testRunnerExitPollInterval)
+
+		running, err := listCucumberProcesses()
+		if err != nil {
+			return err
+		}
+
+		remaining = []CucumberProcessModel{}
+		for _, process := range running {
+			for _, terminated := range processes {
+				if process.PID == terminated.PID {
+					remaining = append(remaining, process)
+				}
+			}
+		}
+		if len(remaining) == 0 {
+			return nil
+		}
+	}
+
+	log.Warnf("%d cucumber process(es) did not exit in %s, killing them", len(remaining), testRunnerExitTimeout)
+	return killCucumberProcesses("KILL", remaining)
+}
+
+// checkRunningCucumberProcesses fails if another build left a cucumber process running, two cucumber processes
+// driving simulators at once produce unreliable results. With kill_existing_cucumber, the processes are terminated instead.
+func checkRunningCucumberProcesses(kill bool) error {
+	fmt.Println()
+	log.Infof("Checking for running cucumber processes...")
+
+	processes, err := listCucumberProcesses()
+	if err != nil {
+		return newStepError(categoryInfrastructure, "Failed to list the running cucumber processes, error: %s", err)
+	}
+	if len(processes) == 0 {
+		log.Donef("No cucumber process is running")
+		return nil
+	}
+
+	lines := []string{}
+	for _, process := range processes {
+		lines = append(lines, fmt.Sprintf("- %d (running for %s): %s", process.PID, process.Age, process.Command))
+	}
+
+	if !kill {
+		return newStepError(categoryInfrastructure, "%d cucumber process(es) of another build are running:\n%s\n"+
+			"Set kill_existing_cucumber to yes to terminate them before the run", len(processes), strings.Join(lines, "\n"))
+	}
+
+	log.Warnf("%d cucumber process(es) of another build are running, terminating them:", len(processes))
+	for _, line := range lines {
+		log.Warnf("%s", line)
+	}
+	if err := terminateCucumberProcesses(processes); err != nil {
+		return newStepError(categoryInfrastructure, "Failed to terminate the running cucumber processes, error: %s", err)
+	}
+
+	log.Donef("Terminated %d cucumber process(es)", len(processes))
+	return nil
+}
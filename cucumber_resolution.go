@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/bitrise-io/go-steputils/command/rubycommand"
+	"github.com/bitrise-io/go-utils/command"
+	"github.com/bitrise-io/go-utils/log"
+)
+
+// isRbenvManaged reports whether the Ruby is managed by rbenv, which resolves the gem executables through shims.
+func isRbenvManaged() bool {
+	if os.Getenv("RBENV_ROOT") != "" {
+		return true
+	}
+	_, err := exec.LookPath("rbenv")
+	return err == nil
+}
+
+// isRbenvRehashCommand reports whether the command is the `rbenv rehash` appended to the gem install commands by rubycommand.
+func isRbenvRehashCommand(cmd *command.Model) bool {
+	args := cmd.GetCmd().Args
+	return len(args) == 2 && args[0] == "rbenv" && args[1] == "rehash"
+}
+
+// rehashRbenvShims regenerates the rbenv shims, so that the executables of the installed gems resolve,
+// a failure is logged as a warning only.
+func rehashRbenvShims() {
+	if !isRbenvManaged() {
+		return
+	}
+
+	if err := runCommand(command.New("rbenv", "rehash")); err != nil {
+		log.Warnf("Failed to rehash the rbenv shims, error: %s", err)
+	}
+}
+
+// cucumberVersionFromOutput returns the version printed by `cucumber --version`, the last line of the output
+// (bundler and rbenv may print warnings before it).
+func cucumberVersionFromOutput(out string) string {
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	return strings.TrimSpace(lines[len(lines)-1])
+}
+
+// verifyCucumberResolves runs the step's cucumber command with --version, to fail before the run
+// if the installed cucumber executable does not resolve (for example stale rbenv shims), printing the PATH and the gem environment.
+func (ctx *StepContext) verifyCucumberResolves() error {
+	args := []string{"cucumber"}
+	if ctx.UseBundler {
+		args = append([]string{"bundle", "exec"}, args...)
+	} else if executableVersion := ctx.cucumberExecutableVersion(); executableVersion != "" {
+		args = append(args, fmt.Sprintf("_%s_", executableVersion))
+	}
+	args = append(args, "--version")
+
+	var cmd *command.Model
+	if ctx.UseSandbox {
+		cmd = calabashSandboxCommandModel(args)
+	} else {
+		var err error
+		if cmd, err = rubycommand.NewFromSlice(args); err != nil {
+			return newStepError(categoryDependencyInstall, "Failed to create command, error: %s", err)
+		}
+	}
+	if ctx.UseBundler {
+		cmd.AppendEnvs(ctx.cucumberBundlerEnvs()...)
+	}
+	cmd.SetDir(ctx.WorkDir)
+
+	printCommand(cmd)
+	out, err := commandRecorder.RunAndReturnTrimmedCombinedOutput(cmd)
+	if err == nil {
+		log.Donef("cucumber resolves, version: %s", out)
+		if runSummary.Versions.Cucumber == "" {
+			runSummary.Versions.Cucumber = cucumberVersionFromOutput(out)
+		}
+		return nil
+	}
+
+	log.Errorf("%s", out)
+	log.Printf("PATH: %s", os.Getenv("PATH"))
+	if gemEnvCmd, cmdErr := rubycommand.New("gem", "env"); cmdErr == nil {
+		if gemEnv, envErr := commandRecorder.RunAndReturnTrimmedCombinedOutput(gemEnvCmd); envErr == nil {
+			log.Printf("gem env:\n%s", gemEnv)
+		}
+	}
+	return newStepError(categoryDependencyInstall, "cucumber does not resolve (%s), error: %s", strings.Join(args, " "), err)
+}
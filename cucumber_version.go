@@ -0,0 +1,151 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/bitrise-io/go-steputils/command/rubycommand"
+	"github.com/bitrise-io/go-utils/log"
+	version "github.com/hashicorp/go-version"
+)
+
+// exactGemVersionExp matches an exact gem version, like `3.1.2` or `3.0.0.pre.2`, the cucumber_version input can not be a requirement.
+var exactGemVersionExp = regexp.MustCompile(`^\d+(\.[0-9A-Za-z]+)*$`)
+
+// cucumberRequirementExp matches the cucumber dependency of the `gem dependency` output, like `  cucumber (~> 2.0)`.
+var cucumberRequirementExp = regexp.MustCompile(`(?m)^\s+cucumber \(([^)]+)\)\s*$`)
+
+// parseCucumberRequirement returns calabash-cucumber's runtime requirement on cucumber from the `gem dependency` output,
+// the development dependencies (like `cucumber (>= 0, development)`) are skipped.
+func parseCucumberRequirement(out string) string {
+	for _, match := range cucumberRequirementExp.FindAllStringSubmatch(out, -1) {
+		requirement := strings.TrimSpace(match[1])
+		if strings.HasSuffix(requirement, ", development") {
+			continue
+		}
+		return strings.TrimSuffix(requirement, ", runtime")
+	}
+	return ""
+}
+
+// satisfiesGemRequirement reports whether the gem version satisfies the RubyGems requirement, like `~> 2.0` or `>= 2.0, < 4.0`.
+func satisfiesGemRequirement(gemVersion, requirement string) (bool, error) {
+	v, err := version.NewVersion(constraintVersionFromGemVersion(gemVersion))
+	if err != nil {
+		return false, err
+	}
+
+	constraints, err := version.NewConstraint(requirement)
+	if err != nil {
+		return false, err
+	}
+	return constraints.Check(v), nil
+}
+
+// cucumberExecutableVersion returns the version passed to the cucumber executable as `_<version>_` if the gems are installed with `gem install`:
+// the pinned cucumber version, otherwise the pinned calabash-cucumber version.
+func (ctx *StepContext) cucumberExecutableVersion() string {
+	if ctx.CucumberVersion != "" {
+		return ctx.CucumberVersion
+	}
+	return ctx.CalabashCucumberVersion
+}
+
+// checkLockfileCucumberVersion warns if the Gemfile.lock's cucumber version differs from the cucumber_version input,
+// as bundler uses the Gemfile.lock's version.
+func checkLockfileCucumberVersion(pinned, lockfileVersion string) {
+	switch {
+	case lockfileVersion == "":
+		log.Warnf("Gemfile.lock does not contain cucumber, cucumber_version (%s) is not applied with bundler, add cucumber to the Gemfile", pinned)
+	case !gemVersionsEqual(lockfileVersion, pinned):
+		log.Warnf("cucumber version in Gemfile.lock (%s) differs from cucumber_version (%s), bundler uses the Gemfile.lock's version", lockfileVersion, pinned)
+	default:
+		log.Printf("cucumber version in Gemfile.lock agrees with cucumber_version (%s)", pinned)
+	}
+}
+
+// installPinnedCucumber installs the pinned cucumber version if the exact version is not installed yet.
+// It runs before the calabash-cucumber install, so that RubyGems resolves calabash-cucumber's cucumber dependency to it if possible.
+func (ctx *StepContext) installPinnedCucumber() error {
+	pinned := ctx.CucumberVersion
+
+	versions, err := installedGemVersions("cucumber")
+	if err != nil {
+		return newStepError(categoryDependencyInstall, "Failed to list the installed cucumber versions, error: %s", err)
+	}
+	log.Printf("Installed cucumber versions: %s", installedVersionsString(versions))
+
+	if indexOfGemVersion(pinned, versions) != -1 {
+		log.Printf("cucumber %s installed", pinned)
+		return nil
+	}
+
+	if err := gemInstall("cucumber", pinned); err != nil {
+		return err
+	}
+	log.Donef("cucumber %s installed", pinned)
+	return nil
+}
+
+// checkCucumberRequirement fails if the pinned cucumber version does not satisfy the installed calabash-cucumber's requirement on cucumber:
+// RubyGems installs an other cucumber version for calabash-cucumber in this case, and loading both fails at runtime.
+func (ctx *StepContext) checkCucumberRequirement() error {
+	calabashVersion := ctx.CalabashCucumberVersion
+	if calabashVersion == "" {
+		versions, err := installedCalabashVersions()
+		if err != nil {
+			return newStepError(categoryDependencyInstall, "Failed to list the installed calabash-cucumber versions, error: %s", err)
+		}
+		if len(versions) == 0 {
+			return newStepError(categoryDependencyInstall, "calabash-cucumber is not installed after gem install")
+		}
+		calabashVersion = versions[0]
+	}
+
+	cmd, err := rubycommand.New("gem", "dependency", "calabash-cucumber", "--version", calabashVersion)
+	if err != nil {
+		return newStepError(categoryDependencyInstall, "Failed to create command, error: %s", err)
+	}
+
+	out, err := commandRecorder.RunAndReturnTrimmedCombinedOutput(cmd)
+	if err != nil {
+		return newStepError(categoryDependencyInstall, "%s failed, output: %s, error: %s", cmd.PrintableCommandArgs(), out, err)
+	}
+
+	requirement := parseCucumberRequirement(out)
+	if requirement == "" {
+		log.Warnf("No cucumber requirement found in the dependencies of calabash-cucumber %s, cucumber_version (%s) is not checked", calabashVersion, ctx.CucumberVersion)
+		return nil
+	}
+
+	satisfies, err := satisfiesGemRequirement(ctx.CucumberVersion, requirement)
+	if err != nil {
+		log.Warnf("Failed to check cucumber_version (%s) against calabash-cucumber %s's requirement (cucumber %s), error: %s", ctx.CucumberVersion, calabashVersion, requirement, err)
+		return nil
+	}
+	if !satisfies {
+		return newNonRetryableStepError(categoryDependencyInstall,
+			"cucumber version conflict:\n"+
+				"  cucumber_version requires: cucumber (= %s)\n"+
+				"  calabash-cucumber %s requires: cucumber (%s)\n"+
+				"set a cucumber_version satisfying calabash-cucumber's requirement, or a calabash_cucumber_version accepting cucumber %s",
+			ctx.CucumberVersion, calabashVersion, requirement, ctx.CucumberVersion)
+	}
+
+	log.Donef("cucumber %s satisfies calabash-cucumber %s's requirement (%s)", ctx.CucumberVersion, calabashVersion, requirement)
+	return nil
+}
+
+// verifyCucumberInstalled checks that a previous prepare_only run installed the pinned cucumber version.
+func (ctx *StepContext) verifyCucumberInstalled() error {
+	versions, err := installedGemVersions("cucumber")
+	if err != nil {
+		return newStepError(categoryDependencyInstall, "Failed to check if cucumber %s installed, error: %s", ctx.CucumberVersion, err)
+	}
+	log.Printf("Installed cucumber versions: %s", installedVersionsString(versions))
+
+	if indexOfGemVersion(ctx.CucumberVersion, versions) == -1 {
+		return newStepError(categoryDependencyInstall, "cucumber %s is not installed, run the step in prepare_only mode first", ctx.CucumberVersion)
+	}
+	return nil
+}
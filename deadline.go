@@ -0,0 +1,34 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+var stepDeadline *time.Timer
+
+// startStepDeadline fails the step once it has been running for the given timeout, whichever phase is active.
+// The deadline is started once and covers every run of the language and the device matrix.
+func startStepDeadline(timeout time.Duration) {
+	stepDeadline = time.AfterFunc(timeout-phaseTimer.Total(), func() {
+		onStepTimeout(timeout)
+	})
+}
+
+// onStepTimeout kills the running command tree and fails the step with the step_timeout failure.
+func onStepTimeout(timeout time.Duration) {
+	phase, phaseDuration := phaseTimer.Current()
+
+	timings := []string{}
+	for _, p := range phaseTimer.Phases() {
+		timings = append(timings, fmt.Sprintf("%s: %s", p.Name, roundDuration(p.Duration)))
+	}
+	if phase != "" {
+		timings = append(timings, fmt.Sprintf("%s: %s (active)", phase, roundDuration(phaseDuration)))
+	}
+
+	fmt.Println()
+	interruptStep(newStepError(categoryStepTimeout, "Step timed out after %s in phase (%s), running for %s, phase timings: %s",
+		timeout, phase, roundDuration(phaseDuration), strings.Join(timings, ", ")))
+}
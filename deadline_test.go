@@ -0,0 +1,68 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestStepInterruptFailsTheStep(t *testing.T) {
+	tests := []struct {
+		name       string
+		helper     string
+		wantLog    string
+		wantNotLog []string
+	}{
+		{
+			name:       "the step body exits at the killed command",
+			helper:     "deadline_command",
+			wantLog:    "Step timed out after 100ms in phase (run tests)",
+			wantNotLog: []string{"did not stop within", "Command failed", "Command was not interrupted"},
+		},
+		{
+			name:    "the step body is stuck outside a command",
+			helper:  "deadline",
+			wantLog: "The step did not stop within 200ms after the interrupt, exiting",
+		},
+		{
+			name:       "abort signal",
+			helper:     "abort",
+			wantLog:    "Step aborted by signal: terminated",
+			wantNotLog: []string{"did not stop within", "Command failed", "Command was not interrupted"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			category := categoryStepTimeout
+			if tt.helper == "abort" {
+				category = categoryAborted
+			}
+
+			result := runStepHelper(t, tt.helper)
+
+			if result.exitCode != category.ExitCode {
+				t.Fatalf("exit code: %d, expected: %d, log:\n%s", result.exitCode, category.ExitCode, result.log)
+			}
+			if !strings.Contains(result.log, tt.wantLog) {
+				t.Errorf("%q is not logged, log:\n%s", tt.wantLog, result.log)
+			}
+			for _, notLog := range tt.wantNotLog {
+				if strings.Contains(result.log, notLog) {
+					t.Errorf("%q is logged, log:\n%s", notLog, result.log)
+				}
+			}
+
+			for _, key := range []string{testResultOutputKey, legacyTestResultOutputKey} {
+				if actual := result.outputs[key]; actual != testResultFailed {
+					t.Errorf("output %s: %q, expected: %q", key, actual, testResultFailed)
+				}
+			}
+
+			if result.summary.FailureClassification != category.Name {
+				t.Errorf("failure classification: %q, expected: %q", result.summary.FailureClassification, category.Name)
+			}
+			if result.summary.ExitCode != category.ExitCode {
+				t.Errorf("summary exit code: %d, expected: %d", result.summary.ExitCode, category.ExitCode)
+			}
+		})
+	}
+}
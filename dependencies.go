@@ -0,0 +1,552 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/bitrise-io/go-steputils/command/rubycommand"
+	"github.com/bitrise-io/go-utils/command"
+	"github.com/bitrise-io/go-utils/fileutil"
+	"github.com/bitrise-io/go-utils/log"
+	"github.com/bitrise-io/go-utils/pathutil"
+)
+
+func calabashCucumberFromGemfileLockContent(content string) string {
+	return gemVersionFromGemfileLockContent(content, "calabash-cucumber")
+}
+
+func gemVersionFromGemfileLockContent(content, gem string) string {
+	relevantLines := []string{}
+	lines := strings.Split(content, "\n")
+
+	specsStart := false
+	for _, line := range lines {
+		if strings.Contains(line, "specs:") {
+			specsStart = true
+		}
+
+		trimmed := strings.Trim(line, " ")
+		if trimmed == "" {
+			break
+		}
+
+		if specsStart {
+			relevantLines = append(relevantLines, line)
+		}
+	}
+
+	exp := regexp.MustCompile(`^\s{4}` + regexp.QuoteMeta(gem) + ` \((.+)\)`)
+	for _, line := range relevantLines {
+		match := exp.FindStringSubmatch(line)
+		if match != nil && len(match) == 2 {
+			return match[1]
+		}
+	}
+
+	return ""
+}
+
+func calabashCucumberVersionFromGemfileLock(gemfileLockPth string) (string, error) {
+	content, err := fileutil.ReadStringFromFile(gemfileLockPth)
+	if err != nil {
+		return "", err
+	}
+	return calabashCucumberFromGemfileLockContent(content), nil
+}
+
+func cucumberVersionFromGemfileLock(gemfileLockPth string) (string, error) {
+	content, err := fileutil.ReadStringFromFile(gemfileLockPth)
+	if err != nil {
+		return "", err
+	}
+	return gemVersionFromGemfileLockContent(content, "cucumber"), nil
+}
+
+// Dependency resolutions, auto decides based on the Gemfile.lock and the calabash_cucumber_version input,
+// sandbox runs cucumber in calabash-sandbox instead of the system ruby's gems
+const (
+	dependencyResolutionAuto       = "auto"
+	dependencyResolutionBundler    = "bundler"
+	dependencyResolutionGemVersion = "gem_version"
+	dependencyResolutionSandbox    = "sandbox"
+)
+
+var dependencyResolutions = []string{dependencyResolutionAuto, dependencyResolutionBundler, dependencyResolutionGemVersion, dependencyResolutionSandbox}
+
+// DependencyDecisionModel is the way calabash-cucumber gets installed and invoked:
+// with bundler or with `gem install` of the given version (the latest if empty).
+type DependencyDecisionModel struct {
+	UseBundler bool
+	Version    string
+	Reason     string
+}
+
+// decideDependencyResolution applies the dependency_resolution input's precedence rule on the pinned calabash-cucumber version
+// and on the calabash-cucumber version of the Gemfile.lock (lockfileFound is false if there is no Gemfile.lock).
+func decideDependencyResolution(resolution, pinnedVersion string, lockfileFound bool, lockfileVersion string) (DependencyDecisionModel, error) {
+	switch resolution {
+	case dependencyResolutionBundler:
+		if !lockfileFound {
+			return DependencyDecisionModel{}, newStepError(categoryInvalidInput, "DependencyResolution is %s, but no Gemfile.lock found next to the Gemfile", resolution)
+		}
+
+		reason := "dependency_resolution is bundler"
+		if pinnedVersion != "" {
+			reason += fmt.Sprintf(", calabash_cucumber_version (%s) is ignored", pinnedVersion)
+		}
+		return DependencyDecisionModel{UseBundler: true, Reason: reason}, nil
+	case dependencyResolutionGemVersion:
+		reason := "dependency_resolution is gem_version"
+		if lockfileFound {
+			reason += ", the Gemfile is ignored"
+		}
+		if pinnedVersion == "" {
+			reason += ", calabash_cucumber_version is not set, using the latest version"
+		}
+		return DependencyDecisionModel{Version: pinnedVersion, Reason: reason}, nil
+	}
+
+	switch {
+	case lockfileFound && pinnedVersion != "" && lockfileVersion == "":
+		return DependencyDecisionModel{Version: pinnedVersion, Reason: "Gemfile.lock does not contain calabash-cucumber, using calabash_cucumber_version"}, nil
+	case lockfileFound && pinnedVersion != "" && !gemVersionsEqual(lockfileVersion, pinnedVersion):
+		return DependencyDecisionModel{}, newStepError(categoryInvalidInput,
+			"calabash-cucumber version in Gemfile.lock (%s) conflicts with calabash_cucumber_version (%s), "+
+				"update one of them, or set dependency_resolution to %s or %s", lockfileVersion, pinnedVersion, dependencyResolutionBundler, dependencyResolutionGemVersion)
+	case lockfileFound && pinnedVersion != "":
+		return DependencyDecisionModel{UseBundler: true, Reason: fmt.Sprintf("calabash-cucumber version in Gemfile.lock agrees with calabash_cucumber_version (%s), using bundler", pinnedVersion)}, nil
+	case lockfileFound:
+		return DependencyDecisionModel{UseBundler: true, Reason: "Gemfile.lock found, using bundler"}, nil
+	case pinnedVersion != "":
+		return DependencyDecisionModel{Version: pinnedVersion, Reason: "no Gemfile.lock found, using calabash_cucumber_version"}, nil
+	}
+	return DependencyDecisionModel{Reason: "neither Gemfile.lock nor calabash_cucumber_version found, using the latest version"}, nil
+}
+
+// determineCalabashVersion decides whether bundler, the pinned calabash-cucumber version or the latest version is used.
+func (ctx *StepContext) determineCalabashVersion() error {
+	fmt.Println()
+	log.Infof("Determining calabash-cucumber version...")
+
+	configs := ctx.Configs
+	gemFilePath := ctx.GemFilePath
+
+	if configs.DependencyResolution == dependencyResolutionSandbox {
+		log.Printf("Dependency resolution (%s): calabash-cucumber of %s is used, the Gemfile is ignored", dependencyResolutionSandbox, calabashSandboxCommand)
+		if configs.CucumberVersion != "" {
+			log.Warnf("cucumber_version (%s) is ignored, the cucumber of %s is used", configs.CucumberVersion, calabashSandboxCommand)
+		}
+		ctx.UseSandbox = true
+		return nil
+	}
+
+	lockfileFound := false
+	lockfileVersion, lockfileCucumberVersion := "", ""
+	lockfilePth, lockfilePlatforms := "", []string{}
+	if gemFilePath != "" {
+		if exist, err := pathutil.IsPathExists(gemFilePath); err != nil {
+			return newStepError(categoryInfrastructure, "Failed to check if Gemfile exists at (%s) exist, error: %s", gemFilePath, err)
+		} else if exist {
+			log.Printf("Gemfile exists at: %s", gemFilePath)
+
+			gemfileDir := filepath.Dir(gemFilePath)
+			gemfileLockPth := filepath.Join(gemfileDir, "Gemfile.lock")
+
+			if exist, err := pathutil.IsPathExists(gemfileLockPth); err != nil {
+				return newStepError(categoryInfrastructure, "Failed to check if Gemfile.lock exists at (%s), error: %s", gemfileLockPth, err)
+			} else if exist {
+				log.Printf("Gemfile.lock exists at: %s", gemfileLockPth)
+
+				version, err := calabashCucumberVersionFromGemfileLock(gemfileLockPth)
+				if err != nil {
+					return newStepError(categoryDependencyInstall, "Failed to get calabash-cucumber version from Gemfile.lock, error: %s", err)
+				}
+
+				log.Printf("calabash-cucumber version in Gemfile.lock: %s", version)
+
+				cucumberVersion, err := cucumberVersionFromGemfileLock(gemfileLockPth)
+				if err != nil {
+					return newStepError(categoryDependencyInstall, "Failed to get cucumber version from Gemfile.lock, error: %s", err)
+				}
+
+				platforms, err := gemfileLockPlatforms(gemfileLockPth)
+				if err != nil {
+					return newStepError(categoryDependencyInstall, "Failed to get the platforms from Gemfile.lock, error: %s", err)
+				}
+
+				log.Printf("Gemfile.lock platforms: %s", strings.Join(platforms, ", "))
+
+				lockfileFound = true
+				lockfileVersion, lockfileCucumberVersion = version, cucumberVersion
+				lockfilePth, lockfilePlatforms = gemfileLockPth, platforms
+			} else {
+				log.Warnf("Gemfile.lock doest no find with calabash-cucumber gem at: %s", gemfileLockPth)
+			}
+		} else {
+			log.Warnf("Gemfile doest no find with calabash-cucumber gem at: %s", gemFilePath)
+		}
+	}
+
+	resolution := configs.DependencyResolution
+	if resolution == "" {
+		resolution = dependencyResolutionAuto
+	}
+
+	decision, err := decideDependencyResolution(resolution, configs.CalabashCucumberVersion, lockfileFound, lockfileVersion)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Dependency resolution (%s): %s", resolution, decision.Reason)
+
+	ctx.UseBundler = decision.UseBundler
+	ctx.CalabashCucumberVersion = decision.Version
+	ctx.LockfilePlatformMissing = ctx.UseBundler && !isDarwinCompatiblePlatforms(lockfilePlatforms)
+
+	if ctx.LockfilePlatformMissing {
+		warnLockfilePlatforms(lockfilePth, lockfilePlatforms)
+	}
+
+	if ctx.UseBundler {
+		if configs.CucumberVersion != "" {
+			checkLockfileCucumberVersion(configs.CucumberVersion, lockfileCucumberVersion)
+		}
+
+		log.Donef("using calabash-cucumber with bundler")
+
+		runSummary.Versions.CalabashCucumber = lockfileVersion
+		runSummary.Versions.Cucumber = lockfileCucumberVersion
+	} else {
+		ctx.CucumberVersion = configs.CucumberVersion
+		if ctx.CalabashCucumberVersion != "" {
+			log.Donef("using calabash-cucumber version: %s", ctx.CalabashCucumberVersion)
+		} else {
+			log.Donef("using calabash-cucumber latest version")
+		}
+		if ctx.CucumberVersion != "" {
+			log.Donef("using cucumber version: %s", ctx.CucumberVersion)
+		}
+
+		runSummary.Versions.CalabashCucumber = ctx.CalabashCucumberVersion
+		runSummary.Versions.Cucumber = ctx.CucumberVersion
+	}
+	runSummary.Versions.UseBundler = ctx.UseBundler
+
+	return nil
+}
+
+// Bundle install strategies, env points bundler to the Gemfile with BUNDLE_GEMFILE, chdir runs bundler in the Gemfile's dir
+const (
+	bundleInstallStrategyEnv   = "env"
+	bundleInstallStrategyChdir = "chdir"
+)
+
+var bundleInstallStrategies = []string{bundleInstallStrategyEnv, bundleInstallStrategyChdir}
+
+// bundlerEnvs returns the envs pointing bundler to the Gemfile independently of the working dir:
+// the absolute BUNDLE_GEMFILE and BUNDLE_APP_CONFIG set to the .bundle config dir next to the Gemfile,
+// as some bundler versions look for the config relative to the working dir. A BUNDLE_APP_CONFIG set by the user is kept.
+func (ctx *StepContext) bundlerEnvs() []string {
+	envs := []string{"BUNDLE_GEMFILE=" + ctx.GemFilePath}
+	if os.Getenv("BUNDLE_APP_CONFIG") == "" {
+		envs = append(envs, "BUNDLE_APP_CONFIG="+filepath.Join(filepath.Dir(ctx.GemFilePath), ".bundle"))
+	}
+	return envs
+}
+
+// setBundleCommandDirAndEnvs sets up the bundle install and check commands according to the bundle_install_strategy:
+// with chdir, the command runs in the Gemfile's dir without any bundler env overrides, with env, the bundlerEnvs are set.
+func (ctx *StepContext) setBundleCommandDirAndEnvs(cmd *command.Model) {
+	if ctx.Configs.BundleInstallStrategy == bundleInstallStrategyChdir {
+		dir := filepath.Dir(ctx.GemFilePath)
+		cmd.SetDir(dir)
+		log.Printf("Bundle install strategy: %s, cwd: %s, no bundler env override", bundleInstallStrategyChdir, dir)
+		return
+	}
+
+	envs := ctx.bundlerEnvs()
+	cmd.AppendEnvs(envs...)
+	log.Printf("Bundle install strategy: %s, envs: %s", bundleInstallStrategyEnv, strings.Join(envs, " "))
+}
+
+// isDefaultGemfileFoundFrom reports whether bundler finds the Gemfile without BUNDLE_GEMFILE if it runs in the dir:
+// bundler looks for a file named Gemfile in the dir and its parents.
+func isDefaultGemfileFoundFrom(gemfilePth, dir string) bool {
+	if filepath.Base(gemfilePth) != "Gemfile" {
+		return false
+	}
+
+	gemfileDir := filepath.Dir(gemfilePth)
+	for {
+		if exist, err := pathutil.IsPathExists(filepath.Join(dir, "Gemfile")); err == nil && exist {
+			return dir == gemfileDir
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return false
+		}
+		dir = parent
+	}
+}
+
+// cucumberRequiresBundleGemfile reports whether the `bundle exec cucumber` command, run in the work dir, needs BUNDLE_GEMFILE:
+// with the chdir bundle_install_strategy, only if bundler would not find the Gemfile from the work dir.
+func (ctx *StepContext) cucumberRequiresBundleGemfile() bool {
+	return ctx.Configs.BundleInstallStrategy != bundleInstallStrategyChdir || !isDefaultGemfileFoundFrom(ctx.GemFilePath, ctx.WorkDir)
+}
+
+// cucumberBundlerEnvs returns the bundler envs of the `bundle exec cucumber` command.
+func (ctx *StepContext) cucumberBundlerEnvs() []string {
+	if ctx.Configs.BundleInstallStrategy != bundleInstallStrategyChdir {
+		return ctx.bundlerEnvs()
+	}
+
+	if !ctx.cucumberRequiresBundleGemfile() {
+		log.Printf("Bundle install strategy: %s, bundler finds the Gemfile from the work dir, no bundler env override", bundleInstallStrategyChdir)
+		return nil
+	}
+	log.Printf("Bundle install strategy: %s, bundler does not find the Gemfile from the work dir, setting BUNDLE_GEMFILE", bundleInstallStrategyChdir)
+	return []string{"BUNDLE_GEMFILE=" + ctx.GemFilePath}
+}
+
+// installCalabash installs the pinned calabash-cucumber version, the Gemfile's gems or the latest calabash-cucumber.
+func (ctx *StepContext) installCalabash() error {
+	fmt.Println()
+	log.Infof("Installing calabash-cucumber...")
+
+	if ctx.UseSandbox {
+		return ctx.setUpCalabashSandbox(true)
+	} else if ctx.UseBundler {
+		if ctx.LockfilePlatformMissing && ctx.Configs.FixLockfilePlatform == "yes" {
+			if err := ctx.fixLockfilePlatform(); err != nil {
+				return err
+			}
+		}
+
+		bundleInstallCmd, err := rubycommand.New("bundle", "install", "--jobs", "20", "--retry", "5")
+		if err != nil {
+			return newStepError(categoryDependencyInstall, "Failed to create command, error: %s", err)
+		}
+
+		// the output is captured as well, to surface a version conflict in the failure message
+		var output bytes.Buffer
+		outputWriter := io.MultiWriter(stepLogger, &output)
+
+		ctx.setBundleCommandDirAndEnvs(bundleInstallCmd)
+		bundleInstallCmd.SetStdout(outputWriter).SetStderr(outputWriter)
+
+		if err := runCommand(bundleInstallCmd); err != nil {
+			if conflict, ok := parseBundlerConflict(output.String()); ok {
+				return newStepError(categoryDependencyInstall, "bundle install failed, Bundler could not resolve the gem versions:\n%s", conflict)
+			}
+			return newStepError(categoryDependencyInstall, "bundle install failed, error: %s", err)
+		}
+		return nil
+	}
+
+	if ctx.CucumberVersion != "" {
+		if err := ctx.installPinnedCucumber(); err != nil {
+			return err
+		}
+	}
+
+	if ctx.CalabashCucumberVersion != "" {
+		if err := ctx.installPinnedCalabash(); err != nil {
+			return err
+		}
+	} else if err := gemInstall("calabash-cucumber", ""); err != nil {
+		return err
+	}
+
+	if ctx.CucumberVersion != "" {
+		if err := ctx.checkCucumberRequirement(); err != nil {
+			return err
+		}
+	}
+
+	// the shims of an already installed version might be stale as well, they are rehashed after every plain install
+	rehashRbenvShims()
+	return nil
+}
+
+// parseInstalledGemVersions returns the versions of the gem from the `gem list` output, like `calabash-cucumber (0.21.10, default: 0.20.5)`.
+func parseInstalledGemVersions(out, gem string) []string {
+	prefix := gem + " ("
+	for _, line := range strings.Split(out, "\n") {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, prefix) || !strings.HasSuffix(line, ")") {
+			continue
+		}
+
+		versions := []string{}
+		for _, version := range strings.Split(strings.TrimSuffix(strings.TrimPrefix(line, prefix), ")"), ",") {
+			version = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(version), "default:"))
+			// platform specific versions are listed as `<version> <platform>`
+			if fields := strings.Fields(version); len(fields) > 0 {
+				versions = append(versions, fields[0])
+			}
+		}
+		return versions
+	}
+	return nil
+}
+
+// installedCalabashVersions returns the installed calabash-cucumber versions, the latest first.
+func installedCalabashVersions() ([]string, error) {
+	return installedGemVersions("calabash-cucumber")
+}
+
+// installedGemVersions returns the installed versions of the gem, the latest first.
+func installedGemVersions(gem string) ([]string, error) {
+	cmd, err := rubycommand.New("gem", "list", gem, "--exact")
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := commandRecorder.RunAndReturnTrimmedCombinedOutput(cmd)
+	if err != nil {
+		return nil, fmt.Errorf("%s failed, output: %s, error: %s", cmd.PrintableCommandArgs(), out, err)
+	}
+	return parseInstalledGemVersions(out, gem), nil
+}
+
+func installedVersionsString(versions []string) string {
+	if len(versions) == 0 {
+		return "none"
+	}
+	return strings.Join(versions, ", ")
+}
+
+// installPinnedCalabash installs the pinned calabash-cucumber version if the exact version is not installed yet,
+// and verifies the installation. Other installed versions are uninstalled if prune_other_calabash_versions is set,
+// as the `cucumber _<version>_` shim might resolve to one of them.
+func (ctx *StepContext) installPinnedCalabash() error {
+	pinned := ctx.CalabashCucumberVersion
+
+	versions, err := installedCalabashVersions()
+	if err != nil {
+		return newStepError(categoryDependencyInstall, "Failed to list the installed calabash-cucumber versions, error: %s", err)
+	}
+	log.Printf("Installed calabash-cucumber versions: %s", installedVersionsString(versions))
+
+	if indexOfGemVersion(pinned, versions) != -1 {
+		log.Printf("calabash-cucumber %s installed", pinned)
+	} else {
+		if err := gemInstall("calabash-cucumber", pinned); err != nil {
+			return err
+		}
+
+		versions, err = installedCalabashVersions()
+		if err != nil {
+			return newStepError(categoryDependencyInstall, "Failed to list the installed calabash-cucumber versions, error: %s", err)
+		}
+		if indexOfGemVersion(pinned, versions) == -1 {
+			return newStepError(categoryDependencyInstall, "calabash-cucumber %s is not installed after gem install, installed versions: %s", pinned, installedVersionsString(versions))
+		}
+		log.Donef("calabash-cucumber %s installed", pinned)
+	}
+
+	if ctx.Configs.PruneOtherCalabashVersions == "yes" {
+		pruneCalabashVersions(pinned, versions)
+	}
+	return nil
+}
+
+// pruneCalabashVersions uninstalls the calabash-cucumber versions other than the pinned one, failures are logged as warnings only.
+func pruneCalabashVersions(pinned string, versions []string) {
+	for _, version := range versions {
+		if gemVersionsEqual(version, pinned) {
+			continue
+		}
+
+		cmd, err := rubycommand.New("gem", "uninstall", "calabash-cucumber", "--version", version, "--executables", "--ignore-dependencies")
+		if err != nil {
+			log.Warnf("Failed to create command, error: %s", err)
+			continue
+		}
+		cmd.SetStdout(stepLogger).SetStderr(stepLogger)
+
+		if err := runCommand(cmd); err != nil {
+			log.Warnf("Failed to uninstall calabash-cucumber %s, error: %s", version, err)
+			continue
+		}
+		log.Printf("Uninstalled calabash-cucumber %s", version)
+	}
+}
+
+func gemInstall(gem, version string) error {
+	// RubyGems skips the prerelease versions without --prerelease
+	installCommands, err := rubycommand.GemInstall(gem, version, isPrereleaseGemVersion(version))
+	if err != nil {
+		return newStepError(categoryDependencyInstall, "Failed to create gem install commands, error: %s", err)
+	}
+
+	for _, installCommand := range installCommands {
+		// the shims are rehashed by the install, once the gems are installed
+		if isRbenvRehashCommand(installCommand) {
+			continue
+		}
+		installCommand.SetStdout(stepLogger).SetStderr(stepLogger)
+
+		if err := runCommand(installCommand); err != nil {
+			return newStepError(categoryDependencyInstall, "command failed, error: %s", err)
+		}
+	}
+	return nil
+}
+
+// verifyCalabashInstalled checks that a previous prepare_only run installed the required gems.
+func (ctx *StepContext) verifyCalabashInstalled() error {
+	fmt.Println()
+	log.Infof("Verifying calabash-cucumber installation...")
+
+	if ctx.UseSandbox {
+		return ctx.setUpCalabashSandbox(false)
+	}
+
+	if ctx.UseBundler {
+		bundleCheckCmd, err := rubycommand.New("bundle", "check")
+		if err != nil {
+			return newStepError(categoryDependencyInstall, "Failed to create command, error: %s", err)
+		}
+
+		ctx.setBundleCommandDirAndEnvs(bundleCheckCmd)
+		bundleCheckCmd.SetStdout(stepLogger).SetStderr(stepLogger)
+
+		if err := runCommand(bundleCheckCmd); err != nil {
+			return newStepError(categoryDependencyInstall, "the Gemfile's dependencies are not installed, run the step in prepare_only mode first, error: %s", err)
+		}
+
+		log.Donef("Gemfile's dependencies are installed")
+		return nil
+	}
+
+	gem := "calabash-cucumber"
+	if ctx.CalabashCucumberVersion != "" {
+		gem += " " + ctx.CalabashCucumberVersion
+	}
+
+	versions, err := installedCalabashVersions()
+	if err != nil {
+		return newStepError(categoryDependencyInstall, "Failed to check if %s installed, error: %s", gem, err)
+	}
+	log.Printf("Installed calabash-cucumber versions: %s", installedVersionsString(versions))
+
+	if len(versions) == 0 || (ctx.CalabashCucumberVersion != "" && indexOfGemVersion(ctx.CalabashCucumberVersion, versions) == -1) {
+		return newStepError(categoryDependencyInstall, "%s is not installed, run the step in prepare_only mode first", gem)
+	}
+
+	if ctx.CucumberVersion != "" {
+		if err := ctx.verifyCucumberInstalled(); err != nil {
+			return err
+		}
+	}
+
+	log.Donef("calabash-cucumber is installed")
+	return nil
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestDecideDependencyResolution(t *testing.T) {
+	tests := []struct {
+		name            string
+		resolution      string
+		pinnedVersion   string
+		lockfileFound   bool
+		lockfileVersion string
+		wantBundler     bool
+		wantVersion     string
+		wantErr         bool
+	}{
+		{name: "auto, nothing found", resolution: dependencyResolutionAuto},
+		{name: "auto, pinned version only", resolution: dependencyResolutionAuto, pinnedVersion: "0.21.10", wantVersion: "0.21.10"},
+		{name: "auto, Gemfile.lock only", resolution: dependencyResolutionAuto, lockfileFound: true, lockfileVersion: "0.21.10", wantBundler: true},
+		{name: "auto, versions agree", resolution: dependencyResolutionAuto, pinnedVersion: "0.21.10", lockfileFound: true, lockfileVersion: "0.21.10", wantBundler: true},
+		{name: "auto, versions conflict", resolution: dependencyResolutionAuto, pinnedVersion: "0.21.10", lockfileFound: true, lockfileVersion: "0.20.5", wantErr: true},
+		{name: "auto, Gemfile.lock without calabash-cucumber", resolution: dependencyResolutionAuto, pinnedVersion: "0.21.10", lockfileFound: true, wantVersion: "0.21.10"},
+		{name: "bundler, versions conflict", resolution: dependencyResolutionBundler, pinnedVersion: "0.21.10", lockfileFound: true, lockfileVersion: "0.20.5", wantBundler: true},
+		{name: "bundler, no Gemfile.lock", resolution: dependencyResolutionBundler, pinnedVersion: "0.21.10", wantErr: true},
+		{name: "gem version, versions conflict", resolution: dependencyResolutionGemVersion, pinnedVersion: "0.21.10", lockfileFound: true, lockfileVersion: "0.20.5", wantVersion: "0.21.10"},
+		{name: "gem version, latest", resolution: dependencyResolutionGemVersion, lockfileFound: true, lockfileVersion: "0.20.5"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decision, err := decideDependencyResolution(tt.resolution, tt.pinnedVersion, tt.lockfileFound, tt.lockfileVersion)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got decision: %+v", decision)
+				}
+				if category := failureCategoryOf(err); category != categoryInvalidInput {
+					t.Errorf("error category: %v, expected: %v", category, categoryInvalidInput)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if decision.UseBundler != tt.wantBundler || decision.Version != tt.wantVersion {
+				t.Errorf("decision: %+v, expected bundler: %v, version: %s", decision, tt.wantBundler, tt.wantVersion)
+			}
+			if decision.Reason == "" {
+				t.Error("decision has no reason")
+			}
+		})
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/log"
+)
+
+const deprecationCountOutputKey = "BITRISE_CALABASH_DEPRECATION_COUNT"
+
+var (
+	// deprecatedHelperExp matches the warnings of the calabash-cucumber and run_loop deprecated helpers:
+	// `WARN: deprecated '0.19.0' - use 'foo' instead`
+	deprecatedHelperExp = regexp.MustCompile(`(?i)^WARN: deprecated\b`)
+	// deprecationMentionExp matches any other deprecation notice mentioning calabash or run_loop
+	deprecationMentionExp = regexp.MustCompile(`(?i)deprecat.*(calabash|run_?loop)|(calabash|run_?loop).*deprecat`)
+)
+
+// isDeprecationWarning reports whether a (color codes stripped, trimmed) cucumber output line is a calabash deprecation warning.
+func isDeprecationWarning(line string) bool {
+	return deprecatedHelperExp.MatchString(line) || deprecationMentionExp.MatchString(line)
+}
+
+// DeprecationCollector collects the unique deprecation warnings of the cucumber runs, in the order of their first occurrence.
+type DeprecationCollector struct {
+	warnings []string
+	seen     map[string]bool
+}
+
+// NewDeprecationCollector ...
+func NewDeprecationCollector() *DeprecationCollector {
+	return &DeprecationCollector{seen: map[string]bool{}}
+}
+
+// Add adds the warning, unless it was already collected: calabash prints most warnings once per scenario.
+func (c *DeprecationCollector) Add(warning string) {
+	if c.seen[warning] {
+		return
+	}
+	c.seen[warning] = true
+	c.warnings = append(c.warnings, warning)
+}
+
+// Warnings ...
+func (c *DeprecationCollector) Warnings() []string {
+	return c.warnings
+}
+
+var deprecations = NewDeprecationCollector()
+
+// DeprecationScanner passes the cucumber output through, while collecting the deprecation warnings.
+type DeprecationScanner struct {
+	out       io.Writer
+	line      []byte
+	collector *DeprecationCollector
+}
+
+// NewDeprecationScanner ...
+func NewDeprecationScanner(out io.Writer, collector *DeprecationCollector) *DeprecationScanner {
+	return &DeprecationScanner{out: out, collector: collector}
+}
+
+func (s *DeprecationScanner) Write(p []byte) (int, error) {
+	s.line = append(s.line, p...)
+	for {
+		idx := bytes.IndexByte(s.line, '\n')
+		if idx == -1 {
+			break
+		}
+		s.scanLine(string(s.line[:idx]))
+		s.line = s.line[idx+1:]
+	}
+	return s.out.Write(p)
+}
+
+func (s *DeprecationScanner) scanLine(line string) {
+	line = strings.TrimSpace(stripANSI(line))
+	if isDeprecationWarning(line) {
+		s.collector.Add(line)
+	}
+}
+
+// Flush scans the last, not newline terminated line.
+func (s *DeprecationScanner) Flush() {
+	if len(s.line) > 0 {
+		s.scanLine(string(s.line))
+		s.line = nil
+	}
+}
+
+// printDeprecations prints the collected deprecation warnings and exports their count.
+func printDeprecations() {
+	warnings := deprecations.Warnings()
+	exportOutput(deprecationCountOutputKey, fmt.Sprintf("%d", len(warnings)))
+
+	if len(warnings) == 0 {
+		return
+	}
+
+	fmt.Println()
+	log.Warnf("Deprecations (%d):", len(warnings))
+	for _, warning := range warnings {
+		log.Printf("- %s", warning)
+	}
+	log.Printf("Update the features and the step definitions before upgrading calabash-cucumber.")
+}
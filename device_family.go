@@ -0,0 +1,211 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/command"
+	"github.com/bitrise-io/go-utils/log"
+)
+
+// Device families, as listed in the UIDeviceFamily key of the app's Info.plist
+const (
+	deviceFamilyIPhone  = 1
+	deviceFamilyIPad    = 2
+	deviceFamilyAppleTV = 3
+)
+
+func deviceFamilyName(family int) string {
+	switch family {
+	case deviceFamilyIPhone:
+		return "iPhone"
+	case deviceFamilyIPad:
+		return "iPad"
+	case deviceFamilyAppleTV:
+		return "Apple TV"
+	}
+	return fmt.Sprintf("device family %d", family)
+}
+
+// parseDeviceFamilies reads the UIDeviceFamily values of an Info.plist,
+// a missing UIDeviceFamily means a universal app and is returned as nil.
+func parseDeviceFamilies(infoPlist map[string]interface{}) ([]int, error) {
+	value, ok := infoPlist["UIDeviceFamily"]
+	if !ok {
+		return nil, nil
+	}
+
+	values, ok := value.([]interface{})
+	if !ok {
+		values = []interface{}{value}
+	}
+
+	families := []int{}
+	for _, v := range values {
+		switch family := v.(type) {
+		case float64:
+			families = append(families, int(family))
+		case string:
+			i, err := strconv.Atoi(family)
+			if err != nil {
+				return nil, fmt.Errorf("invalid UIDeviceFamily value: %s", family)
+			}
+			families = append(families, i)
+		default:
+			return nil, fmt.Errorf("invalid UIDeviceFamily value: %v", v)
+		}
+	}
+	return families, nil
+}
+
+// deviceFamilyOfDeviceType returns the device family of a simulator device type identifier
+// (for example com.apple.CoreSimulator.SimDeviceType.iPad-Air) or device name, 0 if unknown.
+func deviceFamilyOfDeviceType(deviceType string) int {
+	deviceType = strings.ToLower(deviceType)
+	switch {
+	case strings.Contains(deviceType, "apple-tv"), strings.Contains(deviceType, "apple tv"):
+		return deviceFamilyAppleTV
+	case strings.Contains(deviceType, "ipad"):
+		return deviceFamilyIPad
+	case strings.Contains(deviceType, "iphone"), strings.Contains(deviceType, "ipod"):
+		return deviceFamilyIPhone
+	}
+	return 0
+}
+
+// SimctlDeviceModel is a device listed by `xcrun simctl list devices --json`.
+type SimctlDeviceModel struct {
+	UDID                 string `json:"udid"`
+	Name                 string `json:"name"`
+	State                string `json:"state"`
+	IsAvailable          *bool  `json:"isAvailable"`
+	Availability         string `json:"availability"`
+	AvailabilityError    string `json:"availabilityError"`
+	DeviceTypeIdentifier string `json:"deviceTypeIdentifier"`
+	DataPath             string `json:"dataPath"`
+}
+
+// availabilityReason returns why simctl lists the device as unavailable, like `device type profile not found`,
+// older simctl versions list it in the availability, like `(unavailable, runtime profile not found)`.
+func (device SimctlDeviceModel) availabilityReason() string {
+	if device.AvailabilityError != "" {
+		return device.AvailabilityError
+	}
+	if reason := strings.Trim(strings.TrimPrefix(device.Availability, "(unavailable"), ", ()"); reason != "" {
+		return reason
+	}
+	return "unavailable"
+}
+
+type simctlDevicesModel struct {
+	Devices map[string][]SimctlDeviceModel `json:"devices"`
+}
+
+// runtimeDevices returns the devices of the runtime, older simctl versions list the devices by the runtime names.
+func (devices simctlDevicesModel) runtimeDevices(runtime SimulatorRuntimeModel) []SimctlDeviceModel {
+	runtimeDevices, ok := devices.Devices[runtime.Identifier]
+	if !ok {
+		runtimeDevices = devices.Devices[runtime.Name]
+	}
+	return runtimeDevices
+}
+
+func listSimctlDevices() (simctlDevicesModel, error) {
+	cmd := command.New("xcrun", "simctl", "list", "devices", "--json")
+	out, err := commandRecorder.RunAndReturnTrimmedCombinedOutput(cmd)
+	if err != nil {
+		return simctlDevicesModel{}, fmt.Errorf("%s failed, output: %s, error: %s", cmd.PrintableCommandArgs(), out, err)
+	}
+
+	var devices simctlDevicesModel
+	if err := json.Unmarshal([]byte(out), &devices); err != nil {
+		return simctlDevicesModel{}, err
+	}
+
+	// after an Xcode downgrade the simulators of unknown device types and runtimes are listed as unavailable
+	for runtime, runtimeDevices := range devices.Devices {
+		for _, device := range runtimeDevices {
+			if !simctlAvailable(device.IsAvailable, device.Availability) {
+				log.Debugf("Simulator %s (%s) on %s is unavailable: %s", device.Name, device.UDID, runtime, device.availabilityReason())
+			}
+		}
+	}
+	return devices, nil
+}
+
+// simulatorDeviceType returns the device type identifier of the simulator, empty if simctl does not list it.
+func simulatorDeviceType(udid string) (string, error) {
+	devices, err := listSimctlDevices()
+	if err != nil {
+		return "", err
+	}
+
+	for _, runtimeDevices := range devices.Devices {
+		for _, device := range runtimeDevices {
+			if device.UDID == udid {
+				return device.DeviceTypeIdentifier, nil
+			}
+		}
+	}
+	return "", nil
+}
+
+// checkDeviceFamily compares the app's supported device families with the simulator's family,
+// an incompatible simulator is reported with a warning, or fails the step if strict is set.
+func (ctx *StepContext) checkDeviceFamily(strict bool) error {
+	if ctx.AppPath == "" {
+		return nil
+	}
+
+	infoPlist, err := appInfoPlist(ctx.AppPath)
+	if err != nil {
+		log.Warnf("%s, skipping device family check", err)
+		return nil
+	}
+
+	appFamilies, err := parseDeviceFamilies(infoPlist)
+	if err != nil {
+		log.Warnf("Failed to parse UIDeviceFamily of the app's Info.plist, skipping device family check, error: %s", err)
+		return nil
+	}
+	if appFamilies == nil {
+		log.Printf("UIDeviceFamily is not set, the app is universal")
+		return nil
+	}
+
+	deviceType, err := simulatorDeviceType(ctx.Simulator.ID)
+	if err != nil {
+		log.Warnf("Failed to get the simulator's device type, error: %s", err)
+	}
+	if deviceType == "" {
+		deviceType = ctx.Simulator.Name
+	}
+
+	simulatorFamily := deviceFamilyOfDeviceType(deviceType)
+	if simulatorFamily == 0 {
+		log.Warnf("Unknown device family of simulator (%s), skipping device family check", deviceType)
+		return nil
+	}
+
+	appFamilyNames := []string{}
+	for _, family := range appFamilies {
+		if family == simulatorFamily {
+			log.Printf("The app supports the simulator's device family (%s)", deviceFamilyName(simulatorFamily))
+			return nil
+		}
+		appFamilyNames = append(appFamilyNames, deviceFamilyName(family))
+	}
+
+	message := fmt.Sprintf("The app supports %s devices only, but the selected simulator (%s) is an %s device",
+		strings.Join(appFamilyNames, ", "), ctx.Simulator.Name, deviceFamilyName(simulatorFamily))
+	if strict {
+		return newStepError(categoryInvalidInput, "%s", message)
+	}
+
+	fmt.Println()
+	log.Warnf("%s", message)
+	log.Warnf("Installing the app on the simulator is likely to fail, select a simulator of a supported device family.")
+	return nil
+}
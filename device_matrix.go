@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/log"
+)
+
+const deviceMatrixSummaryOutputKey = "BITRISE_CALABASH_DEVICE_MATRIX_SUMMARY"
+
+var repeatedUnderscoresExp = regexp.MustCompile(`_+`)
+
+// parseSimulatorDevices parses the simulator devices, one per line, empty lines are skipped.
+// A device might contain its OS version, like `iPhone 8 (12.1)`.
+func parseSimulatorDevices(value string) ([]string, error) {
+	devices := []string{}
+	for i, line := range strings.Split(value, "\n") {
+		device := strings.TrimSpace(line)
+		if device == "" {
+			continue
+		}
+
+		if indexInStringSlice(device, devices) != -1 {
+			return nil, fmt.Errorf("invalid SimulatorDevices line %d (%s), duplicated device", i+1, device)
+		}
+		devices = append(devices, device)
+	}
+	return devices, nil
+}
+
+// deviceOutputKey returns the output key of a per-device value, for example BITRISE_CALABASH_DEVICE_IPHONE_SE_2ND_GENERATION_PASSED_COUNT.
+func deviceOutputKey(device, suffix string) string {
+	name := outputKeyInvalidCharExp.ReplaceAllString(strings.ToUpper(device), "_")
+	name = strings.Trim(repeatedUnderscoresExp.ReplaceAllString(name, "_"), "_")
+	return "BITRISE_CALABASH_DEVICE_" + name + "_" + suffix
+}
+
+// deviceResultsPath returns the device's dir within the reports or screenshots subdir, for example iPhone-SE-2nd-generation.
+func deviceResultsPath(device string) string {
+	return strings.Trim(resultsDirNameUnsafeCharsExp.ReplaceAllString(device, "-"), "-")
+}
+
+// runDeviceMatrix runs the suite once per simulator device, with retries applied to each run.
+// The simulator is resolved and prepared for each device, the installed gems are reused.
+// Every device runs to completion, the first failed device's failure is returned.
+func (ctx *StepContext) runDeviceMatrix() error {
+	osVersion := ctx.Configs.SimulatorOsVersion
+
+	runs := ctx.runMatrix("Device", ctx.SimulatorDevices, deviceOutputKey, func(device string) {
+		ctx.Device = device
+		ctx.Configs.SimulatorDevice = device
+		ctx.Configs.SimulatorOsVersion = osVersion
+		if name, deviceOsVersion, ok := splitSimulatorDevice(device, platformOrDefault(ctx.Configs.Platform)); ok {
+			ctx.Configs.SimulatorDevice = name
+			ctx.Configs.SimulatorOsVersion = deviceOsVersion
+		}
+	})
+
+	for _, run := range runs {
+		runSummary.Devices = append(runSummary.Devices, DeviceSummaryModel{
+			Device:    run.Entry,
+			Simulator: run.Simulator,
+			Result:    run.Result(),
+			Scenarios: run.Scenarios,
+		})
+	}
+
+	table := deviceMatrixTable(runs)
+
+	fmt.Println()
+	log.Infof("Device matrix results:")
+	log.Printf("%s", table)
+
+	exportOutput(deviceMatrixSummaryOutputKey, table)
+
+	if failed, firstErr := failedMatrixEntries(runs); firstErr != nil {
+		return newStepError(failureCategoryOf(firstErr), "Device matrix failed on: %s, first failure: %s", strings.Join(failed, ", "), firstErr)
+	}
+	return nil
+}
+
+func deviceMatrixTable(runs []MatrixRunModel) string {
+	width := len("Device")
+	for _, run := range runs {
+		if len(run.Entry) > width {
+			width = len(run.Entry)
+		}
+	}
+
+	lines := []string{fmt.Sprintf("%-*s %-12s %-10s %8s %8s %8s", width, "Device", "Runtime", "Result", "Passed", "Failed", "Total")}
+	for _, run := range runs {
+		runtime := run.Simulator.Runtime
+		if runtime == "" {
+			runtime = "-"
+		}
+		lines = append(lines, fmt.Sprintf("%-*s %-12s %-10s %8d %8d %8d", width, run.Entry, runtime, run.Result(), run.Scenarios.Passed, run.Scenarios.Failed, run.Scenarios.Total))
+	}
+	return strings.Join(lines, "\n")
+}
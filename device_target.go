@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/log"
+)
+
+const (
+	deviceTargetFormatUDID            = "udid"
+	deviceTargetFormatInstrumentsName = "instruments_name"
+)
+
+var deviceTargetFormats = []string{deviceTargetFormatUDID, deviceTargetFormatInstrumentsName}
+
+// instrumentsDeviceTarget returns the Instruments-style device target, like `iPhone 6 (9.3) [<udid>]`,
+// which legacy calabash-cucumber versions resolve more reliably than a bare UDID.
+func instrumentsDeviceTarget(name, osVersion, udid string) (string, error) {
+	version := osVersionNumber(osVersion)
+
+	missing := []string{}
+	if name == "" {
+		missing = append(missing, "name")
+	}
+	if version == "" {
+		missing = append(missing, "OS version")
+	}
+	if udid == "" {
+		missing = append(missing, "UDID")
+	}
+	if len(missing) > 0 {
+		return "", fmt.Errorf("the simulator's %s is unknown", strings.Join(missing, ", "))
+	}
+
+	return fmt.Sprintf("%s (%s) [%s]", name, version, udid), nil
+}
+
+// resolveDeviceTarget sets the DEVICE_TARGET of the resolved simulator according to the device_target_format.
+func (ctx *StepContext) resolveDeviceTarget() error {
+	if ctx.Configs.DeviceTargetFormat != deviceTargetFormatInstrumentsName {
+		ctx.DeviceTarget = ctx.Simulator.ID
+		return nil
+	}
+
+	target, err := instrumentsDeviceTarget(ctx.Simulator.Name, ctx.SimulatorOsVersion, ctx.Simulator.ID)
+	if err != nil {
+		return newStepError(categoryInfrastructure, "Failed to construct the Instruments-style DEVICE_TARGET, %s", err)
+	}
+	ctx.DeviceTarget = target
+
+	log.Printf("DEVICE_TARGET: %s", ctx.DeviceTarget)
+	return nil
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/command"
+	"github.com/bitrise-io/go-utils/log"
+)
+
+// maxDeviceNameSuggestions is the number of the closest device type names suggested for a mistyped SimulatorDevice.
+const maxDeviceNameSuggestions = 3
+
+type simctlDeviceTypesModel struct {
+	DeviceTypes []struct {
+		Name          string `json:"name"`
+		Identifier    string `json:"identifier"`
+		ProductFamily string `json:"productFamily"`
+	} `json:"devicetypes"`
+}
+
+// listSimctlDeviceTypes returns the simulator device types of the Xcode selected by the developer dir,
+// the default Xcode's if empty.
+func listSimctlDeviceTypes(developerDir string) (simctlDeviceTypesModel, error) {
+	cmd := command.New("xcrun", "simctl", "list", "devicetypes", "--json")
+	if developerDir != "" {
+		cmd.AppendEnvs("DEVELOPER_DIR=" + developerDir)
+	}
+
+	out, err := commandRecorder.RunAndReturnTrimmedCombinedOutput(cmd)
+	if err != nil {
+		return simctlDeviceTypesModel{}, fmt.Errorf("%s failed, output: %s, error: %s", cmd.PrintableCommandArgs(), out, err)
+	}
+
+	var deviceTypes simctlDeviceTypesModel
+	if err := json.Unmarshal([]byte(out), &deviceTypes); err != nil {
+		return simctlDeviceTypesModel{}, err
+	}
+	return deviceTypes, nil
+}
+
+// simctlDeviceTypeIdentifier returns the identifier of the device type of the given name, like com.apple.CoreSimulator.SimDeviceType.iPhone-8.
+func simctlDeviceTypeIdentifier(name, developerDir string) (string, error) {
+	deviceTypes, err := listSimctlDeviceTypes(developerDir)
+	if err != nil {
+		return "", err
+	}
+
+	for _, deviceType := range deviceTypes.DeviceTypes {
+		if deviceType.Name == name {
+			return deviceType.Identifier, nil
+		}
+	}
+	return "", fmt.Errorf("no simulator device type found with name: %s", name)
+}
+
+// listSimctlDeviceTypeNames returns the names of the platform's simulator device types of the Xcode selected by the developer dir,
+// the default Xcode's if empty.
+func listSimctlDeviceTypeNames(platform, developerDir string) ([]string, error) {
+	deviceTypes, err := listSimctlDeviceTypes(developerDir)
+	if err != nil {
+		return nil, err
+	}
+
+	names := []string{}
+	for _, deviceType := range deviceTypes.DeviceTypes {
+		if deviceTypePlatform(deviceType.ProductFamily, deviceType.Identifier) == platform {
+			names = append(names, deviceType.Name)
+		}
+	}
+	return names, nil
+}
+
+// levenshteinDistance returns the number of single character edits turning a into b.
+func levenshteinDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+
+	previous := make([]int, len(rb)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		current := make([]int, len(rb)+1)
+		current[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			current[j] = minInt(previous[j]+1, minInt(current[j-1]+1, previous[j-1]+cost))
+		}
+		previous = current
+	}
+	return previous[len(rb)]
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// closestNames returns up to max names, the closest to the name first (compared case-insensitively).
+func closestNames(name string, names []string, max int) []string {
+	distances := map[string]int{}
+	for _, candidate := range names {
+		distances[candidate] = levenshteinDistance(strings.ToLower(name), strings.ToLower(candidate))
+	}
+
+	sorted := append([]string{}, names...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return distances[sorted[i]] < distances[sorted[j]]
+	})
+	if len(sorted) > max {
+		sorted = sorted[:max]
+	}
+	return sorted
+}
+
+// checkSimulatorDeviceName catches the mistyped SimulatorDevice inputs before anything is installed:
+// the device name has to match a simulator device type of the platform, or an existing simulator's (custom) name on the platform's runtimes,
+// at least case-insensitively. The runtime aware simulator lookup stays with the simulator resolution, if simctl fails here, the check is skipped.
+func checkSimulatorDeviceName(device, platform, developerDir string) error {
+	name := device
+	if deviceName, _, ok := splitSimulatorDevice(device, platform); ok {
+		name = deviceName
+	}
+
+	deviceTypeNames, err := listSimctlDeviceTypeNames(platform, developerDir)
+	if err != nil {
+		log.Warnf("Failed to list the simulator device types, skipping the SimulatorDevice check, error: %s", err)
+		return nil
+	}
+	for _, deviceTypeName := range deviceTypeNames {
+		if strings.EqualFold(name, deviceTypeName) {
+			return nil
+		}
+	}
+
+	// simulators can be created with a custom name
+	if devices, err := listSimctlDevices(); err == nil {
+		for runtime, runtimeDevices := range devices.Devices {
+			if !isPlatformRuntime(runtime, platform) {
+				continue
+			}
+			for _, simulatorDevice := range runtimeDevices {
+				if strings.EqualFold(name, simulatorDevice.Name) {
+					return nil
+				}
+			}
+		}
+	}
+
+	return fmt.Errorf("SimulatorDevice (%s) matches no %s simulator device type, did you mean: %s\navailable %s device types: %s", device, platform,
+		strings.Join(closestNames(name, deviceTypeNames, maxDeviceNameSuggestions), ", "), platform, strings.Join(deviceTypeNames, ", "))
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/log"
+)
+
+const (
+	diagnosticsManifestFileName      = "manifest.json"
+	defaultDiagnosticsSizeLimitInMB  = 200
+	diagnosticsManifestFormatVersion = "1.0.0"
+)
+
+// Diagnostic item kinds, the optional kinds listed in diagnosticsDropOrder are dropped first when the size limit is exceeded.
+const (
+	diagnosticKindRunSummary      = "run_summary"
+	diagnosticKindCommandsLog     = "commands_log"
+	diagnosticKindStepLog         = "step_log"
+	diagnosticKindCucumberReport  = "cucumber_report"
+	diagnosticKindCucumberOutput  = "cucumber_output"
+	diagnosticKindSimulatorLog    = "simulator_log"
+	diagnosticKindVideo           = "video"
+	diagnosticKindSimctlDiagnose  = "simctl_diagnose"
+	diagnosticKindScreenshots     = "screenshots"
+	diagnosticKindFailures        = "failures"
+	diagnosticKindAppContainer    = "app_container"
+	diagnosticKindPreferences     = "simulator_preferences"
+	diagnosticKindEffectiveConfig = "effective_config"
+)
+
+var diagnosticsDropOrder = []string{diagnosticKindVideo, diagnosticKindSimctlDiagnose, diagnosticKindAppContainer, diagnosticKindScreenshots}
+
+// DiagnosticItemModel is a file or dir collected into the diagnostics bundle.
+type DiagnosticItemModel struct {
+	Kind     string
+	Path     string
+	Optional bool
+}
+
+// DiagnosticsManifestEntryModel ...
+type DiagnosticsManifestEntryModel struct {
+	Name      string `json:"name,omitempty"`
+	Kind      string `json:"kind"`
+	Source    string `json:"source"`
+	SizeBytes int64  `json:"size_bytes"`
+	Optional  bool   `json:"optional"`
+	Included  bool   `json:"included"`
+	Note      string `json:"note,omitempty"`
+}
+
+// DiagnosticsManifestModel is the schema of the bundle's manifest.json.
+type DiagnosticsManifestModel struct {
+	FormatVersion  string                          `json:"format_version"`
+	Build          string                          `json:"build"`
+	SizeLimitBytes int64                           `json:"size_limit_bytes"`
+	Entries        []DiagnosticsManifestEntryModel `json:"entries"`
+}
+
+// DiagnosticsCollector gathers the diagnostics produced by the step and packages them into a single zip.
+type DiagnosticsCollector struct {
+	items          []DiagnosticItemModel
+	sizeLimitBytes int64
+}
+
+// NewDiagnosticsCollector ...
+func NewDiagnosticsCollector() *DiagnosticsCollector {
+	return &DiagnosticsCollector{sizeLimitBytes: defaultDiagnosticsSizeLimitInMB * 1024 * 1024}
+}
+
+// SetSizeLimit ...
+func (c *DiagnosticsCollector) SetSizeLimit(limitInMB int) {
+	c.sizeLimitBytes = int64(limitInMB) * 1024 * 1024
+}
+
+// Add registers a diagnostic item, registering the same path multiple times is a no-op.
+func (c *DiagnosticsCollector) Add(kind, pth string, optional bool) {
+	for _, item := range c.items {
+		if item.Path == pth {
+			return
+		}
+	}
+	c.items = append(c.items, DiagnosticItemModel{Kind: kind, Path: pth, Optional: optional})
+}
+
+func pathSize(pth string) (int64, error) {
+	var size int64
+	err := filepath.Walk(pth, func(_ string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
+}
+
+// dropPriority orders the optional items to drop: the kinds of diagnosticsDropOrder first, then the rest, larger items first.
+func dropPriority(kind string) int {
+	if idx := indexInStringSlice(kind, diagnosticsDropOrder); idx != -1 {
+		return idx
+	}
+	return len(diagnosticsDropOrder)
+}
+
+// manifest collects the existing items and drops the optional ones over the size limit.
+func (c *DiagnosticsCollector) manifest(build string) DiagnosticsManifestModel {
+	manifest := DiagnosticsManifestModel{
+		FormatVersion:  diagnosticsManifestFormatVersion,
+		Build:          build,
+		SizeLimitBytes: c.sizeLimitBytes,
+		Entries:        []DiagnosticsManifestEntryModel{},
+	}
+
+	usedNames := map[string]bool{}
+	var totalSize int64
+	for _, item := range c.items {
+		entry := DiagnosticsManifestEntryModel{Kind: item.Kind, Source: item.Path, Optional: item.Optional}
+
+		size, err := pathSize(item.Path)
+		if err != nil {
+			if !os.IsNotExist(err) {
+				entry.Note = fmt.Sprintf("failed to read: %s", err)
+				manifest.Entries = append(manifest.Entries, entry)
+			}
+			continue
+		}
+
+		name := filepath.Join(item.Kind, filepath.Base(item.Path))
+		for i := 2; usedNames[name]; i++ {
+			name = filepath.Join(item.Kind, fmt.Sprintf("%d_%s", i, filepath.Base(item.Path)))
+		}
+		usedNames[name] = true
+
+		entry.Name = name
+		entry.SizeBytes = size
+		entry.Included = true
+		totalSize += size
+
+		manifest.Entries = append(manifest.Entries, entry)
+	}
+
+	if totalSize <= c.sizeLimitBytes {
+		return manifest
+	}
+
+	droppable := []int{}
+	for i, entry := range manifest.Entries {
+		if entry.Included && entry.Optional {
+			droppable = append(droppable, i)
+		}
+	}
+	sort.SliceStable(droppable, func(i, j int) bool {
+		a, b := manifest.Entries[droppable[i]], manifest.Entries[droppable[j]]
+		if dropPriority(a.Kind) != dropPriority(b.Kind) {
+			return dropPriority(a.Kind) < dropPriority(b.Kind)
+		}
+		return a.SizeBytes > b.SizeBytes
+	})
+
+	for _, idx := range droppable {
+		if totalSize <= c.sizeLimitBytes {
+			break
+		}
+		entry := &manifest.Entries[idx]
+		entry.Included = false
+		entry.Note = fmt.Sprintf("dropped, the bundle exceeded the size limit (%d bytes)", c.sizeLimitBytes)
+		totalSize -= entry.SizeBytes
+	}
+
+	return manifest
+}
+
+// WriteZip packages the collected items and their manifest into calabash_diagnostics_<build>.zip in the given dir.
+func (c *DiagnosticsCollector) WriteZip(dir, build string) (string, error) {
+	manifest := c.manifest(build)
+
+	pth := filepath.Join(dir, fmt.Sprintf("calabash_diagnostics_%s.zip", build))
+	f, err := os.Create(pth)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		if err := f.Close(); err != nil {
+			log.Warnf("Failed to close file (%s), error: %s", pth, err)
+		}
+	}()
+
+	w := zip.NewWriter(f)
+
+	for i, entry := range manifest.Entries {
+		if !entry.Included {
+			continue
+		}
+		if err := addPathToZip(w, entry.Source, entry.Name); err != nil {
+			manifest.Entries[i].Included = false
+			manifest.Entries[i].Note = fmt.Sprintf("failed to add: %s", err)
+		}
+	}
+
+	b, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	manifestWriter, err := w.Create(diagnosticsManifestFileName)
+	if err != nil {
+		return "", err
+	}
+	if _, err := manifestWriter.Write(b); err != nil {
+		return "", err
+	}
+
+	if err := w.Close(); err != nil {
+		return "", err
+	}
+	return pth, nil
+}
+
+// addPathToZip adds a file, or a dir recursively, under the given name.
+func addPathToZip(w *zip.Writer, pth, name string) error {
+	return filepath.Walk(pth, func(walkPth string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(pth, walkPth)
+		if err != nil {
+			return err
+		}
+		entryName := name
+		if rel != "." {
+			entryName = filepath.Join(name, rel)
+		}
+
+		header, err := zip.FileInfoHeader(info)
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(entryName)
+		header.Method = zip.Deflate
+
+		entryWriter, err := w.CreateHeader(header)
+		if err != nil {
+			return err
+		}
+
+		src, err := os.Open(walkPth)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if err := src.Close(); err != nil {
+				log.Warnf("Failed to close file (%s), error: %s", walkPth, err)
+			}
+		}()
+
+		_, err = io.Copy(entryWriter, src)
+		return err
+	})
+}
+
+// diagnosticsBuild returns the build identifier used in the bundle's name.
+func diagnosticsBuild() string {
+	if build := strings.TrimSpace(os.Getenv("BITRISE_BUILD_NUMBER")); build != "" {
+		return build
+	}
+	return "local"
+}
+
+var diagnostics = NewDiagnosticsCollector()
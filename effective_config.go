@@ -0,0 +1,165 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/bitrise-io/go-utils/fileutil"
+	"github.com/bitrise-io/go-utils/log"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	effectiveConfigPathOutputKey = "BITRISE_CALABASH_EFFECTIVE_CONFIG_PATH"
+
+	effectiveConfigFileName      = "effective_config.yml"
+	effectiveConfigFormatVersion = "2.0.0"
+)
+
+// effectiveConfigSkippedFields are the StepContext fields left out of the effective configuration:
+// the inputs are written by their env keys, the rest are the results of the run, not its configuration.
+var effectiveConfigSkippedFields = []string{"Configs", "JSONReportPath", "ScenarioResults", "CalabashServerErrorDetected", "LaunchFailureCount"}
+
+// effectiveConfigSkippedInputFields are the ConfigsModel fields without an input, left out of the effective configuration:
+// ParsedOptions is written as the resolved options.
+var effectiveConfigSkippedInputFields = []string{"ParsedOptions"}
+
+// EffectiveConfigModel is the schema of effective_config.yml, its sections are converted by reflection from the structs
+// the run uses, so the new inputs and StepContext fields show up without changing the serialization.
+type EffectiveConfigModel struct {
+	FormatVersion string            `yaml:"format_version"`
+	Inputs        map[string]string `yaml:"inputs"`
+	Resolved      interface{}       `yaml:"resolved"`
+	Versions      interface{}       `yaml:"versions"`
+	Formatters    interface{}       `yaml:"formatters"`
+}
+
+// effectiveConfig returns every input's final value (secrets masked) and the decisions derived from them.
+func (ctx *StepContext) effectiveConfig() EffectiveConfigModel {
+	inputs := map[string]string{}
+	for key, value := range ctx.Configs.inputValues() {
+		if key == "app_launch_environment" {
+			value = maskedAppLaunchEnvironment(value)
+		}
+		inputs[key] = maskSecret(key, value)
+	}
+
+	return EffectiveConfigModel{
+		FormatVersion: effectiveConfigFormatVersion,
+		Inputs:        inputs,
+		Resolved:      effectiveConfigValue("", reflect.ValueOf(ctx)),
+		Versions:      effectiveConfigValue("", reflect.ValueOf(runSummary.Versions)),
+		Formatters:    effectiveConfigValue("", reflect.ValueOf(ctx.cucumberFormatters())),
+	}
+}
+
+// saveEffectiveConfig writes the effective configuration into the results dir, once the simulator, the app
+// and the gems are resolved, and exports its path. Failures are logged as warnings only.
+func (ctx *StepContext) saveEffectiveConfig() {
+	pth := filepath.Join(resultsDir(), effectiveConfigFileName)
+
+	content, err := yaml.Marshal(ctx.effectiveConfig())
+	if err != nil {
+		log.Warnf("Failed to serialize the effective configuration, error: %s", err)
+		return
+	}
+	if err := fileutil.WriteBytesToFile(pth, content); err != nil {
+		log.Warnf("Failed to write the effective configuration (%s), error: %s", pth, err)
+		return
+	}
+
+	log.Printf("Effective configuration: %s", pth)
+	exportOutput(effectiveConfigPathOutputKey, pth)
+	diagnostics.Add(diagnosticKindEffectiveConfig, pth, false)
+}
+
+// effectiveConfigFieldName returns the field's json tag name, or its name in snake case, like `json_report_path` for JSONReportPath.
+func effectiveConfigFieldName(field reflect.StructField) string {
+	if name := strings.Split(field.Tag.Get("json"), ",")[0]; name != "" {
+		return name
+	}
+
+	runes := []rune(field.Name)
+	name := []rune{}
+	for i, r := range runes {
+		if i > 0 && unicode.IsUpper(r) {
+			prev := runes[i-1]
+			nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) || (unicode.IsUpper(prev) && nextLower) {
+				name = append(name, '_')
+			}
+		}
+		name = append(name, unicode.ToLower(r))
+	}
+	return string(name)
+}
+
+// isEffectiveConfigField reports whether the struct field is written into the effective configuration.
+func isEffectiveConfigField(structType reflect.Type, field reflect.StructField) bool {
+	if field.PkgPath != "" || field.Tag.Get("json") == "-" {
+		return false
+	}
+	return structType != reflect.TypeOf(StepContext{}) || indexInStringSlice(field.Name, effectiveConfigSkippedFields) == -1
+}
+
+// maskedConfigString masks the value of a secret key, and the secret values of the `KEY=VALUE` strings, like the app launch environment's.
+func maskedConfigString(key, value string) string {
+	if value != "" && isSecretKey(key) {
+		return maskedValue
+	}
+	if envKey, envValue := splitEnv(value); envValue != "" && isSecretKey(envKey) {
+		return envKey + "=" + maskedValue
+	}
+	return value
+}
+
+// effectiveConfigValue converts a value into its serializable form: the structs into maps by their field names,
+// every field included even if it is empty, the durations into strings and the secret strings masked,
+// the key is the name of the value's field.
+func effectiveConfigValue(key string, v reflect.Value) interface{} {
+	if v.Type() == reflect.TypeOf(time.Duration(0)) {
+		return time.Duration(v.Int()).String()
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		return effectiveConfigValue(key, v.Elem())
+	case reflect.String:
+		return maskedConfigString(key, v.String())
+	case reflect.Struct:
+		fields := map[string]interface{}{}
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Type().Field(i)
+			if isEffectiveConfigField(v.Type(), field) {
+				name := effectiveConfigFieldName(field)
+				fields[name] = effectiveConfigValue(name, v.Field(i))
+			}
+		}
+		return fields
+	case reflect.Map:
+		entries := map[string]interface{}{}
+		for _, mapKey := range v.MapKeys() {
+			entryKey := fmt.Sprintf("%v", mapKey.Interface())
+			entries[entryKey] = effectiveConfigValue(entryKey, v.MapIndex(mapKey))
+		}
+		return entries
+	case reflect.Slice, reflect.Array:
+		items := []interface{}{}
+		for i := 0; i < v.Len(); i++ {
+			items = append(items, effectiveConfigValue(key, v.Index(i)))
+		}
+		return items
+	case reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Float32, reflect.Float64:
+		return v.Interface()
+	default:
+		return fmt.Sprintf("%v", v.Interface())
+	}
+}
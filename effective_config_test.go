@@ -0,0 +1,149 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+// effectiveConfigFileModel is the effective configuration read back from its yml content.
+type effectiveConfigFileModel struct {
+	Inputs   map[string]string      `yaml:"inputs"`
+	Resolved map[string]interface{} `yaml:"resolved"`
+}
+
+func effectiveConfigFields(t *testing.T, ctx *StepContext) effectiveConfigFileModel {
+	t.Helper()
+
+	content, err := yaml.Marshal(ctx.effectiveConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fields effectiveConfigFileModel
+	if err := yaml.Unmarshal(content, &fields); err != nil {
+		t.Fatalf("invalid effective configuration: %s\n%s", err, content)
+	}
+	return fields
+}
+
+// TestEffectiveConfigCoversEveryField fails once a new ConfigsModel or StepContext field is neither written
+// into the effective configuration nor added to effectiveConfigSkippedFields.
+func TestEffectiveConfigCoversEveryField(t *testing.T) {
+	configs := ConfigsModel{}
+	configsValue := reflect.ValueOf(&configs).Elem()
+	inputKeys := []string{}
+	for i := 0; i < configsValue.NumField(); i++ {
+		field := configsValue.Type().Field(i)
+		if indexInStringSlice(field.Name, effectiveConfigSkippedInputFields) != -1 {
+			continue
+		}
+		key := field.Tag.Get("env")
+		if key == "" || field.Type.Kind() != reflect.String {
+			t.Errorf("ConfigsModel.%s is neither a string input with an env tag nor skipped", field.Name)
+			continue
+		}
+		configsValue.Field(i).SetString("value of " + key)
+		inputKeys = append(inputKeys, key)
+	}
+	for _, name := range effectiveConfigSkippedInputFields {
+		if _, ok := configsValue.Type().FieldByName(name); !ok {
+			t.Errorf("skipped field %s is not a ConfigsModel field", name)
+		}
+	}
+
+	fields := effectiveConfigFields(t, &StepContext{Configs: configs})
+
+	inputs := fields.Inputs
+	for _, key := range inputKeys {
+		expected := "value of " + key
+		if isSecretKey(key) {
+			expected = maskedValue
+		}
+		if inputs[key] != expected {
+			t.Errorf("input %s: %v, expected: %s", key, inputs[key], expected)
+		}
+	}
+
+	resolved := fields.Resolved
+	ctxType := reflect.TypeOf(StepContext{})
+	for i := 0; i < ctxType.NumField(); i++ {
+		field := ctxType.Field(i)
+		name := effectiveConfigFieldName(field)
+		_, written := resolved[name]
+		skipped := indexInStringSlice(field.Name, effectiveConfigSkippedFields) != -1
+
+		if !written && !skipped {
+			t.Errorf("StepContext.%s is neither written into the effective configuration (as %s) nor skipped", field.Name, name)
+		}
+		if written && skipped {
+			t.Errorf("StepContext.%s is skipped but written into the effective configuration", field.Name)
+		}
+	}
+	for _, name := range effectiveConfigSkippedFields {
+		if _, ok := ctxType.FieldByName(name); !ok {
+			t.Errorf("skipped field %s is not a StepContext field", name)
+		}
+	}
+}
+
+func TestEffectiveConfigValues(t *testing.T) {
+	tests := []string{
+		"key: value",
+		"value # comment",
+		"  leading and trailing spaces  ",
+		"first line\nsecond line",
+		`"quoted" and 'single quoted'`,
+		"- dash",
+		"yes",
+		"1.0",
+		"",
+	}
+	for _, value := range tests {
+		t.Run(value, func(t *testing.T) {
+			ctx := &StepContext{
+				Configs: ConfigsModel{Options: value},
+				WorkDir: value,
+				Options: []string{value},
+			}
+
+			fields := effectiveConfigFields(t, ctx)
+			resolved := fields.Resolved
+
+			if actual := fields.Inputs["additional_options"]; actual != value {
+				t.Errorf("input: %q, expected: %q", actual, value)
+			}
+			if actual := resolved["work_dir"]; actual != value {
+				t.Errorf("resolved value: %q, expected: %q", actual, value)
+			}
+			if actual := resolved["options"].([]interface{}); len(actual) != 1 || actual[0] != value {
+				t.Errorf("resolved list: %q, expected: [%q]", actual, value)
+			}
+		})
+	}
+}
+
+func TestEffectiveConfigMasksSecrets(t *testing.T) {
+	ctx := &StepContext{
+		Configs:              ConfigsModel{AppLaunchEnvironment: "API_TOKEN=s3cr3t\nLANG=en"},
+		AppLaunchEnvironment: []string{"API_TOKEN=s3cr3t", "LANG=en"},
+	}
+
+	content, err := yaml.Marshal(ctx.effectiveConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(content), "s3cr3t") {
+		t.Errorf("effective configuration holds a secret: %s", content)
+	}
+
+	fields := effectiveConfigFields(t, ctx)
+	if actual := fields.Inputs["app_launch_environment"]; actual != "API_TOKEN="+maskedValue+"\nLANG=en" {
+		t.Errorf("app launch environment input: %q", actual)
+	}
+	expected := []interface{}{"API_TOKEN=" + maskedValue, "LANG=en"}
+	if actual := fields.Resolved["app_launch_environment"]; !reflect.DeepEqual(actual, expected) {
+		t.Errorf("app launch environment: %q, expected: %q", actual, expected)
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"os"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/log"
+)
+
+// expandEnvs replaces the $VAR and ${VAR} references of the value with the environment variables' values,
+// like os.ExpandEnv, and returns the referenced variables, which are not set.
+func expandEnvs(value string) (string, []string) {
+	unset := []string{}
+	expanded := os.Expand(value, func(key string) string {
+		envValue, ok := os.LookupEnv(key)
+		if !ok && indexInStringSlice(key, unset) == -1 {
+			unset = append(unset, key)
+		}
+		return envValue
+	})
+	return expanded, unset
+}
+
+// warnUnsetEnvs warns about the unset environment variables referenced by the input, as they are most likely typos.
+func warnUnsetEnvs(name string, unset []string) {
+	if len(unset) > 0 {
+		log.Warnf("%s references unset environment variables, expanded them to empty strings: %s", name, strings.Join(unset, ", "))
+	}
+}
+
+// expandAppLaunchEnvironment expands the environment variable references in the values of the KEY=VALUE envs,
+// the keys are kept as they are.
+func expandAppLaunchEnvironment(envs []string) []string {
+	expandedEnvs := []string{}
+	unset := []string{}
+	for _, env := range envs {
+		split := strings.SplitN(env, "=", 2)
+
+		value, unsetInValue := expandEnvs(split[1])
+		for _, key := range unsetInValue {
+			if indexInStringSlice(key, unset) == -1 {
+				unset = append(unset, key)
+			}
+		}
+		expandedEnvs = append(expandedEnvs, split[0]+"="+value)
+	}
+
+	warnUnsetEnvs("AppLaunchEnvironment", unset)
+	return expandedEnvs
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// FailureCategory classifies why the step failed, each category has a dedicated exit code.
+type FailureCategory struct {
+	Name     string
+	ExitCode int
+}
+
+// Failure categories and their exit codes, consumers only checking for a non-zero exit code keep working.
+var (
+	categoryTestFailure       = FailureCategory{Name: "test_failure", ExitCode: 1}
+	categoryCrash             = FailureCategory{Name: "crash", ExitCode: 1}
+	categoryDeprecation       = FailureCategory{Name: "deprecation", ExitCode: 1}
+	categoryPostRunScript     = FailureCategory{Name: "post_run_script", ExitCode: 1}
+	categoryInvalidInput      = FailureCategory{Name: "invalid_input", ExitCode: 2}
+	categoryInfrastructure    = FailureCategory{Name: "infrastructure", ExitCode: 3}
+	categoryDependencyInstall = FailureCategory{Name: "dependency_install", ExitCode: 4}
+	categoryTimeout           = FailureCategory{Name: "timeout", ExitCode: 5}
+	categoryStepTimeout       = FailureCategory{Name: "step_timeout", ExitCode: 5}
+	categoryAborted           = FailureCategory{Name: "aborted", ExitCode: 5}
+)
+
+// StepError is an error with a failure category.
+type StepError struct {
+	Category FailureCategory
+	Err      error
+	// NoRetry marks a failure of a retryable category, which a retry would most likely run into again
+	NoRetry bool
+}
+
+func (e StepError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap ...
+func (e StepError) Unwrap() error {
+	return e.Err
+}
+
+func newStepError(category FailureCategory, format string, v ...interface{}) error {
+	return StepError{Category: category, Err: fmt.Errorf(format, v...)}
+}
+
+func newNonRetryableStepError(category FailureCategory, format string, v ...interface{}) error {
+	return StepError{Category: category, Err: fmt.Errorf(format, v...), NoRetry: true}
+}
+
+// failureCategoryOf returns the category of a StepError, unclassified errors count as test failures (exit code 1).
+func failureCategoryOf(err error) FailureCategory {
+	var stepErr StepError
+	if errors.As(err, &stepErr) {
+		return stepErr.Category
+	}
+	return categoryTestFailure
+}
+
+// failureResult maps an error to the step's exit code and test result.
+func failureResult(err error) (exitCode int, testResult string) {
+	return failureCategoryOf(err).ExitCode, testResultFailed
+}
+
+// isRetryableFailure reports whether a re-run of the step body may succeed:
+// infrastructure and dependency install failures are often transient, test failures and invalid inputs are not.
+func isRetryableFailure(err error) bool {
+	var stepErr StepError
+	if errors.As(err, &stepErr) && stepErr.NoRetry {
+		return false
+	}
+
+	category := failureCategoryOf(err)
+	return category == categoryInfrastructure || category == categoryDependencyInstall
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bitrise-io/go-xcode/simulator"
+)
+
+// runFailingPhase runs a phase of the step which fails in the test environment: the PATH only holds a calabash-sandbox,
+// which fails like a cucumber run with failed scenarios.
+func runFailingPhase(phase string) error {
+	binDir, err := ioutil.TempDir("", "bin")
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filepath.Join(binDir, calabashSandboxCommand), []byte("#!/bin/sh\nexit 1\n"), 0755); err != nil {
+		return err
+	}
+	if err := os.Setenv("PATH", binDir); err != nil {
+		return err
+	}
+
+	switch phase {
+	case "invalid input":
+		configs := createConfigsModelFromEnvs()
+		configs.StepTimeoutMinutes = "-1"
+		_, err := newStepContext(configs)
+		return err
+	case "simulator boot":
+		ctx := &StepContext{Simulator: simulator.InfoModel{ID: "11111111-2222-3333-4444-555555555555"}}
+		return ctx.bootSimulator()
+	case "gem install":
+		return gemInstall("calabash-cucumber", "0.21.10")
+	case "cucumber":
+		ctx := &StepContext{WorkDir: os.Getenv("work_dir"), UseSandbox: true}
+		return ctx.runCucumber()
+	case "panic":
+		defer recoverPanic()
+		panic("synthetic panic")
+	}
+	return fmt.Errorf("unknown phase: %s", phase)
+}
+
+func TestPhaseFailureResult(t *testing.T) {
+	tests := []struct {
+		phase        string
+		wantCategory FailureCategory
+	}{
+		{phase: "invalid input", wantCategory: categoryInvalidInput},
+		{phase: "simulator boot", wantCategory: categoryInfrastructure},
+		{phase: "gem install", wantCategory: categoryDependencyInstall},
+		{phase: "cucumber", wantCategory: categoryTestFailure},
+		{phase: "panic", wantCategory: categoryCrash},
+	}
+	for _, tt := range tests {
+		t.Run(tt.phase, func(t *testing.T) {
+			result := runStepHelper(t, "phase_failure", stepTestPhaseEnvKey+"="+tt.phase)
+
+			if result.exitCode != tt.wantCategory.ExitCode {
+				t.Fatalf("exit code: %d, expected: %d, log:\n%s", result.exitCode, tt.wantCategory.ExitCode, result.log)
+			}
+			if result.summary.FailureClassification != tt.wantCategory.Name {
+				t.Errorf("failure classification: %q, expected: %q, log:\n%s", result.summary.FailureClassification, tt.wantCategory.Name, result.log)
+			}
+			if result.summary.ExitCode != tt.wantCategory.ExitCode {
+				t.Errorf("summary exit code: %d, expected: %d", result.summary.ExitCode, tt.wantCategory.ExitCode)
+			}
+			if actual := result.outputs[testResultOutputKey]; actual != testResultFailed {
+				t.Errorf("output %s: %q, expected: %q", testResultOutputKey, actual, testResultFailed)
+			}
+		})
+	}
+}
+
+func TestIsRetryableFailure(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "unclassified", err: errors.New("failed"), want: false},
+		{name: "test failure", err: newStepError(categoryTestFailure, "failed"), want: false},
+		{name: "infrastructure", err: newStepError(categoryInfrastructure, "failed"), want: true},
+		{name: "non-retryable infrastructure", err: newNonRetryableStepError(categoryInfrastructure, "failed"), want: false},
+		{name: "dependency install", err: newStepError(categoryDependencyInstall, "failed"), want: true},
+		{name: "wrapped", err: fmt.Errorf("attempt 2: %w", newStepError(categoryInfrastructure, "failed")), want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isRetryableFailure(tt.err); got != tt.want {
+				t.Errorf("isRetryableFailure() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/log"
+)
+
+// parseExcludePatterns parses the feature file exclude patterns, one per line, empty lines are skipped.
+// Cucumber matches every pattern against the feature file paths, so a pattern has to compile with the syntax
+// supported by both Ruby and Go, and must not match the empty string (it would exclude every feature).
+func parseExcludePatterns(value string) ([]string, error) {
+	patterns := []string{}
+	for i, line := range strings.Split(value, "\n") {
+		pattern := strings.TrimSpace(line)
+		if pattern == "" {
+			continue
+		}
+
+		exp, err := regexp.Compile(rubyNamedGroupExp.ReplaceAllString(pattern, "(?P<$1>"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid ExcludePatterns line %d (%s), not a supported regular expression: %s", i+1, pattern, err)
+		}
+		if exp.MatchString("") {
+			return nil, fmt.Errorf("invalid ExcludePatterns line %d (%s), matches the empty path, it would exclude every feature", i+1, pattern)
+		}
+		patterns = append(patterns, pattern)
+	}
+	return patterns, nil
+}
+
+// excludePatternArgs returns an --exclude argument for every pattern, cucumber skips the feature files matching any of them.
+func excludePatternArgs(patterns []string) []string {
+	args := []string{}
+	for _, pattern := range patterns {
+		args = append(args, "--exclude", pattern)
+	}
+	return args
+}
+
+// excludeFeatures returns the features not matching any of the patterns, and the excluded ones,
+// the features are matched as they are passed to cucumber (relative to the work dir).
+func excludeFeatures(features, patterns []string) (kept, excluded []string) {
+	exps := []*regexp.Regexp{}
+	for _, pattern := range patterns {
+		// validated by parseExcludePatterns
+		exps = append(exps, regexp.MustCompile(rubyNamedGroupExp.ReplaceAllString(pattern, "(?P<$1>")))
+	}
+
+	kept, excluded = []string{}, []string{}
+	for _, feature := range features {
+		isExcluded := false
+		for _, exp := range exps {
+			if exp.MatchString(feature) {
+				isExcluded = true
+				break
+			}
+		}
+
+		if isExcluded {
+			excluded = append(excluded, feature)
+		} else {
+			kept = append(kept, feature)
+		}
+	}
+	return kept, excluded
+}
+
+// runFeatures returns the feature files the run selects, before the exclude patterns apply:
+// the features of the feature order file, or the feature files of the features dir.
+func (ctx *StepContext) runFeatures() ([]string, error) {
+	if ctx.Configs.FeatureOrderFile != "" {
+		return ctx.OrderedFeatures, nil
+	}
+	return listFeatureFiles(filepath.Join(ctx.WorkDir, ctx.featuresPath()), ctx.WorkDir)
+}
+
+// printExcludedFeatures logs the feature files the exclude patterns filter out of the run.
+func (ctx *StepContext) printExcludedFeatures() {
+	if len(ctx.ExcludePatterns) == 0 {
+		return
+	}
+
+	features, err := ctx.runFeatures()
+	if err != nil {
+		log.Warnf("Failed to list the feature files, error: %s", err)
+		return
+	}
+	kept, excluded := excludeFeatures(features, ctx.ExcludePatterns)
+
+	fmt.Println()
+	log.Printf("exclude_patterns filter out %d of %d feature file(s):", len(excluded), len(features))
+	for _, feature := range excluded {
+		log.Printf("- %s", feature)
+	}
+	if len(features) > 0 && len(kept) == 0 {
+		log.Warnf("Every feature file is excluded by exclude_patterns, cucumber runs no scenario")
+	}
+}
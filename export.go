@@ -0,0 +1,274 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/bitrise-io/go-utils/command"
+	"github.com/bitrise-io/go-utils/fileutil"
+	"github.com/bitrise-io/go-utils/log"
+	"github.com/bitrise-io/go-utils/pathutil"
+	shellquote "github.com/kballard/go-shellquote"
+)
+
+const (
+	fallbackEnvFileName = "calabash_step_outputs.env"
+
+	// defaultEnvBytesLimitInKB is a conservative default, older envman versions reject values above 20 KB.
+	defaultEnvBytesLimitInKB = 20
+
+	// envman add fails transiently, for example while the envstore is locked
+	envmanExportAttempts  = 3
+	envmanExportRetryWait = 500 * time.Millisecond
+)
+
+// OutputExporter exports the step outputs with envman,
+// or collects them into a .env file if envman is not available (for example when running the step locally).
+// It is safe for concurrent use, the exit path of an interrupt can export while the step body is still running.
+type OutputExporter struct {
+	lock sync.Mutex
+
+	envmanAvailable bool
+
+	fallbackDir string
+	keys        []string
+	values      map[string]string
+
+	exportedValues map[string]string
+
+	valueLimitInBytes int
+	truncatedKeys     []string
+
+	failedKeys []string
+}
+
+// NewOutputExporter checks for envman on the PATH.
+func NewOutputExporter() *OutputExporter {
+	_, err := exec.LookPath("envman")
+	return &OutputExporter{
+		envmanAvailable:   err == nil,
+		fallbackDir:       ".",
+		values:            map[string]string{},
+		exportedValues:    map[string]string{},
+		valueLimitInBytes: envmanValueLimitInKB() * 1024,
+	}
+}
+
+type envmanConfigsModel struct {
+	EnvBytesLimitInKB int `json:"env_bytes_limit_in_kb"`
+}
+
+// envmanValueLimitInKB returns the value size limit configured for envman (~/.envman/configs.json),
+// or a conservative default if the limit is not configured.
+func envmanValueLimitInKB() int {
+	pth := filepath.Join(pathutil.UserHomeDir(), ".envman", "configs.json")
+	content, err := fileutil.ReadBytesFromFile(pth)
+	if err != nil {
+		return defaultEnvBytesLimitInKB
+	}
+
+	var configs envmanConfigsModel
+	if err := json.Unmarshal(content, &configs); err != nil || configs.EnvBytesLimitInKB <= 0 {
+		return defaultEnvBytesLimitInKB
+	}
+	return configs.EnvBytesLimitInKB
+}
+
+// SetFallbackDir sets the dir of the .env file used when envman is not available.
+func (e *OutputExporter) SetFallbackDir(dir string) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	if dir != "" {
+		e.fallbackDir = dir
+	}
+}
+
+// FallbackFilePath ...
+func (e *OutputExporter) FallbackFilePath() string {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	return e.fallbackFilePath()
+}
+
+func (e *OutputExporter) fallbackFilePath() string {
+	return filepath.Join(e.fallbackDir, fallbackEnvFileName)
+}
+
+// Export exports the value, values above the size limit are truncated
+// after their full content is written into the deploy dir.
+func (e *OutputExporter) Export(key, value string) error {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	if len(value) > e.valueLimitInBytes {
+		fullContentPth := filepath.Join(resultsDir(), fullContentFileName(key))
+		if err := fileutil.WriteStringToFile(fullContentPth, value); err != nil {
+			return fmt.Errorf("failed to write the full content of the oversize value to (%s), error: %s", fullContentPth, err)
+		}
+
+		value = truncateValue(value, e.valueLimitInBytes, fullContentPth)
+		e.truncatedKeys = append(e.truncatedKeys, key)
+	}
+
+	e.exportedValues[key] = value
+
+	if e.envmanAvailable {
+		if err := exportEnvironmentWithEnvmanRetry(key, value); err != nil {
+			e.failedKeys = append(e.failedKeys, key)
+			return err
+		}
+		return nil
+	}
+
+	if _, ok := e.values[key]; !ok {
+		e.keys = append(e.keys, key)
+	}
+	e.values[key] = value
+
+	return fileutil.WriteStringToFile(e.fallbackFilePath(), e.envFileContent())
+}
+
+// Value returns the last exported value of the key, empty if it is not exported.
+func (e *OutputExporter) Value(key string) string {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	return e.exportedValues[key]
+}
+
+func (e *OutputExporter) envFileContent() string {
+	lines := []string{}
+	for _, key := range e.keys {
+		lines = append(lines, key+"="+shellquote.Join(e.values[key]))
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// PrintTruncationNotice lists the outputs which exceeded the size limit.
+func (e *OutputExporter) PrintTruncationNotice() {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	if len(e.truncatedKeys) == 0 {
+		return
+	}
+	log.Warnf("The following outputs exceeded the %d KB size limit and were truncated: %s", e.valueLimitInBytes/1024, strings.Join(e.truncatedKeys, ", "))
+}
+
+// PrintExportFailures lists the outputs which could not be persisted with envman, the later steps do not see them.
+func (e *OutputExporter) PrintExportFailures() {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	if len(e.failedKeys) == 0 {
+		return
+	}
+	log.Errorf("The following outputs could not be persisted with envman, the later steps can not read them: %s", strings.Join(e.failedKeys, ", "))
+}
+
+// PrintFallbackNotice prints where the outputs were written when envman was not available.
+func (e *OutputExporter) PrintFallbackNotice() {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	if e.envmanAvailable || len(e.keys) == 0 {
+		return
+	}
+	log.Warnf("envman is not available, the step outputs were written to: %s", e.fallbackFilePath())
+}
+
+func exportEnvironmentWithEnvman(keyStr, valueStr string) error {
+	cmd := command.New("envman", "add", "--key", keyStr)
+	cmd.SetStdin(strings.NewReader(valueStr))
+	if out, err := cmd.RunAndReturnTrimmedCombinedOutput(); err != nil {
+		return fmt.Errorf("envman add failed, output: %s, error: %s", out, err)
+	}
+	return nil
+}
+
+// exportEnvironmentWithEnvmanRetry retries the failed envman add with a short backoff,
+// then reads the envstore back to verify the key is persisted, as envman add might fail silently.
+func exportEnvironmentWithEnvmanRetry(key, value string) error {
+	var err error
+	for attempt := 1; attempt <= envmanExportAttempts; attempt++ {
+		if err = exportEnvironmentWithEnvman(key, value); err == nil {
+			break
+		}
+		if attempt < envmanExportAttempts {
+			log.Warnf("Failed to export environment: %s (attempt %d/%d), error: %s, retrying...", key, attempt, envmanExportAttempts, err)
+			time.Sleep(time.Duration(attempt) * envmanExportRetryWait)
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	keys, err := envmanStoredKeys()
+	if err != nil {
+		return fmt.Errorf("failed to verify the export, error: %s", err)
+	}
+	if indexInStringSlice(key, keys) == -1 {
+		return fmt.Errorf("envman add succeeded, but the key is not in the envstore")
+	}
+	return nil
+}
+
+// envmanStoredKeys returns the keys of the envstore, read with `envman print --format json`.
+func envmanStoredKeys() ([]string, error) {
+	cmd := command.New("envman", "print", "--format", "json")
+	out, err := cmd.RunAndReturnTrimmedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("%s failed, output: %s, error: %s", cmd.PrintableCommandArgs(), out, err)
+	}
+
+	var envs map[string]interface{}
+	if err := json.Unmarshal([]byte(out), &envs); err != nil {
+		return nil, fmt.Errorf("failed to parse the envman print output, error: %s", err)
+	}
+
+	keys := []string{}
+	for key := range envs {
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+func fullContentFileName(key string) string {
+	return regexp.MustCompile(`[^a-zA-Z0-9_.-]`).ReplaceAllString(strings.ToLower(key), "_") + ".txt"
+}
+
+// truncateValue cuts the value to fit into limit bytes together with the truncation suffix,
+// without breaking multi-byte characters.
+func truncateValue(value string, limit int, fullContentPth string) string {
+	suffix := fmt.Sprintf("[truncated, full content at %s]", fullContentPth)
+	if len(value) <= limit {
+		return value
+	}
+
+	end := limit - len(suffix)
+	if end < 0 {
+		end = 0
+	}
+	for end > 0 && !utf8.RuneStart(value[end]) {
+		end--
+	}
+	return value[:end] + suffix
+}
+
+var outputExporter = NewOutputExporter()
+
+// exportOutput exports a step output, failures are logged as warnings.
+func exportOutput(key, value string) {
+	if err := outputExporter.Export(key, value); err != nil {
+		log.Warnf("Failed to export environment: %s, error: %s", key, err)
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestTruncateValue(t *testing.T) {
+	const limit = 64
+	const fullContentPth = "/deploy/key.txt"
+	suffix := "[truncated, full content at " + fullContentPth + "]"
+
+	tests := []struct {
+		name  string
+		value string
+		want  string
+	}{
+		{name: "empty", value: "", want: ""},
+		{name: "below the limit", value: "short", want: "short"},
+		{name: "at the limit", value: strings.Repeat("a", limit), want: strings.Repeat("a", limit)},
+		{name: "one byte over the limit", value: strings.Repeat("a", limit+1), want: strings.Repeat("a", limit-len(suffix)) + suffix},
+		// é is 2 bytes, the cut would split the character at an odd offset
+		{name: "multi-byte characters", value: strings.Repeat("é", limit), want: strings.Repeat("é", (limit-len(suffix))/2) + suffix},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := truncateValue(tt.value, limit, fullContentPth)
+			if got != tt.want {
+				t.Errorf("truncateValue() = %q, want %q", got, tt.want)
+			}
+			if len(got) > limit && len(tt.value) > limit {
+				t.Errorf("truncated value is %d bytes, above the %d bytes limit", len(got), limit)
+			}
+			if !utf8.ValidString(got) {
+				t.Errorf("truncated value is not valid utf8: %q", got)
+			}
+		})
+	}
+}
+
+func newTestOutputExporter(t *testing.T, limit int) *OutputExporter {
+	t.Helper()
+
+	previousResultsDir := resultsDirPath
+	resultsDirPath = t.TempDir()
+	t.Cleanup(func() { resultsDirPath = previousResultsDir })
+
+	return &OutputExporter{
+		fallbackDir:       t.TempDir(),
+		values:            map[string]string{},
+		exportedValues:    map[string]string{},
+		valueLimitInBytes: limit,
+	}
+}
+
+func TestExportSizeLimit(t *testing.T) {
+	// the limit leaves room for the truncation suffix, which holds the full content file's temp path
+	const limit = 1024
+	const key = "BITRISE_CALABASH_TEST_VALUE"
+
+	tests := []struct {
+		name      string
+		value     string
+		truncated bool
+		envLine   string
+	}{
+		{name: "empty", value: "", envLine: key + "=''"},
+		{name: "at the limit", value: strings.Repeat("a", limit), envLine: key + "=" + strings.Repeat("a", limit)},
+		{name: "one byte over the limit", value: strings.Repeat("a", limit+1), truncated: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			exporter := newTestOutputExporter(t, limit)
+
+			if err := exporter.Export(key, tt.value); err != nil {
+				t.Fatal(err)
+			}
+
+			fullContentPth := filepath.Join(resultsDir(), fullContentFileName(key))
+			exported := exporter.Value(key)
+
+			if !tt.truncated {
+				if exported != tt.value {
+					t.Errorf("exported value: %q, expected: %q", exported, tt.value)
+				}
+				if len(exporter.truncatedKeys) != 0 {
+					t.Errorf("truncated keys: %v, expected none", exporter.truncatedKeys)
+				}
+				if _, err := os.Stat(fullContentPth); !os.IsNotExist(err) {
+					t.Errorf("full content file is written for a value within the limit")
+				}
+			} else {
+				if exported != truncateValue(tt.value, limit, fullContentPth) {
+					t.Errorf("exported value: %q, expected the truncated value", exported)
+				}
+				if len(exported) > limit {
+					t.Errorf("exported value is %d bytes, above the %d bytes limit", len(exported), limit)
+				}
+				if len(exporter.truncatedKeys) != 1 || exporter.truncatedKeys[0] != key {
+					t.Errorf("truncated keys: %v, expected: [%s]", exporter.truncatedKeys, key)
+				}
+				content, err := os.ReadFile(fullContentPth)
+				if err != nil {
+					t.Fatalf("full content file is not written: %s", err)
+				}
+				if string(content) != tt.value {
+					t.Errorf("full content: %q, expected: %q", content, tt.value)
+				}
+			}
+
+			envContent, err := os.ReadFile(exporter.FallbackFilePath())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.envLine != "" && strings.TrimSpace(string(envContent)) != tt.envLine {
+				t.Errorf("env file: %q, expected: %q", strings.TrimSpace(string(envContent)), tt.envLine)
+			}
+		})
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/fileutil"
+	"github.com/bitrise-io/go-utils/log"
+)
+
+const (
+	failuresOutputKey = "BITRISE_CALABASH_FAILURES_PATH"
+	failuresFileName  = "failures.txt"
+
+	// failureBacktraceMaxFrames caps the backtrace frames printed per failed scenario,
+	// the full backtraces are written into the failures file
+	failureBacktraceMaxFrames = 8
+)
+
+// backtraceFrameExp matches the Ruby backtrace frames of cucumber's error messages,
+// like `./features/support/env.rb:12:in 'block in <top (required)>'` or `features/login.feature:5:in 'Given the app is launched'`.
+var backtraceFrameExp = regexp.MustCompile("^\\s*(?:from )?\\S+:\\d+(?::in [`'].*)?$")
+
+// splitErrorMessage splits cucumber's error message into the error lines and the backtrace frames following them.
+func splitErrorMessage(message string) (errorLines, frames []string) {
+	for _, line := range strings.Split(strings.TrimSpace(message), "\n") {
+		if len(errorLines) > 0 && backtraceFrameExp.MatchString(line) {
+			frames = append(frames, strings.TrimSpace(line))
+			continue
+		}
+		if len(frames) > 0 {
+			// the backtrace ended, the rest belongs to the error (like a nested cause)
+			errorLines = append(errorLines, frames...)
+			frames = nil
+		}
+		errorLines = append(errorLines, line)
+	}
+	return errorLines, frames
+}
+
+// failureLines returns the failure of the scenario: its location, failing step, error and at most maxFrames backtrace frames,
+// all of the frames if maxFrames is negative.
+func failureLines(index int, scenario FailedScenarioSummaryModel, maxFrames int) []string {
+	lines := []string{fmt.Sprintf("%d) %s (%s)", index, scenario.Name, scenario.Location)}
+	if scenario.FailedStep != "" {
+		lines = append(lines, "   Failing step: "+scenario.FailedStep)
+	}
+
+	errorLines, frames := splitErrorMessage(scenario.Error)
+	for _, line := range errorLines {
+		if line != "" {
+			lines = append(lines, "   "+line)
+		}
+	}
+
+	omitted := 0
+	if maxFrames >= 0 && len(frames) > maxFrames {
+		omitted = len(frames) - maxFrames
+		frames = frames[:maxFrames]
+	}
+	for _, frame := range frames {
+		lines = append(lines, "     "+frame)
+	}
+	if omitted > 0 {
+		lines = append(lines, fmt.Sprintf("     ... %d more frame(s)", omitted))
+	}
+	return lines
+}
+
+// printFailures prints the failed scenarios with the first frames of their backtraces, and writes them with the complete backtraces
+// into the failures file of the logs dir: the backtraces of full_backtraces would flood the build log.
+func printFailures(scenarios []FailedScenarioSummaryModel) {
+	if len(scenarios) == 0 {
+		return
+	}
+
+	fileLines := []string{}
+	truncated := false
+	fmt.Println()
+	log.Errorf("Failures:")
+	for i, scenario := range scenarios {
+		lines := failureLines(i+1, scenario, failureBacktraceMaxFrames)
+		fullLines := failureLines(i+1, scenario, -1)
+		if len(fullLines) != len(lines) {
+			truncated = true
+		}
+
+		fmt.Println()
+		for _, line := range lines {
+			log.Printf("%s", line)
+		}
+		fileLines = append(fileLines, fullLines...)
+		fileLines = append(fileLines, "")
+	}
+
+	pth := filepath.Join(resultsSubdir(resultsLogsDirName), failuresFileName)
+	if err := fileutil.WriteStringToFile(pth, strings.Join(fileLines, "\n")); err != nil {
+		log.Warnf("Failed to write the failures (%s), error: %s", pth, err)
+		return
+	}
+
+	fmt.Println()
+	if truncated {
+		log.Printf("The backtraces are truncated, the complete ones are written to: %s", pth)
+	} else {
+		log.Printf("Failures written to: %s", pth)
+	}
+	exportOutput(failuresOutputKey, pth)
+	diagnostics.Add(diagnosticKindFailures, pth, false)
+}
+
+// backtraceArgs returns the cucumber arguments of full_backtraces: --backtrace prints the full backtraces of the errors,
+// --expand prints the steps of every scenario outline example, so the backtrace's step can be located.
+func (ctx *StepContext) backtraceArgs() []string {
+	if ctx.Configs.FullBacktraces != "yes" {
+		return nil
+	}
+
+	args := []string{}
+	for _, arg := range []string{"--backtrace", "--expand"} {
+		if indexInStringSlice(arg, ctx.Options) == -1 {
+			args = append(args, arg)
+		}
+	}
+	return args
+}
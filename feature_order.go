@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/fileutil"
+	"github.com/bitrise-io/go-utils/pathutil"
+)
+
+// Unlisted features handling of the feature_order_file, the features missing from the file are
+// appended after the listed ones (in cucumber's alphabetical order), excluded from the run, or fail the step
+const (
+	unlistedFeaturesAppend  = "append"
+	unlistedFeaturesExclude = "exclude"
+	unlistedFeaturesFail    = "fail"
+)
+
+var unlistedFeaturesOptions = []string{unlistedFeaturesAppend, unlistedFeaturesExclude, unlistedFeaturesFail}
+
+// parseFeatureOrderFile returns the feature paths listed in the feature order file, one per line,
+// empty lines and # comments are skipped. Every feature has to exist, relative paths are relative to the work dir,
+// the features are returned relative to the work dir, as they are passed to cucumber.
+func parseFeatureOrderFile(pth, workDir string) ([]string, error) {
+	content, err := fileutil.ReadStringFromFile(pth)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read FeatureOrderFile (%s), error: %s", pth, err)
+	}
+
+	features := []string{}
+	for i, line := range strings.Split(content, "\n") {
+		feature := strings.TrimSpace(line)
+		if feature == "" || strings.HasPrefix(feature, "#") {
+			continue
+		}
+
+		if filepath.Ext(feature) != ".feature" {
+			return nil, fmt.Errorf("invalid FeatureOrderFile line %d (%s), should be a .feature file", i+1, feature)
+		}
+
+		featurePth := feature
+		if !filepath.IsAbs(featurePth) {
+			featurePth = filepath.Join(workDir, featurePth)
+		}
+		if exist, err := pathutil.IsPathExists(featurePth); err != nil {
+			return nil, fmt.Errorf("failed to check if feature exists at (%s), error: %s", featurePth, err)
+		} else if !exist {
+			return nil, fmt.Errorf("invalid FeatureOrderFile line %d (%s), feature file not exists at: %s%s", i+1, feature, featurePth, caseMismatchHint(featurePth))
+		}
+
+		if filepath.IsAbs(feature) {
+			if rel, err := filepath.Rel(workDir, feature); err == nil {
+				feature = rel
+			}
+		}
+		features = append(features, filepath.Clean(feature))
+	}
+	return features, nil
+}
+
+// listFeatureFiles returns the .feature files of the dir relative to the work dir, in cucumber's alphabetical order,
+// hidden dirs are not searched.
+func listFeatureFiles(dir, workDir string) ([]string, error) {
+	features := []string{}
+	err := filepath.Walk(dir, func(pth string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if pth != dir && strings.HasPrefix(info.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if filepath.Ext(pth) != ".feature" {
+			return nil
+		}
+
+		if rel, err := filepath.Rel(workDir, pth); err == nil {
+			pth = rel
+		}
+		features = append(features, pth)
+		return nil
+	})
+	sort.Strings(features)
+	return features, err
+}
+
+// orderFeatures returns the features to run in the order of the feature order file,
+// the unlisted features of the features dir are handled according to unlisted_features.
+func orderFeatures(listed []string, featuresDir, workDir, unlisted string) ([]string, error) {
+	all := []string{}
+	if exist, err := pathutil.IsDirExists(featuresDir); err != nil {
+		return nil, fmt.Errorf("failed to check if features dir exist, error: %s", err)
+	} else if exist {
+		if all, err = listFeatureFiles(featuresDir, workDir); err != nil {
+			return nil, fmt.Errorf("failed to list the features of (%s), error: %s", featuresDir, err)
+		}
+	}
+
+	isListed := map[string]bool{}
+	for _, feature := range listed {
+		isListed[feature] = true
+	}
+
+	unlistedFeatures := []string{}
+	for _, feature := range all {
+		if !isListed[feature] {
+			unlistedFeatures = append(unlistedFeatures, feature)
+		}
+	}
+
+	features := append([]string{}, listed...)
+	switch unlisted {
+	case unlistedFeaturesExclude:
+	case unlistedFeaturesFail:
+		if len(unlistedFeatures) > 0 {
+			return nil, fmt.Errorf("%d feature(s) are not listed in FeatureOrderFile, unlisted_features is %s: %s", len(unlistedFeatures), unlistedFeaturesFail, strings.Join(unlistedFeatures, ", "))
+		}
+	default:
+		features = append(features, unlistedFeatures...)
+	}
+
+	if len(features) == 0 {
+		return nil, fmt.Errorf("no feature to run, FeatureOrderFile lists none and unlisted_features is %s", unlisted)
+	}
+	return features, nil
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/pathutil"
+)
+
+var errFeatureFileFound = errors.New("feature file found")
+
+// isPathInside reports whether the absolute pth is the absolute dir or is inside it.
+func isPathInside(pth, dir string) bool {
+	rel, err := filepath.Rel(dir, pth)
+	if err != nil {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// checkFeaturesDirLocation fails if the features dir is neither inside the work dir nor alongside it (inside the work dir's parent),
+// cucumber runs in the work dir, so the features dir is passed to it relative to the work dir.
+func checkFeaturesDirLocation(featuresDir, workDir string) error {
+	absFeaturesDir, err := pathutil.AbsPath(featuresDir)
+	if err != nil {
+		return fmt.Errorf("failed to expand FeaturesDir (%s), error: %s", featuresDir, err)
+	}
+	absWorkDir, err := pathutil.AbsPath(workDir)
+	if err != nil {
+		return fmt.Errorf("failed to expand WorkDir (%s), error: %s", workDir, err)
+	}
+
+	if !isPathInside(absFeaturesDir, absWorkDir) && !isPathInside(absFeaturesDir, filepath.Dir(absWorkDir)) {
+		return fmt.Errorf("FeaturesDir (%s) is neither inside nor alongside WorkDir (%s)", featuresDir, workDir)
+	}
+	return nil
+}
+
+// featuresSearchDir returns the dir searched for the feature files: the features_dir if set, the work_dir otherwise.
+func (ctx *StepContext) featuresSearchDir() string {
+	if ctx.FeaturesDir != "" {
+		return ctx.FeaturesDir
+	}
+	return ctx.WorkDir
+}
+
+// featuresPath returns the features dir relative to the work dir, as it is passed to cucumber.
+func (ctx *StepContext) featuresPath() string {
+	if ctx.FeaturesDir == "" {
+		return "features"
+	}
+	if rel, err := filepath.Rel(ctx.WorkDir, ctx.FeaturesDir); err == nil {
+		return rel
+	}
+	return ctx.FeaturesDir
+}
+
+// hasFeatureFiles reports whether the dir contains any .feature file, hidden dirs are not searched.
+func hasFeatureFiles(dir string) (bool, error) {
+	err := filepath.Walk(dir, func(pth string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if pth != dir && strings.HasPrefix(info.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if filepath.Ext(pth) == ".feature" {
+			return errFeatureFileFound
+		}
+		return nil
+	})
+	if err == errFeatureFileFound {
+		return true, nil
+	}
+	return false, err
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/command"
+	"github.com/bitrise-io/go-utils/fileutil"
+	"github.com/bitrise-io/go-utils/log"
+	"github.com/bitrise-io/go-utils/pathutil"
+)
+
+const (
+	fingerprintPathOutputKey = "BITRISE_CALABASH_FINGERPRINT_PATH"
+
+	fingerprintFormatVersion = "1.0.0"
+	fingerprintFileName      = "calabash_fingerprint.json"
+	// fingerprintCacheFileName is stored in the calabash dir and added to the cache include paths,
+	// the next build finds it there if the cache is pulled.
+	fingerprintCacheFileName = ".bitrise_calabash_fingerprint.json"
+)
+
+// RunFingerprintModel is the schema of calabash_fingerprint.json: the environment affecting values of a run
+// (the non-secret inputs, the gem versions, the Xcode version and the simulator runtime), compared field by field with the next build's.
+type RunFingerprintModel struct {
+	FormatVersion string            `json:"format_version"`
+	Fields        map[string]string `json:"fields"`
+}
+
+var xcodeVersionValue *string
+
+// xcodeVersion returns the Xcode version and build of `xcodebuild -version`, like `12.1 (12A7403)`, empty if it fails.
+func xcodeVersion() string {
+	if xcodeVersionValue != nil {
+		return *xcodeVersionValue
+	}
+
+	version := ""
+	cmd := command.New("xcodebuild", "-version")
+	if out, err := commandRecorder.RunAndReturnTrimmedCombinedOutput(cmd); err == nil {
+		build := ""
+		for _, line := range strings.Split(out, "\n") {
+			line = strings.TrimSpace(line)
+			if strings.HasPrefix(line, "Xcode ") {
+				version = strings.TrimPrefix(line, "Xcode ")
+			} else if strings.HasPrefix(line, "Build version ") {
+				build = strings.TrimPrefix(line, "Build version ")
+			}
+		}
+		if version != "" && build != "" {
+			version = fmt.Sprintf("%s (%s)", version, build)
+		}
+	}
+	xcodeVersionValue = &version
+	return version
+}
+
+// runFingerprint returns the run's fingerprint, the secret inputs are left out.
+func (ctx *StepContext) runFingerprint() RunFingerprintModel {
+	fields := map[string]string{}
+	for key, value := range ctx.Configs.inputValues() {
+		if isSecretKey(key) {
+			continue
+		}
+		fields[key] = value
+	}
+
+	useBundler := "no"
+	if runSummary.Versions.UseBundler {
+		useBundler = "yes"
+	}
+	runtime := runSummary.Simulator.RuntimeVersion
+	if runtime == "" {
+		runtime = runSummary.Simulator.Runtime
+	}
+
+	// the latest installed version if the inputs and the Gemfile.lock do not determine it
+	calabashVersion, err := calabashCucumberVersionInUse()
+	if err != nil {
+		calabashVersion = ""
+	}
+
+	fields["calabash-cucumber"] = calabashVersion
+	fields["cucumber"] = runSummary.Versions.Cucumber
+	fields["bundler"] = useBundler
+	fields["xcode"] = xcodeVersion()
+	fields["runtime"] = runtime
+	fields["runtime_build"] = runSummary.Simulator.RuntimeBuild
+
+	return RunFingerprintModel{FormatVersion: fingerprintFormatVersion, Fields: fields}
+}
+
+// readFingerprint reads the fingerprint of a previous build, fingerprints of a different major format version can not be compared.
+func readFingerprint(pth string) (RunFingerprintModel, error) {
+	content, err := fileutil.ReadBytesFromFile(pth)
+	if err != nil {
+		return RunFingerprintModel{}, err
+	}
+
+	var fingerprint RunFingerprintModel
+	if err := json.Unmarshal(content, &fingerprint); err != nil {
+		return RunFingerprintModel{}, fmt.Errorf("not a fingerprint, error: %s", err)
+	}
+	if fingerprint.Fields == nil {
+		return RunFingerprintModel{}, fmt.Errorf("not a fingerprint, fields are missing")
+	}
+
+	major := strings.Split(fingerprintFormatVersion, ".")[0]
+	if strings.Split(fingerprint.FormatVersion, ".")[0] != major {
+		return RunFingerprintModel{}, fmt.Errorf("incompatible format_version (%s), expected: %s.x", fingerprint.FormatVersion, major)
+	}
+	return fingerprint, nil
+}
+
+// fingerprintChanges returns the changed fields sorted by name, like `calabash-cucumber 0.20.3 → 0.21.0`,
+// the fields missing from either fingerprint (added by a newer step version, or a secret input) are not compared.
+func fingerprintChanges(previous, current RunFingerprintModel) []string {
+	keys := []string{}
+	for key, value := range current.Fields {
+		if previousValue, ok := previous.Fields[key]; ok && previousValue != value {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	displayValue := func(value string) string {
+		if value == "" {
+			return "(empty)"
+		}
+		return value
+	}
+
+	changes := []string{}
+	for _, key := range keys {
+		changes = append(changes, fmt.Sprintf("%s %s → %s", key, displayValue(previous.Fields[key]), displayValue(current.Fields[key])))
+	}
+	return changes
+}
+
+func fingerprintCachePath() string {
+	return filepath.Join(calabashDir(), fingerprintCacheFileName)
+}
+
+var fingerprintChangesPrinted bool
+
+// printFingerprintChanges prints the fields changed since the previous build's fingerprint (previous_fingerprint_path,
+// otherwise the one restored by the cache), once per run. A missing or unparsable fingerprint is silently ignored.
+func (ctx *StepContext) printFingerprintChanges() {
+	if fingerprintChangesPrinted {
+		return
+	}
+	fingerprintChangesPrinted = true
+
+	pth := fingerprintCachePath()
+	if ctx.Configs.PreviousFingerprintPath != "" {
+		absPth, err := pathutil.AbsPath(ctx.Configs.PreviousFingerprintPath)
+		if err != nil {
+			return
+		}
+		pth = absPth
+	}
+
+	if exist, err := pathutil.IsPathExists(pth); err != nil || !exist {
+		return
+	}
+	previous, err := readFingerprint(pth)
+	if err != nil {
+		return
+	}
+
+	fmt.Println()
+	changes := fingerprintChanges(previous, ctx.runFingerprint())
+	if len(changes) == 0 {
+		log.Printf("No environment changes since the previous build")
+		return
+	}
+	log.Warnf("Environment changes since the previous build: %s", strings.Join(changes, ", "))
+}
+
+// saveFingerprint writes the run's fingerprint into the summary dir.
+func (ctx *StepContext) saveFingerprint() {
+	// cucumber did not run, the fingerprint would miss the resolved versions
+	if ctx.JSONReportPath == "" {
+		return
+	}
+
+	content, err := json.MarshalIndent(ctx.runFingerprint(), "", "  ")
+	if err != nil {
+		log.Warnf("Failed to serialize the run's fingerprint, error: %s", err)
+		return
+	}
+
+	pth := filepath.Join(resultsSubdir(resultsSummaryDirName), fingerprintFileName)
+	if err := fileutil.WriteBytesToFile(pth, content); err != nil {
+		log.Warnf("Failed to write the run's fingerprint (%s), error: %s", pth, err)
+		return
+	}
+	exportOutput(fingerprintPathOutputKey, pth)
+}
+
+// cacheFingerprint writes the run's fingerprint into the calabash dir and adds it to the cache include paths,
+// only the passed runs update it, so the next build is compared with the last passed one.
+func (ctx *StepContext) cacheFingerprint() {
+	if ctx.JSONReportPath == "" {
+		return
+	}
+
+	content, err := json.MarshalIndent(ctx.runFingerprint(), "", "  ")
+	if err != nil {
+		log.Warnf("Failed to serialize the run's fingerprint, error: %s", err)
+		return
+	}
+
+	pth := fingerprintCachePath()
+	if err := os.MkdirAll(filepath.Dir(pth), 0755); err != nil {
+		log.Warnf("Failed to create dir (%s), error: %s", filepath.Dir(pth), err)
+		return
+	}
+	if err := fileutil.WriteBytesToFile(pth, content); err != nil {
+		log.Warnf("Failed to write the run's fingerprint (%s), error: %s", pth, err)
+		return
+	}
+	appendCacheIncludePaths([]string{pth})
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+)
+
+// flagNameOfInput returns the command line flag mirroring the env input, for example work_dir: work-dir.
+func flagNameOfInput(key string) string {
+	return strings.Replace(key, "_", "-", -1)
+}
+
+// FlagsModel is the result of the local run's command line flags.
+type FlagsModel struct {
+	PrintConfigOnly bool
+}
+
+// applyFlags parses the command line flags of a local run into the configs: every env input has a mirroring flag
+// (work_dir: -work-dir), a flag set on the command line takes precedence over the env var.
+// Without flags (as the step runs on Bitrise) the configs are left as read from the env vars.
+func (configs *ConfigsModel) applyFlags(args []string, output io.Writer) (FlagsModel, error) {
+	flagSet := flag.NewFlagSet("steps-calabash-ios-uitest", flag.ContinueOnError)
+	flagSet.SetOutput(output)
+
+	var flags FlagsModel
+	flagSet.BoolVar(&flags.PrintConfigOnly, "print-config-only", false, "validate and print the resolved configuration, then exit")
+
+	value := reflect.ValueOf(configs).Elem()
+	fieldsByFlag := map[string]reflect.Value{}
+	for i := 0; i < value.NumField(); i++ {
+		key := value.Type().Field(i).Tag.Get("env")
+		if key == "" || value.Field(i).Kind() != reflect.String {
+			continue
+		}
+
+		name := flagNameOfInput(key)
+		fieldsByFlag[name] = value.Field(i)
+		flagSet.String(name, "", fmt.Sprintf("overrides the %s env input", key))
+	}
+
+	if err := flagSet.Parse(args); err != nil {
+		return FlagsModel{}, err
+	}
+	if flagSet.NArg() > 0 {
+		return FlagsModel{}, fmt.Errorf("unexpected arguments: %s", strings.Join(flagSet.Args(), " "))
+	}
+
+	flagSet.Visit(func(f *flag.Flag) {
+		if field, ok := fieldsByFlag[f.Name]; ok {
+			field.SetString(f.Value.String())
+		}
+	})
+
+	return flags, nil
+}
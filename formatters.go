@@ -0,0 +1,131 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/log"
+)
+
+// cucumberDefaultFormat is the formatter cucumber uses if no --format is specified.
+const cucumberDefaultFormat = "pretty"
+
+// CucumberFormatterModel is a --format option of the cucumber options with the destination of its --out option,
+// Out is empty if the formatter writes to the stdout.
+type CucumberFormatterModel struct {
+	Format string
+	Out    string
+}
+
+func (formatter CucumberFormatterModel) String() string {
+	if formatter.Out == "" {
+		return formatter.Format + " (stdout)"
+	}
+	return formatter.Format + " (" + formatter.Out + ")"
+}
+
+// parseCucumberFormatters returns the formatters of the cucumber options in order, following cucumber's rules:
+// an --out applies to the previous --format, or to the default formatter if no --format precedes it,
+// and of multiple --out options of a formatter the last one wins.
+func parseCucumberFormatters(options []string) []CucumberFormatterModel {
+	formatters := []CucumberFormatterModel{}
+	setOut := func(out string) {
+		if len(formatters) == 0 {
+			formatters = append(formatters, CucumberFormatterModel{Format: cucumberDefaultFormat})
+		}
+		formatters[len(formatters)-1].Out = out
+	}
+
+	for i := 0; i < len(options); i++ {
+		option := options[i]
+		switch {
+		case (option == "--format" || option == "-f") && i+1 < len(options):
+			i++
+			formatters = append(formatters, CucumberFormatterModel{Format: options[i]})
+		case strings.HasPrefix(option, "--format="):
+			formatters = append(formatters, CucumberFormatterModel{Format: strings.TrimPrefix(option, "--format=")})
+		case (option == "--out" || option == "-o") && i+1 < len(options):
+			i++
+			setOut(options[i])
+		case strings.HasPrefix(option, "--out="):
+			setOut(strings.TrimPrefix(option, "--out="))
+		}
+	}
+	return formatters
+}
+
+// cucumberFormatters returns the formatters of the additional options, the --out paths made absolute.
+func (ctx *StepContext) cucumberFormatters() []CucumberFormatterModel {
+	formatters := parseCucumberFormatters(ctx.Options)
+	for i, formatter := range formatters {
+		if formatter.Out != "" && !filepath.IsAbs(formatter.Out) {
+			formatters[i].Out = filepath.Join(ctx.WorkDir, formatter.Out)
+		}
+	}
+	return formatters
+}
+
+// cucumberFormatterOutPaths returns the absolute --out paths of the additional options' formatter.
+func (ctx *StepContext) cucumberFormatterOutPaths(format string) []string {
+	pths := []string{}
+	for _, formatter := range ctx.cucumberFormatters() {
+		if formatter.Format == format && formatter.Out != "" {
+			pths = append(pths, formatter.Out)
+		}
+	}
+	return pths
+}
+
+// stdoutFormats returns the formats of the run's formatters writing to the stdout, the step default included.
+func (ctx *StepContext) stdoutFormats() []string {
+	formats := []string{}
+	for _, formatter := range ctx.cucumberFormatters() {
+		if formatter.Out == "" {
+			formats = append(formats, formatter.Format)
+		}
+	}
+	if len(formats) == 0 {
+		formats = append(formats, cucumberDefaultFormat)
+	}
+	return formats
+}
+
+// defaultFormatterArgs returns the default formatter, if none of the additional options' formatters writes to the stdout,
+// and logs where each formatter of the run comes from, the step's json report is logged by jsonReportFormatterArgs.
+// The default formatter's output is what the log markers and the progress reporting follow,
+// like with the step.yml default `--format html --out <path>` options.
+func (ctx *StepContext) defaultFormatterArgs() []string {
+	log.Printf("Formatters:")
+	writesStdout := false
+	for _, formatter := range ctx.cucumberFormatters() {
+		log.Printf("- %s: additional_options", formatter)
+		writesStdout = writesStdout || formatter.Out == ""
+	}
+	if writesStdout {
+		return nil
+	}
+
+	log.Printf("- %s: step default", CucumberFormatterModel{Format: cucumberDefaultFormat})
+	return []string{"--format", cucumberDefaultFormat}
+}
+
+// jsonReportFormatterArgs returns the json formatter of the run summary's report and sets the report's path.
+// If the additional options write a json report into a file already, the step's one is skipped and the additional options' one
+// is used for the run summary, as multiple json formatters either duplicate the report or fail, depending on the cucumber version.
+func (ctx *StepContext) jsonReportFormatterArgs(stepJSONReportPath string) []string {
+	if userReports := ctx.cucumberFormatterOutPaths("json"); len(userReports) > 0 {
+		ctx.JSONReportPath = userReports[len(userReports)-1]
+		log.Printf("- json: the step's json report is skipped, the additional_options' one is used for the run summary")
+
+		// a report left by a previous attempt or build would be read if cucumber does not write it
+		if err := os.Remove(ctx.JSONReportPath); err != nil && !os.IsNotExist(err) {
+			log.Warnf("Failed to remove previous json report (%s), error: %s", ctx.JSONReportPath, err)
+		}
+		return nil
+	}
+
+	ctx.JSONReportPath = stepJSONReportPath
+	log.Printf("- %s: step managed, used for the run summary", CucumberFormatterModel{Format: "json", Out: stepJSONReportPath})
+	return []string{"--format", "json", "--out", stepJSONReportPath}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestDefaultFormatterArgs(t *testing.T) {
+	tests := []struct {
+		name        string
+		options     []string
+		wantArgs    []string
+		wantFormats []string
+	}{
+		{name: "no options", options: nil, wantArgs: []string{"--format", "pretty"}, wantFormats: []string{"pretty"}},
+		{name: "step.yml default", options: []string{"--format", "html", "--out", "report.html"}, wantArgs: []string{"--format", "pretty"}, wantFormats: []string{"pretty"}},
+		{name: "default formatter into a file", options: []string{"--out", "report.txt"}, wantArgs: []string{"--format", "pretty"}, wantFormats: []string{"pretty"}},
+		{name: "progress to the stdout", options: []string{"--format", "html", "--out", "report.html", "--format", "progress"}, wantArgs: nil, wantFormats: []string{"progress"}},
+		{name: "pretty to the stdout", options: []string{"-f", "pretty", "--format=junit", "--out=junit"}, wantArgs: nil, wantFormats: []string{"pretty"}},
+		{name: "tags only", options: []string{"--tags", "@smoke"}, wantArgs: []string{"--format", "pretty"}, wantFormats: []string{"pretty"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := &StepContext{Options: tt.options, WorkDir: "/workdir"}
+
+			if got := ctx.defaultFormatterArgs(); !reflect.DeepEqual(got, tt.wantArgs) {
+				t.Errorf("defaultFormatterArgs() = %v, want %v", got, tt.wantArgs)
+			}
+			if got := ctx.stdoutFormats(); !reflect.DeepEqual(got, tt.wantFormats) {
+				t.Errorf("stdoutFormats() = %v, want %v", got, tt.wantFormats)
+			}
+		})
+	}
+}
+
+func TestParseCucumberFormatters(t *testing.T) {
+	tests := []struct {
+		name    string
+		options []string
+		want    []CucumberFormatterModel
+	}{
+		{name: "no formatters", options: []string{"--tags", "@smoke"}, want: []CucumberFormatterModel{}},
+		{name: "json without out", options: []string{"-f", "json"}, want: []CucumberFormatterModel{{Format: "json"}}},
+		{name: "out before format", options: []string{"--out", "report.txt", "--format", "json"}, want: []CucumberFormatterModel{{Format: "pretty", Out: "report.txt"}, {Format: "json"}}},
+		{name: "inline values", options: []string{"--format=json", "--out=report.json"}, want: []CucumberFormatterModel{{Format: "json", Out: "report.json"}}},
+		{name: "last out wins", options: []string{"--format", "json", "-o", "first.json", "--out", "second.json"}, want: []CucumberFormatterModel{{Format: "json", Out: "second.json"}}},
+		{
+			name:    "several formatters",
+			options: []string{"--format", "html", "--out", "report.html", "--format", "progress", "-f", "json", "-o", "report.json", "--format=junit", "--out=junit"},
+			want:    []CucumberFormatterModel{{Format: "html", Out: "report.html"}, {Format: "progress"}, {Format: "json", Out: "report.json"}, {Format: "junit", Out: "junit"}},
+		},
+		{name: "missing values", options: []string{"--format", "json", "--out"}, want: []CucumberFormatterModel{{Format: "json"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseCucumberFormatters(tt.options); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseCucumberFormatters() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestJSONReportFormatterArgs(t *testing.T) {
+	tests := []struct {
+		name       string
+		options    []string
+		wantReport string
+		wantArgs   bool
+	}{
+		{name: "no formatters", options: nil, wantArgs: true},
+		// a json report on the stdout is not a file the run summary could read
+		{name: "json without out", options: []string{"-f", "json"}, wantArgs: true},
+		{name: "out before format", options: []string{"--out", "report.json", "--format", "json"}, wantArgs: true},
+		{name: "inline values", options: []string{"--format=json", "--out=report.json"}, wantReport: "report.json"},
+		{name: "absolute out", options: []string{"--format", "json", "--out", "/reports/report.json"}, wantReport: "/reports/report.json"},
+		{
+			name:       "several json formatters",
+			options:    []string{"--format", "json", "--out", "first.json", "--format", "html", "--out", "report.html", "-f", "json", "-o", "last.json"},
+			wantReport: "last.json",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			workDir := t.TempDir()
+			stepReport := filepath.Join(workDir, "step_report.json")
+			ctx := &StepContext{Options: tt.options, WorkDir: workDir}
+
+			wantReport := stepReport
+			var wantArgs []string
+			if tt.wantArgs {
+				wantArgs = []string{"--format", "json", "--out", stepReport}
+			} else if filepath.IsAbs(tt.wantReport) {
+				wantReport = tt.wantReport
+			} else {
+				wantReport = filepath.Join(workDir, tt.wantReport)
+				if err := os.WriteFile(wantReport, []byte("[]"), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if got := ctx.jsonReportFormatterArgs(stepReport); !reflect.DeepEqual(got, wantArgs) {
+				t.Errorf("jsonReportFormatterArgs() = %v, want %v", got, wantArgs)
+			}
+			if ctx.JSONReportPath != wantReport {
+				t.Errorf("json report: %s, want %s", ctx.JSONReportPath, wantReport)
+			}
+			if !tt.wantArgs {
+				// the previous report is removed, so a report not written by cucumber is not read
+				if _, err := os.Stat(wantReport); !os.IsNotExist(err) {
+					t.Errorf("previous json report is not removed: %s", wantReport)
+				}
+			}
+		})
+	}
+}
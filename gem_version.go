@@ -0,0 +1,98 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+// gemVersionSegmentExp matches the segments of a gem version, RubyGems splits `0.21.0.pre2` into 0, 21, 0, pre, 2.
+var gemVersionSegmentExp = regexp.MustCompile(`[0-9]+|[a-zA-Z]+`)
+
+// gemVersionLetterExp matches a letter, RubyGems treats a version containing a letter as a prerelease.
+var gemVersionLetterExp = regexp.MustCompile(`[a-zA-Z]`)
+
+// stripGemVersionBuildMetadata removes the semver style build metadata (`+build.7`), which is not part of RubyGems versions
+// and does not take part in the comparison.
+func stripGemVersionBuildMetadata(gemVersion string) string {
+	if i := strings.Index(gemVersion, "+"); i != -1 {
+		return gemVersion[:i]
+	}
+	return gemVersion
+}
+
+// isPrereleaseGemVersion reports whether RubyGems treats the version as a prerelease, like `0.21.0.pre2` or `1.0.0-rc1`.
+func isPrereleaseGemVersion(gemVersion string) bool {
+	return gemVersionLetterExp.MatchString(stripGemVersionBuildMetadata(gemVersion))
+}
+
+// canonicalGemVersionSegments returns the segments of the gem version the way RubyGems compares them:
+// a `-` stands for `.pre.`, numbers are compared by value, and the trailing zeros of the release and the prerelease part are dropped,
+// so `0.21.0.pre2`, `0.21.pre.2` and `0.21.0-2` (`0.21.0.pre.2`) are all equal.
+func canonicalGemVersionSegments(gemVersion string) []string {
+	gemVersion = strings.Replace(stripGemVersionBuildMetadata(strings.TrimSpace(gemVersion)), "-", ".pre.", -1)
+
+	release, prerelease := []string{}, []string{}
+	for _, segment := range gemVersionSegmentExp.FindAllString(gemVersion, -1) {
+		if isNumericGemVersionSegment(segment) {
+			segment = strings.TrimLeft(segment, "0")
+			if segment == "" {
+				segment = "0"
+			}
+		}
+
+		if len(prerelease) == 0 && isNumericGemVersionSegment(segment) {
+			release = append(release, segment)
+		} else {
+			prerelease = append(prerelease, segment)
+		}
+	}
+
+	return append(trimTrailingZeroSegments(release), trimTrailingZeroSegments(prerelease)...)
+}
+
+func isNumericGemVersionSegment(segment string) bool {
+	return segment != "" && segment[0] >= '0' && segment[0] <= '9'
+}
+
+func trimTrailingZeroSegments(segments []string) []string {
+	for len(segments) > 0 && segments[len(segments)-1] == "0" {
+		segments = segments[:len(segments)-1]
+	}
+	return segments
+}
+
+// gemVersionsEqual reports whether RubyGems considers the two versions equal, like `1.0` and `1.0.0`.
+func gemVersionsEqual(a, b string) bool {
+	return strings.Join(canonicalGemVersionSegments(a), ".") == strings.Join(canonicalGemVersionSegments(b), ".")
+}
+
+// indexOfGemVersion returns the index of the version equal to the gem version, -1 if there is none.
+func indexOfGemVersion(gemVersion string, versions []string) int {
+	for i, version := range versions {
+		if gemVersionsEqual(gemVersion, version) {
+			return i
+		}
+	}
+	return -1
+}
+
+// constraintVersionFromGemVersion converts the gem version to the form go-version parses:
+// the prerelease part is separated by `-`, like `0.21.0-pre.2` for `0.21.0.pre2`.
+func constraintVersionFromGemVersion(gemVersion string) string {
+	release, prerelease := []string{}, []string{}
+	for _, segment := range canonicalGemVersionSegments(gemVersion) {
+		if len(prerelease) == 0 && isNumericGemVersionSegment(segment) {
+			release = append(release, segment)
+		} else {
+			prerelease = append(prerelease, segment)
+		}
+	}
+
+	if len(release) == 0 {
+		release = []string{"0"}
+	}
+	if len(prerelease) == 0 {
+		return strings.Join(release, ".")
+	}
+	return strings.Join(release, ".") + "-" + strings.Join(prerelease, ".")
+}
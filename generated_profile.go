@@ -0,0 +1,174 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/fileutil"
+	"github.com/bitrise-io/go-utils/log"
+	"github.com/bitrise-io/go-utils/pathutil"
+	shellquote "github.com/kballard/go-shellquote"
+)
+
+const (
+	generatedProfileName     = "bitrise_generated"
+	generatedProfileFileName = "cucumber_profile.yml"
+)
+
+// projectProfileBackupModel is the project's cucumber.yml before the generated profile was added to it.
+type projectProfileBackupModel struct {
+	Path    string
+	Content string
+	Existed bool
+	Mode    os.FileMode
+}
+
+var (
+	projectProfileBackup                   *projectProfileBackupModel
+	projectProfileRestoreCleanupRegistered bool
+)
+
+// yamlSingleQuoted returns the value as a single quoted YAML scalar.
+func yamlSingleQuoted(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", "''") + "'"
+}
+
+// generatedProfileLine returns the cucumber.yml line of the generated profile: cucumber renders the file with ERB
+// and splits the profile's value like a shell would, so the options are shell quoted and the ERB tags escaped.
+func generatedProfileLine(options []string) string {
+	value := strings.ReplaceAll(shellquote.Join(options...), "<%", "<%%")
+	return generatedProfileName + ": " + yamlSingleQuoted(value)
+}
+
+// splitProfileOptions splits the cucumber options into the profile selecting options and the rest.
+func splitProfileOptions(options []string) (profileOptions, rest []string) {
+	for i := 0; i < len(options); i++ {
+		option := options[i]
+		switch {
+		case (option == "--profile" || option == "-p") && i+1 < len(options):
+			profileOptions = append(profileOptions, option, options[i+1])
+			i++
+		case strings.HasPrefix(option, "--profile="):
+			profileOptions = append(profileOptions, option)
+		default:
+			rest = append(rest, option)
+		}
+	}
+	return profileOptions, rest
+}
+
+// projectProfilePath returns the project's cucumber.yml, which cucumber reads the profiles from,
+// the work dir's cucumber.yml if the project has none.
+func projectProfilePath(workDir string) (string, bool, error) {
+	for _, name := range cucumberProfileFiles {
+		pth := filepath.Join(workDir, name)
+		if exist, err := pathutil.IsPathExists(pth); err != nil {
+			return "", false, err
+		} else if exist {
+			return pth, true, nil
+		}
+	}
+	return filepath.Join(workDir, "cucumber.yml"), false, nil
+}
+
+// hasCucumberProfile reports whether the cucumber.yml content defines the profile.
+func hasCucumberProfile(content, profile string) bool {
+	for _, line := range strings.Split(content, "\n") {
+		if match := cucumberProfileNameExp.FindStringSubmatch(line); len(match) == 2 && match[1] == profile {
+			return true
+		}
+	}
+	return false
+}
+
+// generatedProfileArgs moves the cucumber options into the bitrise_generated profile and returns the cucumber command selecting it:
+// cucumber reads the profiles only from the work dir's cucumber.yml, the profile is added to the project's one
+// until restoreProjectProfile restores it. The profile is written to the run temp dir and the results' logs dir as well.
+// Returns false if the profile can not be used, as the options skip the profiles.
+func (ctx *StepContext) generatedProfileArgs(command, options, featureArgs []string) ([]string, bool, error) {
+	if indexInStringSlice("--no-profile", options) != -1 {
+		log.Warnf("The cucumber profiles are skipped (--no-profile), passing the options on the command line instead of the generated profile")
+		return nil, false, nil
+	}
+
+	profileOptions, rest := splitProfileOptions(options)
+	line := generatedProfileLine(rest)
+
+	tmpDir, err := ctx.createTempDir("cucumber_profile")
+	if err != nil {
+		return nil, false, newStepError(categoryInfrastructure, "Failed to create tmp dir, error: %s", err)
+	}
+	content := "# Written by the Calabash iOS UI test step (use_generated_profile).\n" + line + "\n"
+	for _, pth := range []string{filepath.Join(tmpDir, generatedProfileFileName), filepath.Join(resultsSubdir(resultsLogsDirName), generatedProfileFileName)} {
+		if err := fileutil.WriteStringToFile(pth, content); err != nil {
+			return nil, false, newStepError(categoryInfrastructure, "Failed to write the generated cucumber profile (%s), error: %s", pth, err)
+		}
+	}
+
+	pth, exist, err := projectProfilePath(ctx.WorkDir)
+	if err != nil {
+		return nil, false, newStepError(categoryInfrastructure, "Failed to look up the project's cucumber.yml, error: %s", err)
+	}
+	backup := projectProfileBackupModel{Path: pth, Existed: exist, Mode: 0644}
+	if exist {
+		info, err := os.Stat(pth)
+		if err != nil {
+			return nil, false, newStepError(categoryInfrastructure, "Failed to read the project's cucumber.yml (%s), error: %s", pth, err)
+		}
+		backup.Mode = info.Mode()
+		if backup.Content, err = fileutil.ReadStringFromFile(pth); err != nil {
+			return nil, false, newStepError(categoryInfrastructure, "Failed to read the project's cucumber.yml (%s), error: %s", pth, err)
+		}
+	}
+
+	projectContent := backup.Content
+	if projectContent != "" && !strings.HasSuffix(projectContent, "\n") {
+		projectContent += "\n"
+	}
+	projectContent += line + "\n"
+
+	projectProfileBackup = &backup
+	if !projectProfileRestoreCleanupRegistered {
+		projectProfileRestoreCleanupRegistered = true
+		registerCleanup("cucumber profile", restoreProjectProfile)
+	}
+	if err := fileutil.WriteStringToFileWithPermission(pth, projectContent, backup.Mode); err != nil {
+		return nil, false, newStepError(categoryInfrastructure, "Failed to add the generated profile to the project's cucumber.yml (%s), error: %s", pth, err)
+	}
+
+	fmt.Println()
+	log.Printf("Generated cucumber profile (%s), added to: %s", generatedProfileName, pth)
+	log.Printf("%s", line)
+
+	args := append([]string{}, command...)
+	args = append(args, profileOptions...)
+	// selecting a profile skips the default profile, it is selected explicitly to keep applying it
+	if len(profileOptions) == 0 && hasCucumberProfile(backup.Content, "default") {
+		args = append(args, "--profile", "default")
+	}
+	args = append(args, "--profile", generatedProfileName)
+	return append(args, featureArgs...), true, nil
+}
+
+// restoreProjectProfile restores the project's cucumber.yml changed by generatedProfileArgs,
+// it is a no-op if the generated profile was not added.
+func restoreProjectProfile() error {
+	backup := projectProfileBackup
+	if backup == nil {
+		return nil
+	}
+	projectProfileBackup = nil
+
+	if !backup.Existed {
+		if err := os.Remove(backup.Path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove the generated cucumber.yml (%s), error: %s", backup.Path, err)
+		}
+		return nil
+	}
+	if err := fileutil.WriteStringToFileWithPermission(backup.Path, backup.Content, backup.Mode); err != nil {
+		return fmt.Errorf("failed to restore the project's cucumber.yml (%s), error: %s", backup.Path, err)
+	}
+	return nil
+}
@@ -6,6 +6,7 @@ require (
 	github.com/bitrise-io/go-steputils v0.0.0-20210514150206-5b6261447e77
 	github.com/bitrise-io/go-utils v0.0.0-20210517140706-aa64fd88ca49
 	github.com/bitrise-io/go-xcode v0.0.0-20210517092111-792daa927657
-	github.com/hashicorp/go-version v1.3.0 // indirect
+	github.com/hashicorp/go-version v1.3.0
 	github.com/kballard/go-shellquote v0.0.0-20180428030007-95032a82bc51
+	gopkg.in/yaml.v2 v2.4.0
 )
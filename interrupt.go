@@ -0,0 +1,65 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bitrise-io/go-utils/log"
+)
+
+// interruptGracePeriod is how long the step body has to stop after an interrupt, before the interrupt exits the step itself.
+var interruptGracePeriod = 30 * time.Second
+
+// The step interrupt is the failure of the step deadline or of an abort signal. The first interrupt wins,
+// the step body exits with it at its next checkpoint, so the outputs and the run summary are written by a single goroutine.
+var (
+	stepInterruptOnce sync.Once
+	stepInterruptErr  atomic.Value
+	stepInterrupted   = make(chan struct{})
+)
+
+// interruptStep records the interrupt's failure and kills the running command tree, so the step body returns to a checkpoint
+// and exits with the failure. The interrupt exits the step itself only if the step body does not exit within the grace period,
+// like when it is stuck outside a command.
+func interruptStep(err error) {
+	first := false
+	stepInterruptOnce.Do(func() {
+		stepInterruptErr.Store(err)
+		close(stepInterrupted)
+		first = true
+	})
+	if !first {
+		return
+	}
+
+	if err := commandRecorder.KillRunning(); err != nil {
+		log.Warnf("Failed to kill the running command, error: %s", err)
+	}
+
+	time.Sleep(interruptGracePeriod)
+
+	log.Warnf("The step did not stop within %s after the interrupt, exiting", interruptGracePeriod)
+	registerFailure(err)
+}
+
+// stepInterruptError returns the failure of the step deadline or of the abort signal, nil if the step is not interrupted.
+func stepInterruptError() error {
+	select {
+	case <-stepInterrupted:
+		return stepInterruptErr.Load().(error)
+	default:
+		return nil
+	}
+}
+
+// checkStepInterrupt exits the step with the interrupt's failure if the step deadline fired or the step was aborted.
+// The step body calls it around its commands, the exit path's own commands (the cleanups) are not interrupted.
+func checkStepInterrupt() {
+	if atomic.LoadInt32(&exitStarted) == 1 {
+		return
+	}
+	if err := stepInterruptError(); err != nil {
+		registerFailure(err)
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/bitrise-io/go-utils/command"
+	"github.com/bitrise-io/go-utils/log"
+	"github.com/bitrise-io/go-utils/pathutil"
+)
+
+// keyboardPreferencesPlistName is the simulator's Settings app preferences, which hold the software keyboard settings.
+const keyboardPreferencesPlistName = "com.apple.Preferences.plist"
+
+// KeyboardPreferenceModel is a software keyboard setting written into the simulator's preferences.
+type KeyboardPreferenceModel struct {
+	Key   string
+	Value bool
+}
+
+// keyboardPreferences returns the keyboard settings requested by the inputs, in a stable order.
+// Every setting is written as a separate key, so the settings compose with each other
+// and with the other preferences of the plist instead of overwriting them.
+func (configs ConfigsModel) keyboardPreferences() []KeyboardPreferenceModel {
+	var prefs []KeyboardPreferenceModel
+	if configs.DisablePredictiveText == "yes" {
+		prefs = append(prefs, KeyboardPreferenceModel{Key: "KeyboardPrediction", Value: false})
+	}
+	if configs.DisableSlideToType == "yes" {
+		prefs = append(prefs, KeyboardPreferenceModel{Key: "KeyboardContinuousPathEnabled", Value: false})
+	}
+	if configs.DisableCapsLock == "yes" {
+		prefs = append(prefs, KeyboardPreferenceModel{Key: "KeyboardCapsLock", Value: false})
+	}
+	return prefs
+}
+
+// readPlistValue returns the raw value of the key, found is false if the key is not set.
+func readPlistValue(pth, key string) (value string, found bool) {
+	out, err := commandRecorder.RunAndReturnTrimmedCombinedOutput(command.New("plutil", "-extract", key, "raw", "-o", "-", pth))
+	if err != nil {
+		return "", false
+	}
+	return out, true
+}
+
+func plistValueString(value string, found bool) string {
+	if !found {
+		return "(not set)"
+	}
+	return value
+}
+
+// writeKeyboardPreferences writes the requested keyboard settings into the shut down simulator's preferences,
+// verifies them by reading the plist back and logs the changed keys.
+func (ctx *StepContext) writeKeyboardPreferences(prefs []KeyboardPreferenceModel) error {
+	fmt.Println()
+	log.Infof("Setting simulator keyboard preferences...")
+
+	prefsPth := filepath.Join(simulatorPreferencesDir(ctx.Simulator.ID), keyboardPreferencesPlistName)
+	if err := ensurePlistExists(prefsPth); err != nil {
+		return err
+	}
+
+	var changes []string
+	for _, pref := range prefs {
+		value := fmt.Sprintf("%t", pref.Value)
+		before, beforeFound := readPlistValue(prefsPth, pref.Key)
+
+		cmd := command.New("plutil", "-replace", pref.Key, "-bool", value, prefsPth)
+		printCommand(cmd)
+
+		if out, err := commandRecorder.RunAndReturnTrimmedCombinedOutput(cmd); err != nil {
+			return newStepError(categoryInfrastructure, "Failed to write simulator preferences (%s), output: %s, error: %s", prefsPth, out, err)
+		}
+
+		if after, found := readPlistValue(prefsPth, pref.Key); !found || after != value {
+			return newStepError(categoryInfrastructure, "Failed to verify simulator preferences (%s), %s is %s instead of %s", prefsPth, pref.Key, plistValueString(after, found), value)
+		}
+
+		if !beforeFound || before != value {
+			changes = append(changes, fmt.Sprintf("- %s: %s -> %s", pref.Key, plistValueString(before, beforeFound), value))
+		}
+	}
+
+	if len(changes) == 0 {
+		log.Donef("Keyboard preferences already set")
+		return nil
+	}
+
+	log.Printf("Changed keyboard preferences:")
+	for _, change := range changes {
+		log.Printf("%s", change)
+	}
+	log.Donef("Keyboard preferences set")
+	return nil
+}
+
+func simulatorPreferencesDir(udid string) string {
+	return filepath.Join(pathutil.UserHomeDir(), "Library", "Developer", "CoreSimulator", "Devices", udid, "data", "Library", "Preferences")
+}
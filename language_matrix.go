@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/log"
+)
+
+const languageMatrixSummaryOutputKey = "BITRISE_CALABASH_LANGUAGE_MATRIX_SUMMARY"
+
+// localeIdentifierExp matches locale identifiers like `en`, `de_DE`, `pt-BR` or `zh-Hans`.
+var localeIdentifierExp = regexp.MustCompile(`^[a-z]{2,3}(?:[_-][A-Za-z0-9]+)*$`)
+
+// parseLanguageMatrix parses the locale identifiers of the language matrix, one per line, empty lines are skipped.
+func parseLanguageMatrix(value string) ([]string, error) {
+	locales := []string{}
+	for i, line := range strings.Split(value, "\n") {
+		locale := strings.TrimSpace(line)
+		if locale == "" {
+			continue
+		}
+
+		if !localeIdentifierExp.MatchString(locale) {
+			return nil, fmt.Errorf("invalid LanguageMatrix line %d (%s), should be a locale identifier, like en or de_DE", i+1, locale)
+		}
+		if indexInStringSlice(locale, locales) != -1 {
+			return nil, fmt.Errorf("invalid LanguageMatrix line %d (%s), duplicated locale", i+1, locale)
+		}
+		locales = append(locales, locale)
+	}
+	return locales, nil
+}
+
+// localeOutputKey returns the output key of a per-language value, for example BITRISE_CALABASH_LANGUAGE_PT_BR_PASSED_COUNT.
+func localeOutputKey(locale, suffix string) string {
+	return "BITRISE_CALABASH_LANGUAGE_" + strings.ToUpper(strings.Replace(locale, "-", "_", -1)) + "_" + suffix
+}
+
+// runLanguageMatrix runs the suite once per language of the matrix, with retries applied to each run.
+// Every language runs to completion, the first failed language's failure is returned.
+func (ctx *StepContext) runLanguageMatrix() error {
+	runs := ctx.runMatrix("Language", ctx.LanguageMatrix, localeOutputKey, func(locale string) {
+		ctx.Locale = locale
+	})
+
+	for _, run := range runs {
+		runSummary.Languages = append(runSummary.Languages, LanguageSummaryModel{
+			Locale:    run.Entry,
+			Result:    run.Result(),
+			Scenarios: run.Scenarios,
+		})
+	}
+
+	table := languageMatrixTable(runs)
+
+	fmt.Println()
+	log.Infof("Language matrix results:")
+	log.Printf("%s", table)
+
+	exportOutput(languageMatrixSummaryOutputKey, table)
+
+	if failed, firstErr := failedMatrixEntries(runs); firstErr != nil {
+		return newStepError(failureCategoryOf(firstErr), "Language matrix failed in: %s, first failure: %s", strings.Join(failed, ", "), firstErr)
+	}
+	return nil
+}
+
+func languageMatrixTable(runs []MatrixRunModel) string {
+	lines := []string{fmt.Sprintf("%-12s %-10s %8s %8s %8s", "Language", "Result", "Passed", "Failed", "Total")}
+	for _, run := range runs {
+		lines = append(lines, fmt.Sprintf("%-12s %-10s %8d %8d %8d", run.Entry, run.Result(), run.Scenarios.Passed, run.Scenarios.Failed, run.Scenarios.Total))
+	}
+	return strings.Join(lines, "\n")
+}
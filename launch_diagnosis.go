@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/bitrise-io/go-utils/command"
+	"github.com/bitrise-io/go-utils/log"
+)
+
+const (
+	// launchFailureRepeatThreshold is the number of the launch failures printed by cucumber, which trigger the launch diagnosis
+	launchFailureRepeatThreshold = 2
+	// launchDiagnosisTimeout is the time the app gets to crash, an app still running after it launched fine
+	launchDiagnosisTimeout  = 15 * time.Second
+	launchDiagnosisMaxLines = 100
+)
+
+// launchFailureDiagnosed is set after the first launch diagnosis, the app is launched for the diagnosis once per step run.
+var launchFailureDiagnosed bool
+
+// tailLineWriter keeps the last maxLines lines written into it.
+type tailLineWriter struct {
+	lock     sync.Mutex
+	maxLines int
+	lines    []string
+	dropped  int
+	partial  []byte
+}
+
+func newTailLineWriter(maxLines int) *tailLineWriter {
+	return &tailLineWriter{maxLines: maxLines}
+}
+
+func (w *tailLineWriter) Write(p []byte) (int, error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	w.partial = append(w.partial, p...)
+	for {
+		idx := bytes.IndexByte(w.partial, '\n')
+		if idx == -1 {
+			break
+		}
+		w.addLine(string(w.partial[:idx]))
+		w.partial = w.partial[idx+1:]
+	}
+	return len(p), nil
+}
+
+func (w *tailLineWriter) addLine(line string) {
+	w.lines = append(w.lines, strings.TrimRight(line, "\r"))
+	if len(w.lines) > w.maxLines {
+		w.lines = w.lines[1:]
+		w.dropped++
+	}
+}
+
+// Lines returns the last lines, the last unterminated line included, and the number of the dropped lines before them.
+func (w *tailLineWriter) Lines() ([]string, int) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if len(w.partial) > 0 {
+		w.addLine(string(w.partial))
+		w.partial = nil
+	}
+	return append([]string{}, w.lines...), w.dropped
+}
+
+// diagnoseLaunchFailure launches the app once with its console attached (simctl launch --console-pty),
+// and prints the last lines of its output: the unhandled exceptions and dyld errors of an app crashing on launch
+// are only printed to its console. The diagnosis never changes the step's result, its failures are logged as warnings.
+func (ctx *StepContext) diagnoseLaunchFailure() {
+	if launchFailureDiagnosed {
+		return
+	}
+	launchFailureDiagnosed = true
+
+	fmt.Println()
+	log.Errorf("Cucumber reported %d app launch failure(s), launching the app with its console attached, diagnosis:", ctx.LaunchFailureCount)
+
+	if ctx.AppPath == "" {
+		log.Warnf("- App path is not set, the app's bundle id is unknown, the app is not launched.")
+		return
+	}
+
+	infoPlist, err := appInfoPlist(ctx.AppPath)
+	if err != nil {
+		log.Warnf("- Failed to read the app's bundle id, the app is not launched: %s", err)
+		return
+	}
+	bundleID, _ := infoPlist["CFBundleIdentifier"].(string)
+	if bundleID == "" {
+		log.Warnf("- CFBundleIdentifier is not set in the app's Info.plist, the app is not launched.")
+		return
+	}
+
+	writer := newTailLineWriter(launchDiagnosisMaxLines)
+	cmd := command.New("xcrun", "simctl", "launch", "--console-pty", ctx.Simulator.ID, bundleID)
+	cmd.SetStdout(writer).SetStderr(writer)
+	printCommand(cmd)
+
+	done, err := commandRecorder.Start(cmd)
+	if err != nil {
+		log.Warnf("- Failed to launch the app: %s", err)
+		return
+	}
+
+	result := ""
+	select {
+	case err := <-done:
+		if err != nil {
+			result = fmt.Sprintf("the launch exited: %s", err)
+		} else {
+			result = "the app exited"
+		}
+	case <-time.After(launchDiagnosisTimeout):
+		result = fmt.Sprintf("the app was still running after %s, it was stopped", launchDiagnosisTimeout)
+		if process := cmd.GetCmd().Process; process != nil {
+			if err := syscall.Kill(-process.Pid, syscall.SIGKILL); err != nil && err != syscall.ESRCH {
+				log.Warnf("Failed to stop the app launch, error: %s", err)
+			}
+		}
+		<-done
+	}
+
+	lines, dropped := writer.Lines()
+	if len(lines) == 0 {
+		log.Printf("- The app printed nothing to its console, %s.", result)
+		return
+	}
+
+	if dropped > 0 {
+		log.Printf("- The last %d lines of the app's console output (%d earlier lines dropped), %s:", len(lines), dropped, result)
+	} else {
+		log.Printf("- The app's console output, %s:", result)
+	}
+	for _, line := range lines {
+		log.Printf("  %s", line)
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/bitrise-io/go-steputils/command/rubycommand"
+	"github.com/bitrise-io/go-utils/fileutil"
+	"github.com/bitrise-io/go-utils/log"
+)
+
+// lockfileFixPlatform is the platform added to a Gemfile.lock without a macOS compatible platform,
+// the generic ruby platform resolves the gems on any host.
+const lockfileFixPlatform = "ruby"
+
+// gemfileLockPlatformsFromContent returns the platforms listed in the PLATFORMS section of the Gemfile.lock, like `x86_64-linux`.
+func gemfileLockPlatformsFromContent(content string) []string {
+	platforms := []string{}
+
+	platformsStart := false
+	for _, line := range strings.Split(content, "\n") {
+		if strings.TrimRight(line, " \r") == "PLATFORMS" {
+			platformsStart = true
+			continue
+		}
+		if !platformsStart {
+			continue
+		}
+
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || !strings.HasPrefix(line, " ") {
+			break
+		}
+		platforms = append(platforms, trimmed)
+	}
+
+	return platforms
+}
+
+func gemfileLockPlatforms(gemfileLockPth string) ([]string, error) {
+	content, err := fileutil.ReadStringFromFile(gemfileLockPth)
+	if err != nil {
+		return nil, err
+	}
+	return gemfileLockPlatformsFromContent(content), nil
+}
+
+// isDarwinCompatiblePlatforms reports whether bundler can install the locked gems on macOS:
+// the lockfile lists the generic ruby platform or a darwin one. A lockfile without a PLATFORMS section is not checked.
+func isDarwinCompatiblePlatforms(platforms []string) bool {
+	if len(platforms) == 0 {
+		return true
+	}
+	for _, platform := range platforms {
+		if platform == "ruby" || strings.Contains(platform, "darwin") {
+			return true
+		}
+	}
+	return false
+}
+
+// warnLockfilePlatforms warns if the Gemfile.lock was generated on a non-darwin platform only,
+// bundle install then fails or re-resolves the gems on macOS.
+func warnLockfilePlatforms(gemfileLockPth string, platforms []string) {
+	fmt.Println()
+	log.Warnf("Gemfile.lock (%s) lists no macOS compatible platform, only: %s", gemfileLockPth, strings.Join(platforms, ", "))
+	log.Warnf("The lockfile was most likely generated on a non-darwin machine, bundle install might fail or re-resolve the gems on macOS.")
+	log.Warnf("Add a macOS compatible platform to the lockfile and commit it: bundle lock --add-platform %s", lockfileFixPlatform)
+	log.Warnf("or set fix_lockfile_platform to yes to add it before bundle install.")
+}
+
+// fixLockfilePlatform adds the generic ruby platform to the Gemfile.lock with `bundle lock --add-platform`.
+func (ctx *StepContext) fixLockfilePlatform() error {
+	log.Printf("Adding the %s platform to the Gemfile.lock (fix_lockfile_platform)", lockfileFixPlatform)
+
+	cmd, err := rubycommand.New("bundle", "lock", "--add-platform", lockfileFixPlatform)
+	if err != nil {
+		return newStepError(categoryDependencyInstall, "Failed to create command, error: %s", err)
+	}
+
+	ctx.setBundleCommandDirAndEnvs(cmd)
+	cmd.SetStdout(stepLogger).SetStderr(stepLogger)
+
+	if err := runCommand(cmd); err != nil {
+		return newStepError(categoryDependencyInstall, "bundle lock --add-platform %s failed, error: %s", lockfileFixPlatform, err)
+	}
+	return nil
+}
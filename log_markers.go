@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/bitrise-io/go-utils/log"
+)
+
+const (
+	scenarioStartMarker  = "##[scenario-start]"
+	scenarioFinishMarker = "##[scenario-finish]"
+)
+
+// prettyLocationExp matches the location comment the pretty formatter prints after a scenario's name, like `# features/login.feature:3`.
+var prettyLocationExp = regexp.MustCompile(`\s+#\s+(\S+:\d+)\s*$`)
+
+// prettyFailureLineExp matches the error lines the pretty formatter prints after a failed step:
+// the error message ending with the exception class, like `expected "Home" (RuntimeError)`, and the backtrace lines.
+var prettyFailureLineExp = regexp.MustCompile("\\([A-Z][A-Za-z0-9_]*(::[A-Z][A-Za-z0-9_]*)*\\)$|:\\d+:in `")
+
+// ansiRedExp matches the red color code the pretty formatter prints the failed steps with.
+var ansiRedExp = regexp.MustCompile(`\x1b\[(0;)?31m`)
+
+// LogMarkerWriter passes the pretty formatter's output through line by line, printing a start marker before
+// (`##[scenario-start] features/login.feature:3 Valid login`) and a finish marker with the scenario's status and duration after
+// each scenario's output (`##[scenario-finish] features/login.feature:3 Valid login (passed in 2.3s)`).
+// The examples' rows of a scenario outline are printed when they are done, their markers enclose the row and its error lines.
+type LogMarkerWriter struct {
+	out     io.Writer
+	enabled bool
+	line    []byte
+	now     func() time.Time
+
+	inScenario bool
+	location   string
+	name       string
+	failed     bool
+	start      time.Time
+	exampleRow bool
+	lastLine   time.Time
+
+	outline         bool
+	outlineLocation string
+	outlineName     string
+	inExamples      bool
+	headerSeen      bool
+	exampleIndex    int
+	finished        bool
+}
+
+// NewLogMarkerWriter returns a writer passing everything through unchanged if enabled is false.
+func NewLogMarkerWriter(out io.Writer, enabled bool) *LogMarkerWriter {
+	return &LogMarkerWriter{out: out, enabled: enabled, now: time.Now}
+}
+
+func (w *LogMarkerWriter) Write(p []byte) (int, error) {
+	if !w.enabled {
+		return w.out.Write(p)
+	}
+
+	w.line = append(w.line, p...)
+	for {
+		idx := bytes.IndexByte(w.line, '\n')
+		if idx == -1 {
+			break
+		}
+		if err := w.writeLine(string(w.line[:idx+1])); err != nil {
+			return 0, err
+		}
+		w.line = w.line[idx+1:]
+	}
+	return len(p), nil
+}
+
+// Flush writes the last unterminated line and closes the last scenario.
+func (w *LogMarkerWriter) Flush() error {
+	if !w.enabled {
+		return nil
+	}
+	if len(w.line) > 0 {
+		line := string(w.line)
+		w.line = nil
+		if err := w.writeLine(line); err != nil {
+			return err
+		}
+	}
+	return w.finishScenario()
+}
+
+func (w *LogMarkerWriter) writeLine(raw string) error {
+	if w.finished {
+		_, err := io.WriteString(w.out, raw)
+		return err
+	}
+
+	line := strings.TrimSpace(stripANSI(raw))
+	markers := []string{}
+	switch {
+	case cucumberSummaryScenariosExp.MatchString(line) || line == "Failing Scenarios:":
+		markers = append(markers, w.finishMarker()...)
+		w.finished = true
+	case strings.HasPrefix(line, "Feature:") || strings.HasPrefix(line, "Background:"):
+		markers = append(markers, w.finishMarker()...)
+		w.outline, w.inExamples = false, false
+	case strings.HasPrefix(line, "Scenario Outline:") || strings.HasPrefix(line, "Scenario Template:"):
+		markers = append(markers, w.finishMarker()...)
+		w.outline, w.inExamples, w.exampleIndex = true, false, 0
+		w.outlineName, w.outlineLocation = scenarioNameAndLocation(line)
+	case strings.HasPrefix(line, "Examples:") || strings.HasPrefix(line, "Scenarios:"):
+		markers = append(markers, w.finishMarker()...)
+		w.inExamples, w.headerSeen = w.outline, false
+	case strings.HasPrefix(line, "Scenario:") || strings.HasPrefix(line, "Example:"):
+		markers = append(markers, w.finishMarker()...)
+		w.outline, w.inExamples = false, false
+		name, location := scenarioNameAndLocation(line)
+		markers = append(markers, w.startScenario(name, location, w.now(), false))
+	case strings.HasPrefix(line, "|") && w.inExamples:
+		// the examples' rows are printed when they are done, the row ran since the previous line
+		markers = append(markers, w.finishMarker()...)
+		if w.headerSeen {
+			w.exampleIndex++
+			name := fmt.Sprintf("%s, Examples (#%d)", w.outlineName, w.exampleIndex)
+			markers = append(markers, w.startScenario(name, w.outlineLocation, w.lastLine, true))
+			w.failed = ansiRedExp.MatchString(raw)
+		}
+		w.headerSeen = true
+	case w.inScenario && (prettyFailureLineExp.MatchString(line) || ansiRedExp.MatchString(raw)):
+		w.failed = true
+	}
+
+	w.lastLine = w.now()
+	for _, marker := range markers {
+		if _, err := io.WriteString(w.out, marker+"\n"); err != nil {
+			return err
+		}
+	}
+	_, err := io.WriteString(w.out, raw)
+	return err
+}
+
+func (w *LogMarkerWriter) startScenario(name, location string, start time.Time, exampleRow bool) string {
+	w.inScenario = true
+	w.name, w.location = name, location
+	w.failed = false
+	w.start, w.exampleRow = start, exampleRow
+	return strings.TrimSpace(fmt.Sprintf("%s %s %s", scenarioStartMarker, location, name))
+}
+
+// finishMarker closes the current scenario, it returns nothing if no scenario is open.
+func (w *LogMarkerWriter) finishMarker() []string {
+	if !w.inScenario {
+		return nil
+	}
+	w.inScenario = false
+
+	status := "passed"
+	if w.failed {
+		status = "failed"
+	}
+	// an examples' row is done by the time it is printed, a scenario when the next one starts
+	end := w.now()
+	if w.exampleRow {
+		end = w.lastLine
+	}
+	duration := end.Sub(w.start).Round(100 * time.Millisecond)
+	return []string{strings.TrimSpace(fmt.Sprintf("%s %s %s", scenarioFinishMarker, w.location, w.name)) +
+		fmt.Sprintf(" (%s in %s)", status, duration)}
+}
+
+func (w *LogMarkerWriter) finishScenario() error {
+	for _, marker := range w.finishMarker() {
+		if _, err := io.WriteString(w.out, marker+"\n"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// scenarioNameAndLocation splits a (color codes stripped) scenario line of the pretty formatter into the scenario's name and location,
+// like `Scenario: Valid login  # features/login.feature:3`.
+func scenarioNameAndLocation(line string) (string, string) {
+	location := ""
+	if match := prettyLocationExp.FindStringSubmatchIndex(line); match != nil {
+		location = line[match[2]:match[3]]
+		line = line[:match[0]]
+	}
+	name := strings.TrimSpace(line[strings.Index(line, ":")+1:])
+	return name, location
+}
+
+// emitLogMarkers reports whether the log markers are printed: emit_log_markers is set and the cucumber output is the pretty formatter's,
+// the progress formatter's output has no scenario boundaries.
+func (ctx *StepContext) emitLogMarkers() bool {
+	if ctx.Configs.EmitLogMarkers != "yes" {
+		return false
+	}
+
+	stdoutFormats := ctx.stdoutFormats()
+	if indexInStringSlice("progress", stdoutFormats) != -1 {
+		log.Printf("Log markers are skipped, the progress formatter's output has no scenario boundaries")
+		return false
+	}
+	if indexInStringSlice(cucumberDefaultFormat, stdoutFormats) == -1 {
+		log.Printf("Log markers are skipped, the pretty formatter does not write to the output")
+		return false
+	}
+	return true
+}
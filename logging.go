@@ -0,0 +1,302 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bitrise-io/go-utils/command"
+	"github.com/bitrise-io/go-utils/log"
+)
+
+// Log levels
+const (
+	logLevelDebug = "debug"
+	logLevelInfo  = "info"
+	logLevelWarn  = "warn"
+	logLevelError = "error"
+)
+
+var logLevels = []string{logLevelDebug, logLevelInfo, logLevelWarn, logLevelError}
+
+const (
+	stepLogFileName  = "step.log"
+	stepLogOutputKey = "BITRISE_CALABASH_STEP_LOG_PATH"
+)
+
+const (
+	ansiWarnPrefix  = "\x1b[33;1m"
+	ansiErrorPrefix = "\x1b[31;1m"
+)
+
+var ansiEscapeExp = regexp.MustCompile("\x1b\\[[0-9;]*[a-zA-Z]")
+
+func stripANSI(s string) string {
+	return ansiEscapeExp.ReplaceAllString(s, "")
+}
+
+// ansiStrippingWriter removes the ANSI escape sequences from everything written through it.
+type ansiStrippingWriter struct {
+	out io.Writer
+}
+
+func (w ansiStrippingWriter) Write(p []byte) (int, error) {
+	if _, err := w.out.Write([]byte(stripANSI(string(p)))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// StepLogger is the output of the step's log messages and of the executed commands.
+// Colors are removed when stdout is not a terminal (unless colors are forced or disabled),
+// and on warn and error levels the output of the successful sections is collapsed.
+// Everything is written into the full log as well, without colors and collapsing.
+type StepLogger struct {
+	level string
+	out   io.Writer
+
+	fullLog     io.Writer
+	fullLogLock sync.Mutex
+
+	section      string
+	sectionStart time.Time
+	buffer       *bytes.Buffer
+}
+
+// NewStepLogger ...
+func NewStepLogger() *StepLogger {
+	var out io.Writer = os.Stdout
+	if !isTerminal(os.Stdout) {
+		out = ansiStrippingWriter{out: os.Stdout}
+	}
+	// the full log is buffered until its file is opened
+	return &StepLogger{level: logLevelInfo, out: out, fullLog: &bytes.Buffer{}}
+}
+
+// SetColors enables or disables the colors of the console output, regardless of stdout being a terminal.
+func (l *StepLogger) SetColors(enabled bool) {
+	if enabled {
+		l.out = os.Stdout
+	} else {
+		l.out = ansiStrippingWriter{out: os.Stdout}
+	}
+}
+
+// OpenFullLog writes the full log into the file at pth, starting with the output logged so far.
+func (l *StepLogger) OpenFullLog(pth string) error {
+	file, err := os.Create(pth)
+	if err != nil {
+		return err
+	}
+
+	l.fullLogLock.Lock()
+	defer l.fullLogLock.Unlock()
+
+	fullLog := ansiStrippingWriter{out: file}
+	if buffered, ok := l.fullLog.(*bytes.Buffer); ok {
+		if _, err := fullLog.Write(buffered.Bytes()); err != nil {
+			return err
+		}
+	}
+	l.fullLog = fullLog
+	return nil
+}
+
+func (l *StepLogger) writeFullLog(p []byte) {
+	l.fullLogLock.Lock()
+	defer l.fullLogLock.Unlock()
+
+	if _, err := l.fullLog.Write(p); err != nil {
+		// the console output goes on, the full log is incomplete
+		l.fullLog = ioutil.Discard
+		fmt.Printf("failed to write the full log, error: %s\n", err)
+	}
+}
+
+// SetLevel ...
+func (l *StepLogger) SetLevel(level string) {
+	if indexInStringSlice(level, logLevels) == -1 {
+		return
+	}
+
+	l.level = level
+	log.SetEnableDebugLog(level == logLevelDebug)
+}
+
+// IsDebug ...
+func (l *StepLogger) IsDebug() bool {
+	return l.level == logLevelDebug
+}
+
+func (l *StepLogger) collapsesSections() bool {
+	return l.level == logLevelWarn || l.level == logLevelError
+}
+
+// Write buffers the output of the current section if sections are collapsed,
+// warnings (on warn level) and errors are always written through.
+func (l *StepLogger) Write(p []byte) (int, error) {
+	l.writeFullLog(p)
+
+	if l.buffer == nil || l.passesThrough(p) {
+		return l.out.Write(p)
+	}
+	return l.buffer.Write(p)
+}
+
+func (l *StepLogger) passesThrough(p []byte) bool {
+	if bytes.HasPrefix(p, []byte(ansiErrorPrefix)) {
+		return true
+	}
+	return l.level == logLevelWarn && bytes.HasPrefix(p, []byte(ansiWarnPrefix))
+}
+
+type rawStepLogWriter struct {
+	logger *StepLogger
+}
+
+func (w rawStepLogWriter) Write(p []byte) (int, error) {
+	w.logger.writeFullLog(p)
+	return w.logger.out.Write(p)
+}
+
+// Raw returns a writer which is never collapsed, used for the cucumber output.
+func (l *StepLogger) Raw() io.Writer {
+	return rawStepLogWriter{logger: l}
+}
+
+// BeginSection closes the current section as successful and starts a new one.
+func (l *StepLogger) BeginSection(name string) {
+	l.EndSection()
+
+	l.section = name
+	l.sectionStart = time.Now()
+	if l.collapsesSections() {
+		l.buffer = &bytes.Buffer{}
+	}
+}
+
+// EndSection closes the current section as successful,
+// a collapsed section is reduced to a single line on warn level.
+func (l *StepLogger) EndSection() {
+	if l.section == "" {
+		return
+	}
+
+	collapsed := l.buffer != nil
+	l.buffer = nil
+	if collapsed && l.level == logLevelWarn {
+		log.Donef("✓ %s (%s)", l.section, roundDuration(time.Since(l.sectionStart)))
+	}
+	l.section = ""
+}
+
+// FailSection writes the collapsed output of the current section, so the context of the failure is visible.
+func (l *StepLogger) FailSection() {
+	if l.buffer != nil {
+		buffered := l.buffer.Bytes()
+		l.buffer = nil
+		if _, err := l.out.Write(buffered); err != nil {
+			fmt.Printf("failed to print section output, error: %s\n", err)
+		}
+	}
+	l.section = ""
+}
+
+var stepLogger = NewStepLogger()
+
+// openStepLog writes the full step log into the results dir's logs subdir,
+// it is always free of colors, independently of the console's color mode.
+func openStepLog() {
+	pth := filepath.Join(resultsSubdir(resultsLogsDirName), stepLogFileName)
+	if err := stepLogger.OpenFullLog(pth); err != nil {
+		log.Warnf("Failed to open the step log (%s), error: %s", pth, err)
+		return
+	}
+
+	log.Printf("Step log: %s", pth)
+	exportOutput(stepLogOutputKey, pth)
+	diagnostics.Add(diagnosticKindStepLog, pth, false)
+}
+
+func init() {
+	log.SetOutWriter(stepLogger)
+}
+
+// printCommand prints the command, on debug level the working dir and the env overrides as well.
+func printCommand(cmd *command.Model) {
+	log.Printf("$ %s", cmd.PrintableCommandArgs())
+
+	if !stepLogger.IsDebug() {
+		return
+	}
+
+	if dir := cmd.GetCmd().Dir; dir != "" {
+		log.Debugf("  cwd: %s", dir)
+	}
+	for _, env := range commandEnvOverrides(cmd) {
+		log.Debugf("  env: %s", env)
+	}
+}
+
+// commandEnvOverrides returns the command's envs which differ from the step's own environment, secrets masked.
+func commandEnvOverrides(cmd *command.Model) []string {
+	envs := cmd.GetCmd().Env
+	if envs == nil {
+		return nil
+	}
+
+	inherited := map[string]bool{}
+	for _, env := range os.Environ() {
+		inherited[env] = true
+	}
+
+	overrides := []string{}
+	for _, env := range envs {
+		if inherited[env] {
+			continue
+		}
+		key, value := splitEnv(env)
+		overrides = append(overrides, key+"="+maskSecret(key, value))
+	}
+	return overrides
+}
+
+func splitEnv(env string) (string, string) {
+	split := strings.SplitN(env, "=", 2)
+	if len(split) < 2 {
+		return split[0], ""
+	}
+	return split[0], split[1]
+}
+
+// debugEnvMap prints an env list in sorted order on debug level, secrets masked.
+func debugEnvMap(title string, envs []string) {
+	if !stepLogger.IsDebug() {
+		return
+	}
+
+	sorted := append([]string{}, envs...)
+	sort.Strings(sorted)
+
+	log.Debugf("%s:", title)
+	for _, env := range sorted {
+		key, value := splitEnv(env)
+		log.Debugf("  %s=%s", key, maskSecret(key, value))
+	}
+}
@@ -2,143 +2,938 @@ package main
 
 import (
 	"errors"
+	"flag"
 	"fmt"
 	"os"
 	"path/filepath"
-	"regexp"
+	"runtime/debug"
 	"strings"
+	"sync"
+	"sync/atomic"
 
-	"github.com/bitrise-io/go-steputils/command/rubycommand"
-	"github.com/bitrise-io/go-utils/command"
-	"github.com/bitrise-io/go-utils/fileutil"
 	"github.com/bitrise-io/go-utils/log"
 	"github.com/bitrise-io/go-utils/pathutil"
-	"github.com/bitrise-io/go-xcode/simulator"
 	shellquote "github.com/kballard/go-shellquote"
 )
 
+// Step modes
+const (
+	modeFull        = "full"
+	modePrepareOnly = "prepare_only"
+	modeTestOnly    = "test_only"
+)
+
+var modes = []string{modeFull, modePrepareOnly, modeTestOnly}
+
+// Outputs of the resolved simulator, the UDID and the app path of the prepare_only mode are used by the test_only mode
+const (
+	simulatorNameOutputKey      = "BITRISE_CALABASH_SIMULATOR_NAME"
+	simulatorOsVersionOutputKey = "BITRISE_CALABASH_SIMULATOR_OS_VERSION"
+	simulatorUDIDOutputKey      = "BITRISE_CALABASH_SIMULATOR_UDID"
+	appPathOutputKey            = "BITRISE_CALABASH_APP_PATH"
+)
+
+// Test result outputs, BITRISE_XAMARIN_TEST_RESULT is kept for compatibility
+const (
+	testResultOutputKey       = "BITRISE_CALABASH_TEST_RESULT"
+	legacyTestResultOutputKey = "BITRISE_XAMARIN_TEST_RESULT"
+)
+
+// Test results
+const (
+	testResultSucceeded = "succeeded"
+	testResultFailed    = "failed"
+	testResultSkipped   = "skipped"
+)
+
 // ConfigsModel ...
 type ConfigsModel struct {
-	WorkDir     string
-	GemFilePath string
-	AppPath     string
-	Options     string
+	Mode     string `env:"mode"`
+	LogLevel string `env:"log_level"`
+
+	NoColor    string `env:"no_color"`
+	ForceColor string `env:"force_color"`
+
+	SuppressCucumberBanners string `env:"suppress_cucumber_banners"`
+
+	WorkDir     string `env:"work_dir"`
+	FeaturesDir string `env:"features_dir"`
+	GemFilePath string `env:"gem_file_path"`
+	AppPath     string `env:"app_path"`
+	Options     string `env:"additional_options"`
+	RerunFile   string `env:"rerun_file"`
+	ResultsDir  string `env:"results_dir"`
+
+	AppendToResultsDir string `env:"append_to_results_dir"`
+
+	TestRunName string `env:"test_run_name"`
+
+	ArchiveTempDirOnFailure string `env:"archive_temp_dir_on_failure"`
+
+	AppMinSizeKB string `env:"app_min_size_kb"`
+
+	AppResolution string `env:"app_resolution"`
+
+	AdditionalAppPaths string `env:"additional_app_paths"`
+
+	ScenarioNameFilter string `env:"scenario_name_filter"`
+
+	ExcludePatterns string `env:"exclude_patterns"`
+
+	WipTags             string `env:"wip_tags"`
+	FailOnWipViolations string `env:"fail_on_wip_violations"`
+
+	ReportTags string `env:"report_tags"`
+
+	FullBacktraces string `env:"full_backtraces"`
+
+	EmitLogMarkers string `env:"emit_log_markers"`
+
+	UseGeneratedProfile string `env:"use_generated_profile"`
+
+	FeatureOrderFile string `env:"feature_order_file"`
+	UnlistedFeatures string `env:"unlisted_features"`
+
+	DisableEnvExpansion string `env:"disable_env_expansion"`
+
+	AppLaunchArguments   string `env:"app_launch_arguments"`
+	AppLaunchEnvironment string `env:"app_launch_environment"`
+
+	Platform           string `env:"platform"`
+	SimulatorDevice    string `env:"simulator_device"`
+	SimulatorOsVersion string `env:"simulator_os_version"`
+	SimulatorDevices   string `env:"simulator_devices"`
+
+	PreferBootedSimulator string `env:"prefer_booted_simulator"`
+
+	SimulatorSelectionStrategy string `env:"simulator_selection_strategy"`
+
+	RequireExactOsVersion string `env:"require_exact_os_version"`
+
+	DeviceTargetFormat string `env:"device_target_format"`
+
+	DisablePredictiveText string `env:"disable_predictive_text"`
+	DisableSlideToType    string `env:"disable_slide_to_type"`
+	DisableCapsLock       string `env:"disable_caps_lock"`
+
+	NetworkProfile string `env:"network_profile"`
+
+	StreamAppLogs string `env:"stream_app_logs"`
+
+	CollectAppContainerOnFailure string `env:"collect_app_container_on_failure"`
+
+	DiagnoseLaunchFailures string `env:"diagnose_launch_failures"`
+
+	CalabashCucumberVersion    string `env:"calabash_cucumber_version"`
+	DependencyResolution       string `env:"dependency_resolution"`
+	BundleInstallStrategy      string `env:"bundle_install_strategy"`
+	FixLockfilePlatform        string `env:"fix_lockfile_platform"`
+	PruneOtherCalabashVersions string `env:"prune_other_calabash_versions"`
+	CucumberVersion            string `env:"cucumber_version"`
+
+	XcodeDeveloperDirPath string `env:"xcode_developer_dir_path"`
+
+	SkipSimctlPreflight string `env:"skip_simctl_preflight"`
+	SkipIfNoFeatures    string `env:"skip_if_no_features"`
+	FailOnDeprecations  string `env:"fail_on_deprecations"`
+	AllowEmptyRun       string `env:"allow_empty_run"`
+
+	FailOnUndefined string `env:"fail_on_undefined"`
+	FailOnPending   string `env:"fail_on_pending"`
+
+	FailIfCucumberRunning string `env:"fail_if_cucumber_running"`
+	KillExistingCucumber  string `env:"kill_existing_cucumber"`
+
+	StrictDeviceFamilyCheck string `env:"strict_device_family_check"`
+	EnforceStepTarget       string `env:"enforce_step_target"`
+
+	LanguageMatrix string `env:"language_matrix"`
+
+	BaselineSummaryPath                string `env:"baseline_summary_path"`
+	DurationRegressionThresholdPercent string `env:"duration_regression_threshold_percent"`
+	PreviousFingerprintPath            string `env:"previous_fingerprint_path"`
+
+	MinSelectedScenariosPercent string `env:"min_selected_scenarios_percent"`
+
+	StepTimeoutMinutes       string `env:"step_timeout_minutes"`
+	MatrixRunTimeoutMinutes  string `env:"matrix_run_timeout_minutes"`
+	ProgressIntervalSeconds  string `env:"progress_interval_seconds"`
+	StepRetryCount           string `env:"step_retry_count"`
+	DiagnosticsSizeLimitInMB string `env:"diagnostics_size_limit_mb"`
+
+	MetricsWebhookURL string `env:"metrics_webhook_url"`
+
+	PreRunScriptPath            string `env:"pre_run_script_path"`
+	PreRunScriptTimeoutSeconds  string `env:"pre_run_script_timeout_seconds"`
+	PostRunScriptPath           string `env:"post_run_script_path"`
+	PostRunScriptTimeoutSeconds string `env:"post_run_script_timeout_seconds"`
+	FailOnPostScriptError       string `env:"fail_on_post_script_error"`
+
+	// ParsedOptions is the additional_options input split into arguments by validate.
+	ParsedOptions []string
+}
+
+func createConfigsModelFromEnvs() ConfigsModel {
+	return ConfigsModel{
+		Mode:     os.Getenv("mode"),
+		LogLevel: os.Getenv("log_level"),
+
+		NoColor:    os.Getenv("no_color"),
+		ForceColor: os.Getenv("force_color"),
+
+		SuppressCucumberBanners: os.Getenv("suppress_cucumber_banners"),
+
+		WorkDir:     os.Getenv("work_dir"),
+		FeaturesDir: os.Getenv("features_dir"),
+		GemFilePath: os.Getenv("gem_file_path"),
+		AppPath:     os.Getenv("app_path"),
+		Options:     os.Getenv("additional_options"),
+		RerunFile:   os.Getenv("rerun_file"),
+		ResultsDir:  os.Getenv("results_dir"),
+
+		AppendToResultsDir: os.Getenv("append_to_results_dir"),
+
+		TestRunName: os.Getenv("test_run_name"),
+
+		ArchiveTempDirOnFailure: os.Getenv("archive_temp_dir_on_failure"),
+
+		AppMinSizeKB: os.Getenv("app_min_size_kb"),
+
+		AppResolution: os.Getenv("app_resolution"),
+
+		AdditionalAppPaths: os.Getenv("additional_app_paths"),
+
+		ScenarioNameFilter: os.Getenv("scenario_name_filter"),
+
+		ExcludePatterns: os.Getenv("exclude_patterns"),
+
+		WipTags:             os.Getenv("wip_tags"),
+		FailOnWipViolations: os.Getenv("fail_on_wip_violations"),
+
+		ReportTags: os.Getenv("report_tags"),
+
+		FullBacktraces: os.Getenv("full_backtraces"),
+
+		EmitLogMarkers: os.Getenv("emit_log_markers"),
+
+		UseGeneratedProfile: os.Getenv("use_generated_profile"),
+
+		FeatureOrderFile: os.Getenv("feature_order_file"),
+		UnlistedFeatures: os.Getenv("unlisted_features"),
+
+		DisableEnvExpansion: os.Getenv("disable_env_expansion"),
+
+		AppLaunchArguments:   os.Getenv("app_launch_arguments"),
+		AppLaunchEnvironment: os.Getenv("app_launch_environment"),
+
+		Platform:           os.Getenv("platform"),
+		SimulatorDevice:    os.Getenv("simulator_device"),
+		SimulatorOsVersion: os.Getenv("simulator_os_version"),
+		SimulatorDevices:   os.Getenv("simulator_devices"),
+
+		PreferBootedSimulator: os.Getenv("prefer_booted_simulator"),
+
+		SimulatorSelectionStrategy: os.Getenv("simulator_selection_strategy"),
+
+		RequireExactOsVersion: os.Getenv("require_exact_os_version"),
+
+		DeviceTargetFormat: os.Getenv("device_target_format"),
+
+		DisablePredictiveText: os.Getenv("disable_predictive_text"),
+		DisableSlideToType:    os.Getenv("disable_slide_to_type"),
+		DisableCapsLock:       os.Getenv("disable_caps_lock"),
+
+		NetworkProfile: os.Getenv("network_profile"),
+
+		StreamAppLogs: os.Getenv("stream_app_logs"),
+
+		CollectAppContainerOnFailure: os.Getenv("collect_app_container_on_failure"),
+
+		DiagnoseLaunchFailures: os.Getenv("diagnose_launch_failures"),
+
+		CalabashCucumberVersion:    os.Getenv("calabash_cucumber_version"),
+		DependencyResolution:       os.Getenv("dependency_resolution"),
+		BundleInstallStrategy:      os.Getenv("bundle_install_strategy"),
+		FixLockfilePlatform:        os.Getenv("fix_lockfile_platform"),
+		PruneOtherCalabashVersions: os.Getenv("prune_other_calabash_versions"),
+		CucumberVersion:            os.Getenv("cucumber_version"),
+
+		XcodeDeveloperDirPath: os.Getenv("xcode_developer_dir_path"),
+
+		SkipSimctlPreflight: os.Getenv("skip_simctl_preflight"),
+		SkipIfNoFeatures:    os.Getenv("skip_if_no_features"),
+		FailOnDeprecations:  os.Getenv("fail_on_deprecations"),
+		AllowEmptyRun:       os.Getenv("allow_empty_run"),
+
+		FailOnUndefined: os.Getenv("fail_on_undefined"),
+		FailOnPending:   os.Getenv("fail_on_pending"),
+
+		FailIfCucumberRunning: os.Getenv("fail_if_cucumber_running"),
+		KillExistingCucumber:  os.Getenv("kill_existing_cucumber"),
+
+		StrictDeviceFamilyCheck: os.Getenv("strict_device_family_check"),
+		EnforceStepTarget:       os.Getenv("enforce_step_target"),
+
+		LanguageMatrix: os.Getenv("language_matrix"),
+
+		BaselineSummaryPath:                os.Getenv("baseline_summary_path"),
+		DurationRegressionThresholdPercent: os.Getenv("duration_regression_threshold_percent"),
+		PreviousFingerprintPath:            os.Getenv("previous_fingerprint_path"),
+
+		MinSelectedScenariosPercent: os.Getenv("min_selected_scenarios_percent"),
+
+		StepTimeoutMinutes:       os.Getenv("step_timeout_minutes"),
+		MatrixRunTimeoutMinutes:  os.Getenv("matrix_run_timeout_minutes"),
+		ProgressIntervalSeconds:  os.Getenv("progress_interval_seconds"),
+		StepRetryCount:           os.Getenv("step_retry_count"),
+		DiagnosticsSizeLimitInMB: os.Getenv("diagnostics_size_limit_mb"),
+
+		MetricsWebhookURL: os.Getenv("metrics_webhook_url"),
+
+		PreRunScriptPath:            os.Getenv("pre_run_script_path"),
+		PreRunScriptTimeoutSeconds:  os.Getenv("pre_run_script_timeout_seconds"),
+		PostRunScriptPath:           os.Getenv("post_run_script_path"),
+		PostRunScriptTimeoutSeconds: os.Getenv("post_run_script_timeout_seconds"),
+		FailOnPostScriptError:       os.Getenv("fail_on_post_script_error"),
+	}
+}
+
+func (configs ConfigsModel) print() {
+	log.Infof("Configs:")
+	log.Printf("- Mode: %s", configs.Mode)
+	log.Printf("- LogLevel: %s", configs.LogLevel)
+
+	log.Printf("- NoColor: %s", configs.NoColor)
+	log.Printf("- ForceColor: %s", configs.ForceColor)
+	log.Printf("- SuppressCucumberBanners: %s", configs.SuppressCucumberBanners)
+	log.Printf("- WorkDir: %s", configs.WorkDir)
+	log.Printf("- FeaturesDir: %s", configs.FeaturesDir)
+	log.Printf("- GemFilePath: %s", configs.GemFilePath)
+	log.Printf("- AppPath: %s", configs.AppPath)
+	log.Printf("- Options: %s", configs.Options)
+	log.Printf("- RerunFile: %s", configs.RerunFile)
+	log.Printf("- ResultsDir: %s", configs.ResultsDir)
+
+	log.Printf("- AppendToResultsDir: %s", configs.AppendToResultsDir)
+
+	log.Printf("- TestRunName: %s", configs.TestRunName)
+
+	log.Printf("- ArchiveTempDirOnFailure: %s", configs.ArchiveTempDirOnFailure)
+
+	log.Printf("- AppMinSizeKB: %s", configs.AppMinSizeKB)
+
+	log.Printf("- AppResolution: %s", configs.AppResolution)
+
+	log.Printf("- AdditionalAppPaths: %s", configs.AdditionalAppPaths)
+
+	log.Printf("- ScenarioNameFilter: %s", configs.ScenarioNameFilter)
+
+	log.Printf("- ExcludePatterns: %s", configs.ExcludePatterns)
+
+	log.Printf("- WipTags: %s", configs.WipTags)
+	log.Printf("- FailOnWipViolations: %s", configs.FailOnWipViolations)
+
+	log.Printf("- ReportTags: %s", configs.ReportTags)
+
+	log.Printf("- FullBacktraces: %s", configs.FullBacktraces)
+
+	log.Printf("- EmitLogMarkers: %s", configs.EmitLogMarkers)
+
+	log.Printf("- UseGeneratedProfile: %s", configs.UseGeneratedProfile)
+
+	log.Printf("- FeatureOrderFile: %s", configs.FeatureOrderFile)
+	log.Printf("- UnlistedFeatures: %s", configs.UnlistedFeatures)
+
+	log.Printf("- DisableEnvExpansion: %s", configs.DisableEnvExpansion)
+
+	log.Printf("- AppLaunchArguments: %s", configs.AppLaunchArguments)
+	log.Printf("- AppLaunchEnvironment: %s", maskedAppLaunchEnvironment(configs.AppLaunchEnvironment))
+
+	log.Printf("- Platform: %s", configs.Platform)
+	log.Printf("- SimulatorDevice: %s", configs.SimulatorDevice)
+	log.Printf("- SimulatorOsVersion: %s", configs.SimulatorOsVersion)
+	log.Printf("- SimulatorDevices: %s", configs.SimulatorDevices)
+
+	log.Printf("- PreferBootedSimulator: %s", configs.PreferBootedSimulator)
+
+	log.Printf("- SimulatorSelectionStrategy: %s", configs.SimulatorSelectionStrategy)
+
+	log.Printf("- RequireExactOsVersion: %s", configs.RequireExactOsVersion)
+
+	log.Printf("- DeviceTargetFormat: %s", configs.DeviceTargetFormat)
+
+	log.Printf("- DisablePredictiveText: %s", configs.DisablePredictiveText)
+	log.Printf("- DisableSlideToType: %s", configs.DisableSlideToType)
+	log.Printf("- DisableCapsLock: %s", configs.DisableCapsLock)
+
+	log.Printf("- NetworkProfile: %s", configs.NetworkProfile)
+
+	log.Printf("- StreamAppLogs: %s", configs.StreamAppLogs)
+
+	log.Printf("- CollectAppContainerOnFailure: %s", configs.CollectAppContainerOnFailure)
+
+	log.Printf("- DiagnoseLaunchFailures: %s", configs.DiagnoseLaunchFailures)
+
+	log.Printf("- CalabashCucumberVersion: %s", configs.CalabashCucumberVersion)
+	log.Printf("- DependencyResolution: %s", configs.DependencyResolution)
+	log.Printf("- BundleInstallStrategy: %s", configs.BundleInstallStrategy)
+	log.Printf("- FixLockfilePlatform: %s", configs.FixLockfilePlatform)
+	log.Printf("- PruneOtherCalabashVersions: %s", configs.PruneOtherCalabashVersions)
+	log.Printf("- CucumberVersion: %s", configs.CucumberVersion)
+
+	log.Printf("- XcodeDeveloperDirPath: %s", configs.XcodeDeveloperDirPath)
+	log.Printf("- SkipSimctlPreflight: %s", configs.SkipSimctlPreflight)
+	log.Printf("- SkipIfNoFeatures: %s", configs.SkipIfNoFeatures)
+	log.Printf("- FailOnDeprecations: %s", configs.FailOnDeprecations)
+	log.Printf("- AllowEmptyRun: %s", configs.AllowEmptyRun)
+
+	log.Printf("- FailOnUndefined: %s", configs.FailOnUndefined)
+	log.Printf("- FailOnPending: %s", configs.FailOnPending)
+
+	log.Printf("- FailIfCucumberRunning: %s", configs.FailIfCucumberRunning)
+	log.Printf("- KillExistingCucumber: %s", configs.KillExistingCucumber)
+
+	log.Printf("- StrictDeviceFamilyCheck: %s", configs.StrictDeviceFamilyCheck)
+	log.Printf("- EnforceStepTarget: %s", configs.EnforceStepTarget)
+
+	log.Printf("- LanguageMatrix: %s", configs.LanguageMatrix)
+
+	log.Printf("- BaselineSummaryPath: %s", configs.BaselineSummaryPath)
+	log.Printf("- DurationRegressionThresholdPercent: %s", configs.DurationRegressionThresholdPercent)
+	log.Printf("- PreviousFingerprintPath: %s", configs.PreviousFingerprintPath)
+
+	log.Printf("- MinSelectedScenariosPercent: %s", configs.MinSelectedScenariosPercent)
+
+	log.Printf("- StepTimeoutMinutes: %s", configs.StepTimeoutMinutes)
+	log.Printf("- MatrixRunTimeoutMinutes: %s", configs.MatrixRunTimeoutMinutes)
+	log.Printf("- ProgressIntervalSeconds: %s", configs.ProgressIntervalSeconds)
+	log.Printf("- StepRetryCount: %s", configs.StepRetryCount)
+	log.Printf("- DiagnosticsSizeLimitInMB: %s", configs.DiagnosticsSizeLimitInMB)
+
+	log.Printf("- MetricsWebhookURL: %s", maskSecret("metrics_webhook_url", configs.MetricsWebhookURL))
+
+	log.Printf("- PreRunScriptPath: %s", configs.PreRunScriptPath)
+	log.Printf("- PreRunScriptTimeoutSeconds: %s", configs.PreRunScriptTimeoutSeconds)
+	log.Printf("- PostRunScriptPath: %s", configs.PostRunScriptPath)
+	log.Printf("- PostRunScriptTimeoutSeconds: %s", configs.PostRunScriptTimeoutSeconds)
+	log.Printf("- FailOnPostScriptError: %s", configs.FailOnPostScriptError)
+}
+
+func (configs *ConfigsModel) validate() error {
+	if configs.Mode != "" && indexInStringSlice(configs.Mode, modes) == -1 {
+		return fmt.Errorf("invalid Mode (%s), available: %s", configs.Mode, strings.Join(modes, ", "))
+	}
+
+	if configs.LogLevel != "" && indexInStringSlice(configs.LogLevel, logLevels) == -1 {
+		return fmt.Errorf("invalid LogLevel (%s), available: %s", configs.LogLevel, strings.Join(logLevels, ", "))
+	}
+
+	if configs.NoColor != "" && configs.NoColor != "yes" && configs.NoColor != "no" {
+		return fmt.Errorf("invalid NoColor (%s), available: yes, no", configs.NoColor)
+	}
+	if configs.ForceColor != "" && configs.ForceColor != "yes" && configs.ForceColor != "no" {
+		return fmt.Errorf("invalid ForceColor (%s), available: yes, no", configs.ForceColor)
+	}
+	if configs.SuppressCucumberBanners != "" && configs.SuppressCucumberBanners != "yes" && configs.SuppressCucumberBanners != "no" {
+		return fmt.Errorf("invalid SuppressCucumberBanners (%s), available: yes, no", configs.SuppressCucumberBanners)
+	}
+	if configs.NoColor == "yes" && configs.ForceColor == "yes" {
+		return errors.New("both NoColor and ForceColor are set, set only one of them")
+	}
+
+	if configs.WorkDir == "" {
+		return errors.New("no WorkDir parameter specified")
+	}
+	if exist, err := pathutil.IsDirExists(configs.WorkDir); err != nil {
+		return fmt.Errorf("failed to check if WorkDir exist, error: %s", err)
+	} else if !exist {
+		return fmt.Errorf("WorkDir directory not exists at: %s%s", configs.WorkDir, caseMismatchHint(configs.WorkDir))
+	}
+
+	if configs.FeaturesDir != "" {
+		if exist, err := pathutil.IsDirExists(configs.FeaturesDir); err != nil {
+			return fmt.Errorf("failed to check if FeaturesDir exist, error: %s", err)
+		} else if !exist {
+			return fmt.Errorf("FeaturesDir directory not exists at: %s%s", configs.FeaturesDir, caseMismatchHint(configs.FeaturesDir))
+		}
+		if err := checkFeaturesDirLocation(configs.FeaturesDir, configs.WorkDir); err != nil {
+			return err
+		}
+	}
+
+	if configs.AppPath != "" {
+		if exist, err := pathutil.IsDirExists(configs.AppPath); err != nil {
+			return fmt.Errorf("failed to check if AppPath exist, error: %s", err)
+		} else if !exist {
+			return fmt.Errorf("AppPath directory not exists at: %s", configs.AppPath)
+		}
+	}
+
+	if err := checkSwappedPaths(configs.WorkDir, configs.AppPath); err != nil {
+		return err
+	}
+
+	if configs.AppResolution != "" && indexInStringSlice(configs.AppResolution, appResolutions) == -1 {
+		return fmt.Errorf("invalid AppResolution (%s), available: %s", configs.AppResolution, strings.Join(appResolutions, ", "))
+	}
+	if configs.AppResolution == appResolutionRequireAppPath && configs.AppPath == "" && configs.Mode != modeTestOnly {
+		return fmt.Errorf("no AppPath parameter specified, AppResolution is %s", appResolutionRequireAppPath)
+	}
+	if configs.AppResolution == appResolutionRepoManaged && configs.AppPath != "" {
+		return fmt.Errorf("AppPath is specified, but AppResolution is %s, which passes no APP to cucumber", appResolutionRepoManaged)
+	}
+
+	if configs.AppendToResultsDir != "" {
+		if err := checkAppendResultsDir(configs.AppendToResultsDir); err != nil {
+			return err
+		}
+	}
+
+	if configs.MetricsWebhookURL != "" {
+		if err := validateMetricsWebhookURL(configs.MetricsWebhookURL); err != nil {
+			return err
+		}
+	}
+
+	if configs.PreRunScriptPath != "" {
+		if exist, err := pathutil.IsPathExists(configs.PreRunScriptPath); err != nil {
+			return fmt.Errorf("failed to check if PreRunScriptPath exist, error: %s", err)
+		} else if !exist {
+			return fmt.Errorf("PreRunScriptPath not exists at: %s", configs.PreRunScriptPath)
+		}
+	}
+	if configs.PostRunScriptPath != "" {
+		if exist, err := pathutil.IsPathExists(configs.PostRunScriptPath); err != nil {
+			return fmt.Errorf("failed to check if PostRunScriptPath exist, error: %s", err)
+		} else if !exist {
+			return fmt.Errorf("PostRunScriptPath not exists at: %s", configs.PostRunScriptPath)
+		}
+	}
+	if configs.FailOnPostScriptError != "" && configs.FailOnPostScriptError != "yes" && configs.FailOnPostScriptError != "no" {
+		return fmt.Errorf("invalid FailOnPostScriptError (%s), available: yes, no", configs.FailOnPostScriptError)
+	}
+
+	if configs.DisableEnvExpansion != "" && configs.DisableEnvExpansion != "yes" && configs.DisableEnvExpansion != "no" {
+		return fmt.Errorf("invalid DisableEnvExpansion (%s), available: yes, no", configs.DisableEnvExpansion)
+	}
+
+	if configs.DisableEnvExpansion != "yes" {
+		options, unset := expandEnvs(configs.Options)
+		warnUnsetEnvs("AdditionalOptions", unset)
+		configs.Options = options
+	}
+	if options, normalized := normalizeOptions(configs.Options); normalized {
+		log.Warnf("AdditionalOptions contains a byte order mark or smart quotes (“ ”), replaced them with plain quotes: %s", options)
+		configs.Options = options
+	}
+	options, err := splitOptions("AdditionalOptions", configs.Options)
+	if err != nil {
+		return err
+	}
+	configs.ParsedOptions = options
+	if err := checkRequirePathsCase(options, configs.WorkDir); err != nil {
+		return err
+	}
+
+	if _, err := shellquote.Split(configs.AppLaunchArguments); err != nil {
+		return fmt.Errorf("invalid AppLaunchArguments (%s), error: %s", configs.AppLaunchArguments, err)
+	}
+
+	if _, err := parseAppLaunchEnvironment(configs.AppLaunchEnvironment); err != nil {
+		return err
+	}
+
+	if configs.RerunFile != "" {
+		if exist, err := pathutil.IsPathExists(configs.RerunFile); err != nil {
+			return fmt.Errorf("failed to check if RerunFile exist, error: %s", err)
+		} else if !exist {
+			return fmt.Errorf("RerunFile not exists at: %s", configs.RerunFile)
+		}
+	}
+
+	if configs.Platform != "" && indexInStringSlice(configs.Platform, platforms) == -1 {
+		return fmt.Errorf("invalid Platform (%s), available: %s", configs.Platform, strings.Join(platforms, ", "))
+	}
+	platform := platformOrDefault(configs.Platform)
+
+	simulatorDevices, err := parseSimulatorDevices(configs.SimulatorDevices)
+	if err != nil {
+		return err
+	}
+	if len(simulatorDevices) > 0 {
+		if configs.Mode == modePrepareOnly || configs.Mode == modeTestOnly {
+			return fmt.Errorf("SimulatorDevices is not supported in %s Mode", configs.Mode)
+		}
+		if strings.TrimSpace(configs.LanguageMatrix) != "" {
+			return errors.New("SimulatorDevices and LanguageMatrix can not be used together")
+		}
+	} else if configs.SimulatorDevice == "" {
+		return errors.New("no SimulatorDevice parameter specified")
+	} else {
+		simulatorDevices = []string{configs.SimulatorDevice}
+	}
+
+	if configs.SimulatorOsVersion != "" {
+		osVersion, err := normalizeSimulatorOsVersion(configs.SimulatorOsVersion, platform)
+		if err != nil {
+			return err
+		}
+		if osVersion != configs.SimulatorOsVersion {
+			log.Printf("SimulatorOsVersion (%s) normalized to: %s", configs.SimulatorOsVersion, osVersion)
+			configs.SimulatorOsVersion = osVersion
+		}
+	}
+
+	for _, device := range simulatorDevices {
+		if _, deviceOsVersion, ok := splitSimulatorDevice(device, platform); ok {
+			if configs.SimulatorOsVersion != "" && configs.SimulatorOsVersion != "latest" && configs.SimulatorOsVersion != deviceOsVersion {
+				return fmt.Errorf("SimulatorDevice (%s) contains an OS version (%s), which conflicts with SimulatorOsVersion (%s)", device, deviceOsVersion, configs.SimulatorOsVersion)
+			}
+		} else if configs.SimulatorOsVersion == "" {
+			return errors.New("no SimulatorOsVersion parameter specified")
+		}
+	}
+
+	if configs.DependencyResolution != "" && indexInStringSlice(configs.DependencyResolution, dependencyResolutions) == -1 {
+		return fmt.Errorf("invalid DependencyResolution (%s), available: %s", configs.DependencyResolution, strings.Join(dependencyResolutions, ", "))
+	}
+
+	if configs.BundleInstallStrategy != "" && indexInStringSlice(configs.BundleInstallStrategy, bundleInstallStrategies) == -1 {
+		return fmt.Errorf("invalid BundleInstallStrategy (%s), available: %s", configs.BundleInstallStrategy, strings.Join(bundleInstallStrategies, ", "))
+	}
+
+	if configs.TestRunName != "" && testRunFileName(configs.TestRunName) == "" {
+		return fmt.Errorf("invalid TestRunName (%s), should contain a letter or a digit", configs.TestRunName)
+	}
+
+	if configs.ArchiveTempDirOnFailure != "" && configs.ArchiveTempDirOnFailure != "yes" && configs.ArchiveTempDirOnFailure != "no" {
+		return fmt.Errorf("invalid ArchiveTempDirOnFailure (%s), available: yes, no", configs.ArchiveTempDirOnFailure)
+	}
+
+	if configs.FixLockfilePlatform != "" && configs.FixLockfilePlatform != "yes" && configs.FixLockfilePlatform != "no" {
+		return fmt.Errorf("invalid FixLockfilePlatform (%s), available: yes, no", configs.FixLockfilePlatform)
+	}
+
+	if configs.PruneOtherCalabashVersions != "" && configs.PruneOtherCalabashVersions != "yes" && configs.PruneOtherCalabashVersions != "no" {
+		return fmt.Errorf("invalid PruneOtherCalabashVersions (%s), available: yes, no", configs.PruneOtherCalabashVersions)
+	}
+
+	if configs.CucumberVersion != "" && !exactGemVersionExp.MatchString(configs.CucumberVersion) {
+		return fmt.Errorf("invalid CucumberVersion (%s), should be an exact version, like 3.1.2", configs.CucumberVersion)
+	}
+
+	if configs.PreferBootedSimulator != "" && configs.PreferBootedSimulator != "yes" && configs.PreferBootedSimulator != "no" {
+		return fmt.Errorf("invalid PreferBootedSimulator (%s), available: yes, no", configs.PreferBootedSimulator)
+	}
+
+	if configs.SimulatorSelectionStrategy != "" && indexInStringSlice(configs.SimulatorSelectionStrategy, simulatorSelectionStrategies) == -1 {
+		return fmt.Errorf("invalid SimulatorSelectionStrategy (%s), available: %s", configs.SimulatorSelectionStrategy, strings.Join(simulatorSelectionStrategies, ", "))
+	}
+	if configs.SimulatorSelectionStrategy == simulatorSelectionAlwaysCreateClean && configs.PreferBootedSimulator == "yes" {
+		return fmt.Errorf("PreferBootedSimulator can not be used with SimulatorSelectionStrategy %s", simulatorSelectionAlwaysCreateClean)
+	}
+
+	if configs.RequireExactOsVersion != "" && configs.RequireExactOsVersion != "yes" && configs.RequireExactOsVersion != "no" {
+		return fmt.Errorf("invalid RequireExactOsVersion (%s), available: yes, no", configs.RequireExactOsVersion)
+	}
+
+	if configs.DeviceTargetFormat != "" && indexInStringSlice(configs.DeviceTargetFormat, deviceTargetFormats) == -1 {
+		return fmt.Errorf("invalid DeviceTargetFormat (%s), available: %s", configs.DeviceTargetFormat, strings.Join(deviceTargetFormats, ", "))
+	}
+
+	if configs.DisablePredictiveText != "" && configs.DisablePredictiveText != "yes" && configs.DisablePredictiveText != "no" {
+		return fmt.Errorf("invalid DisablePredictiveText (%s), available: yes, no", configs.DisablePredictiveText)
+	}
+
+	if configs.DisableSlideToType != "" && configs.DisableSlideToType != "yes" && configs.DisableSlideToType != "no" {
+		return fmt.Errorf("invalid DisableSlideToType (%s), available: yes, no", configs.DisableSlideToType)
+	}
+
+	if configs.DisableCapsLock != "" && configs.DisableCapsLock != "yes" && configs.DisableCapsLock != "no" {
+		return fmt.Errorf("invalid DisableCapsLock (%s), available: yes, no", configs.DisableCapsLock)
+	}
+
+	if configs.NetworkProfile != "" && indexInStringSlice(configs.NetworkProfile, networkProfileNames()) == -1 {
+		return fmt.Errorf("invalid NetworkProfile (%s), available: %s", configs.NetworkProfile, strings.Join(networkProfileNames(), ", "))
+	}
+
+	if configs.XcodeDeveloperDirPath != "" {
+		if exist, err := pathutil.IsDirExists(configs.XcodeDeveloperDirPath); err != nil {
+			return fmt.Errorf("failed to check if XcodeDeveloperDirPath exist, error: %s", err)
+		} else if !exist {
+			return fmt.Errorf("XcodeDeveloperDirPath directory not exists at: %s", configs.XcodeDeveloperDirPath)
+		}
+		if isCommandLineToolsDeveloperDir(configs.XcodeDeveloperDirPath) {
+			return fmt.Errorf("XcodeDeveloperDirPath (%s) points to the Command Line Tools, should be the Developer dir of an Xcode, like /Applications/Xcode.app/Contents/Developer", configs.XcodeDeveloperDirPath)
+		}
+	}
+
+	if configs.SkipSimctlPreflight != "" && configs.SkipSimctlPreflight != "yes" && configs.SkipSimctlPreflight != "no" {
+		return fmt.Errorf("invalid SkipSimctlPreflight (%s), available: yes, no", configs.SkipSimctlPreflight)
+	}
+
+	if configs.SkipIfNoFeatures != "" && configs.SkipIfNoFeatures != "yes" && configs.SkipIfNoFeatures != "no" {
+		return fmt.Errorf("invalid SkipIfNoFeatures (%s), available: yes, no", configs.SkipIfNoFeatures)
+	}
+
+	if configs.FailOnDeprecations != "" && configs.FailOnDeprecations != "yes" && configs.FailOnDeprecations != "no" {
+		return fmt.Errorf("invalid FailOnDeprecations (%s), available: yes, no", configs.FailOnDeprecations)
+	}
+
+	if configs.AllowEmptyRun != "" && configs.AllowEmptyRun != "yes" && configs.AllowEmptyRun != "no" {
+		return fmt.Errorf("invalid AllowEmptyRun (%s), available: yes, no", configs.AllowEmptyRun)
+	}
+
+	if configs.FailOnUndefined != "" && configs.FailOnUndefined != "yes" && configs.FailOnUndefined != "no" {
+		return fmt.Errorf("invalid FailOnUndefined (%s), available: yes, no", configs.FailOnUndefined)
+	}
+	if configs.FailOnPending != "" && configs.FailOnPending != "yes" && configs.FailOnPending != "no" {
+		return fmt.Errorf("invalid FailOnPending (%s), available: yes, no", configs.FailOnPending)
+	}
+
+	if configs.StrictDeviceFamilyCheck != "" && configs.StrictDeviceFamilyCheck != "yes" && configs.StrictDeviceFamilyCheck != "no" {
+		return fmt.Errorf("invalid StrictDeviceFamilyCheck (%s), available: yes, no", configs.StrictDeviceFamilyCheck)
+	}
+
+	if configs.EnforceStepTarget != "" && configs.EnforceStepTarget != "yes" && configs.EnforceStepTarget != "no" {
+		return fmt.Errorf("invalid EnforceStepTarget (%s), available: yes, no", configs.EnforceStepTarget)
+	}
+
+	if configs.FailIfCucumberRunning != "" && configs.FailIfCucumberRunning != "yes" && configs.FailIfCucumberRunning != "no" {
+		return fmt.Errorf("invalid FailIfCucumberRunning (%s), available: yes, no", configs.FailIfCucumberRunning)
+	}
+
+	if configs.KillExistingCucumber != "" && configs.KillExistingCucumber != "yes" && configs.KillExistingCucumber != "no" {
+		return fmt.Errorf("invalid KillExistingCucumber (%s), available: yes, no", configs.KillExistingCucumber)
+	}
+
+	if configs.StreamAppLogs != "" && configs.StreamAppLogs != "yes" && configs.StreamAppLogs != "no" {
+		return fmt.Errorf("invalid StreamAppLogs (%s), available: yes, no", configs.StreamAppLogs)
+	}
+
+	if configs.CollectAppContainerOnFailure != "" && configs.CollectAppContainerOnFailure != "yes" && configs.CollectAppContainerOnFailure != "no" {
+		return fmt.Errorf("invalid CollectAppContainerOnFailure (%s), available: yes, no", configs.CollectAppContainerOnFailure)
+	}
+
+	if configs.DiagnoseLaunchFailures != "" && configs.DiagnoseLaunchFailures != "yes" && configs.DiagnoseLaunchFailures != "no" {
+		return fmt.Errorf("invalid DiagnoseLaunchFailures (%s), available: yes, no", configs.DiagnoseLaunchFailures)
+	}
+
+	if _, err := parseScenarioNameFilter(configs.ScenarioNameFilter); err != nil {
+		return err
+	}
+
+	if _, err := parseExcludePatterns(configs.ExcludePatterns); err != nil {
+		return err
+	}
+
+	if _, err := parseWipTags(configs.WipTags); err != nil {
+		return err
+	}
+	if configs.FailOnWipViolations != "" && configs.FailOnWipViolations != "yes" && configs.FailOnWipViolations != "no" {
+		return fmt.Errorf("invalid FailOnWipViolations (%s), available: yes, no", configs.FailOnWipViolations)
+	}
+
+	if _, err := parseReportTags(configs.ReportTags); err != nil {
+		return err
+	}
+
+	if configs.FullBacktraces != "" && configs.FullBacktraces != "yes" && configs.FullBacktraces != "no" {
+		return fmt.Errorf("invalid FullBacktraces (%s), available: yes, no", configs.FullBacktraces)
+	}
+
+	if configs.EmitLogMarkers != "" && configs.EmitLogMarkers != "yes" && configs.EmitLogMarkers != "no" {
+		return fmt.Errorf("invalid EmitLogMarkers (%s), available: yes, no", configs.EmitLogMarkers)
+	}
+
+	if configs.UseGeneratedProfile != "" && configs.UseGeneratedProfile != "yes" && configs.UseGeneratedProfile != "no" {
+		return fmt.Errorf("invalid UseGeneratedProfile (%s), available: yes, no", configs.UseGeneratedProfile)
+	}
+
+	if configs.UnlistedFeatures != "" && indexInStringSlice(configs.UnlistedFeatures, unlistedFeaturesOptions) == -1 {
+		return fmt.Errorf("invalid UnlistedFeatures (%s), available: %s", configs.UnlistedFeatures, strings.Join(unlistedFeaturesOptions, ", "))
+	}
+
+	if configs.FeatureOrderFile != "" {
+		if exist, err := pathutil.IsPathExists(configs.FeatureOrderFile); err != nil {
+			return fmt.Errorf("failed to check if FeatureOrderFile exist, error: %s", err)
+		} else if !exist {
+			return fmt.Errorf("FeatureOrderFile not exists at: %s", configs.FeatureOrderFile)
+		}
+		if _, err := parseFeatureOrderFile(configs.FeatureOrderFile, configs.WorkDir); err != nil {
+			return err
+		}
+	}
+
+	if locales, err := parseLanguageMatrix(configs.LanguageMatrix); err != nil {
+		return err
+	} else if len(locales) > 0 && configs.Mode == modePrepareOnly {
+		return fmt.Errorf("LanguageMatrix is not supported in %s Mode", modePrepareOnly)
+	}
 
-	SimulatorDevice    string
-	SimulatorOsVersion string
+	// the only check calling simctl, the static checks run first
+	for _, device := range simulatorDevices {
+		if err := checkSimulatorDeviceName(device, platform, configs.XcodeDeveloperDirPath); err != nil {
+			return err
+		}
+	}
 
-	CalabashCucumberVersion string
+	return nil
 }
 
-func createConfigsModelFromEnvs() ConfigsModel {
-	return ConfigsModel{
-		WorkDir:     os.Getenv("work_dir"),
-		GemFilePath: os.Getenv("gem_file_path"),
-		AppPath:     os.Getenv("app_path"),
-		Options:     os.Getenv("additional_options"),
+var phaseTimer = NewPhaseTimer()
 
-		SimulatorDevice:    os.Getenv("simulator_device"),
-		SimulatorOsVersion: os.Getenv("simulator_os_version"),
+var runSummary = NewRunSummary()
 
-		CalabashCucumberVersion: os.Getenv("calabash_cucumber_version"),
-	}
+func registerFail(category FailureCategory, format string, v ...interface{}) {
+	registerFailure(newStepError(category, format, v...))
 }
 
-func (configs ConfigsModel) print() {
-	log.Infof("Configs:")
-	log.Printf("- WorkDir: %s", configs.WorkDir)
-	log.Printf("- GemFilePath: %s", configs.GemFilePath)
-	log.Printf("- AppPath: %s", configs.AppPath)
-	log.Printf("- Options: %s", configs.Options)
+// exitLock is held by the goroutine running the step's exit path (the step body, or an interrupt the step body did not stop for),
+// the other one blocks until the step exits.
+var (
+	exitLock    sync.Mutex
+	exitStarted int32
+)
 
-	log.Printf("- SimulatorDevice: %s", configs.SimulatorDevice)
-	log.Printf("- SimulatorOsVersion: %s", configs.SimulatorOsVersion)
+// registerFailure is the single exit point of the failed runs:
+// it exports the failed result and exits with the failure category's exit code.
+// Once the step is interrupted, it exits with the interrupt's failure, whichever failure the interrupted phase returned.
+func registerFailure(err error) {
+	exitLock.Lock()
+	atomic.StoreInt32(&exitStarted, 1)
 
-	log.Printf("- CalabashCucumberVersion: %s", configs.CalabashCucumberVersion)
+	if interruptErr := stepInterruptError(); interruptErr != nil {
+		err = interruptErr
+	}
+
+	stepLogger.FailSection()
+
+	log.Errorf("%s", err)
+
+	terminateLeftoverTestRunners()
+
+	exitCode, testResult := failureResult(err)
+	runSummary.FailureClassification = failureCategoryOf(err).Name
+
+	exportTestResult(testResult)
+
+	exit(exitCode)
 }
 
-func (configs ConfigsModel) validate() error {
-	if configs.WorkDir == "" {
-		return errors.New("no WorkDir parameter specified")
-	}
-	if exist, err := pathutil.IsDirExists(configs.WorkDir); err != nil {
-		return fmt.Errorf("failed to check if WorkDir exist, error: %s", err)
-	} else if !exist {
-		return fmt.Errorf("WorkDir directory not exists at: %s", configs.WorkDir)
-	}
+// exportTestResult exports the test result, a skipped run counts as succeeded in the legacy output.
+func exportTestResult(result string) {
+	exportOutput(testResultOutputKey, result)
 
-	if configs.AppPath != "" {
-		if exist, err := pathutil.IsDirExists(configs.AppPath); err != nil {
-			return fmt.Errorf("failed to check if AppPath exist, error: %s", err)
-		} else if !exist {
-			return fmt.Errorf("AppPath directory not exists at: %s", configs.AppPath)
-		}
+	if result == testResultSkipped {
+		result = testResultSucceeded
 	}
+	exportOutput(legacyTestResultOutputKey, result)
+}
 
-	if configs.SimulatorDevice == "" {
-		return errors.New("no SimulatorDevice parameter specified")
+// recoverPanic turns a panic into a regular step failure: the failed result is exported,
+// the registered cleanups run and the step exits with 1.
+func recoverPanic() {
+	r := recover()
+	if r == nil {
+		return
 	}
 
-	if configs.SimulatorOsVersion == "" {
-		return errors.New("no SimulatorOsVersion parameter specified")
+	stepLogger.FailSection()
+
+	fmt.Println()
+	log.Errorf("Step crashed: %v", r)
+	log.Printf("%s", debug.Stack())
+	fmt.Println()
+	log.Errorf("The step crashed unexpectedly, please report this issue with the stack trace above at:")
+	log.Errorf("https://github.com/bitrise-steplib/steps-calabash-ios-uitest/issues")
+
+	// the exit path itself crashed, exitLock is already held
+	if atomic.LoadInt32(&exitStarted) == 1 {
+		os.Exit(categoryCrash.ExitCode)
 	}
 
-	return nil
+	registerFailure(newStepError(categoryCrash, "step crashed: %v", r))
 }
 
-func exportEnvironmentWithEnvman(keyStr, valueStr string) error {
-	cmd := command.New("envman", "add", "--key", keyStr)
-	cmd.SetStdin(strings.NewReader(valueStr))
-	return cmd.Run()
+// finish exits a successful run, unless the step is interrupted.
+func finish(exitCode int) {
+	checkStepInterrupt()
+
+	exitLock.Lock()
+	atomic.StoreInt32(&exitStarted, 1)
+
+	exit(exitCode)
 }
 
-func registerFail(format string, v ...interface{}) {
-	log.Errorf(format, v...)
+// exit runs the registered cleanups, writes the run summary, packages the diagnostics,
+// prints the phase timings, exports them and exits the step with the given exit code.
+func exit(exitCode int) {
+	atomic.StoreInt32(&exitStarted, 1)
 
-	if err := exportEnvironmentWithEnvman("BITRISE_XAMARIN_TEST_RESULT", "failed"); err != nil {
-		log.Warnf("Failed to export environment: %s, error: %s", "BITRISE_XAMARIN_TEST_RESULT", err)
+	// the cleanups depend on the run's outcome
+	runSummary.ExitCode = exitCode
+
+	if hasCleanups() {
+		startPhase(phaseCleanup)
+		runCleanups()
 	}
 
-	os.Exit(1)
-}
+	startPhase(phaseDiagnostics)
 
-func calabashCucumberFromGemfileLockContent(content string) string {
-	relevantLines := []string{}
-	lines := strings.Split(content, "\n")
+	runSummary.SetPhases(phaseTimer)
 
-	specsStart := false
-	for _, line := range lines {
-		if strings.Contains(line, "specs:") {
-			specsStart = true
-		}
+	if summaryPth, err := runSummary.WriteToDir(resultsSubdir(resultsSummaryDirName)); err != nil {
+		log.Warnf("Failed to write run summary, error: %s", err)
+	} else {
+		log.Printf("Run summary: %s", summaryPth)
+		exportOutput("BITRISE_CALABASH_SUMMARY_JSON_PATH", summaryPth)
+		diagnostics.Add(diagnosticKindRunSummary, summaryPth, false)
+	}
 
-		trimmed := strings.Trim(line, " ")
-		if trimmed == "" {
-			break
-		}
+	if markdownPth, err := runSummary.WriteMarkdownToDir(resultsDir()); err != nil {
+		log.Warnf("Failed to write results markdown, error: %s", err)
+	} else {
+		log.Printf("Results markdown: %s", markdownPth)
+		exportOutput(resultsMarkdownOutputKey, markdownPth)
+	}
 
-		if specsStart {
-			relevantLines = append(relevantLines, line)
-		}
+	writeCombinedResults()
+
+	sendMetrics(runSummary)
+
+	if commandsLogPth, err := commandRecorder.WriteToDir(resultsSubdir(resultsLogsDirName)); err != nil {
+		log.Warnf("Failed to write commands log, error: %s", err)
+	} else {
+		log.Printf("Commands log: %s", commandsLogPth)
+		exportOutput("BITRISE_CALABASH_COMMANDS_LOG_PATH", commandsLogPth)
+		diagnostics.Add(diagnosticKindCommandsLog, commandsLogPth, false)
 	}
 
-	exp := regexp.MustCompile(`calabash-cucumber \((.+)\)`)
-	for _, line := range relevantLines {
-		match := exp.FindStringSubmatch(line)
-		if match != nil && len(match) == 2 {
-			return match[1]
-		}
+	if diagnosticsPth, err := diagnostics.WriteZip(resultsDir(), diagnosticsBuild()); err != nil {
+		log.Warnf("Failed to write diagnostics bundle, error: %s", err)
+	} else {
+		log.Printf("Diagnostics bundle: %s", diagnosticsPth)
+		exportOutput("BITRISE_CALABASH_DIAGNOSTICS_ZIP_PATH", diagnosticsPth)
 	}
 
-	return ""
-}
+	phaseTimer.Stop()
+	stepLogger.EndSection()
 
-func calabashCucumberVersionFromGemfileLock(gemfileLockPth string) (string, error) {
-	content, err := fileutil.ReadStringFromFile(gemfileLockPth)
-	if err != nil {
-		return "", err
+	fmt.Println()
+	phaseTimer.PrintSummary()
+
+	if timings, err := phaseTimer.JSON(); err != nil {
+		log.Warnf("Failed to serialize phase timings, error: %s", err)
+	} else {
+		exportOutput("BITRISE_CALABASH_PHASE_TIMINGS", timings)
 	}
-	return calabashCucumberFromGemfileLockContent(content), nil
+
+	outputExporter.PrintTruncationNotice()
+	outputExporter.PrintExportFailures()
+	outputExporter.PrintFallbackNotice()
+
+	os.Exit(exitCode)
 }
 
 func copyDir(src, dst string, contentOnly bool) error {
@@ -172,311 +967,482 @@ func indexInStringSlice(value string, list []string) int {
 	return -1
 }
 
+// startPhase starts timing the phase and opens its log section.
+func startPhase(name string) {
+	phaseTimer.Start(name)
+	stepLogger.BeginSection(name)
+}
+
+// stepBodyHook is called by run() once the inputs are read, the tests use it to crash the step body.
+var stepBodyHook = func(configs ConfigsModel) {}
+
 func main() {
-	configs := createConfigsModelFromEnvs()
+	defer recoverPanic()
 
-	fmt.Println()
-	configs.print()
+	handleAbort()
+
+	run()
+}
+
+func run() {
+	startPhase(phaseValidation)
+
+	configs := createConfigsModelFromEnvs()
 
-	if err := configs.validate(); err != nil {
-		registerFail("Issue with input: %s", err)
+	// local runs might pass the inputs as flags
+	flags, err := configs.applyFlags(os.Args[1:], os.Stderr)
+	if err == flag.ErrHelp {
+		os.Exit(0)
+	} else if err != nil {
+		registerFail(categoryInvalidInput, "Issue with command line flags: %s", err)
 	}
 
-	options, err := shellquote.Split(configs.Options)
-	if err != nil {
-		registerFail("Failed to split additional options (%s), error: %s", configs.Options, err)
+	stepLogger.SetLevel(configs.LogLevel)
+	if configs.NoColor == "yes" {
+		stepLogger.SetColors(false)
+	} else if configs.ForceColor == "yes" {
+		stepLogger.SetColors(true)
 	}
+	outputExporter.SetFallbackDir(configs.WorkDir)
 
-	// Get Simulator Infos
 	fmt.Println()
-	log.Infof("Collecting simulator info...")
+	printStepHeader(configs)
 
-	var simulatorInfo simulator.InfoModel
-	if configs.SimulatorOsVersion == "latest" {
-		info, version, err := simulator.GetLatestSimulatorInfoAndVersion("iOS", configs.SimulatorDevice)
-		if err != nil {
-			registerFail("Failed to get simulator info, error: %s", err)
-		}
-		simulatorInfo = info
+	fmt.Println()
+	configs.print()
 
-		log.Printf("Latest os version: %s", version)
-	} else {
-		info, err := simulator.GetSimulatorInfo(configs.SimulatorOsVersion, configs.SimulatorDevice)
-		if err != nil {
-			registerFail("Failed to get simulator info, error: %s", err)
+	if flags.PrintConfigOnly {
+		fmt.Println()
+		if err := configs.validate(); err != nil {
+			log.Errorf("Issue with input: %s", err)
+			os.Exit(categoryInvalidInput.ExitCode)
 		}
-		simulatorInfo = info
+		log.Donef("Configuration is valid")
+		os.Exit(0)
 	}
 
-	log.Donef("Simulator (%s), id: (%s), status: %s", simulatorInfo.Name, simulatorInfo.ID, simulatorInfo.Status)
-	// ---
-
-	// Ensure if app is compatible with simulator device
-	if configs.AppPath != "" {
-		monotouch32Dir := filepath.Join(configs.AppPath, ".monotouch-32")
-		monotouch32DirExist, err := pathutil.IsDirExists(monotouch32Dir)
-		if err != nil {
-			registerFail("Failed to check if path (%s) exist, error: %s", monotouch32Dir, err)
-		}
+	if !outputExporter.envmanAvailable {
+		fmt.Println()
+		log.Warnf("envman is not available on the PATH, the step outputs will be written to: %s", outputExporter.FallbackFilePath())
+	}
 
-		monotouch64Dir := filepath.Join(configs.AppPath, ".monotouch-64")
-		monotouch64DirExist, err := pathutil.IsDirExists(monotouch64Dir)
-		if err != nil {
-			registerFail("Failed to check if path (%s) exist, error: %s", monotouch64Dir, err)
-		}
+	fmt.Println()
+	createResultsDir(configs)
+	openStepLog()
+	createRunTempDir(configs.ArchiveTempDirOnFailure == "yes")
 
-		if monotouch32DirExist && monotouch64DirExist {
-			fmt.Println()
-			log.Warnf("The .app file generated for 'i386 + x86_64' architecture")
+	fmt.Println()
+	logCalabashCache()
 
-			is64Bit, err := simulator.Is64BitArchitecture(configs.SimulatorDevice)
-			if err != nil {
-				registerFail("Failed to check simulator architecture, error: %s", err)
-			}
+	runSummary.SetInputs(configs.inputValues())
 
-			log.Warnf("Simulator is 64-bit architecture: %v", is64Bit)
+	stepBodyHook(configs)
 
-			tmpDir, err := pathutil.NormalizedOSTempDirPath("_calabash_ios_")
-			if err != nil {
-				registerFail("Failed to create tmp dir, error: %s", err)
-			}
+	ctx, err := newStepContext(configs)
+	if err != nil {
+		registerFailure(err)
+	}
 
-			appName := filepath.Base(configs.AppPath)
-			newAppPath := filepath.Join(tmpDir, appName)
+	diagnostics.SetSizeLimit(ctx.DiagnosticsSizeLimitInMB)
+	metricsWebhookURL = configs.MetricsWebhookURL
 
-			log.Warnf("Creating compatible .app file at: %s", newAppPath)
+	if ctx.StepTimeout > 0 {
+		startStepDeadline(ctx.StepTimeout)
+	}
 
-			if err := command.CopyDir(configs.AppPath, tmpDir, false); err != nil {
-				registerFail("Failed to copy .app to (%s), error: %s", newAppPath, err)
-			}
+	if err := ctx.checkFeaturesPathCase(); err != nil {
+		registerFailure(err)
+	}
 
-			newAppMonotouch32Dir := filepath.Join(newAppPath, ".monotouch-32")
-			newAppMonotouch64Dir := filepath.Join(newAppPath, ".monotouch-64")
+	if configs.SkipIfNoFeatures == "yes" {
+		if found, err := hasFeatureFiles(ctx.featuresSearchDir()); err != nil {
+			registerFail(categoryInfrastructure, "Failed to search for feature files in (%s), error: %s", ctx.featuresSearchDir(), err)
+		} else if !found {
+			fmt.Println()
+			log.Warnf("Skipped: no features found")
+			log.Printf("No .feature file found in: %s", ctx.featuresSearchDir())
 
-			if is64Bit {
-				log.Warnf("Copy files from .monotouch-64 dir...")
+			exportTestResult(testResultSkipped)
 
-				if err := command.CopyDir(newAppMonotouch64Dir, newAppPath, true); err != nil {
-					registerFail("Failed to copy .monotouch-64 files, error: %s", err)
-				}
-			} else {
-				log.Warnf("Copy files from .monotouch-32 dir...")
+			finish(0)
+		}
+	}
 
-				if err := command.CopyDir(newAppMonotouch32Dir, newAppPath, true); err != nil {
-					registerFail("Failed to copy .monotouch-32 files, error: %s", err)
-				}
+	if ctx.RerunFilePath != "" {
+		if hasScenarios, err := rerunFileHasScenarios(ctx.RerunFilePath); err != nil {
+			registerFail(categoryInvalidInput, "Failed to read rerun file (%s), error: %s", ctx.RerunFilePath, err)
+		} else if !hasScenarios {
+			if configs.AllowEmptyRun != "yes" {
+				registerFail(categoryInvalidInput, "Rerun file (%s) is empty, nothing to rerun, set allow_empty_run to yes to succeed without running", ctx.RerunFilePath)
 			}
 
-			configs.AppPath = newAppPath
+			fmt.Println()
+			log.Warnf("Skipped: nothing to rerun")
+			log.Printf("Rerun file is empty: %s", ctx.RerunFilePath)
+
+			exportTestResult(testResultSkipped)
+
+			finish(0)
 		}
 	}
-	// ---
 
-	//
-	// Determining calabash-cucumber version
-	fmt.Println()
-	log.Infof("Determining calabash-cucumber version...")
+	if ctx.XcodeDeveloperDirPath != "" {
+		// DEVELOPER_DIR selects the Xcode of every xcrun, xcodebuild and simctl call, calabash's calls included
+		log.Printf("Using the Xcode developer dir: %s", ctx.XcodeDeveloperDirPath)
 
-	workDir, err := pathutil.AbsPath(configs.WorkDir)
-	if err != nil {
-		registerFail("Failed to expand WorkDir (%s), error: %s", configs.WorkDir, err)
+		if err := os.Setenv("DEVELOPER_DIR", ctx.XcodeDeveloperDirPath); err != nil {
+			registerFail(categoryInfrastructure, "Failed to set DEVELOPER_DIR, error: %s", err)
+		}
 	}
 
-	gemFilePath := ""
-	if configs.GemFilePath != "" {
-		gemFilePath, err = pathutil.AbsPath(configs.GemFilePath)
-		if err != nil {
-			registerFail("Failed to expand GemFilePath (%s), error: %s", configs.GemFilePath, err)
+	if configs.SkipSimctlPreflight != "yes" {
+		startPhase(phaseSimulator)
+
+		if err := runSimctlPreflight(); err != nil {
+			registerFailure(err)
 		}
 	}
 
-	useBundler := false
+	if configs.NetworkProfile != "" && configs.NetworkProfile != networkProfileNone {
+		if err := checkNetworkShapingSupport(configs.NetworkProfile); err != nil {
+			registerFailure(err)
+		}
+	}
 
-	if gemFilePath != "" {
-		if exist, err := pathutil.IsPathExists(gemFilePath); err != nil {
-			registerFail("Failed to check if Gemfile exists at (%s) exist, error: %s", gemFilePath, err)
-		} else if exist {
-			log.Printf("Gemfile exists at: %s", gemFilePath)
+	if configs.FailIfCucumberRunning == "yes" || configs.KillExistingCucumber == "yes" {
+		if err := checkRunningCucumberProcesses(configs.KillExistingCucumber == "yes"); err != nil {
+			registerFailure(err)
+		}
+	}
 
-			gemfileDir := filepath.Dir(gemFilePath)
-			gemfileLockPth := filepath.Join(gemfileDir, "Gemfile.lock")
+	if configs.Mode != modePrepareOnly {
+		if err := ctx.checkAppResolution(); err != nil {
+			registerFailure(err)
+		}
+		ctx.scanStepTargetOverrides()
+		ctx.printExcludedFeatures()
+		ctx.printScenarioSelection()
+	}
 
-			if exist, err := pathutil.IsPathExists(gemfileLockPth); err != nil {
-				registerFail("Failed to check if Gemfile.lock exists at (%s), error: %s", gemfileLockPth, err)
-			} else if exist {
-				log.Printf("Gemfile.lock exists at: %s", gemfileLockPth)
+	var runErr error
+	if len(ctx.LanguageMatrix) > 0 {
+		runErr = ctx.runLanguageMatrix()
+	} else if len(ctx.SimulatorDevices) > 0 {
+		runErr = ctx.runDeviceMatrix()
+	} else {
+		runErr = runWithRetries(ctx)
+	}
 
-				version, err := calabashCucumberVersionFromGemfileLock(gemfileLockPth)
-				if err != nil {
-					registerFail("Failed to get calabash-cucumber version from Gemfile.lock, error: %s", err)
-				}
+	var wipErr error
+	if len(ctx.WipTags) > 0 && configs.Mode != modePrepareOnly {
+		wipErr = ctx.runWipScenarios(runErr)
+	}
 
-				log.Printf("calabash-cucumber version in Gemfile.lock: %s", version)
+	ctx.exportRerunFile()
+	ctx.compareWithBaseline()
+	if configs.Mode != modePrepareOnly {
+		ctx.saveFingerprint()
+	}
+	annotateFailedScenarios(runSummary.FailedScenarios)
+	printFailures(runSummary.FailedScenarios)
+	printDeprecations()
+	exportScenarioCounts(runSummary.Scenarios)
+	exportTagCounts(ctx.ReportTags, runSummary.Tags)
+
+	statusErr := scenarioStatusFailure(configs, runSummary)
+
+	// the post-run script runs after the tests, whatever their result, before the cleanups
+	var postRunScriptErr error
+	if ctx.PostRunScriptPath != "" && configs.Mode != modePrepareOnly {
+		result := testResultSucceeded
+		if runErr != nil || statusErr != nil || wipErr != nil {
+			result = testResultFailed
+		}
 
-				useBundler = true
+		if err := ctx.runPostRunScript(result); err != nil {
+			if configs.FailOnPostScriptError == "yes" {
+				postRunScriptErr = err
 			} else {
-				log.Warnf("Gemfile.lock doest no find with calabash-cucumber gem at: %s", gemfileLockPth)
+				log.Warnf("%s, fail_on_post_script_error is not set", err)
 			}
-		} else {
-			log.Warnf("Gemfile doest no find with calabash-cucumber gem at: %s", gemFilePath)
 		}
 	}
 
-	if configs.CalabashCucumberVersion != "" {
-		log.Donef("using calabash-cucumber version: %s", configs.CalabashCucumberVersion)
-	} else if useBundler {
-		log.Donef("using calabash-cucumber with bundler")
+	if runErr != nil {
+		registerFailure(runErr)
+	}
+
+	if statusErr != nil {
+		registerFailure(statusErr)
+	}
+
+	if wipErr != nil {
+		registerFailure(wipErr)
+	}
+
+	if postRunScriptErr != nil {
+		registerFailure(postRunScriptErr)
+	}
+
+	if configs.FailOnDeprecations == "yes" && len(deprecations.Warnings()) > 0 {
+		registerFail(categoryDeprecation, "Cucumber output contains %d calabash deprecation warning(s), fail_on_deprecations is set", len(deprecations.Warnings()))
+	}
+
+	if configs.Mode == modePrepareOnly {
+		fmt.Println()
+		log.Donef("Environment prepared, run the step in test_only mode to run the tests")
 	} else {
-		log.Donef("using calabash-cucumber latest version")
+		cacheCalabashDir()
+		ctx.cacheFingerprint()
+
+		exportTestResult(testResultSucceeded)
 	}
 
-	// ---
+	finish(0)
+}
 
-	//
-	// Intsalling cucumber gem
-	fmt.Println()
-	log.Infof("Installing calabash-cucumber...")
+// runWithRetries runs the attempts until one succeeds, fails with a non retryable failure or the retries are exhausted.
+// The scenarios failed by an earlier attempt and passed by a later one are added to the run summary's flaky scenarios.
+func runWithRetries(ctx *StepContext) error {
+	maxAttempts := ctx.StepRetryCount + 1
+	failedScenarios := []string{}
+	for attempt := 1; ; attempt++ {
+		runSummary.Retry.Attempts = attempt
+		ctx.Attempt = attempt
 
-	if configs.CalabashCucumberVersion != "" {
-		installed, err := rubycommand.IsGemInstalled("calabash-cucumber", configs.CalabashCucumberVersion)
-		if err != nil {
-			registerFail("Failed to check if calabash-cucumber (v%s) installed, error: %s", configs.CalabashCucumberVersion, err)
+		if maxAttempts > 1 {
+			fmt.Println()
+			log.Infof("=== Attempt %d of %d ===", attempt, maxAttempts)
 		}
 
-		if !installed {
-			installCommands, err := rubycommand.GemInstall("calabash-cucumber", configs.CalabashCucumberVersion, false)
-			if err != nil {
-				registerFail("Failed to create gem install commands, error: %s", err)
-			}
+		err := runAttempt(ctx)
+		runSummary.Retry.FlakyScenarios = appendFlakyScenarios(runSummary.Retry.FlakyScenarios, failedScenarios, ctx.ScenarioResults)
+		if err == nil {
+			return nil
+		}
+
+		if attempt >= maxAttempts || !isRetryableFailure(err) {
+			return err
+		}
 
-			for _, installCommand := range installCommands {
-				log.Printf("$ %s", installCommand.PrintableCommandArgs())
+		stepLogger.FailSection()
 
-				installCommand.SetStdout(os.Stdout).SetStderr(os.Stderr)
+		fmt.Println()
+		log.Warnf("Attempt %d of %d failed with a %s failure: %s", attempt, maxAttempts, failureCategoryOf(err).Name, err)
 
-				if err := installCommand.Run(); err != nil {
-					registerFail("command failed, error: %s", err)
-				}
+		for _, scenario := range ctx.ScenarioResults {
+			if scenario.Status == statusFailed && indexInStringSlice(scenario.Location(), failedScenarios) == -1 {
+				failedScenarios = append(failedScenarios, scenario.Location())
 			}
-		} else {
-			log.Printf("calabash-cucumber %s installed", configs.CalabashCucumberVersion)
-		}
-	} else if useBundler {
-		bundleInstallCmd, err := rubycommand.New("bundle", "install", "--jobs", "20", "--retry", "5")
-		if err != nil {
-			registerFail("Failed to create command, error: %s", err)
 		}
 
-		bundleInstallCmd.AppendEnvs("BUNDLE_GEMFILE=" + gemFilePath)
-		bundleInstallCmd.SetStdout(os.Stdout).SetStderr(os.Stderr)
+		startPhase(phaseRetryTeardown)
+		ctx.tearDownAttempt()
+	}
+}
 
-		log.Printf("$ %s", bundleInstallCmd.PrintableCommandArgs())
+// appendFlakyScenarios appends the locations of the passed scenarios, which an earlier attempt failed, to the flaky scenarios.
+func appendFlakyScenarios(flaky, failedEarlier []string, results []ScenarioResultModel) []string {
+	for _, scenario := range results {
+		location := scenario.Location()
+		if scenario.Status == statusPassed && indexInStringSlice(location, failedEarlier) != -1 && indexInStringSlice(location, flaky) == -1 {
+			flaky = append(flaky, location)
+		}
+	}
+	return flaky
+}
 
-		if err := bundleInstallCmd.Run(); err != nil {
-			registerFail("bundle install failed, error: %s", err)
+// runAttempt runs the step body from the simulator resolution onward,
+// a failed attempt with an infrastructure failure can be retried after tearing it down.
+func runAttempt(ctx *StepContext) error {
+	configs := ctx.Configs
+
+	// test_only mode reuses the simulator and the app prepared by a previous prepare_only run
+	preparedSimulatorUDID, preparedAppPath := "", ""
+	if configs.Mode == modeTestOnly {
+		preparedSimulatorUDID = os.Getenv(simulatorUDIDOutputKey)
+		// the project selects the app, APP is not set
+		if configs.AppResolution != appResolutionRepoManaged {
+			preparedAppPath = os.Getenv(appPathOutputKey)
 		}
-	} else {
-		installCommands, err := rubycommand.GemInstall("calabash-cucumber", "", false)
-		if err != nil {
-			registerFail("Failed to create gem install commands, error: %s", err)
+	}
+
+	// Get Simulator Infos
+	startPhase(phaseSimulator)
+
+	if preparedSimulatorUDID != "" {
+		log.Printf("Using the simulator prepared by a previous run (%s): %s", simulatorUDIDOutputKey, preparedSimulatorUDID)
+
+		if err := ctx.resolveSimulatorByUDID(preparedSimulatorUDID); err != nil {
+			return err
 		}
+	} else if err := ctx.resolveSimulator(); err != nil {
+		return err
+	}
+	// ---
 
-		for _, installCommand := range installCommands {
-			log.Printf("$ %s", installCommand.PrintableCommandArgs())
+	// Ensure if app is compatible with simulator device
+	startPhase(phaseAppPreflight)
 
-			installCommand.SetStdout(os.Stdout).SetStderr(os.Stderr)
+	if preparedAppPath != "" {
+		log.Printf("Using the app prepared by a previous run (%s): %s", appPathOutputKey, preparedAppPath)
+		ctx.AppPath = preparedAppPath
+	} else if err := ctx.prepareApp(); err != nil {
+		return err
+	}
 
-			if err := installCommand.Run(); err != nil {
-				registerFail("command failed, error: %s", err)
-			}
+	if ctx.AppPath != "" {
+		if err := checkAppBundle(ctx.AppPath, ctx.AppMinSizeKB); err != nil {
+			return err
 		}
 	}
 
+	if err := ctx.checkAppPlatform(); err != nil {
+		return err
+	}
+	if err := ctx.checkAppArchitecture(); err != nil {
+		return err
+	}
+	if err := ctx.checkDeviceFamily(configs.StrictDeviceFamilyCheck == "yes"); err != nil {
+		return err
+	}
+	// ---
+
 	//
-	// Run cucumber
-	fmt.Println()
-	log.Infof("Running cucumber test...")
+	// Determining calabash-cucumber version
+	startPhase(phaseDependencyInstall)
 
-	cucumberEnvs := []string{"DEVICE_TARGET=" + simulatorInfo.ID}
-	if configs.AppPath != "" {
-		cucumberEnvs = append(cucumberEnvs, "APP="+configs.AppPath)
+	if err := ctx.determineCalabashVersion(); err != nil {
+		return err
 	}
 
-	cucumberArgs := []string{"cucumber"}
-	if configs.CalabashCucumberVersion != "" {
-		cucumberArgs = append(cucumberArgs, fmt.Sprintf("_%s_", configs.CalabashCucumberVersion))
-	} else if useBundler {
-		cucumberArgs = append([]string{"bundle", "exec"}, cucumberArgs...)
-		cucumberEnvs = append(cucumberEnvs, "BUNDLE_GEMFILE="+gemFilePath)
+	if ctx.CalabashInstalled {
+		log.Printf("calabash-cucumber is installed by the matrix's previous run")
+	} else if configs.Mode == modeTestOnly {
+		if err := ctx.verifyCalabashInstalled(); err != nil {
+			return err
+		}
+	} else if err := ctx.installCalabash(); err != nil {
+		return err
+	}
+	if !ctx.CalabashInstalled {
+		if err := ctx.verifyCucumberResolves(); err != nil {
+			return err
+		}
 	}
+	// the next runs of the language and the device matrix reuse the installed gems
+	ctx.CalabashInstalled = len(ctx.SimulatorDevices) > 0 || len(ctx.LanguageMatrix) > 0
 
-	cucumberArgs = append(cucumberArgs, options...)
+	validateCalabashCache()
+	// ---
 
-	cucumberCmd, err := rubycommand.NewFromSlice(cucumberArgs)
-	if err != nil {
-		registerFail("Failed to create command, error: %s", err)
-	}
+	ctx.saveEffectiveConfig()
 
-	cucumberCmd.AppendEnvs(cucumberEnvs...)
-	cucumberCmd.SetDir(workDir)
-	cucumberCmd.SetStdout(os.Stdout).SetStderr(os.Stderr)
+	if configs.Mode == modePrepareOnly {
+		startPhase(phaseSimulator)
 
-	log.Printf("$ %s", cucumberCmd.PrintableCommandArgs())
-	fmt.Println()
+		if ctx.SimulatorReused {
+			log.Printf("Skipping simulator boot, the reused simulator is already booted")
+		} else if err := ctx.bootSimulator(); err != nil {
+			return err
+		}
+		if err := ctx.installApp(); err != nil {
+			return err
+		}
+		if err := ctx.installAdditionalApps(); err != nil {
+			return err
+		}
 
-	if err := cucumberCmd.Run(); err != nil {
-		fmt.Println()
-		log.Errorf("Failed to run command, error: %s", err)
-		if err := exportEnvironmentWithEnvman("BITRISE_XAMARIN_TEST_RESULT", "failed"); err != nil {
-			log.Warnf("Failed to export environment: %s, error: %s", "BITRISE_XAMARIN_TEST_RESULT", err)
+		exportOutput(appPathOutputKey, ctx.AppPath)
+
+		return nil
+	}
+
+	//
+	// Run cucumber
+	if ctx.Locale != "" || len(configs.keyboardPreferences()) > 0 {
+		startPhase(phaseSimulator)
+
+		if err := ctx.prepareSimulatorPreferences(); err != nil {
+			return err
 		}
+	}
 
-		// find --out flag and get the next index containing output file's pth
-		outputFilePth := ""
-		if index := indexInStringSlice("--out", options); index != -1 {
-			outputFilePth = options[index+1]
+	if len(ctx.AdditionalAppPaths) > 0 {
+		startPhase(phaseSimulator)
+
+		if err := ctx.installAdditionalApps(); err != nil {
+			return err
 		}
-		if outputFilePth == "" {
-			os.Exit(1)
+	}
+
+	ctx.collectSimulatorPreferences(preferencesStageAfterPreparation)
+
+	ctx.printFingerprintChanges()
+
+	startPhase(phaseCucumber)
+
+	if err := ctx.verifySimulator(); err != nil {
+		return err
+	}
+
+	ctx.terminateStaleApp()
+
+	if ctx.PreRunScriptPath != "" {
+		if err := ctx.runPreRunScript(); err != nil {
+			return err
 		}
+	}
 
-		// if --out is BITRISE_DEPLOY_DIR, print Deploy to bitrise.io step usage
-		if filepath.Dir(outputFilePth) == os.Getenv("BITRISE_DEPLOY_DIR") {
-			log.Printf("Use Deploy to bitrise.io step to attach report file (%s) to your build artifacts.", outputFilePth)
-		} else {
-			log.Printf("The generated report file is available at: %s", outputFilePth)
+	if profile, ok := networkProfileByName(configs.NetworkProfile); ok {
+		if err := activateNetworkProfile(profile); err != nil {
+			return err
 		}
-		fmt.Println()
+	}
 
-		// read output file
-		outputFileContent, err := fileutil.ReadStringFromFile(outputFilePth)
-		if err != nil {
-			registerFail("Failed to read output file (%s), error: %s", outputFilePth, err)
-		}
-
-		// check if output format is html
-		if index := indexInStringSlice("--format", options); index != -1 && (options[index+1] == "html") {
-			// regex messages from output html and avoid duplicating messages
-			outputs := []string{}
-			exp := regexp.MustCompile(`<div class="message"><pre>(?s)(.*?)</pre></div>`)
-			for _, match := range exp.FindAllStringSubmatch(outputFileContent, -1) {
-				if len(match) > 1 {
-					if index := indexInStringSlice(match[1], outputs); index == -1 {
-						log.Printf(match[1])
-						outputs = append(outputs, match[1])
-					}
-				}
+	if configs.StreamAppLogs == "yes" {
+		ctx.startAppLogStream()
+	}
+
+	testRunnersSimulatorUDID = ctx.Simulator.ID
+	cucumberErr := ctx.runCucumber()
+
+	if err := stopAppLogStream(); err != nil {
+		log.Warnf("%s", err)
+	}
+	if err := deactivateNetworkProfile(); err != nil {
+		log.Warnf("%s", err)
+	}
+
+	startPhase(phaseReportExport)
+
+	ctx.collectReport()
+	ctx.collectReportFiles()
+	ctx.collectScreenshots(cucumberErr)
+	ctx.collectSimulatorPreferences(preferencesStageAfterRun)
+	if cucumberErr != nil && configs.CollectAppContainerOnFailure == "yes" {
+		ctx.collectAppContainer()
+	}
+	ctx.exportTestReport()
+
+	if cucumberErr != nil {
+		if failureCategoryOf(cucumberErr) == categoryTestFailure {
+			fmt.Println()
+			if err := printCucumberOutputFile(ctx.Options); err != nil {
+				log.Warnf("%s", err)
 			}
-			os.Exit(1)
 		}
 
-		// output isn't html, print file content
-		log.Printf(outputFileContent)
-		os.Exit(1)
+		if ctx.CalabashServerErrorDetected {
+			printCalabashServerDiagnosis(ctx.AppPath)
+		}
+		if configs.DiagnoseLaunchFailures != "no" && ctx.LaunchFailureCount >= launchFailureRepeatThreshold {
+			ctx.diagnoseLaunchFailure()
+		}
+
+		return cucumberErr
 	}
 	// ---
 
-	if err := exportEnvironmentWithEnvman("BITRISE_XAMARIN_TEST_RESULT", "succeeded"); err != nil {
-		log.Warnf("Failed to export environment: %s, error: %s", "BITRISE_XAMARIN_TEST_RESULT", err)
-	}
+	return nil
 }
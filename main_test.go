@@ -0,0 +1,251 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/bitrise-io/go-utils/command"
+	shellquote "github.com/kballard/go-shellquote"
+)
+
+// stepTestHelperEnvKey selects the exit path the test binary runs as a child process, the exit paths call os.Exit.
+const stepTestHelperEnvKey = "STEP_TEST_HELPER"
+
+// stepTestPhaseEnvKey selects the failing phase of the phase_failure helper.
+const stepTestPhaseEnvKey = "STEP_TEST_PHASE"
+
+func TestMain(m *testing.M) {
+	switch os.Getenv(stepTestHelperEnvKey) {
+	case "panic":
+		os.Args = []string{"step"}
+		stepBodyHook = func(ConfigsModel) {
+			panic("synthetic panic")
+		}
+		main()
+	case "phase_failure":
+		outputExporter.SetFallbackDir(os.Getenv("work_dir"))
+		if err := runFailingPhase(os.Getenv(stepTestPhaseEnvKey)); err != nil {
+			registerFailure(err)
+		}
+		os.Exit(0)
+	case "deadline":
+		// the step body is stuck outside a command, the deadline exits the step after the grace period
+		outputExporter.SetFallbackDir(os.Getenv("work_dir"))
+		interruptGracePeriod = 200 * time.Millisecond
+		phaseTimer.Start("run tests")
+		startStepDeadline(100 * time.Millisecond)
+		time.Sleep(10 * time.Second)
+		os.Exit(0)
+	case "deadline_command":
+		outputExporter.SetFallbackDir(os.Getenv("work_dir"))
+		interruptGracePeriod = 10 * time.Second
+		phaseTimer.Start("run tests")
+		startStepDeadline(100 * time.Millisecond)
+		runInterruptedCommand()
+	case "abort":
+		outputExporter.SetFallbackDir(os.Getenv("work_dir"))
+		interruptGracePeriod = 10 * time.Second
+		handleAbort()
+		go func() {
+			time.Sleep(100 * time.Millisecond)
+			if err := syscall.Kill(os.Getpid(), syscall.SIGTERM); err != nil {
+				panic(err)
+			}
+		}()
+		runInterruptedCommand()
+	}
+
+	os.Exit(m.Run())
+}
+
+// runInterruptedCommand runs a command the interrupt kills, the step body fails the run with the command's failure,
+// unless it exits with the interrupt's failure at the command.
+func runInterruptedCommand() {
+	if err := commandRecorder.Run(command.New("sleep", "10")); err != nil {
+		registerFailure(newStepError(categoryTestFailure, "Command failed, error: %s", err))
+	}
+	registerFailure(newStepError(categoryTestFailure, "Command was not interrupted"))
+}
+
+// stepHelperResult is the outcome of an exit path run in a child process.
+type stepHelperResult struct {
+	exitCode int
+	outputs  map[string]string
+	summary  RunSummaryModel
+	log      string
+}
+
+// runStepHelper runs the test binary with the given helper and the additional envs, envman is not on the PATH,
+// so the outputs are written into the work dir's .env file.
+func runStepHelper(t *testing.T, helper string, envs ...string) stepHelperResult {
+	t.Helper()
+
+	root := t.TempDir()
+	workDir := filepath.Join(root, "workspace")
+	deployDir := filepath.Join(root, "deploy")
+	for _, dir := range []string{workDir, deployDir, filepath.Join(root, "home")} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cmd := exec.Command(os.Args[0], "-test.run=^$")
+	cmd.Env = []string{
+		stepTestHelperEnvKey + "=" + helper,
+		"PATH=/usr/bin:/bin",
+		"HOME=" + filepath.Join(root, "home"),
+		"TMPDIR=" + root,
+		"BITRISE_DEPLOY_DIR=" + deployDir,
+		"work_dir=" + workDir,
+		"simulator_device=iPhone 8",
+	}
+	cmd.Env = append(cmd.Env, envs...)
+	out, err := cmd.CombinedOutput()
+
+	result := stepHelperResult{outputs: map[string]string{}, log: string(out)}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		result.exitCode = exitErr.ExitCode()
+	} else if err != nil {
+		t.Fatalf("failed to run the helper (%s): %s", helper, err)
+	}
+
+	if content, err := os.ReadFile(filepath.Join(workDir, fallbackEnvFileName)); err == nil {
+		// a quoted multi-line value spans the following lines
+		pending := ""
+		for _, line := range strings.Split(strings.TrimSpace(string(content)), "\n") {
+			if pending != "" {
+				line = pending + "\n" + line
+			}
+			split := strings.SplitN(line, "=", 2)
+			if len(split) != 2 {
+				continue
+			}
+			values, err := shellquote.Split(split[1])
+			if err == shellquote.UnterminatedSingleQuoteError || err == shellquote.UnterminatedDoubleQuoteError {
+				pending = line
+				continue
+			}
+			if err != nil || len(values) > 1 {
+				t.Fatalf("invalid output line: %s", line)
+			}
+			pending = ""
+			result.outputs[split[0]] = strings.Join(values, "")
+		}
+		if pending != "" {
+			t.Fatalf("invalid output line: %s", pending)
+		}
+	}
+
+	// the summary is written into the results dir, or into the deploy dir if the results dir is not created yet
+	summaries, _ := filepath.Glob(filepath.Join(deployDir, "*", resultsSummaryDirName, runSummaryFileName))
+	if len(summaries) == 0 {
+		summaries, _ = filepath.Glob(filepath.Join(deployDir, resultsSummaryDirName, runSummaryFileName))
+	}
+	if len(summaries) == 1 {
+		content, err := os.ReadFile(summaries[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := json.Unmarshal(content, &result.summary); err != nil {
+			t.Fatalf("invalid run summary: %s", err)
+		}
+	}
+
+	return result
+}
+
+func TestRunPanicExportsFailedResult(t *testing.T) {
+	result := runStepHelper(t, "panic")
+
+	if result.exitCode != categoryCrash.ExitCode {
+		t.Fatalf("exit code: %d, expected: %d, log:\n%s", result.exitCode, categoryCrash.ExitCode, result.log)
+	}
+	if !strings.Contains(result.log, "Step crashed: synthetic panic") {
+		t.Errorf("crash is not logged, log:\n%s", result.log)
+	}
+
+	expectedOutputs := map[string]string{
+		testResultOutputKey:       testResultFailed,
+		legacyTestResultOutputKey: testResultFailed,
+	}
+	for key, expected := range expectedOutputs {
+		if actual := result.outputs[key]; actual != expected {
+			t.Errorf("output %s: %q, expected: %q", key, actual, expected)
+		}
+	}
+	if result.outputs["BITRISE_CALABASH_SUMMARY_JSON_PATH"] == "" {
+		t.Errorf("run summary path is not exported, outputs: %v", result.outputs)
+	}
+
+	if result.summary.FailureClassification != categoryCrash.Name {
+		t.Errorf("failure classification: %q, expected: %q", result.summary.FailureClassification, categoryCrash.Name)
+	}
+	if result.summary.ExitCode != categoryCrash.ExitCode {
+		t.Errorf("summary exit code: %d, expected: %d", result.summary.ExitCode, categoryCrash.ExitCode)
+	}
+}
+
+func TestAppendFlakyScenarios(t *testing.T) {
+	scenario := func(line int, status string) ScenarioResultModel {
+		return ScenarioResultModel{URI: "features/login.feature", Line: line, Status: status}
+	}
+
+	tests := []struct {
+		name          string
+		flaky         []string
+		failedEarlier []string
+		results       []ScenarioResultModel
+		want          []string
+	}{
+		{
+			name:    "first attempt",
+			flaky:   []string{},
+			results: []ScenarioResultModel{scenario(3, statusPassed), scenario(8, statusFailed)},
+			want:    []string{},
+		},
+		{
+			name:          "failed then passed",
+			flaky:         []string{},
+			failedEarlier: []string{"features/login.feature:8"},
+			results:       []ScenarioResultModel{scenario(3, statusPassed), scenario(8, statusPassed)},
+			want:          []string{"features/login.feature:8"},
+		},
+		{
+			name:          "failed again",
+			flaky:         []string{},
+			failedEarlier: []string{"features/login.feature:8"},
+			results:       []ScenarioResultModel{scenario(3, statusPassed), scenario(8, statusFailed)},
+			want:          []string{},
+		},
+		{
+			name:          "not run by the later attempt",
+			flaky:         []string{},
+			failedEarlier: []string{"features/login.feature:8"},
+			results:       []ScenarioResultModel{},
+			want:          []string{},
+		},
+		{
+			name:          "already flaky",
+			flaky:         []string{"features/login.feature:8"},
+			failedEarlier: []string{"features/login.feature:8"},
+			results:       []ScenarioResultModel{scenario(8, statusPassed)},
+			want:          []string{"features/login.feature:8"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := appendFlakyScenarios(tt.flaky, tt.failedEarlier, tt.results)
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") || got == nil {
+				t.Errorf("appendFlakyScenarios() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/bitrise-io/go-utils/fileutil"
+)
+
+const (
+	resultsMarkdownOutputKey = "BITRISE_CALABASH_RESULTS_MARKDOWN_PATH"
+	resultsMarkdownFileName  = "calabash_results.md"
+)
+
+// markdownTableCellReplacer escapes the characters, which would break the table or format the cell's text.
+var markdownTableCellReplacer = strings.NewReplacer(
+	`\`, `\\`,
+	"|", `\|`,
+	"*", `\*`,
+	"_", `\_`,
+	"`", "\\`",
+	"[", `\[`,
+	"]", `\]`,
+	"<", "&lt;",
+	">", "&gt;",
+	"\r\n", " ",
+	"\n", " ",
+)
+
+func escapeMarkdownTableCell(s string) string {
+	return markdownTableCellReplacer.Replace(s)
+}
+
+// Verdict returns the one line overall result of the run.
+func (summary *RunSummaryModel) Verdict() string {
+	result := "passed"
+	if summary.ExitCode != 0 {
+		result = "failed"
+		if summary.FailureClassification != "" {
+			result += fmt.Sprintf(" (%s)", summary.FailureClassification)
+		}
+	}
+
+	duration := roundDuration(time.Duration(summary.TotalDurationMs) * time.Millisecond)
+
+	counts := summary.Scenarios
+	if counts.Total == 0 {
+		return fmt.Sprintf("**Calabash UI tests %s**: no scenarios ran, in %s", result, duration)
+	}
+	return fmt.Sprintf("**Calabash UI tests %s**: %d scenarios, %d passed, %d failed, %d pending, in %s",
+		result, counts.Total, counts.Passed, counts.Failed, counts.Pending, duration)
+}
+
+// Markdown returns the overall verdict followed by the per-feature results as a Markdown table.
+func (summary *RunSummaryModel) Markdown() string {
+	lines := []string{}
+	if summary.TestRunName != "" {
+		lines = append(lines, "### "+escapeMarkdownTableCell(summary.TestRunName), "")
+	}
+	lines = append(lines, summary.Verdict())
+
+	if len(summary.Features) > 0 {
+		lines = append(lines,
+			"",
+			"| Feature | Scenarios | Passed | Failed | Pending | Duration |",
+			"| --- | ---: | ---: | ---: | ---: | ---: |",
+		)
+		for _, feature := range summary.Features {
+			name := feature.Name
+			if name == "" {
+				name = feature.URI
+			}
+
+			lines = append(lines, fmt.Sprintf("| %s | %d | %d | %d | %d | %s |",
+				escapeMarkdownTableCell(name), feature.Scenarios.Total, feature.Scenarios.Passed, feature.Scenarios.Failed,
+				feature.Scenarios.Pending, roundDuration(time.Duration(feature.DurationMs)*time.Millisecond)))
+		}
+	}
+
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// WriteMarkdownToDir writes the Markdown results into the given dir and returns the file's path.
+func (summary *RunSummaryModel) WriteMarkdownToDir(dir string) (string, error) {
+	pth := filepath.Join(dir, resultsMarkdownFileName)
+	if err := fileutil.WriteStringToFile(pth, summary.Markdown()); err != nil {
+		return "", err
+	}
+	return pth, nil
+}
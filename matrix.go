@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bitrise-io/go-utils/log"
+)
+
+// MatrixRunModel is the result of the suite's run on one entry of the language or the device matrix.
+type MatrixRunModel struct {
+	Entry     string
+	Simulator SimulatorSummaryModel
+	Scenarios ScenarioCountsModel
+	Err       error
+}
+
+// Result ...
+func (run MatrixRunModel) Result() string {
+	if run.Err != nil {
+		return testResultFailed
+	}
+	return testResultSucceeded
+}
+
+// runMatrix runs the suite once per entry of the language or the device matrix, with retries applied to each run.
+// Every run starts from the reset attempt state, apply sets the entry's values on it. The gems are installed
+// by the first run only, the next runs reuse them. Every entry runs to completion: the scenarios of the runs
+// are added to the run summary and each entry's passed and failed counts are exported with outputKey's keys.
+// The matrix run timeout applies to each run with its retries, a timed out run fails and the next entry runs.
+func (ctx *StepContext) runMatrix(title string, entries []string, outputKey func(entry, suffix string) string, apply func(entry string)) []MatrixRunModel {
+	runs := []MatrixRunModel{}
+	allScenarios := []ScenarioResultModel{}
+
+	for i, entry := range entries {
+		fmt.Println()
+		log.Infof("=== %s %d of %d: %s ===", title, i+1, len(entries), entry)
+
+		calabashInstalled := ctx.CalabashInstalled
+		ctx.resetAttemptState()
+		ctx.CalabashInstalled = calabashInstalled
+		runSummary.Simulator = SimulatorSummaryModel{}
+		apply(entry)
+
+		stopRunTimeout := startMatrixRunTimeout(title, entry, ctx.MatrixRunTimeout)
+		err := runWithRetries(ctx)
+		if timeoutErr := stopRunTimeout(); timeoutErr != nil {
+			err = timeoutErr
+		}
+		if err != nil {
+			stepLogger.FailSection()
+
+			fmt.Println()
+			log.Errorf("Run of %s (%s) failed: %s", strings.ToLower(title), entry, err)
+
+			terminateLeftoverTestRunners()
+		}
+
+		allScenarios = append(allScenarios, ctx.ScenarioResults...)
+		runSummary.SetScenarios(allScenarios)
+
+		run := MatrixRunModel{
+			Entry:     entry,
+			Simulator: runSummary.Simulator,
+			Scenarios: countScenarios(ctx.ScenarioResults),
+			Err:       err,
+		}
+		runs = append(runs, run)
+
+		exportOutput(outputKey(entry, "PASSED_COUNT"), fmt.Sprintf("%d", run.Scenarios.Passed))
+		exportOutput(outputKey(entry, "FAILED_COUNT"), fmt.Sprintf("%d", run.Scenarios.Failed))
+	}
+
+	return runs
+}
+
+// startMatrixRunTimeout interrupts the matrix run once it has been running for the timeout, 0 means no timeout:
+// the running command is killed and the run's later commands fail with the timeout failure.
+// The returned stop func stops the timeout, and returns the timeout failure if the run timed out.
+func startMatrixRunTimeout(title, entry string, timeout time.Duration) func() error {
+	if timeout <= 0 {
+		return func() error { return nil }
+	}
+
+	timeoutErr := newNonRetryableStepError(categoryTimeout, "Run of %s (%s) timed out after %s", strings.ToLower(title), entry, timeout)
+
+	// the lock keeps a timeout firing while the run stops from interrupting the next run
+	var lock sync.Mutex
+	stopped, timedOut := false, false
+	timer := time.AfterFunc(timeout, func() {
+		lock.Lock()
+		defer lock.Unlock()
+
+		if stopped {
+			return
+		}
+		timedOut = true
+		if err := commandRecorder.InterruptRun(timeoutErr); err != nil {
+			log.Warnf("Failed to kill the running command, error: %s", err)
+		}
+	})
+
+	return func() error {
+		lock.Lock()
+		defer lock.Unlock()
+
+		timer.Stop()
+		stopped = true
+		commandRecorder.ResumeRun()
+		if timedOut {
+			return timeoutErr
+		}
+		return nil
+	}
+}
+
+// failedMatrixEntries returns the failed entries and the first failed run's failure, nil if every run passed.
+func failedMatrixEntries(runs []MatrixRunModel) ([]string, error) {
+	failed := []string{}
+	var firstErr error
+	for _, run := range runs {
+		if run.Err != nil {
+			failed = append(failed, run.Entry)
+			if firstErr == nil {
+				firstErr = run.Err
+			}
+		}
+	}
+	return failed, firstErr
+}
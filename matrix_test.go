@@ -0,0 +1,59 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bitrise-io/go-utils/command"
+)
+
+func TestStartMatrixRunTimeout(t *testing.T) {
+	stop := startMatrixRunTimeout("Language", "de_DE", 100*time.Millisecond)
+
+	start := time.Now()
+	err := commandRecorder.Run(command.New("sleep", "10"))
+	if time.Since(start) > 5*time.Second {
+		t.Fatalf("the running command was not killed")
+	}
+	if category := failureCategoryOf(err); category != categoryTimeout {
+		t.Fatalf("killed command's error category: %v, expected: %v, error: %v", category, categoryTimeout, err)
+	}
+	if isRetryableFailure(err) {
+		t.Error("timed out run is retryable")
+	}
+
+	if err := commandRecorder.Run(command.New("true")); failureCategoryOf(err) != categoryTimeout {
+		t.Errorf("command of the timed out run did not fail with the timeout, error: %v", err)
+	}
+
+	err = stop()
+	if category := failureCategoryOf(err); category != categoryTimeout {
+		t.Fatalf("run's error category: %v, expected: %v, error: %v", category, categoryTimeout, err)
+	}
+	if want := "Run of language (de_DE) timed out after 100ms"; err.Error() != want {
+		t.Errorf("run's error: %s, expected: %s", err, want)
+	}
+
+	// the next run's commands run
+	if err := commandRecorder.Run(command.New("true")); err != nil {
+		t.Errorf("command of the next run failed: %v", err)
+	}
+	if commandRecorder.Killed() {
+		t.Error("the next run starts as killed")
+	}
+}
+
+func TestStartMatrixRunTimeoutNotReached(t *testing.T) {
+	stop := startMatrixRunTimeout("Device", "iPhone SE", time.Minute)
+
+	if err := commandRecorder.Run(command.New("true")); err != nil {
+		t.Fatal(err)
+	}
+	if err := stop(); err != nil {
+		t.Errorf("run's error: %v, expected no error", err)
+	}
+
+	if err := startMatrixRunTimeout("Device", "iPhone SE", 0)(); err != nil {
+		t.Errorf("run's error without timeout: %v, expected no error", err)
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/bitrise-io/go-utils/log"
+)
+
+const (
+	metricsPayloadFormatVersion = "1.0.0"
+
+	// metricsWebhookTimeoutSeconds caps the webhook delivery, the telemetry must not slow down the build
+	metricsWebhookTimeoutSeconds = 5
+)
+
+// metricsWebhookTimeout is the webhook delivery's timeout, the tests shorten it.
+var metricsWebhookTimeout = metricsWebhookTimeoutSeconds * time.Second
+
+// metricsWebhookURL is the metrics_webhook_url input, the run's metrics are posted to it at the end of the run.
+var metricsWebhookURL string
+
+// MetricsPayloadModel is the schema of the metrics posted to metrics_webhook_url, it holds no build identifying data,
+// bump metricsPayloadFormatVersion on every incompatible change.
+type MetricsPayloadModel struct {
+	FormatVersion         string              `json:"format_version"`
+	StepVersion           string              `json:"step_version"`
+	BuildSlugHash         string              `json:"build_slug_hash,omitempty"`
+	Result                string              `json:"result"`
+	FailureClassification string              `json:"failure_classification,omitempty"`
+	ExitCode              int                 `json:"exit_code"`
+	SimulatorRuntime      string              `json:"simulator_runtime,omitempty"`
+	TotalDurationMs       int64               `json:"total_duration_ms"`
+	Phases                []PhaseSummaryModel `json:"phases"`
+	Scenarios             ScenarioCountsModel `json:"scenarios"`
+}
+
+// buildSlugHash returns the hash of the build slug, which tells the builds apart without identifying them.
+func buildSlugHash(slug string) string {
+	if slug == "" {
+		return ""
+	}
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(slug)))[:16]
+}
+
+// newMetricsPayload returns the metrics of the run summary.
+func newMetricsPayload(summary *RunSummaryModel, buildSlug string) MetricsPayloadModel {
+	result := testResultSucceeded
+	if summary.ExitCode != 0 {
+		result = testResultFailed
+	}
+
+	runtime := summary.Simulator.Runtime
+	if summary.Simulator.RuntimeVersion != "" {
+		runtime = summary.Simulator.RuntimeVersion
+	}
+
+	return MetricsPayloadModel{
+		FormatVersion:         metricsPayloadFormatVersion,
+		StepVersion:           summary.StepVersion,
+		BuildSlugHash:         buildSlugHash(buildSlug),
+		Result:                result,
+		FailureClassification: summary.FailureClassification,
+		ExitCode:              summary.ExitCode,
+		SimulatorRuntime:      runtime,
+		TotalDurationMs:       summary.TotalDurationMs,
+		Phases:                summary.Phases,
+		Scenarios:             summary.Scenarios,
+	}
+}
+
+// validateMetricsWebhookURL checks the metrics_webhook_url input, the value is not printed as the URL might hold a token.
+func validateMetricsWebhookURL(value string) error {
+	u, err := url.Parse(value)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid MetricsWebhookURL, should be an http or https URL")
+	}
+	return nil
+}
+
+// postMetrics posts the payload to the webhook URL. The returned error never holds the URL, as it might hold a token.
+func postMetrics(webhookURL string, payload []byte) error {
+	client := http.Client{Timeout: metricsWebhookTimeout}
+	resp, err := client.Post(webhookURL, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			return urlErr.Err
+		}
+		return err
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			log.Warnf("Failed to close the metrics webhook's response body, error: %s", err)
+		}
+	}()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected response status: %s", resp.Status)
+	}
+	return nil
+}
+
+// sendMetrics posts the run's metrics to the metrics webhook, if set. The delivery is logged in a single line,
+// its failure never fails the step. The post is not a command, so the URL never shows up in the commands log.
+func sendMetrics(summary *RunSummaryModel) {
+	if metricsWebhookURL == "" {
+		return
+	}
+
+	payload, err := json.Marshal(newMetricsPayload(summary, os.Getenv("BITRISE_BUILD_SLUG")))
+	if err != nil {
+		log.Warnf("Metrics not sent, failed to serialize them, error: %s", err)
+		return
+	}
+
+	if err := postMetrics(metricsWebhookURL, payload); err != nil {
+		log.Warnf("Metrics not sent to the webhook: %s", err)
+		return
+	}
+	log.Printf("Metrics sent to the webhook")
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPostMetrics(t *testing.T) {
+	summary := NewRunSummary()
+	summary.StepVersion = "1.2.3"
+	summary.ExitCode = 3
+	summary.FailureClassification = categoryInfrastructure.Name
+	summary.Simulator = SimulatorSummaryModel{Runtime: "iOS 12.1", RuntimeVersion: "12.1"}
+	expected := newMetricsPayload(summary, "0123456789abcdef")
+
+	payload, err := json.Marshal(expected)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var received MetricsPayloadModel
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			t.Errorf("method: %s, expected: POST", r.Method)
+		}
+		if contentType := r.Header.Get("Content-Type"); contentType != "application/json" {
+			t.Errorf("content type: %s, expected: application/json", contentType)
+		}
+		if token := r.URL.Query().Get("token"); token != "abc123" {
+			t.Errorf("token: %s, expected: abc123", token)
+		}
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Error(err)
+		}
+		if err := json.Unmarshal(body, &received); err != nil {
+			t.Errorf("invalid payload: %s", err)
+		}
+	}))
+	defer server.Close()
+
+	if err := postMetrics(server.URL+"/hooks/calabash?token=abc123", payload); err != nil {
+		t.Fatal(err)
+	}
+
+	if received.FormatVersion != metricsPayloadFormatVersion || received.Result != testResultFailed ||
+		received.ExitCode != 3 || received.FailureClassification != "infrastructure" || received.SimulatorRuntime != "12.1" ||
+		received.BuildSlugHash != buildSlugHash("0123456789abcdef") || len(received.BuildSlugHash) != 16 {
+		t.Errorf("received payload: %+v, expected: %+v", received, expected)
+	}
+}
+
+func TestPostMetricsFailures(t *testing.T) {
+	previousTimeout := metricsWebhookTimeout
+	metricsWebhookTimeout = 100 * time.Millisecond
+	defer func() { metricsWebhookTimeout = previousTimeout }()
+
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/slow":
+			<-release
+		case "/error":
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+
+	tests := []struct {
+		name    string
+		path    string
+		wantErr string
+	}{
+		{name: "timeout", path: "/slow", wantErr: "Client.Timeout exceeded"},
+		{name: "error status", path: "/error", wantErr: "unexpected response status: 500 Internal Server Error"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			webhookURL := server.URL + tt.path + "?token=abc123"
+
+			start := time.Now()
+			err := postMetrics(webhookURL, []byte("{}"))
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			if elapsed := time.Since(start); elapsed > 2*time.Second {
+				t.Errorf("post took %s, expected to time out after %s", elapsed, metricsWebhookTimeout)
+			}
+			if !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("error: %s, expected to contain: %s", err, tt.wantErr)
+			}
+			if strings.Contains(err.Error(), "abc123") {
+				t.Errorf("error holds the token: %s", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/command"
+	"github.com/bitrise-io/go-utils/log"
+)
+
+const networkProfileNone = "none"
+
+// NetworkProfileModel is a network condition applied on the host's traffic during the cucumber run,
+// the values follow the Network Link Conditioner presets. An empty bandwidth is unlimited.
+type NetworkProfileModel struct {
+	Name              string
+	DownlinkBandwidth string
+	UplinkBandwidth   string
+	DelayMs           int
+	PacketLossRate    string
+}
+
+var networkProfiles = []NetworkProfileModel{
+	{Name: "off", PacketLossRate: "1"},
+	{Name: "3g", DownlinkBandwidth: "780Kbit/s", UplinkBandwidth: "330Kbit/s", DelayMs: 100, PacketLossRate: "0"},
+	{Name: "edge", DownlinkBandwidth: "240Kbit/s", UplinkBandwidth: "200Kbit/s", DelayMs: 400, PacketLossRate: "0"},
+	{Name: "high-latency", DelayMs: 500, PacketLossRate: "0"},
+}
+
+// networkProfileNames returns the values accepted by the network_profile input.
+func networkProfileNames() []string {
+	names := []string{networkProfileNone}
+	for _, profile := range networkProfiles {
+		names = append(names, profile.Name)
+	}
+	return names
+}
+
+func networkProfileByName(name string) (NetworkProfileModel, bool) {
+	for _, profile := range networkProfiles {
+		if profile.Name == name {
+			return profile, true
+		}
+	}
+	return NetworkProfileModel{}, false
+}
+
+// The profile is applied with dummynet, which the Network Link Conditioner is built on as well:
+// the host's traffic is piped through two dummynet pipes by pf rules loaded into an anchor under com.apple,
+// which the default /etc/pf.conf evaluates. The loopback interface is left out, calabash talks to DeviceAgent through it.
+const (
+	networkProfileAnchor       = "com.apple/calabash.network_profile"
+	networkProfileDownlinkPipe = "41001"
+	networkProfileUplinkPipe   = "41002"
+)
+
+var pfEnableTokenExp = regexp.MustCompile(`(?m)^Token : (\d+)`)
+
+// activeNetworkProfile is the profile applied on the host, nil if none.
+var activeNetworkProfile *NetworkProfileModel
+
+// pfEnableToken references the pf enable of the activated profile, releasing it leaves pf as it was before the run.
+var pfEnableToken string
+
+var networkProfileCleanupRegistered bool
+
+func sudoCommand(name string, args ...string) *command.Model {
+	return command.New("sudo", append([]string{"-n", name}, args...)...)
+}
+
+func runNetworkCommand(cmd *command.Model) (string, error) {
+	printCommand(cmd)
+
+	out, err := commandRecorder.RunAndReturnTrimmedCombinedOutput(cmd)
+	if err != nil {
+		return out, fmt.Errorf("%s failed, output: %s, error: %s", cmd.PrintableCommandArgs(), out, err)
+	}
+	return out, nil
+}
+
+// checkNetworkShapingSupport fails if the host can not apply a network profile:
+// dnctl and pfctl are required, and both run as root through a non-interactive sudo.
+func checkNetworkShapingSupport(profileName string) error {
+	fmt.Println()
+	log.Infof("Checking network profile support...")
+
+	for _, tool := range []string{"dnctl", "pfctl"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			return newStepError(categoryInfrastructure, "network_profile (%s) is not supported on this host: %s is not available, "+
+				"it is part of macOS, the host might not be a Mac", profileName, tool)
+		}
+	}
+
+	if out, err := commandRecorder.RunAndReturnTrimmedCombinedOutput(command.New("sudo", "-n", "true")); err != nil {
+		return newStepError(categoryInfrastructure, "network_profile (%s) is not supported on this host: dnctl and pfctl require root, "+
+			"but sudo asks for a password (output: %s).\nAllow passwordless sudo for the build user, or set network_profile to %s", profileName, out, networkProfileNone)
+	}
+
+	log.Donef("Network profile supported")
+	return nil
+}
+
+// networkProfileRules pipes the incoming and the outgoing traffic of every interface but the loopback through the profile's pipes.
+func networkProfileRules() string {
+	return fmt.Sprintf("dummynet in quick on ! lo0 all pipe %s\ndummynet out quick on ! lo0 all pipe %s\n", networkProfileDownlinkPipe, networkProfileUplinkPipe)
+}
+
+func dummynetPipeArgs(pipe, bandwidth string, profile NetworkProfileModel) []string {
+	args := []string{"pipe", pipe, "config"}
+	if bandwidth != "" {
+		args = append(args, "bw", bandwidth)
+	}
+	return append(args, "delay", strconv.Itoa(profile.DelayMs), "plr", profile.PacketLossRate)
+}
+
+// activateNetworkProfile applies the network profile on the host's traffic,
+// the profile is deactivated by deactivateNetworkProfile, or by the cleanups if the step exits before that.
+func activateNetworkProfile(profile NetworkProfileModel) error {
+	fmt.Println()
+	log.Infof("Activating network profile: %s...", profile.Name)
+
+	activeNetworkProfile = &profile
+	if !networkProfileCleanupRegistered {
+		networkProfileCleanupRegistered = true
+		registerCleanup("network profile", deactivateNetworkProfile)
+	}
+
+	for _, cmd := range []*command.Model{
+		sudoCommand("dnctl", dummynetPipeArgs(networkProfileDownlinkPipe, profile.DownlinkBandwidth, profile)...),
+		sudoCommand("dnctl", dummynetPipeArgs(networkProfileUplinkPipe, profile.UplinkBandwidth, profile)...),
+		sudoCommand("pfctl", "-a", networkProfileAnchor, "-f", "-").SetStdin(strings.NewReader(networkProfileRules())),
+	} {
+		if _, err := runNetworkCommand(cmd); err != nil {
+			return newStepError(categoryInfrastructure, "Failed to activate network profile (%s), %s", profile.Name, err)
+		}
+	}
+
+	out, err := runNetworkCommand(sudoCommand("pfctl", "-E"))
+	if err != nil {
+		return newStepError(categoryInfrastructure, "Failed to activate network profile (%s), %s", profile.Name, err)
+	}
+	if match := pfEnableTokenExp.FindStringSubmatch(out); len(match) == 2 {
+		pfEnableToken = match[1]
+	}
+
+	runSummary.NetworkProfile = profile.Name
+	log.Donef("Network profile active: %s", profile.Name)
+	return nil
+}
+
+// deactivateNetworkProfile removes the active network profile's pf rules and dummynet pipes,
+// it is a no-op if no profile is active.
+func deactivateNetworkProfile() error {
+	profile := activeNetworkProfile
+	if profile == nil {
+		return nil
+	}
+	activeNetworkProfile = nil
+
+	fmt.Println()
+	log.Infof("Deactivating network profile: %s...", profile.Name)
+
+	cmds := []*command.Model{
+		sudoCommand("pfctl", "-a", networkProfileAnchor, "-F", "all"),
+		sudoCommand("dnctl", "pipe", "delete", networkProfileDownlinkPipe),
+		sudoCommand("dnctl", "pipe", "delete", networkProfileUplinkPipe),
+	}
+	if pfEnableToken != "" {
+		cmds = append(cmds, sudoCommand("pfctl", "-X", pfEnableToken))
+		pfEnableToken = ""
+	}
+
+	var errs []string
+	for _, cmd := range cmds {
+		if _, err := runNetworkCommand(cmd); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("failed to deactivate network profile (%s): %s", profile.Name, strings.Join(errs, "; "))
+	}
+
+	log.Donef("Network profile deactivated")
+	return nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	shellquote "github.com/kballard/go-shellquote"
+)
+
+const utf8BOM = "\uFEFF"
+
+// smartQuoteReplacer replaces the typographic quotes, which documents and chat apps put in place of plain ones.
+var smartQuoteReplacer = strings.NewReplacer(
+	"“", `"`, "”", `"`,
+	"‘", "'", "’", "'",
+)
+
+// normalizeOptions trims a leading UTF-8 BOM and replaces the smart quotes of a pasted value,
+// returns true if the value was changed.
+func normalizeOptions(value string) (string, bool) {
+	normalized := smartQuoteReplacer.Replace(strings.TrimPrefix(value, utf8BOM))
+	return normalized, normalized != value
+}
+
+// unterminatedQuotePosition returns the 1 based character position of the quote or backslash,
+// which is left open in the value, 0 if every quote is terminated.
+func unterminatedQuotePosition(value string) int {
+	var quote rune
+	start := 0
+	escaped := false
+
+	for i, r := range []rune(value) {
+		switch {
+		case escaped:
+			escaped = false
+		case r == '\\' && quote != '\'':
+			escaped = true
+			if quote == 0 {
+				start = i + 1
+			}
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '\'' || r == '"':
+			quote = r
+			start = i + 1
+		}
+	}
+
+	if escaped || quote != 0 {
+		return start
+	}
+	return 0
+}
+
+// splitOptions splits the value into arguments like a shell would,
+// the error points to the approximate position of the unterminated quote.
+func splitOptions(name, value string) ([]string, error) {
+	args, err := shellquote.Split(value)
+	if err != nil {
+		if position := unterminatedQuotePosition(value); position > 0 {
+			return nil, fmt.Errorf("invalid %s (%s), %s at around character %d", name, value, strings.ToLower(err.Error()), position)
+		}
+		return nil, fmt.Errorf("invalid %s (%s), error: %s", name, value, err)
+	}
+	return args, nil
+}
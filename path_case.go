@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/pathutil"
+)
+
+// caseMismatchModel is a path component, which does not exist as it is, but exists with a different case.
+type caseMismatchModel struct {
+	Asked string
+	Found string
+}
+
+// findCaseMismatches looks up a not existing path component by component, ignoring the case of the missing components.
+// It returns the components found with a different case, or found is false if the path does not exist even ignoring the case.
+func findCaseMismatches(pth string) (mismatches []caseMismatchModel, found bool) {
+	absPth, err := pathutil.AbsPath(pth)
+	if err != nil {
+		return nil, false
+	}
+
+	current := string(filepath.Separator)
+	for _, component := range strings.Split(strings.TrimPrefix(absPth, current), string(filepath.Separator)) {
+		next := filepath.Join(current, component)
+		if exist, err := pathutil.IsPathExists(next); err != nil {
+			return nil, false
+		} else if exist {
+			current = next
+			continue
+		}
+
+		entries, err := ioutil.ReadDir(current)
+		if err != nil {
+			return nil, false
+		}
+		match := ""
+		for _, entry := range entries {
+			if strings.EqualFold(entry.Name(), component) {
+				match = entry.Name()
+				break
+			}
+		}
+		if match == "" {
+			return nil, false
+		}
+
+		mismatches = append(mismatches, caseMismatchModel{Asked: component, Found: match})
+		current = filepath.Join(current, match)
+	}
+	return mismatches, len(mismatches) > 0
+}
+
+// caseMismatchHint returns a hint for a not existing path, which exists with a different case,
+// like `, found 'features' but 'Features' was given, the filesystem is case-sensitive`. It returns an empty string otherwise.
+func caseMismatchHint(pth string) string {
+	mismatches, found := findCaseMismatches(pth)
+	if !found {
+		return ""
+	}
+
+	var pairs []string
+	for _, mismatch := range mismatches {
+		pairs = append(pairs, fmt.Sprintf("found '%s' but '%s' was given", mismatch.Found, mismatch.Asked))
+	}
+	return ", " + strings.Join(pairs, ", ") + ", the filesystem is case-sensitive"
+}
+
+// cucumberRequireOptions returns the paths of the --require options.
+func cucumberRequireOptions(options []string) []string {
+	pths := []string{}
+	for i, option := range options {
+		switch {
+		case (option == "--require" || option == "-r") && i+1 < len(options):
+			pths = append(pths, options[i+1])
+		case strings.HasPrefix(option, "--require="):
+			pths = append(pths, strings.TrimPrefix(option, "--require="))
+		}
+	}
+	return pths
+}
+
+// checkRequirePathsCase fails if a --require path of the cucumber options, relative to the work dir,
+// exists only with a different case: cucumber would silently load nothing from it on a case-sensitive volume.
+func checkRequirePathsCase(options []string, workDir string) error {
+	for _, pth := range cucumberRequireOptions(options) {
+		absPth := pth
+		if !filepath.IsAbs(absPth) {
+			absPth = filepath.Join(workDir, pth)
+		}
+		if exist, err := pathutil.IsPathExists(absPth); err != nil || exist {
+			continue
+		}
+		if hint := caseMismatchHint(absPth); hint != "" {
+			return fmt.Errorf("invalid AdditionalOptions, --require path not exists at: %s%s", pth, hint)
+		}
+	}
+	return nil
+}
+
+// checkFeaturesPathCase fails if the default features dir does not exist, but exists with a different case,
+// as cucumber would find no features on a case-sensitive volume.
+func (ctx *StepContext) checkFeaturesPathCase() error {
+	if ctx.FeaturesDir != "" {
+		return nil
+	}
+
+	pth := filepath.Join(ctx.WorkDir, ctx.featuresPath())
+	if exist, err := pathutil.IsPathExists(pth); err != nil || exist {
+		return nil
+	}
+	if hint := caseMismatchHint(pth); hint != "" {
+		return newStepError(categoryInvalidInput, "Features dir not exists at: %s%s", pth, hint)
+	}
+	return nil
+}
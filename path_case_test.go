@@ -0,0 +1,94 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCucumberRequireOptions(t *testing.T) {
+	tests := []struct {
+		name    string
+		options []string
+		want    []string
+	}{
+		{name: "no require", options: []string{"--tags", "@smoke"}, want: []string{}},
+		{name: "long and short", options: []string{"--require", "features/support", "-r", "features/step_definitions"}, want: []string{"features/support", "features/step_definitions"}},
+		{name: "inline value", options: []string{"--require=Features/support", "--format", "pretty"}, want: []string{"Features/support"}},
+		{name: "missing value", options: []string{"--tags", "@smoke", "--require"}, want: []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := cucumberRequireOptions(tt.options); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("cucumberRequireOptions() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFindCaseMismatches(t *testing.T) {
+	dir, err := ioutil.TempDir("", "path_case")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := os.RemoveAll(dir); err != nil {
+			t.Error(err)
+		}
+	}()
+	if err := os.MkdirAll(filepath.Join(dir, "features", "Support"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "FEATURES")); err == nil {
+		t.Skip("the temporary dir is on a case-insensitive volume")
+	}
+
+	tests := []struct {
+		name      string
+		pth       string
+		want      []caseMismatchModel
+		wantFound bool
+		wantHint  string
+	}{
+		{name: "exists", pth: "features/Support", want: nil, wantFound: false},
+		{name: "not exists ignoring the case", pth: "features/steps", want: nil, wantFound: false},
+		{
+			name:      "one mismatch",
+			pth:       "Features/Support",
+			want:      []caseMismatchModel{{Asked: "Features", Found: "features"}},
+			wantFound: true,
+			wantHint:  ", found 'features' but 'Features' was given, the filesystem is case-sensitive",
+		},
+		{
+			name:      "every component",
+			pth:       "Features/support",
+			want:      []caseMismatchModel{{Asked: "Features", Found: "features"}, {Asked: "support", Found: "Support"}},
+			wantFound: true,
+			wantHint:  ", found 'features' but 'Features' was given, found 'Support' but 'support' was given, the filesystem is case-sensitive",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pth := filepath.Join(dir, tt.pth)
+
+			mismatches, found := findCaseMismatches(pth)
+			if found != tt.wantFound || !reflect.DeepEqual(mismatches, tt.want) {
+				t.Errorf("findCaseMismatches() = (%v, %v), want (%v, %v)", mismatches, found, tt.want, tt.wantFound)
+			}
+			if hint := caseMismatchHint(pth); hint != tt.wantHint {
+				t.Errorf("caseMismatchHint() = %q, want %q", hint, tt.wantHint)
+			}
+
+			err := checkRequirePathsCase([]string{"--require", tt.pth}, dir)
+			if tt.wantHint == "" && err != nil {
+				t.Errorf("checkRequirePathsCase() error = %s, expected none", err)
+			}
+			if tt.wantHint != "" && (err == nil || !strings.HasSuffix(err.Error(), tt.pth+tt.wantHint)) {
+				t.Errorf("checkRequirePathsCase() error = %v, expected the hint: %s", err, tt.wantHint)
+			}
+		})
+	}
+}
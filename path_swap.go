@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/pathutil"
+)
+
+var infoPlistBundleExecutableExp = regexp.MustCompile(`<key>CFBundleExecutable</key>\s*<string>([^<]*)</string>`)
+
+// isAppBundle reports whether the dir is a built app bundle: it contains an Info.plist and the bundle's executable,
+// the .app suffix alone is not enough (a project dir might be named like an app).
+// The executable is named by the CFBundleExecutable of an xml Info.plist, a binary Info.plist's executable is assumed to be named after the bundle.
+// The Info.plist of an app's sources names the executable by a build setting, like $(EXECUTABLE_NAME), which matches no file.
+func isAppBundle(dir string) bool {
+	content, err := ioutil.ReadFile(filepath.Join(dir, "Info.plist"))
+	if err != nil {
+		return false
+	}
+
+	executable := strings.TrimSuffix(filepath.Base(dir), filepath.Ext(dir))
+	if match := infoPlistBundleExecutableExp.FindSubmatch(content); match != nil {
+		executable = strings.TrimSpace(string(match[1]))
+	}
+	if executable == "" || strings.Contains(executable, "$") {
+		return false
+	}
+
+	info, err := os.Stat(filepath.Join(dir, executable))
+	if err != nil {
+		return false
+	}
+	return info.Mode().IsRegular() && info.Mode()&0111 != 0
+}
+
+// enclosingAppBundle returns the app bundle the absolute pth is (or is inside), empty if none.
+func enclosingAppBundle(pth string) string {
+	for dir := pth; ; dir = filepath.Dir(dir) {
+		if isAppBundle(dir) {
+			return dir
+		}
+		if filepath.Dir(dir) == dir {
+			return ""
+		}
+	}
+}
+
+// checkSwappedPaths fails if the work dir and the app path look swapped: the work dir is the app path,
+// the work dir is inside an app bundle, or the app path is not an app bundle but contains .feature files.
+// Cucumber would run in the app bundle only after the gems are installed, with confusing errors.
+func checkSwappedPaths(workDir, appPath string) error {
+	absWorkDir, err := pathutil.AbsPath(workDir)
+	if err != nil {
+		return fmt.Errorf("failed to expand WorkDir (%s), error: %s", workDir, err)
+	}
+
+	absAppPath := ""
+	if appPath != "" {
+		absAppPath, err = pathutil.AbsPath(appPath)
+		if err != nil {
+			return fmt.Errorf("failed to expand AppPath (%s), error: %s", appPath, err)
+		}
+	}
+
+	if absAppPath != "" && filepath.Clean(absAppPath) == filepath.Clean(absWorkDir) {
+		return fmt.Errorf("WorkDir and AppPath are the same dir (%s), WorkDir should be the dir of the calabash project (containing the features dir), AppPath the built .app", workDir)
+	}
+
+	if bundle := enclosingAppBundle(filepath.Clean(absWorkDir)); bundle != "" {
+		return fmt.Errorf("WorkDir (%s) is inside the app bundle (%s), app_path and work_dir might be swapped: WorkDir should be the dir of the calabash project (containing the features dir)", workDir, bundle)
+	}
+
+	if absAppPath != "" && !isAppBundle(absAppPath) {
+		if found, err := hasFeatureFiles(absAppPath); err != nil {
+			return fmt.Errorf("failed to search AppPath (%s) for feature files, error: %s", appPath, err)
+		} else if found {
+			return fmt.Errorf("AppPath (%s) contains .feature files, app_path and work_dir might be swapped: AppPath should be the built .app, WorkDir the dir of the calabash project", appPath)
+		}
+	}
+	return nil
+}
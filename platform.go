@@ -0,0 +1,114 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/bitrise-io/go-utils/log"
+)
+
+const simulatorPlatformOutputKey = "BITRISE_CALABASH_SIMULATOR_PLATFORM"
+
+// Simulator platforms, the prefix of the simctl runtime names (`iOS 12.1`, `tvOS 12.1`)
+const (
+	platformIOS  = "iOS"
+	platformTvOS = "tvOS"
+)
+
+var platforms = []string{platformIOS, platformTvOS}
+
+// simulatorPlatformNames are listed in the CFBundleSupportedPlatforms of an app built for the platform's simulator,
+// the executable's architectures do not tell it apart from a device build (both can be arm64).
+var simulatorPlatformNames = map[string]string{
+	platformIOS:  "iPhoneSimulator",
+	platformTvOS: "AppleTVSimulator",
+}
+
+// platformOrDefault returns the platform input, iOS if it is not set.
+func platformOrDefault(platform string) string {
+	if platform == "" {
+		return platformIOS
+	}
+	return platform
+}
+
+// isPlatformRuntime reports whether the runtime name (`tvOS 12.1`) or identifier (com.apple.CoreSimulator.SimRuntime.tvOS-12-1) belongs to the platform,
+// simctl lists the devices by the runtime identifiers, older simctl versions by the runtime names.
+func isPlatformRuntime(runtime, platform string) bool {
+	return strings.HasPrefix(runtime, platform+" ") || strings.Contains(runtime, ".SimRuntime."+platform+"-")
+}
+
+// deviceTypePlatform returns the platform of a simulator device type: by its product family (listed by newer simctl versions),
+// otherwise by its identifier, like com.apple.CoreSimulator.SimDeviceType.Apple-TV-1080p.
+func deviceTypePlatform(productFamily, identifier string) string {
+	switch productFamily {
+	case "iPhone", "iPad":
+		return platformIOS
+	case "Apple TV":
+		return platformTvOS
+	case "Apple Watch":
+		return "watchOS"
+	}
+
+	switch {
+	case strings.Contains(identifier, ".Apple-TV"):
+		return platformTvOS
+	case strings.Contains(identifier, ".Apple-Watch"):
+		return "watchOS"
+	}
+	return platformIOS
+}
+
+// platformBundlePlatforms are the CFBundleSupportedPlatforms values of the apps built for the platform's devices and simulators.
+var platformBundlePlatforms = map[string][]string{
+	platformIOS:  {"iPhoneOS", "iPhoneSimulator"},
+	platformTvOS: {"AppleTVOS", "AppleTVSimulator"},
+}
+
+// appSupportedPlatforms returns the CFBundleSupportedPlatforms values of the Info.plist, like iPhoneSimulator.
+func appSupportedPlatforms(infoPlist map[string]interface{}) []string {
+	supportedPlatforms := []string{}
+	if values, ok := infoPlist["CFBundleSupportedPlatforms"].([]interface{}); ok {
+		for _, value := range values {
+			if supportedPlatform, ok := value.(string); ok {
+				supportedPlatforms = append(supportedPlatforms, supportedPlatform)
+			}
+		}
+	}
+	return supportedPlatforms
+}
+
+// checkAppPlatform fails if the app is built for an other platform than the platform input, like an iOS app for a tvOS simulator:
+// the install would fail. An app without CFBundleSupportedPlatforms is not checked.
+func (ctx *StepContext) checkAppPlatform() error {
+	if ctx.AppPath == "" {
+		return nil
+	}
+
+	infoPlist, err := appInfoPlist(ctx.AppPath)
+	if err != nil {
+		log.Warnf("%s, skipping platform check", err)
+		return nil
+	}
+
+	supportedPlatforms := appSupportedPlatforms(infoPlist)
+	if len(supportedPlatforms) == 0 {
+		return nil
+	}
+
+	platform := platformOrDefault(ctx.Configs.Platform)
+	for _, supportedPlatform := range supportedPlatforms {
+		if indexInStringSlice(supportedPlatform, platformBundlePlatforms[platform]) != -1 {
+			return nil
+		}
+	}
+	return newStepError(categoryInvalidInput, "The app is built for %s, but the platform is %s, set the platform input to the app's platform",
+		strings.Join(supportedPlatforms, ", "), platform)
+}
+
+// osVersionNumber returns the version of a `<platform> <version>` OS version, like `12.1` for `tvOS 12.1`.
+func osVersionNumber(osVersion string) string {
+	if fields := strings.Fields(osVersion); len(fields) == 2 {
+		return fields[1]
+	}
+	return strings.TrimSpace(osVersion)
+}
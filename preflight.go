@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/bitrise-io/go-utils/command"
+	"github.com/bitrise-io/go-utils/log"
+)
+
+// preflightIssueModel is a known misconfiguration of the Xcode installation, recognised by its output.
+type preflightIssueModel struct {
+	patterns    []string
+	problem     string
+	remediation string
+}
+
+var preflightIssues = []preflightIssueModel{
+	{
+		patterns:    []string{"license"},
+		problem:     "the Xcode license agreement is not accepted",
+		remediation: "sudo xcodebuild -license accept",
+	},
+	{
+		patterns:    []string{"CommandLineTools", "unable to find utility \"simctl\"", "requires Xcode"},
+		problem:     "the active developer directory points to the Command Line Tools instead of Xcode",
+		remediation: "sudo xcode-select --switch /Applications/Xcode.app/Contents/Developer",
+	},
+	{
+		patterns:    []string{"cannot be located", "iphonesimulator"},
+		problem:     "the iOS Simulator platform is not installed",
+		remediation: "xcodebuild -downloadPlatform iOS",
+	},
+}
+
+// diagnosePreflightOutput returns the known issue matching a failed preflight command's output.
+func diagnosePreflightOutput(out string) (preflightIssueModel, bool) {
+	for _, issue := range preflightIssues {
+		for _, pattern := range issue.patterns {
+			if strings.Contains(out, pattern) {
+				return issue, true
+			}
+		}
+	}
+	return preflightIssueModel{}, false
+}
+
+func preflightIssueError(issue preflightIssueModel) error {
+	return newStepError(categoryInfrastructure, "Simulator preflight failed: %s, to fix it run:\n  %s", issue.problem, issue.remediation)
+}
+
+// commandLineToolsDeveloperDir is the developer dir of the standalone Command Line Tools, which include neither simctl nor the simulators.
+const commandLineToolsDeveloperDir = "/Library/Developer/CommandLineTools"
+
+// isCommandLineToolsDeveloperDir reports whether the `xcode-select -p` output is the Command Line Tools' developer dir.
+func isCommandLineToolsDeveloperDir(out string) bool {
+	pth := strings.TrimSpace(out)
+	if pth == "" {
+		return false
+	}
+	pth = filepath.Clean(pth)
+	return pth == commandLineToolsDeveloperDir || filepath.Base(pth) == filepath.Base(commandLineToolsDeveloperDir)
+}
+
+// checkDeveloperDir fails if the active developer dir is the Command Line Tools instead of an Xcode,
+// an unavailable xcode-select is left to the simctl checks.
+func checkDeveloperDir() error {
+	cmd := command.New("xcode-select", "-p")
+	printCommand(cmd)
+
+	out, err := commandRecorder.RunAndReturnTrimmedCombinedOutput(cmd)
+	if err != nil {
+		log.Warnf("%s failed, output: %s, error: %s", cmd.PrintableCommandArgs(), out, err)
+		return nil
+	}
+
+	if isCommandLineToolsDeveloperDir(out) {
+		return newStepError(categoryInfrastructure, "Simulator preflight failed: the active developer dir is %s, the Command Line Tools do not include simctl, instruments "+
+			"and the iOS simulators, so no simulator can be used.\nSet the xcode_developer_dir_path input to the Developer dir of an Xcode (like /Applications/Xcode.app/Contents/Developer), or run:\n"+
+			"  sudo xcode-select --switch /Applications/Xcode.app/Contents/Developer", out)
+	}
+
+	log.Printf("Active developer dir: %s", out)
+	return nil
+}
+
+// runSimctlPreflight checks that simctl is usable before the simulator lookup,
+// so a misconfigured Xcode fails with a targeted error instead of a simulator lookup failure.
+func runSimctlPreflight() error {
+	fmt.Println()
+	log.Infof("Checking simctl availability...")
+
+	startTime := time.Now()
+
+	if err := checkDeveloperDir(); err != nil {
+		return err
+	}
+
+	// fallback is the issue reported when a failed check's output matches no known issue
+	checks := []struct {
+		cmd      *command.Model
+		fallback *preflightIssueModel
+	}{
+		{cmd: command.New("xcrun", "simctl", "help")},
+		{
+			cmd: command.New("xcodebuild", "-checkFirstLaunchStatus"),
+			fallback: &preflightIssueModel{
+				problem:     "the Xcode first launch tasks (additional components) are not installed",
+				remediation: "sudo xcodebuild -runFirstLaunch",
+			},
+		},
+		{cmd: command.New("xcrun", "--sdk", "iphonesimulator", "--show-sdk-path")},
+	}
+
+	for _, check := range checks {
+		printCommand(check.cmd)
+
+		out, err := commandRecorder.RunAndReturnTrimmedCombinedOutput(check.cmd)
+		if err == nil {
+			continue
+		}
+
+		if issue, ok := diagnosePreflightOutput(out); ok {
+			return preflightIssueError(issue)
+		}
+		if check.fallback != nil {
+			return preflightIssueError(*check.fallback)
+		}
+		return newStepError(categoryInfrastructure, "Simulator preflight failed: %s failed, output: %s, error: %s", check.cmd.PrintableCommandArgs(), out, err)
+	}
+
+	log.Donef("simctl is available (%s)", roundDuration(time.Since(startTime)))
+	return nil
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestIsCommandLineToolsDeveloperDir(t *testing.T) {
+	tests := []struct {
+		out  string
+		want bool
+	}{
+		{out: "", want: false},
+		{out: "/Applications/Xcode.app/Contents/Developer", want: false},
+		{out: "/Applications/Xcode-10.1.app/Contents/Developer\n", want: false},
+		{out: "/Library/Developer/CommandLineTools", want: true},
+		{out: "/Library/Developer/CommandLineTools/\n", want: true},
+		{out: "  /Library/Developer/../Developer/CommandLineTools  ", want: true},
+		// a relocated Command Line Tools install
+		{out: "/opt/CommandLineTools", want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.out, func(t *testing.T) {
+			if got := isCommandLineToolsDeveloperDir(tt.out); got != tt.want {
+				t.Errorf("isCommandLineToolsDeveloperDir(%q) = %v, want %v", tt.out, got, tt.want)
+			}
+		})
+	}
+}
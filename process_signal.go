@@ -0,0 +1,109 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/bitrise-io/go-utils/command"
+	"github.com/bitrise-io/go-utils/log"
+)
+
+// vmStatPageSizeExp matches the page size of vm_stat's header, like `Mach Virtual Memory Statistics: (page size of 4096 bytes)`.
+var vmStatPageSizeExp = regexp.MustCompile(`page size of (\d+) bytes`)
+
+// vmStatPressureKeys are the vm_stat statistics printed when cucumber is killed by a signal,
+// the `Pages` statistics are printed in MB, the rest as counts.
+var vmStatPressureKeys = []string{"Pages free", "Pages active", "Pages inactive", "Pages wired down", "Pages occupied by compressor", "Pageouts", "Swapouts"}
+
+// signalOf returns the signal which terminated the command, if it was terminated by a signal.
+func signalOf(err error) (syscall.Signal, bool) {
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return 0, false
+	}
+
+	status, ok := exitErr.Sys().(syscall.WaitStatus)
+	if !ok || !status.Signaled() {
+		return 0, false
+	}
+	return status.Signal(), true
+}
+
+// vmStatSnapshot returns the memory statistics printed by vm_stat.
+func vmStatSnapshot() (string, error) {
+	return commandRecorder.RunAndReturnTrimmedCombinedOutput(command.New("vm_stat"))
+}
+
+// parseVMStat returns the page size and the statistics of vm_stat's output, like `Pages free:  12345.`.
+func parseVMStat(out string) (int64, map[string]int64) {
+	pageSize := int64(4096)
+	stats := map[string]int64{}
+	for _, line := range strings.Split(out, "\n") {
+		if match := vmStatPageSizeExp.FindStringSubmatch(line); match != nil {
+			if size, err := strconv.ParseInt(match[1], 10, 64); err == nil {
+				pageSize = size
+			}
+			continue
+		}
+
+		split := strings.SplitN(line, ":", 2)
+		if len(split) != 2 {
+			continue
+		}
+		if value, err := strconv.ParseInt(strings.TrimSuffix(strings.TrimSpace(split[1]), "."), 10, 64); err == nil {
+			stats[strings.TrimSpace(split[0])] = value
+		}
+	}
+	return pageSize, stats
+}
+
+func formatVMStatValue(key string, value int64, pageSize int64, found bool) string {
+	if !found {
+		return "-"
+	}
+	if strings.HasPrefix(key, "Pages ") {
+		return fmt.Sprintf("%d MB", value*pageSize/(1024*1024))
+	}
+	return fmt.Sprintf("%d", value)
+}
+
+// memoryPressureTable returns the memory statistics of the vm_stat snapshots taken at the start and the end of the run.
+func memoryPressureTable(start, end string) string {
+	startPageSize, startStats := parseVMStat(start)
+	endPageSize, endStats := parseVMStat(end)
+
+	lines := []string{fmt.Sprintf("%-30s %12s %12s", "Statistic", "Start", "End")}
+	for _, key := range vmStatPressureKeys {
+		startValue, startFound := startStats[key]
+		endValue, endFound := endStats[key]
+		lines = append(lines, fmt.Sprintf("%-30s %12s %12s", key,
+			formatVMStatValue(key, startValue, startPageSize, startFound), formatVMStatValue(key, endValue, endPageSize, endFound)))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// killedBySignalError reports cucumber's termination by a signal, which is most likely the system killing it
+// (for example for running out of memory), with the memory statistics of the run.
+// The failure is not retried, as a retry would run into the same memory conditions.
+func killedBySignalError(sig syscall.Signal, vmStatStart string) error {
+	fmt.Println()
+	log.Errorf("Cucumber was killed by signal %d (%s) before it could finish, this is not a test failure", int(sig), sig)
+
+	vmStatEnd, err := vmStatSnapshot()
+	if err != nil {
+		log.Warnf("Failed to read the memory statistics, error: %s", err)
+	} else if vmStatStart == "" {
+		log.Printf("Memory statistics at the end of the run (vm_stat):")
+		log.Printf("%s", memoryPressureTable("", vmStatEnd))
+	} else {
+		log.Printf("Memory statistics at the start and the end of the run (vm_stat):")
+		log.Printf("%s", memoryPressureTable(vmStatStart, vmStatEnd))
+	}
+
+	return newNonRetryableStepError(categoryInfrastructure, "Cucumber was killed by signal %d (%s), likely by the system (for example running out of memory)", int(sig), sig)
+}
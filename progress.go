@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/bitrise-io/go-utils/log"
+)
+
+const defaultProgressInterval = 60 * time.Second
+
+// cucumberSummaryScenariosExp matches the scenario count line cucumber prints after the run, like `12 scenarios (1 failed, 11 passed)`.
+var cucumberSummaryScenariosExp = regexp.MustCompile(`^\d+ scenarios? \(`)
+
+// withoutFormatterArgs returns the cucumber args without the formatters and their --out options,
+// so that the dry run does not overwrite the run's reports.
+func withoutFormatterArgs(args []string) []string {
+	filtered := []string{}
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "--format" || arg == "-f" || arg == "--out" || arg == "-o":
+			i++
+		case strings.HasPrefix(arg, "--format=") || strings.HasPrefix(arg, "--out="):
+		default:
+			filtered = append(filtered, arg)
+		}
+	}
+	return filtered
+}
+
+// plannedScenarioCount counts the scenarios cucumber is going to run with a dry run of the run's args and envs,
+// so cucumber applies the tags, the names, the excluded features and the rerun file the same way as in the run.
+// A scenario outline counts as the number of its examples' rows.
+func (ctx *StepContext) plannedScenarioCount(args, envs []string) (int, error) {
+	tmpDir, err := ctx.createTempDir("dry_run")
+	if err != nil {
+		return 0, err
+	}
+	reportPth := filepath.Join(tmpDir, "dry_run_report.json")
+
+	dryRunArgs := append(withoutFormatterArgs(args), "--dry-run", "--format", "json", "--out", reportPth)
+	cmd, err := ctx.newCucumberCommand(dryRunArgs)
+	if err != nil {
+		return 0, err
+	}
+	cmd.AppendEnvs(envs...)
+	cmd.SetDir(ctx.WorkDir)
+
+	if out, err := commandRecorder.RunAndReturnTrimmedCombinedOutput(cmd); err != nil {
+		return 0, fmt.Errorf("the dry run failed, output: %s, error: %s", out, err)
+	}
+
+	features, err := parseCucumberJSONReportFile(reportPth)
+	if err != nil {
+		return 0, err
+	}
+	return len(scenarioResults(features)), nil
+}
+
+// formatProgressDuration formats the duration in whole minutes, or in seconds below a minute.
+func formatProgressDuration(d time.Duration) string {
+	switch {
+	case d >= time.Hour:
+		return fmt.Sprintf("%dh%02dm", int(d/time.Hour), int(d%time.Hour/time.Minute))
+	case d >= time.Minute:
+		return fmt.Sprintf("%dm", int(d/time.Minute))
+	default:
+		return fmt.Sprintf("%ds", int(d/time.Second))
+	}
+}
+
+// progressETA estimates the remaining time from the average duration of the done scenarios.
+func progressETA(elapsed time.Duration, done, total int) time.Duration {
+	if done <= 0 || done >= total {
+		return 0
+	}
+	return time.Duration(int64(elapsed) / int64(done) * int64(total-done))
+}
+
+// progressLine returns the progress report, like `[progress] 34/120 scenarios, 12m elapsed, ETA 30m`.
+func progressLine(done, total int, elapsed time.Duration) string {
+	return fmt.Sprintf("[progress] %d/%d scenarios, %s elapsed, ETA %s", done, total,
+		formatProgressDuration(elapsed), formatProgressDuration(progressETA(elapsed, done, total)))
+}
+
+// ProgressScanner passes the cucumber output through, while counting the completed scenarios of the pretty formatter's output,
+// and periodically prints the progress of the run. The progress formatter's output has no scenario boundaries,
+// nothing is reported for it.
+type ProgressScanner struct {
+	out      io.Writer
+	line     []byte
+	total    int
+	interval time.Duration
+	start    time.Time
+	lastLog  time.Time
+
+	done       int
+	pending    bool
+	outline    bool
+	inExamples bool
+	headerSeen bool
+	finished   bool
+}
+
+// NewProgressScanner ...
+func NewProgressScanner(out io.Writer, total int, interval time.Duration) *ProgressScanner {
+	return &ProgressScanner{out: out, total: total, interval: interval, start: time.Now()}
+}
+
+func (s *ProgressScanner) Write(p []byte) (int, error) {
+	n, err := s.out.Write(p)
+
+	s.line = append(s.line, p...)
+	for {
+		idx := bytes.IndexByte(s.line, '\n')
+		if idx == -1 {
+			break
+		}
+		s.scanLine(string(s.line[:idx]))
+		s.line = s.line[idx+1:]
+	}
+	return n, err
+}
+
+func (s *ProgressScanner) scanLine(line string) {
+	if s.finished {
+		return
+	}
+
+	line = strings.TrimSpace(stripANSI(line))
+	switch {
+	case cucumberSummaryScenariosExp.MatchString(line):
+		s.completePending()
+		s.finished = true
+	case strings.HasPrefix(line, "Feature:"):
+		s.completePending()
+		s.outline, s.inExamples = false, false
+	case strings.HasPrefix(line, "Scenario Outline:") || strings.HasPrefix(line, "Scenario Template:"):
+		s.completePending()
+		s.outline, s.inExamples = true, false
+	case strings.HasPrefix(line, "Examples:") || strings.HasPrefix(line, "Scenarios:"):
+		s.inExamples, s.headerSeen = s.outline, false
+	case strings.HasPrefix(line, "Scenario:") || strings.HasPrefix(line, "Example:"):
+		// the pretty formatter prints the scenario when it starts, the previous one is done by then
+		s.completePending()
+		s.pending = true
+		s.outline, s.inExamples = false, false
+	case strings.HasPrefix(line, "|") && s.inExamples:
+		// the examples' rows are printed when they are done
+		if s.headerSeen {
+			s.complete()
+		}
+		s.headerSeen = true
+	}
+}
+
+func (s *ProgressScanner) completePending() {
+	if s.pending {
+		s.pending = false
+		s.complete()
+	}
+}
+
+func (s *ProgressScanner) complete() {
+	s.done++
+	// a miscounted total would report nonsense, the reporting stops instead
+	if s.done > s.total {
+		s.finished = true
+		return
+	}
+
+	now := time.Now()
+	if !s.lastLog.IsZero() && now.Sub(s.lastLog) < s.interval {
+		return
+	}
+	s.lastLog = now
+	log.Printf("%s", progressLine(s.done, s.total, now.Sub(s.start)))
+}
+
+// cucumberOutputWriter wraps the cucumber output's writer with the progress reporting of the run's args and envs,
+// it is skipped silently if progress reporting is disabled or the scenarios can not be counted.
+func (ctx *StepContext) cucumberOutputWriter(out io.Writer, args, envs []string) io.Writer {
+	if ctx.ProgressInterval <= 0 {
+		return out
+	}
+
+	total, err := ctx.plannedScenarioCount(args, envs)
+	if err != nil {
+		log.Debugf("Progress reporting skipped, failed to count the scenarios: %s", err)
+		return out
+	}
+	if total == 0 {
+		log.Debugf("Progress reporting skipped, no scenario found")
+		return out
+	}
+
+	log.Printf("Scenarios to run: %d, progress reported every %s", total, ctx.ProgressInterval)
+	return NewProgressScanner(out, total, ctx.ProgressInterval)
+}
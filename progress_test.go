@@ -0,0 +1,87 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestProgressETA(t *testing.T) {
+	tests := []struct {
+		name    string
+		elapsed time.Duration
+		done    int
+		total   int
+		want    time.Duration
+	}{
+		{name: "nothing done", elapsed: 5 * time.Minute, done: 0, total: 10, want: 0},
+		{name: "first done", elapsed: 2 * time.Minute, done: 1, total: 10, want: 18 * time.Minute},
+		{name: "half done", elapsed: 12 * time.Minute, done: 4, total: 8, want: 12 * time.Minute},
+		{name: "uneven average", elapsed: 10 * time.Second, done: 3, total: 4, want: 3333333333 * time.Nanosecond},
+		{name: "all done", elapsed: 30 * time.Minute, done: 10, total: 10, want: 0},
+		// the retried or the outline rows' scenarios can complete more scenarios than planned
+		{name: "more done than planned", elapsed: 30 * time.Minute, done: 12, total: 10, want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := progressETA(tt.elapsed, tt.done, tt.total); got != tt.want {
+				t.Errorf("progressETA() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFormatProgressDuration(t *testing.T) {
+	tests := []struct {
+		d    time.Duration
+		want string
+	}{
+		{d: 0, want: "0s"},
+		{d: 1500 * time.Millisecond, want: "1s"},
+		{d: 59 * time.Second, want: "59s"},
+		{d: time.Minute, want: "1m"},
+		{d: 12*time.Minute + 59*time.Second, want: "12m"},
+		{d: time.Hour, want: "1h00m"},
+		{d: 2*time.Hour + 5*time.Minute + 30*time.Second, want: "2h05m"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.d.String(), func(t *testing.T) {
+			if got := formatProgressDuration(tt.d); got != tt.want {
+				t.Errorf("formatProgressDuration() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProgressLine(t *testing.T) {
+	if got, want := progressLine(34, 120, 12*time.Minute), "[progress] 34/120 scenarios, 12m elapsed, ETA 30m"; got != want {
+		t.Errorf("progressLine() = %s, want %s", got, want)
+	}
+}
+
+func TestWithoutFormatterArgs(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want []string
+	}{
+		{name: "no formatters", args: []string{"cucumber", "--tags", "@smoke", "features"}, want: []string{"cucumber", "--tags", "@smoke", "features"}},
+		{
+			name: "formatters with out",
+			args: []string{"cucumber", "--format", "html", "--out", "report.html", "-f", "junit", "-o", "junit", "--tags", "@smoke"},
+			want: []string{"cucumber", "--tags", "@smoke"},
+		},
+		{
+			name: "inline values",
+			args: []string{"bundle", "exec", "cucumber", "--format=pretty", "--out=report.txt", "--name", "Login", "@rerun.txt"},
+			want: []string{"bundle", "exec", "cucumber", "--name", "Login", "@rerun.txt"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := withoutFormatterArgs(tt.args); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("withoutFormatterArgs() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/fileutil"
+)
+
+// Cucumber step and scenario statuses
+const (
+	statusPassed    = "passed"
+	statusFailed    = "failed"
+	statusSkipped   = "skipped"
+	statusPending   = "pending"
+	statusUndefined = "undefined"
+)
+
+// CucumberResultModel ...
+type CucumberResultModel struct {
+	Status       string `json:"status"`
+	Duration     int64  `json:"duration"`
+	ErrorMessage string `json:"error_message"`
+}
+
+// CucumberStepModel ...
+type CucumberStepModel struct {
+	Keyword string              `json:"keyword"`
+	Name    string              `json:"name"`
+	Line    int                 `json:"line"`
+	Result  CucumberResultModel `json:"result"`
+}
+
+// CucumberHookModel ...
+type CucumberHookModel struct {
+	Result CucumberResultModel `json:"result"`
+}
+
+// CucumberTagModel ...
+type CucumberTagModel struct {
+	Name string `json:"name"`
+	Line int    `json:"line"`
+}
+
+// CucumberElementModel is a scenario or a background of a feature.
+type CucumberElementModel struct {
+	ID      string              `json:"id"`
+	Keyword string              `json:"keyword"`
+	Name    string              `json:"name"`
+	Line    int                 `json:"line"`
+	Type    string              `json:"type"`
+	Tags    []CucumberTagModel  `json:"tags"`
+	Before  []CucumberHookModel `json:"before"`
+	Steps   []CucumberStepModel `json:"steps"`
+	After   []CucumberHookModel `json:"after"`
+}
+
+// CucumberFeatureModel ...
+type CucumberFeatureModel struct {
+	ID       string                 `json:"id"`
+	URI      string                 `json:"uri"`
+	Keyword  string                 `json:"keyword"`
+	Name     string                 `json:"name"`
+	Line     int                    `json:"line"`
+	Tags     []CucumberTagModel     `json:"tags"`
+	Elements []CucumberElementModel `json:"elements"`
+}
+
+// ScenarioResultModel ...
+type ScenarioResultModel struct {
+	Feature  string
+	URI      string
+	Name     string
+	Line     int
+	Status   string
+	Duration int64 // nanoseconds
+	Tags     []string
+	Error    string
+	// FailedStep is the first failed step (or hook) of the scenario.
+	FailedStep string
+}
+
+// Location returns the scenario's location in the `<feature file>:<line>` form.
+func (scenario ScenarioResultModel) Location() string {
+	return fmt.Sprintf("%s:%d", scenario.URI, scenario.Line)
+}
+
+// ScenarioCountsModel ...
+type ScenarioCountsModel struct {
+	Total     int `json:"total"`
+	Passed    int `json:"passed"`
+	Failed    int `json:"failed"`
+	Skipped   int `json:"skipped"`
+	Pending   int `json:"pending"`
+	Undefined int `json:"undefined"`
+}
+
+func parseCucumberJSONReport(content []byte) ([]CucumberFeatureModel, error) {
+	var features []CucumberFeatureModel
+	if err := json.Unmarshal(content, &features); err != nil {
+		return nil, err
+	}
+	return features, nil
+}
+
+func parseCucumberJSONReportFile(pth string) ([]CucumberFeatureModel, error) {
+	content, err := fileutil.ReadBytesFromFile(pth)
+	if err != nil {
+		return nil, err
+	}
+	return parseCucumberJSONReport(content)
+}
+
+// elementResults returns the results of the element's hooks and steps in execution order.
+func elementResults(element CucumberElementModel) []CucumberResultModel {
+	results := []CucumberResultModel{}
+	for _, hook := range element.Before {
+		results = append(results, hook.Result)
+	}
+	for _, step := range element.Steps {
+		results = append(results, step.Result)
+	}
+	for _, hook := range element.After {
+		results = append(results, hook.Result)
+	}
+	return results
+}
+
+// failedStepName returns the first failed step of the element, like `Then I see the home screen`, empty if none failed.
+func failedStepName(element CucumberElementModel) string {
+	for _, hook := range element.Before {
+		if hook.Result.Status == statusFailed {
+			return "Before hook"
+		}
+	}
+	for _, step := range element.Steps {
+		if step.Result.Status == statusFailed {
+			return strings.TrimSpace(step.Keyword) + " " + step.Name
+		}
+	}
+	for _, hook := range element.After {
+		if hook.Result.Status == statusFailed {
+			return "After hook"
+		}
+	}
+	return ""
+}
+
+// scenarioStatus returns the overall status of a scenario:
+// failed if any step or hook failed, otherwise the first non passed step status.
+func scenarioStatus(element CucumberElementModel) string {
+	status := statusPassed
+	for _, result := range elementResults(element) {
+		switch result.Status {
+		case statusFailed:
+			return statusFailed
+		case statusPassed, "":
+		default:
+			if status == statusPassed {
+				status = result.Status
+			}
+		}
+	}
+	return status
+}
+
+func scenarioResults(features []CucumberFeatureModel) []ScenarioResultModel {
+	scenarios := []ScenarioResultModel{}
+	for _, feature := range features {
+		for _, element := range feature.Elements {
+			if element.Type == "background" {
+				continue
+			}
+
+			scenario := ScenarioResultModel{
+				Feature: feature.Name,
+				URI:     feature.URI,
+				Name:    element.Name,
+				Line:    element.Line,
+				Status:  scenarioStatus(element),
+			}
+
+			for _, tag := range feature.Tags {
+				scenario.Tags = append(scenario.Tags, tag.Name)
+			}
+			for _, tag := range element.Tags {
+				scenario.Tags = append(scenario.Tags, tag.Name)
+			}
+
+			for _, result := range elementResults(element) {
+				scenario.Duration += result.Duration
+				if scenario.Error == "" && result.ErrorMessage != "" {
+					scenario.Error = result.ErrorMessage
+				}
+			}
+			scenario.FailedStep = failedStepName(element)
+
+			scenarios = append(scenarios, scenario)
+		}
+	}
+	return scenarios
+}
+
+func countScenarios(scenarios []ScenarioResultModel) ScenarioCountsModel {
+	counts := ScenarioCountsModel{Total: len(scenarios)}
+	for _, scenario := range scenarios {
+		switch scenario.Status {
+		case statusPassed:
+			counts.Passed++
+		case statusFailed:
+			counts.Failed++
+		case statusPending:
+			counts.Pending++
+		case statusUndefined:
+			counts.Undefined++
+		default:
+			counts.Skipped++
+		}
+	}
+	return counts
+}
+
+func failedScenarios(scenarios []ScenarioResultModel) []ScenarioResultModel {
+	return scenariosWithStatus(scenarios, statusFailed)
+}
+
+func scenariosWithStatus(scenarios []ScenarioResultModel, status string) []ScenarioResultModel {
+	matching := []ScenarioResultModel{}
+	for _, scenario := range scenarios {
+		if scenario.Status == status {
+			matching = append(matching, scenario)
+		}
+	}
+	return matching
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/log"
+	"github.com/kballard/go-shellquote"
+)
+
+const reproCommandOutputKey = "BITRISE_CALABASH_REPRO_COMMAND"
+
+// repoRootDir returns the dir the repository is checked out to, the repro command's paths are relative to it.
+func repoRootDir() string {
+	if dir := os.Getenv("BITRISE_SOURCE_DIR"); dir != "" {
+		if absDir, err := filepath.Abs(dir); err == nil {
+			return absDir
+		}
+	}
+	if dir, err := os.Getwd(); err == nil {
+		return dir
+	}
+	return ""
+}
+
+// reproPath returns the path relative to the base dir if the path is inside the repository, otherwise as it is.
+func reproPath(pth, baseDir, repoDir string) string {
+	absPth, err := filepath.Abs(pth)
+	if err != nil || repoDir == "" || !isPathInside(absPth, repoDir) {
+		return pth
+	}
+	if rel, err := filepath.Rel(baseDir, absPth); err == nil {
+		return rel
+	}
+	return pth
+}
+
+// reproDeviceTarget returns the simulator as calabash accepts it by name, like `iPhone 6 (11.4)`,
+// the simulator's UDID is specific to the CI machine.
+func (ctx *StepContext) reproDeviceTarget() string {
+	version := osVersionNumber(ctx.SimulatorOsVersion)
+	if version == "" {
+		return ctx.Simulator.Name
+	}
+	return fmt.Sprintf("%s (%s)", ctx.Simulator.Name, version)
+}
+
+// reproCommand returns the shell snippet running the step's cucumber command on a local machine, from the repository root:
+// it changes to the work dir, and runs the command with the step's envs, the repository paths relative to the work dir.
+// Secret env values are masked.
+func (ctx *StepContext) reproCommand(args []string) string {
+	repoDir := repoRootDir()
+
+	envs := []string{"DEVICE_TARGET=" + ctx.reproDeviceTarget()}
+	if ctx.AppPath != "" {
+		envs = append(envs, "APP="+reproPath(ctx.AppPath, ctx.WorkDir, repoDir))
+	}
+	if ctx.UseBundler && ctx.cucumberRequiresBundleGemfile() {
+		envs = append(envs, "BUNDLE_GEMFILE="+reproPath(ctx.GemFilePath, ctx.WorkDir, repoDir))
+	}
+	envs = append(envs, ctx.appLaunchEnvs()...)
+
+	commandLine := []string{}
+	for _, env := range envs {
+		key, value := splitEnv(env)
+		commandLine = append(commandLine, key+"="+shellquote.Join(maskSecret(key, value)))
+	}
+	commandLine = append(commandLine, shellquote.Join(args...))
+
+	lines := []string{}
+	if workDir := reproPath(ctx.WorkDir, repoDir, repoDir); workDir != "." {
+		lines = append(lines, "cd "+shellquote.Join(workDir))
+	}
+	lines = append(lines, strings.Join(commandLine, " "))
+	return strings.Join(lines, "\n")
+}
+
+// printReproCommand prints and exports the snippet reproducing the cucumber run locally.
+func (ctx *StepContext) printReproCommand(args []string) {
+	repro := ctx.reproCommand(args)
+
+	fmt.Println()
+	log.Printf("To reproduce this run locally, run from the repository root:")
+	log.Printf("--- repro command ---")
+	log.Printf("%s", repro)
+	log.Printf("--- end of repro command ---")
+
+	exportOutput(reproCommandOutputKey, repro)
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/command"
+	"github.com/bitrise-io/go-utils/fileutil"
+	"github.com/bitrise-io/go-utils/log"
+	"github.com/bitrise-io/go-utils/pathutil"
+)
+
+const (
+	rerunFileOutputKey = "BITRISE_CALABASH_RERUN_FILE_PATH"
+	rerunFileName      = "calabash_rerun.txt"
+)
+
+// rerunFormatterOutPath returns the absolute path of the --out option of the `--format rerun` option,
+// empty if the rerun formatter is not used.
+func (ctx *StepContext) rerunFormatterOutPath() string {
+	if pths := ctx.cucumberFormatterOutPaths("rerun"); len(pths) > 0 {
+		return pths[len(pths)-1]
+	}
+	return ""
+}
+
+// exportRerunFile copies the rerun formatter's output, listing the scenarios still failing after the last attempt,
+// into the deploy dir, so a follow-up run can run only those scenarios with the rerun_file input.
+func (ctx *StepContext) exportRerunFile() {
+	// cucumber did not run
+	if ctx.JSONReportPath == "" {
+		return
+	}
+
+	rerunPth := ctx.rerunFormatterOutPath()
+	if rerunPth == "" {
+		return
+	}
+
+	if exist, err := pathutil.IsPathExists(rerunPth); err != nil {
+		log.Warnf("Failed to check if rerun file exists at (%s), error: %s", rerunPth, err)
+		return
+	} else if !exist {
+		log.Warnf("Rerun file not found at: %s", rerunPth)
+		return
+	}
+
+	pth := filepath.Join(resultsSubdir(resultsReportsDirName), rerunFileName)
+	if err := command.CopyFile(rerunPth, pth); err != nil {
+		log.Warnf("Failed to copy rerun file (%s), error: %s", rerunPth, err)
+		return
+	}
+
+	fmt.Println()
+	log.Printf("Rerun file exported to: %s", pth)
+	exportOutput(rerunFileOutputKey, pth)
+}
+
+// rerunFileHasScenarios reports whether the rerun file lists any scenario.
+func rerunFileHasScenarios(pth string) (bool, error) {
+	content, err := fileutil.ReadStringFromFile(pth)
+	if err != nil {
+		return false, err
+	}
+	return strings.TrimSpace(content) != "", nil
+}
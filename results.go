@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/bitrise-io/go-utils/command"
+	"github.com/bitrise-io/go-utils/log"
+	"github.com/bitrise-io/go-utils/pathutil"
+)
+
+const resultsDirOutputKey = "BITRISE_CALABASH_RESULTS_DIR"
+
+// Subdirs of the results dir
+const (
+	resultsReportsDirName      = "reports"
+	resultsScreenshotsDirName  = "screenshots"
+	resultsLogsDirName         = "logs"
+	resultsSummaryDirName      = "summary"
+	resultsAppContainerDirName = "app_container"
+	resultsPreferencesDirName  = "simulator_preferences"
+)
+
+var resultsDirNameUnsafeCharsExp = regexp.MustCompile(`[^A-Za-z0-9.]+`)
+
+// resultsDirName returns the name of the run's results dir, for example: calabash_results_42_20190102-150405_iPhone-8_iOS-12.1.
+func resultsDirName(build string, startTime time.Time, device, osVersion string) string {
+	simulator := strings.Trim(resultsDirNameUnsafeCharsExp.ReplaceAllString(device, "-"), "-")
+	if osVersion != "" {
+		simulator += "_" + strings.Trim(resultsDirNameUnsafeCharsExp.ReplaceAllString(osVersion, "-"), "-")
+	}
+	return fmt.Sprintf("calabash_results_%s_%s_%s", build, startTime.Format("20060102-150405"), simulator)
+}
+
+var resultsDirPath string
+
+// createResultsDir creates the run's results dir in the results_dir input's dir or in the deploy dir,
+// falls back to a temporary dir if it can not be created.
+// A run appended to a previous run's results dir (append_to_results_dir) gets its next run_<n> subdir.
+func createResultsDir(configs ConfigsModel) {
+	if configs.AppendToResultsDir != "" {
+		if createAppendedResultsDir(configs.AppendToResultsDir) {
+			return
+		}
+	}
+
+	parent := configs.ResultsDir
+	if parent == "" {
+		parent = deployDir()
+	} else if pth, err := pathutil.AbsPath(parent); err != nil {
+		log.Warnf("Failed to expand ResultsDir (%s), error: %s", parent, err)
+	} else {
+		parent = pth
+	}
+
+	device, osVersion := configs.SimulatorDevice, configs.SimulatorOsVersion
+	if name := strings.TrimSpace(configs.TestRunName); name != "" {
+		device, osVersion = name, ""
+	} else if strings.TrimSpace(configs.SimulatorDevices) != "" {
+		device = "device matrix"
+	}
+
+	dir := filepath.Join(parent, resultsDirName(diagnosticsBuild(), time.Now(), device, osVersion))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		log.Warnf("Failed to create results dir (%s), error: %s", dir, err)
+
+		dir, err = pathutil.NormalizedOSTempDirPath("_calabash_results_")
+		if err != nil {
+			log.Warnf("Failed to create tmp dir, error: %s", err)
+			dir = os.TempDir()
+		}
+		log.Warnf("Using results dir: %s", dir)
+	}
+
+	resultsDirPath = dir
+
+	log.Printf("Results dir: %s", dir)
+	exportOutput(resultsDirOutputKey, dir)
+}
+
+// resultsDir returns the run's results dir, the deploy dir if it is not created yet.
+func resultsDir() string {
+	if resultsDirPath != "" {
+		return resultsDirPath
+	}
+	return deployDir()
+}
+
+// resultsSubdir returns the given subdir of the results dir, the results dir itself if the subdir can not be created.
+func resultsSubdir(elems ...string) string {
+	dir := filepath.Join(append([]string{resultsDir()}, elems...)...)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		log.Warnf("Failed to create dir (%s), error: %s", dir, err)
+		return resultsDir()
+	}
+	return dir
+}
+
+// runResultsPath returns the path of the current run (language or device, and attempt) within the reports or screenshots subdir,
+// so the files of retries, languages and devices do not overwrite each other.
+func (ctx *StepContext) runResultsPath() string {
+	attempt := fmt.Sprintf("attempt_%d", ctx.Attempt)
+	if ctx.Locale != "" {
+		return filepath.Join(ctx.Locale, attempt)
+	}
+	if ctx.Device != "" {
+		return filepath.Join(deviceResultsPath(ctx.Device), attempt)
+	}
+	return attempt
+}
+
+// collectReportFiles copies the files of the --out options into the run's reports dir.
+func (ctx *StepContext) collectReportFiles() {
+	dir := resultsSubdir(resultsReportsDirName, ctx.runResultsPath())
+
+	for _, report := range ctx.cucumberOutPaths() {
+		if exist, err := pathutil.IsPathExists(report); err != nil || !exist {
+			continue
+		}
+		// the formatter wrote right into the reports dir
+		if filepath.Dir(report) == dir {
+			continue
+		}
+
+		// formatters like junit write a dir of reports
+		var err error
+		if isDir, _ := pathutil.IsDirExists(report); isDir {
+			err = command.CopyDir(report, dir, false)
+		} else {
+			err = command.CopyFile(report, filepath.Join(dir, filepath.Base(report)))
+		}
+		if err != nil {
+			log.Warnf("Failed to copy report (%s), error: %s", report, err)
+		}
+	}
+}
@@ -0,0 +1,388 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bitrise-io/go-utils/fileutil"
+	"github.com/bitrise-io/go-utils/log"
+	"github.com/bitrise-io/go-utils/pathutil"
+)
+
+// Combined results of the runs appended to a results dir (append_to_results_dir)
+const (
+	appendedRunDirPrefix         = "run_"
+	combinedResultsDirName       = "combined"
+	combinedSummaryFileName      = "calabash_combined_summary.json"
+	combinedMarkdownFileName     = "calabash_combined_results.md"
+	combinedJUnitFileName        = "calabash_combined_junit.xml"
+	combinedSummaryFormatVersion = "1.0.0"
+)
+
+// Combined results outputs
+const (
+	combinedSummaryOutputKey     = "BITRISE_CALABASH_COMBINED_SUMMARY_JSON_PATH"
+	combinedMarkdownOutputKey    = "BITRISE_CALABASH_COMBINED_RESULTS_MARKDOWN_PATH"
+	combinedJUnitOutputKey       = "BITRISE_CALABASH_COMBINED_JUNIT_PATH"
+	combinedPassedCountOutputKey = "BITRISE_CALABASH_COMBINED_PASSED_COUNT"
+	combinedFailedCountOutputKey = "BITRISE_CALABASH_COMBINED_FAILED_COUNT"
+	combinedTotalCountOutputKey  = "BITRISE_CALABASH_COMBINED_TOTAL_COUNT"
+)
+
+var (
+	appendedRunDirExp = regexp.MustCompile(`^run_(\d+)$`)
+
+	// junitXMLDeclarationExp and junitTestsuitesExp match the parts of a junit report dropped when the reports are merged
+	junitXMLDeclarationExp = regexp.MustCompile(`<\?xml[^>]*\?>`)
+	junitTestsuitesExp     = regexp.MustCompile(`</?testsuites\b[^>]*>`)
+)
+
+// resultsAppendDirPath is the results dir of a previous run the current run is appended to.
+var resultsAppendDirPath string
+
+// appendedRunSummaryPath returns the run summary's path within the results dir of a run.
+func appendedRunSummaryPath(runDir string) string {
+	return filepath.Join(runDir, resultsSummaryDirName, runSummaryFileName)
+}
+
+// checkAppendResultsDir checks that the dir is the results dir of a previous run, holding its run summary.
+func checkAppendResultsDir(dir string) error {
+	if exist, err := pathutil.IsDirExists(dir); err != nil {
+		return fmt.Errorf("failed to check if AppendToResultsDir exist, error: %s", err)
+	} else if !exist {
+		return fmt.Errorf("AppendToResultsDir directory not exists at: %s", dir)
+	}
+
+	if exist, err := pathutil.IsPathExists(appendedRunSummaryPath(dir)); err != nil {
+		return fmt.Errorf("failed to check if the run summary exists in AppendToResultsDir, error: %s", err)
+	} else if !exist {
+		return fmt.Errorf("AppendToResultsDir (%s) is not a results dir, %s not found in it", dir, filepath.Join(resultsSummaryDirName, runSummaryFileName))
+	}
+	return nil
+}
+
+// appendedRunDirs returns the results dirs of the runs in the results dir ordered by their run numbers:
+// the results dir itself is the first run, the appended runs are its run_<n> subdirs.
+func appendedRunDirs(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	numbers := []int{}
+	for _, entry := range entries {
+		if match := appendedRunDirExp.FindStringSubmatch(entry.Name()); entry.IsDir() && len(match) == 2 {
+			if number, err := strconv.Atoi(match[1]); err == nil {
+				numbers = append(numbers, number)
+			}
+		}
+	}
+	sort.Ints(numbers)
+
+	dirs := []string{dir}
+	for _, number := range numbers {
+		dirs = append(dirs, filepath.Join(dir, fmt.Sprintf("%s%d", appendedRunDirPrefix, number)))
+	}
+	return dirs, nil
+}
+
+// nextAppendedRunDir returns the results dir of the next run appended to the results dir.
+func nextAppendedRunDir(dir string) (string, error) {
+	dirs, err := appendedRunDirs(dir)
+	if err != nil {
+		return "", err
+	}
+
+	// the results dir itself is the first run
+	next := 2
+	if len(dirs) > 1 {
+		match := appendedRunDirExp.FindStringSubmatch(filepath.Base(dirs[len(dirs)-1]))
+		last, _ := strconv.Atoi(match[1])
+		next = last + 1
+	}
+	return filepath.Join(dir, fmt.Sprintf("%s%d", appendedRunDirPrefix, next)), nil
+}
+
+// createAppendedResultsDir creates the run's results dir as the next run_<n> subdir of the previous run's results dir,
+// BITRISE_CALABASH_RESULTS_DIR stays the previous run's dir, so a later run can be appended to it as well.
+// Returns false if the dir is not a results dir or the subdir can not be created, the validation reports the invalid input.
+func createAppendedResultsDir(appendDir string) bool {
+	root, err := pathutil.AbsPath(appendDir)
+	if err != nil {
+		log.Warnf("Failed to expand AppendToResultsDir (%s), error: %s", appendDir, err)
+		return false
+	}
+	if err := checkAppendResultsDir(root); err != nil {
+		log.Warnf("%s", err)
+		return false
+	}
+
+	dir, err := nextAppendedRunDir(root)
+	if err == nil {
+		err = os.MkdirAll(dir, 0755)
+	}
+	if err != nil {
+		log.Warnf("Failed to create results dir in (%s), error: %s", root, err)
+		return false
+	}
+
+	resultsDirPath = dir
+	resultsAppendDirPath = root
+
+	log.Printf("Results dir: %s, appended to: %s", dir, root)
+	exportOutput(resultsDirOutputKey, root)
+	return true
+}
+
+// CombinedRunModel is a run found in the results dir, an incompatible run is listed but not merged.
+type CombinedRunModel struct {
+	Dir           string              `json:"dir"`
+	TestRunName   string              `json:"test_run_name,omitempty"`
+	FormatVersion string              `json:"format_version,omitempty"`
+	StepVersion   string              `json:"step_version,omitempty"`
+	Scenarios     ScenarioCountsModel `json:"scenarios"`
+	ExitCode      int                 `json:"exit_code"`
+	Included      bool                `json:"included"`
+	Note          string              `json:"note,omitempty"`
+}
+
+// CombinedSummaryModel is the schema of calabash_combined_summary.json, the merged results of the runs in the results dir,
+// bump combinedSummaryFormatVersion on every incompatible change.
+type CombinedSummaryModel struct {
+	FormatVersion   string                       `json:"format_version"`
+	Runs            []CombinedRunModel           `json:"runs"`
+	TotalDurationMs int64                        `json:"total_duration_ms"`
+	Scenarios       ScenarioCountsModel          `json:"scenarios"`
+	Features        []FeatureSummaryModel        `json:"features"`
+	FailedScenarios []FailedScenarioSummaryModel `json:"failed_scenarios"`
+	ExitCode        int                          `json:"exit_code"`
+}
+
+// Label returns the run's test run name, or its dir within the results dir.
+func (run CombinedRunModel) Label() string {
+	if run.TestRunName != "" {
+		return run.TestRunName
+	}
+	return run.Dir
+}
+
+func addScenarioCounts(counts *ScenarioCountsModel, other ScenarioCountsModel) {
+	counts.Total += other.Total
+	counts.Passed += other.Passed
+	counts.Failed += other.Failed
+	counts.Skipped += other.Skipped
+	counts.Pending += other.Pending
+	counts.Undefined += other.Undefined
+}
+
+// versionMismatchNote returns why the run's summary differs from the current format or the current step version,
+// the runs of a different minor format version or step version are merged, but reported.
+func versionMismatchNote(summary RunSummaryModel) string {
+	notes := []string{}
+	if summary.FormatVersion != runSummaryFormatVersion {
+		notes = append(notes, fmt.Sprintf("format_version %s differs from %s", summary.FormatVersion, runSummaryFormatVersion))
+	}
+	if summary.StepVersion != Version {
+		notes = append(notes, fmt.Sprintf("step_version %s differs from %s", summary.StepVersion, Version))
+	}
+	return strings.Join(notes, ", ")
+}
+
+// combineRunSummaries merges the run summaries of the run dirs: the scenario counts are summed,
+// the features of the same uri are merged. The summaries of an incompatible format version are not merged.
+func combineRunSummaries(root string, runDirs []string) CombinedSummaryModel {
+	combined := CombinedSummaryModel{
+		FormatVersion:   combinedSummaryFormatVersion,
+		Runs:            []CombinedRunModel{},
+		Features:        []FeatureSummaryModel{},
+		FailedScenarios: []FailedScenarioSummaryModel{},
+	}
+	featureIndexes := map[string]int{}
+
+	for _, runDir := range runDirs {
+		rel, err := filepath.Rel(root, runDir)
+		if err != nil {
+			rel = runDir
+		}
+		run := CombinedRunModel{Dir: rel}
+
+		summary, err := readBaselineSummary(appendedRunSummaryPath(runDir))
+		if err != nil {
+			run.Note = fmt.Sprintf("not merged: %s", err)
+			combined.Runs = append(combined.Runs, run)
+			continue
+		}
+
+		run.TestRunName = summary.TestRunName
+		run.FormatVersion = summary.FormatVersion
+		run.StepVersion = summary.StepVersion
+		run.Scenarios = summary.Scenarios
+		run.ExitCode = summary.ExitCode
+		run.Included = true
+		run.Note = versionMismatchNote(summary)
+		combined.Runs = append(combined.Runs, run)
+
+		combined.TotalDurationMs += summary.TotalDurationMs
+		addScenarioCounts(&combined.Scenarios, summary.Scenarios)
+		for _, feature := range summary.Features {
+			idx, ok := featureIndexes[feature.URI]
+			if !ok {
+				idx = len(combined.Features)
+				featureIndexes[feature.URI] = idx
+				combined.Features = append(combined.Features, FeatureSummaryModel{Name: feature.Name, URI: feature.URI})
+			}
+			combined.Features[idx].DurationMs += feature.DurationMs
+			addScenarioCounts(&combined.Features[idx].Scenarios, feature.Scenarios)
+		}
+		combined.FailedScenarios = append(combined.FailedScenarios, summary.FailedScenarios...)
+		if combined.ExitCode == 0 {
+			combined.ExitCode = summary.ExitCode
+		}
+	}
+	return combined
+}
+
+// Markdown returns the combined verdict with the runs and the per-feature results as Markdown tables.
+func (combined CombinedSummaryModel) Markdown() string {
+	merged := RunSummaryModel{
+		TotalDurationMs: combined.TotalDurationMs,
+		Scenarios:       combined.Scenarios,
+		Features:        combined.Features,
+		ExitCode:        combined.ExitCode,
+	}
+
+	lines := []string{
+		fmt.Sprintf("### Combined results of %d run(s)", len(combined.Runs)),
+		"",
+		"| Run | Scenarios | Passed | Failed | Exit code | Note |",
+		"| --- | ---: | ---: | ---: | ---: | --- |",
+	}
+	for _, run := range combined.Runs {
+		lines = append(lines, fmt.Sprintf("| %s | %d | %d | %d | %d | %s |",
+			escapeMarkdownTableCell(run.Label()), run.Scenarios.Total, run.Scenarios.Passed, run.Scenarios.Failed, run.ExitCode, escapeMarkdownTableCell(run.Note)))
+	}
+
+	return strings.Join(lines, "\n") + "\n\n" + merged.Markdown()
+}
+
+// combineJUnitReports merges the junit reports (xml files) of the run dirs' reports into a single testsuites report,
+// the testsuite names are prefixed with the run's test run name. Returns false if no junit report is found.
+func combineJUnitReports(runDirs []string, runs []CombinedRunModel) (string, bool, error) {
+	suites := []string{}
+	for i, runDir := range runDirs {
+		if !runs[i].Included {
+			continue
+		}
+
+		reportsDir := filepath.Join(runDir, resultsReportsDirName)
+		if exist, err := pathutil.IsDirExists(reportsDir); err != nil || !exist {
+			continue
+		}
+
+		if err := filepath.Walk(reportsDir, func(pth string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.IsDir() || filepath.Ext(pth) != ".xml" {
+				return nil
+			}
+
+			content, err := fileutil.ReadStringFromFile(pth)
+			if err != nil {
+				return err
+			}
+			if !strings.Contains(content, "<testsuite") {
+				return nil
+			}
+
+			content = junitTestsuitesExp.ReplaceAllString(junitXMLDeclarationExp.ReplaceAllString(content, ""), "")
+			if runs[i].TestRunName != "" {
+				content = prefixJUnitTestsuiteNames(content, runs[i].TestRunName)
+			}
+			suites = append(suites, strings.TrimSpace(content))
+			return nil
+		}); err != nil {
+			return "", false, err
+		}
+	}
+	if len(suites) == 0 {
+		return "", false, nil
+	}
+
+	return "<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n<testsuites>\n" + strings.Join(suites, "\n") + "\n</testsuites>\n", true, nil
+}
+
+// writeCombinedResults merges the results of every run in the results dir the current run is appended to,
+// and writes the combined summary, Markdown results and junit report into its combined dir.
+// Runs of an incompatible format version are reported and left out, the current run's outcome does not change.
+func writeCombinedResults() {
+	if resultsAppendDirPath == "" {
+		return
+	}
+
+	fmt.Println()
+	log.Infof("Combining the results of the runs in: %s", resultsAppendDirPath)
+
+	runDirs, err := appendedRunDirs(resultsAppendDirPath)
+	if err != nil {
+		log.Warnf("Failed to list the runs of the results dir (%s), error: %s", resultsAppendDirPath, err)
+		return
+	}
+	combined := combineRunSummaries(resultsAppendDirPath, runDirs)
+
+	for _, run := range combined.Runs {
+		if !run.Included {
+			log.Warnf("Run (%s) %s", run.Label(), run.Note)
+		} else if run.Note != "" {
+			log.Warnf("Run (%s) merged, but its %s", run.Label(), run.Note)
+		}
+	}
+
+	dir := filepath.Join(resultsAppendDirPath, combinedResultsDirName)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		log.Warnf("Failed to create dir (%s), error: %s", dir, err)
+		return
+	}
+
+	if b, err := json.MarshalIndent(combined, "", "  "); err != nil {
+		log.Warnf("Failed to serialize the combined summary, error: %s", err)
+	} else if pth := filepath.Join(dir, combinedSummaryFileName); fileutil.WriteBytesToFile(pth, b) != nil {
+		log.Warnf("Failed to write the combined summary (%s)", pth)
+	} else {
+		log.Printf("Combined summary: %s", pth)
+		exportOutput(combinedSummaryOutputKey, pth)
+	}
+
+	if pth := filepath.Join(dir, combinedMarkdownFileName); fileutil.WriteStringToFile(pth, combined.Markdown()) != nil {
+		log.Warnf("Failed to write the combined results markdown (%s)", pth)
+	} else {
+		log.Printf("Combined results markdown: %s", pth)
+		exportOutput(combinedMarkdownOutputKey, pth)
+	}
+
+	if junit, found, err := combineJUnitReports(runDirs, combined.Runs); err != nil {
+		log.Warnf("Failed to merge the junit reports, error: %s", err)
+	} else if found {
+		if pth := filepath.Join(dir, combinedJUnitFileName); fileutil.WriteStringToFile(pth, junit) != nil {
+			log.Warnf("Failed to write the combined junit report (%s)", pth)
+		} else {
+			log.Printf("Combined junit report: %s", pth)
+			exportOutput(combinedJUnitOutputKey, pth)
+		}
+	}
+
+	counts := combined.Scenarios
+	log.Donef("Combined %d run(s): %d scenarios, %d passed, %d failed, in %s", len(combined.Runs), counts.Total, counts.Passed, counts.Failed,
+		roundDuration(time.Duration(combined.TotalDurationMs)*time.Millisecond))
+
+	exportOutput(combinedPassedCountOutputKey, fmt.Sprintf("%d", counts.Passed))
+	exportOutput(combinedFailedCountOutputKey, fmt.Sprintf("%d", counts.Failed))
+	exportOutput(combinedTotalCountOutputKey, fmt.Sprintf("%d", counts.Total))
+}
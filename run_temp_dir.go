@@ -0,0 +1,92 @@
+package main
+
+import (
+	"archive/zip"
+	"os"
+	"path/filepath"
+
+	"github.com/bitrise-io/go-utils/log"
+	"github.com/bitrise-io/go-utils/pathutil"
+)
+
+const runTempDirArchiveFileName = "calabash_temp_dir.zip"
+
+// runTempDirPath is the run's temp root, the intermediate files of the run (the monotouch converted app,
+// the generated support files, the downloaded installers) are nested under it, empty if it could not be created.
+var runTempDirPath string
+
+// createRunTempDir creates the run's temp root. It is removed when the step succeeds,
+// and kept for debugging (or archived into the results dir, if archiveOnFailure is set) when the step fails.
+func createRunTempDir(archiveOnFailure bool) {
+	dir, err := pathutil.NormalizedOSTempDirPath("_calabash_run_")
+	if err != nil {
+		log.Warnf("Failed to create the run temp dir, error: %s", err)
+		return
+	}
+
+	runTempDirPath = dir
+	runSummary.TempDir = dir
+	log.Printf("Run temp dir: %s", dir)
+
+	registerCleanup("run temp dir", func() error {
+		return cleanUpRunTempDir(archiveOnFailure)
+	})
+}
+
+func cleanUpRunTempDir(archiveOnFailure bool) error {
+	if runSummary.ExitCode == 0 {
+		return os.RemoveAll(runTempDirPath)
+	}
+
+	if !archiveOnFailure {
+		log.Warnf("The step failed, keeping the run temp dir for debugging: %s", runTempDirPath)
+		return nil
+	}
+
+	pth := filepath.Join(resultsDir(), runTempDirArchiveFileName)
+	if err := writeDirZip(runTempDirPath, pth, "calabash_temp_dir"); err != nil {
+		log.Warnf("Failed to archive the run temp dir, keeping it for debugging: %s", runTempDirPath)
+		return err
+	}
+
+	runSummary.TempDirArchive = pth
+	log.Printf("The step failed, the run temp dir is archived to: %s", pth)
+	return os.RemoveAll(runTempDirPath)
+}
+
+// writeDirZip packages the dir into the zip file at pth, under the given name.
+func writeDirZip(dir, pth, name string) error {
+	f, err := os.Create(pth)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := f.Close(); err != nil {
+			log.Warnf("Failed to close file (%s), error: %s", pth, err)
+		}
+	}()
+
+	w := zip.NewWriter(f)
+	if err := addPathToZip(w, dir, name); err != nil {
+		return err
+	}
+	return w.Close()
+}
+
+// createTempDir creates a dir for the intermediate files of the attempt under the run temp root,
+// like <run temp dir>/[<locale>/]attempt_<n>/<name>.
+func (ctx *StepContext) createTempDir(name string) (string, error) {
+	if runTempDirPath == "" {
+		dir, err := pathutil.NormalizedOSTempDirPath("_calabash_" + name + "_")
+		if err != nil {
+			return "", err
+		}
+		registerCleanup("tmp dir", func() error {
+			return os.RemoveAll(dir)
+		})
+		return dir, nil
+	}
+
+	dir := filepath.Join(runTempDirPath, ctx.runResultsPath(), name)
+	return dir, os.MkdirAll(dir, 0755)
+}
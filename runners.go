@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bitrise-io/go-utils/command"
+	"github.com/bitrise-io/go-utils/log"
+)
+
+// testRunnerNames are the processes, which drive the app under test on the simulator,
+// a runner left behind by a failed attempt occupies the DeviceAgent port and the XCTest session.
+var testRunnerNames = []string{"DeviceAgent", "XCTRunner", "testmanagerd"}
+
+const (
+	testRunnerExitTimeout      = 10 * time.Second
+	testRunnerExitPollInterval = 500 * time.Millisecond
+)
+
+// testRunnersSimulatorUDID is the simulator, which the current attempt started cucumber on, empty if no runner could be started yet.
+var testRunnersSimulatorUDID string
+
+// TestRunnerProcessModel ...
+type TestRunnerProcessModel struct {
+	PID     int
+	Name    string
+	Command string
+}
+
+// parseTestRunnerProcesses returns the test runners of the simulator from the `ps -axo pid=,command=` output.
+func parseTestRunnerProcesses(out, udid string) []TestRunnerProcessModel {
+	runners := []TestRunnerProcessModel{}
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+
+		pid, err := strconv.Atoi(fields[0])
+		if err != nil {
+			continue
+		}
+
+		cmdLine := strings.Join(fields[1:], " ")
+		if !strings.Contains(cmdLine, udid) {
+			continue
+		}
+
+		for _, name := range testRunnerNames {
+			if strings.Contains(cmdLine, name) {
+				runners = append(runners, TestRunnerProcessModel{PID: pid, Name: name, Command: cmdLine})
+				break
+			}
+		}
+	}
+	return runners
+}
+
+func listTestRunners(udid string) ([]TestRunnerProcessModel, error) {
+	cmd := command.New("ps", "-axo", "pid=,command=")
+	out, err := commandRecorder.RunAndReturnTrimmedCombinedOutput(cmd)
+	if err != nil {
+		return nil, fmt.Errorf("%s failed, output: %s, error: %s", cmd.PrintableCommandArgs(), out, err)
+	}
+	return parseTestRunnerProcesses(out, udid), nil
+}
+
+func killTestRunners(signal string, runners []TestRunnerProcessModel) error {
+	args := []string{"-" + signal}
+	for _, runner := range runners {
+		args = append(args, strconv.Itoa(runner.PID))
+	}
+
+	cmd := command.New("kill", args...)
+	printCommand(cmd)
+
+	if out, err := commandRecorder.RunAndReturnTrimmedCombinedOutput(cmd); err != nil {
+		return fmt.Errorf("%s failed, output: %s, error: %s", cmd.PrintableCommandArgs(), out, err)
+	}
+	return nil
+}
+
+// waitForTestRunnersExit polls the simulator's test runners until all of them exit or the timeout passes,
+// returns the runners still running.
+func waitForTestRunnersExit(udid string, timeout time.Duration) ([]TestRunnerProcessModel, error) {
+	var runners []TestRunnerProcessModel
+	for start := time.Now(); time.Since(start) < timeout; {
+		time.Sleep(testRunnerExitPollInterval)
+
+		var err error
+		if runners, err = listTestRunners(udid); err != nil {
+			return nil, err
+		} else if len(runners) == 0 {
+			return nil, nil
+		}
+	}
+	return runners, nil
+}
+
+// terminateTestRunners terminates the DeviceAgent, XCTRunner and testmanagerd processes of the simulator
+// and waits for them to exit, the runners ignoring the termination are killed.
+func terminateTestRunners(udid string) error {
+	fmt.Println()
+	log.Infof("Terminating leftover test runners...")
+
+	runners, err := listTestRunners(udid)
+	if err != nil {
+		return err
+	}
+	if len(runners) == 0 {
+		log.Printf("No test runner left running on the simulator (%s)", udid)
+		return nil
+	}
+
+	log.Printf("Test runners left running on the simulator (%s):", udid)
+	for _, runner := range runners {
+		log.Printf("- %s (%d): %s", runner.Name, runner.PID, runner.Command)
+	}
+
+	if err := killTestRunners("TERM", runners); err != nil {
+		log.Warnf("%s", err)
+	}
+
+	remaining, err := waitForTestRunnersExit(udid, testRunnerExitTimeout)
+	if err != nil {
+		return err
+	}
+	if len(remaining) > 0 {
+		log.Warnf("%d test runner(s) did not exit in %s, killing them", len(remaining), testRunnerExitTimeout)
+
+		if err := killTestRunners("KILL", remaining); err != nil {
+			return err
+		}
+	}
+
+	log.Donef("Terminated %d test runner(s)", len(runners))
+	return nil
+}
+
+// terminateLeftoverTestRunners terminates the test runners started by the current attempt,
+// used before a retry and when the step fails or times out. Failures are logged as warnings only.
+func terminateLeftoverTestRunners() {
+	udid := testRunnersSimulatorUDID
+	if udid == "" {
+		return
+	}
+	testRunnersSimulatorUDID = ""
+
+	if err := terminateTestRunners(udid); err != nil {
+		log.Warnf("Failed to terminate the leftover test runners, error: %s", err)
+	}
+}
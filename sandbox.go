@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/command"
+	"github.com/bitrise-io/go-utils/log"
+	shellquote "github.com/kballard/go-shellquote"
+)
+
+const (
+	calabashSandboxCommand = "calabash-sandbox"
+	// calabashSandboxInstallerURL is the official calabash-sandbox installer,
+	// it installs the sandbox into ~/.calabash/sandbox and the calabash-sandbox command into /usr/local/bin.
+	calabashSandboxInstallerURL = "https://raw.githubusercontent.com/calabash/install/master/install-osx.sh"
+)
+
+var sandboxCalabashCucumberVersionExp = regexp.MustCompile(`(?m)^\s*calabash-cucumber\s*:?\s*v?(\d+(?:\.[0-9A-Za-z]+)*)`)
+
+// parseSandboxCalabashCucumberVersion returns the calabash-cucumber version of the `calabash-sandbox version` output,
+// like `calabash-cucumber: 0.21.10`.
+func parseSandboxCalabashCucumberVersion(out string) string {
+	if match := sandboxCalabashCucumberVersionExp.FindStringSubmatch(out); len(match) == 2 {
+		return match[1]
+	}
+	return ""
+}
+
+// installCalabashSandbox downloads and runs the official calabash-sandbox installer.
+func (ctx *StepContext) installCalabashSandbox() error {
+	tmpDir, err := ctx.createTempDir("sandbox_installer")
+	if err != nil {
+		return fmt.Errorf("failed to create tmp dir, error: %s", err)
+	}
+	installerPth := filepath.Join(tmpDir, "install-osx.sh")
+
+	for _, cmd := range []*command.Model{
+		command.New("curl", "-fsSL", "-o", installerPth, calabashSandboxInstallerURL),
+		command.New("bash", installerPth),
+	} {
+		cmd.SetStdout(stepLogger).SetStderr(stepLogger)
+		if err := runCommand(cmd); err != nil {
+			return fmt.Errorf("%s failed, error: %s", cmd.PrintableCommandArgs(), err)
+		}
+	}
+	return nil
+}
+
+// setUpCalabashSandbox looks up calabash-sandbox, installs it if install is set and it is not installed yet,
+// then checks the sandbox's calabash-cucumber version against the calabash_cucumber_version input.
+func (ctx *StepContext) setUpCalabashSandbox(install bool) error {
+	pth, err := exec.LookPath(calabashSandboxCommand)
+	if err != nil {
+		if !install {
+			return newStepError(categoryDependencyInstall, "%s is not installed, run the step in prepare_only mode first", calabashSandboxCommand)
+		}
+
+		log.Printf("%s not found, installing it...", calabashSandboxCommand)
+		if err := ctx.installCalabashSandbox(); err != nil {
+			return newStepError(categoryDependencyInstall, "Failed to install %s, %s", calabashSandboxCommand, err)
+		}
+		if pth, err = exec.LookPath(calabashSandboxCommand); err != nil {
+			return newStepError(categoryDependencyInstall, "%s not found after the install, error: %s", calabashSandboxCommand, err)
+		}
+	}
+	log.Printf("%s: %s", calabashSandboxCommand, pth)
+
+	versionCmd := command.New(calabashSandboxCommand, "version")
+	out, err := commandRecorder.RunAndReturnTrimmedCombinedOutput(versionCmd)
+	if err != nil {
+		return newStepError(categoryDependencyInstall, "%s failed, output: %s, error: %s", versionCmd.PrintableCommandArgs(), out, err)
+	}
+
+	version := parseSandboxCalabashCucumberVersion(out)
+	if version == "" {
+		return newStepError(categoryDependencyInstall, "Failed to determine the calabash-cucumber version of %s, output: %s", calabashSandboxCommand, out)
+	}
+	log.Printf("calabash-cucumber version in %s: %s", calabashSandboxCommand, version)
+
+	if requested := ctx.Configs.CalabashCucumberVersion; requested != "" && !gemVersionsEqual(requested, version) {
+		return newStepError(categoryDependencyInstall, "calabash-cucumber version in %s (%s) does not match calabash_cucumber_version (%s), "+
+			"update the sandbox with `%s update`, or change calabash_cucumber_version", calabashSandboxCommand, version, requested, calabashSandboxCommand)
+	}
+
+	runSummary.Versions.CalabashCucumber = version
+	log.Donef("using calabash-cucumber %s from %s", version, calabashSandboxCommand)
+	return nil
+}
+
+// calabashSandboxCommandModel returns the command running the cucumber command line in the sandbox:
+// calabash-sandbox opens a shell with the sandbox's ruby and gems, the command line is passed on its stdin,
+// the shell exits with cucumber's exit code at the end of the input.
+func calabashSandboxCommandModel(cucumberArgs []string) *command.Model {
+	commandLine := shellquote.Join(cucumberArgs...)
+	log.Printf("Running in %s: %s", calabashSandboxCommand, commandLine)
+
+	return command.New(calabashSandboxCommand).SetStdin(strings.NewReader(commandLine + "\n"))
+}
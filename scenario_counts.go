@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	pendingCountOutputKey   = "BITRISE_CALABASH_PENDING_COUNT"
+	undefinedCountOutputKey = "BITRISE_CALABASH_UNDEFINED_COUNT"
+	skippedCountOutputKey   = "BITRISE_CALABASH_SKIPPED_COUNT"
+)
+
+// exportScenarioCounts exports the number of the pending, undefined and skipped scenarios of the run.
+func exportScenarioCounts(counts ScenarioCountsModel) {
+	exportOutput(pendingCountOutputKey, fmt.Sprintf("%d", counts.Pending))
+	exportOutput(undefinedCountOutputKey, fmt.Sprintf("%d", counts.Undefined))
+	exportOutput(skippedCountOutputKey, fmt.Sprintf("%d", counts.Skipped))
+}
+
+// scenarioStatusFailure returns the failure of the undefined and the pending scenarios, if fail_on_undefined and fail_on_pending is set,
+// listing the scenarios with their locations.
+func scenarioStatusFailure(configs ConfigsModel, summary *RunSummaryModel) error {
+	lines := []string{}
+	if configs.FailOnUndefined == "yes" && len(summary.UndefinedScenarios) > 0 {
+		lines = append(lines, fmt.Sprintf("%d undefined scenario(s), fail_on_undefined is set:", len(summary.UndefinedScenarios)))
+		lines = append(lines, scenarioListLines(summary.UndefinedScenarios)...)
+	}
+	if configs.FailOnPending == "yes" && len(summary.PendingScenarios) > 0 {
+		lines = append(lines, fmt.Sprintf("%d pending scenario(s), fail_on_pending is set:", len(summary.PendingScenarios)))
+		lines = append(lines, scenarioListLines(summary.PendingScenarios)...)
+	}
+	if len(lines) == 0 {
+		return nil
+	}
+	return newStepError(categoryTestFailure, "%s", strings.Join(lines, "\n"))
+}
+
+func scenarioListLines(scenarios []ScenarioSummaryModel) []string {
+	lines := []string{}
+	for _, scenario := range scenarios {
+		lines = append(lines, fmt.Sprintf("- %s (%s)", scenario.Name, scenario.Location))
+	}
+	return lines
+}
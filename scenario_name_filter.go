@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// rubyNamedGroupExp matches the `(?<name>` named groups, which Ruby supports, but older Go versions only accept as `(?P<name>`.
+var rubyNamedGroupExp = regexp.MustCompile(`\(\?<([A-Za-z_][A-Za-z0-9_]*)>`)
+
+// parseScenarioNameFilter parses the scenario name patterns, one per line, empty lines are skipped.
+// Every pattern is passed to cucumber as is, so it is only validated: it has to compile with the syntax
+// supported by both Ruby and Go (no lookarounds and backreferences).
+func parseScenarioNameFilter(value string) ([]string, error) {
+	patterns := []string{}
+	for i, line := range strings.Split(value, "\n") {
+		pattern := strings.TrimSpace(line)
+		if pattern == "" {
+			continue
+		}
+
+		if _, err := regexp.Compile(rubyNamedGroupExp.ReplaceAllString(pattern, "(?P<$1>")); err != nil {
+			return nil, fmt.Errorf("invalid ScenarioNameFilter line %d (%s), not a supported regular expression: %s", i+1, pattern, err)
+		}
+		patterns = append(patterns, pattern)
+	}
+	return patterns, nil
+}
+
+// scenarioNameFilterArgs returns a --name argument for every pattern, cucumber runs the scenarios matching any of them.
+func scenarioNameFilterArgs(patterns []string) []string {
+	args := []string{}
+	for _, pattern := range patterns {
+		args = append(args, "--name", pattern)
+	}
+	return args
+}
@@ -0,0 +1,363 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/fileutil"
+	"github.com/bitrise-io/go-utils/log"
+)
+
+const (
+	selectedScenarioCountOutputKey = "BITRISE_CALABASH_SELECTED_SCENARIO_COUNT"
+	totalScenarioCountOutputKey    = "BITRISE_CALABASH_TOTAL_SCENARIO_COUNT"
+
+	defaultMinSelectedScenariosPercent = 25.0
+)
+
+// cucumberSelectorOptions select a subset of the scenarios by tags or names.
+var cucumberSelectorOptions = []string{"--tags", "-t", "--name", "-n"}
+
+// hasCucumberSelectorOption reports whether the options select the scenarios to run by tags or names.
+func hasCucumberSelectorOption(options []string) bool {
+	for _, option := range options {
+		for _, selector := range cucumberSelectorOptions {
+			if option == selector || strings.HasPrefix(option, selector+"=") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// FeatureScenarioModel is a scenario of a feature file, every example row of a scenario outline is a separate scenario.
+type FeatureScenarioModel struct {
+	Name string
+	Tags []string
+}
+
+// parseFeatureScenarios returns the scenarios of the feature file's content with their inherited tags (the feature's,
+// the outline's and the examples' tags), in the same way cucumber counts them.
+func parseFeatureScenarios(content string) []FeatureScenarioModel {
+	scenarios := []FeatureScenarioModel{}
+
+	featureTags, pendingTags := []string{}, []string{}
+	scenarioName, scenarioTags, examplesTags := "", []string{}, []string{}
+	outline, inExamples, headerSeen := false, false, false
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "" || strings.HasPrefix(line, "#"):
+		case strings.HasPrefix(line, "Feature:"):
+			featureTags, pendingTags = pendingTags, []string{}
+		case strings.HasPrefix(line, "Scenario Outline:") || strings.HasPrefix(line, "Scenario Template:"):
+			scenarioName = strings.TrimSpace(line[strings.Index(line, ":")+1:])
+			scenarioTags, pendingTags = append(append([]string{}, featureTags...), pendingTags...), []string{}
+			outline, inExamples = true, false
+		case strings.HasPrefix(line, "Examples:") || strings.HasPrefix(line, "Scenarios:"):
+			examplesTags, pendingTags = append(append([]string{}, scenarioTags...), pendingTags...), []string{}
+			inExamples, headerSeen = outline, false
+		case strings.HasPrefix(line, "Scenario:") || strings.HasPrefix(line, "Example:"):
+			tags := append(append([]string{}, featureTags...), pendingTags...)
+			scenarios = append(scenarios, FeatureScenarioModel{Name: strings.TrimSpace(line[strings.Index(line, ":")+1:]), Tags: tags})
+			pendingTags = []string{}
+			outline, inExamples = false, false
+		case strings.HasPrefix(line, "|") && inExamples:
+			if headerSeen {
+				scenarios = append(scenarios, FeatureScenarioModel{Name: scenarioName, Tags: examplesTags})
+			}
+			headerSeen = true
+		case strings.HasPrefix(line, "@"):
+			for _, tag := range strings.Fields(line) {
+				if strings.HasPrefix(tag, "#") {
+					break
+				}
+				pendingTags = append(pendingTags, tag)
+			}
+		default:
+			inExamples = false
+		}
+	}
+	return scenarios
+}
+
+// tagExpression reports whether a scenario with the tags is selected.
+type tagExpression func(tags map[string]bool) bool
+
+// tagExpressionTokenExp splits a tag expression into parentheses and words.
+var tagExpressionTokenExp = regexp.MustCompile(`[()]|[^\s()]+`)
+
+// parseTagExpression parses the value of a --tags option, both the `@smoke and not @wip` style tag expressions
+// and the legacy `@smoke,~@wip` style (the terms of a comma separated list are or-ed, `~` negates a tag).
+func parseTagExpression(value string) (tagExpression, error) {
+	tokens := tagExpressionTokenExp.FindAllString(value, -1)
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("empty tag expression")
+	}
+
+	if len(tokens) == 1 && tokens[0] != "(" && tokens[0] != ")" {
+		return parseLegacyTagExpression(tokens[0])
+	}
+
+	parser := tagExpressionParser{tokens: tokens}
+	expression, err := parser.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if parser.pos != len(tokens) {
+		return nil, fmt.Errorf("unexpected %s in tag expression (%s)", tokens[parser.pos], value)
+	}
+	return expression, nil
+}
+
+func parseLegacyTagExpression(value string) (tagExpression, error) {
+	terms := []tagExpression{}
+	for _, term := range strings.Split(value, ",") {
+		negated := strings.HasPrefix(term, "~")
+		tag := strings.TrimPrefix(term, "~")
+		if !strings.HasPrefix(tag, "@") {
+			return nil, fmt.Errorf("invalid tag (%s) in tag expression (%s)", term, value)
+		}
+
+		terms = append(terms, func(tags map[string]bool) bool {
+			return tags[tag] != negated
+		})
+	}
+
+	return func(tags map[string]bool) bool {
+		for _, term := range terms {
+			if term(tags) {
+				return true
+			}
+		}
+		return false
+	}, nil
+}
+
+// tagExpressionParser is a recursive descent parser of the tag expressions, `not` binds stronger than `and`, `and` stronger than `or`.
+type tagExpressionParser struct {
+	tokens []string
+	pos    int
+}
+
+func (p *tagExpressionParser) next() string {
+	if p.pos >= len(p.tokens) {
+		return ""
+	}
+	return p.tokens[p.pos]
+}
+
+func (p *tagExpressionParser) parseOr() (tagExpression, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.next() == "or" {
+		p.pos++
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(tags map[string]bool) bool { return l(tags) || right(tags) }
+	}
+	return left, nil
+}
+
+func (p *tagExpressionParser) parseAnd() (tagExpression, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.next() == "and" {
+		p.pos++
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(tags map[string]bool) bool { return l(tags) && right(tags) }
+	}
+	return left, nil
+}
+
+func (p *tagExpressionParser) parseNot() (tagExpression, error) {
+	token := p.next()
+	p.pos++
+	switch {
+	case token == "not":
+		operand, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return func(tags map[string]bool) bool { return !operand(tags) }, nil
+	case token == "(":
+		expression, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.next() != ")" {
+			return nil, fmt.Errorf("missing ) in tag expression")
+		}
+		p.pos++
+		return expression, nil
+	case strings.HasPrefix(token, "@"):
+		return func(tags map[string]bool) bool { return tags[token] }, nil
+	case token == "":
+		return nil, fmt.Errorf("unexpected end of tag expression")
+	}
+	return nil, fmt.Errorf("unexpected %s in tag expression", token)
+}
+
+// cucumberSelectorValues returns the values of the --tags and --name options.
+func cucumberSelectorValues(options []string) (tags []string, names []string) {
+	for i := 0; i < len(options); i++ {
+		option, value := options[i], ""
+		if split := strings.SplitN(option, "=", 2); len(split) == 2 && strings.HasPrefix(option, "--") {
+			option, value = split[0], split[1]
+		} else if i+1 < len(options) {
+			value = options[i+1]
+		}
+
+		switch option {
+		case "--tags", "-t":
+			tags = append(tags, value)
+		case "--name", "-n":
+			names = append(names, value)
+		default:
+			continue
+		}
+		// the value is the next argument, unless it is given as --option=value
+		if option == options[i] {
+			i++
+		}
+	}
+	return tags, names
+}
+
+// ScenarioSelectionModel is the number of the scenarios the run selects out of the feature files' scenarios.
+type ScenarioSelectionModel struct {
+	Selected int `json:"selected"`
+	Total    int `json:"total"`
+}
+
+// Percent returns the selected scenarios' percentage of all the scenarios.
+func (s ScenarioSelectionModel) Percent() float64 {
+	if s.Total == 0 {
+		return 100
+	}
+	return float64(s.Selected) / float64(s.Total) * 100
+}
+
+// hasScenarioFilter reports whether the tags, the scenario names, the exclude patterns or the wip tags select a subset of the scenarios.
+func (ctx *StepContext) hasScenarioFilter() bool {
+	return len(ctx.ScenarioNameFilter) > 0 || len(ctx.ExcludePatterns) > 0 || len(ctx.WipTags) > 0 || hasCucumberSelectorOption(ctx.Options)
+}
+
+// scenarioSelection statically evaluates the --tags and --name options, the scenario name filter and the exclude patterns
+// on the feature files' scenarios: every --tags option has to match (and-ed), any of the name patterns has to match (or-ed).
+func (ctx *StepContext) scenarioSelection() (ScenarioSelectionModel, error) {
+	tagValues, names := cucumberSelectorValues(ctx.Options)
+	names = append(names, ctx.ScenarioNameFilter...)
+	// the wip scenarios are excluded from the run
+	wipTagValues, _ := cucumberSelectorValues(ctx.wipExcludeArgs())
+	tagValues = append(tagValues, wipTagValues...)
+
+	tagExpressions := []tagExpression{}
+	for _, value := range tagValues {
+		expression, err := parseTagExpression(value)
+		if err != nil {
+			return ScenarioSelectionModel{}, err
+		}
+		tagExpressions = append(tagExpressions, expression)
+	}
+
+	nameExps := []*regexp.Regexp{}
+	for _, name := range names {
+		exp, err := regexp.Compile(rubyNamedGroupExp.ReplaceAllString(name, "(?P<$1>"))
+		if err != nil {
+			return ScenarioSelectionModel{}, fmt.Errorf("unsupported name pattern (%s): %s", name, err)
+		}
+		nameExps = append(nameExps, exp)
+	}
+
+	features, err := ctx.runFeatures()
+	if err != nil {
+		return ScenarioSelectionModel{}, err
+	}
+	kept, _ := excludeFeatures(features, ctx.ExcludePatterns)
+	keptFeatures := map[string]bool{}
+	for _, feature := range kept {
+		keptFeatures[feature] = true
+	}
+
+	selection := ScenarioSelectionModel{}
+	for _, feature := range features {
+		pth := feature
+		if !filepath.IsAbs(pth) {
+			pth = filepath.Join(ctx.WorkDir, pth)
+		}
+		content, err := fileutil.ReadStringFromFile(pth)
+		if err != nil {
+			return ScenarioSelectionModel{}, err
+		}
+
+		for _, scenario := range parseFeatureScenarios(content) {
+			selection.Total++
+			if keptFeatures[feature] && isScenarioSelected(scenario, tagExpressions, nameExps) {
+				selection.Selected++
+			}
+		}
+	}
+	return selection, nil
+}
+
+func isScenarioSelected(scenario FeatureScenarioModel, tagExpressions []tagExpression, nameExps []*regexp.Regexp) bool {
+	tags := map[string]bool{}
+	for _, tag := range scenario.Tags {
+		tags[tag] = true
+	}
+	for _, expression := range tagExpressions {
+		if !expression(tags) {
+			return false
+		}
+	}
+
+	if len(nameExps) == 0 {
+		return true
+	}
+	for _, exp := range nameExps {
+		if exp.MatchString(scenario.Name) {
+			return true
+		}
+	}
+	return false
+}
+
+// printScenarioSelection prints and exports how many scenarios the filters select out of all the scenarios before the run,
+// and warns if the selected percentage is below min_selected_scenarios_percent.
+func (ctx *StepContext) printScenarioSelection() {
+	if !ctx.hasScenarioFilter() || ctx.RerunFilePath != "" {
+		return
+	}
+
+	selection, err := ctx.scenarioSelection()
+	if err != nil {
+		log.Warnf("Failed to count the selected scenarios, error: %s", err)
+		return
+	}
+
+	fmt.Println()
+	log.Printf("Selected %d of %d scenarios (%.0f%%)", selection.Selected, selection.Total, selection.Percent())
+
+	exportOutput(selectedScenarioCountOutputKey, fmt.Sprintf("%d", selection.Selected))
+	exportOutput(totalScenarioCountOutputKey, fmt.Sprintf("%d", selection.Total))
+	runSummary.ScenarioSelection = &selection
+
+	if selection.Percent() < ctx.MinSelectedScenariosPercent {
+		log.Warnf("Only %.0f%% of the scenarios are selected, below min_selected_scenarios_percent (%.0f%%), check the tag filters, the name filters and exclude_patterns",
+			selection.Percent(), ctx.MinSelectedScenariosPercent)
+	}
+}
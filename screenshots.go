@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/log"
+)
+
+// Screenshot outputs
+const (
+	screenshotsDirOutputKey  = "BITRISE_CALABASH_SCREENSHOTS_DIR"
+	screenshotCountOutputKey = "BITRISE_CALABASH_SCREENSHOT_COUNT"
+)
+
+var screenshotExts = []string{".png", ".jpg", ".jpeg"}
+
+// screenshotsDir returns the current run's screenshots dir within the results dir,
+// calabash writes the screenshots there as it prefixes the screenshot file names with SCREENSHOT_PATH.
+func (ctx *StepContext) screenshotsDir() string {
+	return resultsSubdir(resultsScreenshotsDirName, ctx.runResultsPath())
+}
+
+// countScreenshots returns the number of the image files in the dir.
+func countScreenshots(dir string) (int, error) {
+	count := 0
+	err := filepath.Walk(dir, func(pth string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() && indexInStringSlice(strings.ToLower(filepath.Ext(pth)), screenshotExts) != -1 {
+			count++
+		}
+		return nil
+	})
+	return count, err
+}
+
+// collectScreenshots exports the current run's screenshots dir and the number of screenshots in it.
+// A failed run without any screenshot is most likely an app, which can not take them.
+func (ctx *StepContext) collectScreenshots(cucumberErr error) {
+	dir := ctx.screenshotsDir()
+	diagnostics.Add(diagnosticKindScreenshots, dir, true)
+
+	count, err := countScreenshots(dir)
+	if err != nil {
+		log.Warnf("Failed to count the screenshots in (%s), error: %s", dir, err)
+		return
+	}
+
+	fmt.Println()
+	log.Printf("%d screenshot(s) saved to: %s", count, dir)
+	if count == 0 && cucumberErr != nil && failureCategoryOf(cucumberErr) == categoryTestFailure {
+		log.Warnf("The failed run saved no screenshot, the app might be built without the calabash server's screenshot capability,")
+		log.Warnf("or the project's After hook does not call screenshot_embed for the failed scenarios.")
+	}
+
+	exportOutput(screenshotsDirOutputKey, dir)
+	exportOutput(screenshotCountOutputKey, fmt.Sprintf("%d", count))
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/bitrise-io/go-utils/command"
+	"github.com/bitrise-io/go-utils/log"
+)
+
+const (
+	// scriptHookMaxLines bounds the hook script's output lines written into the step's output
+	scriptHookMaxLines = 2000
+
+	defaultScriptHookTimeout = 300 * time.Second
+
+	// appBundleIDScriptEnvKey passes the app's bundle id to the hook scripts, the step has no such output
+	appBundleIDScriptEnvKey = "BITRISE_CALABASH_APP_BUNDLE_ID"
+)
+
+// Script hooks, their names prefix the script's output lines, like `[pre-run] `.
+const (
+	scriptHookPreRun  = "pre-run"
+	scriptHookPostRun = "post-run"
+)
+
+// scriptHookEnvs returns the run's context passed to the hook scripts, mostly under the names of the step's outputs:
+// the result is empty before the run, the failures file and the rerun file are set only if the run had failed scenarios.
+func (ctx *StepContext) scriptHookEnvs(result string) []string {
+	bundleID := ""
+	if ctx.AppPath != "" {
+		if infoPlist, err := appInfoPlist(ctx.AppPath); err != nil {
+			log.Warnf("%s, the app's bundle id is not passed to the script", err)
+		} else {
+			bundleID, _ = infoPlist["CFBundleIdentifier"].(string)
+		}
+	}
+
+	return []string{
+		simulatorUDIDOutputKey + "=" + ctx.Simulator.ID,
+		appPathOutputKey + "=" + ctx.AppPath,
+		appBundleIDScriptEnvKey + "=" + bundleID,
+		testResultOutputKey + "=" + result,
+		resultsDirOutputKey + "=" + resultsDir(),
+		failuresOutputKey + "=" + outputExporter.Value(failuresOutputKey),
+		rerunFileOutputKey + "=" + outputExporter.Value(rerunFileOutputKey),
+	}
+}
+
+// runScriptHook runs the hook script with bash in the working directory, with the run's context in its environment,
+// its output is streamed with the hook's prefix. A failed, or timed out script is returned as an error, the callers decide
+// whether it fails the step.
+func (ctx *StepContext) runScriptHook(hook, pth string, timeout time.Duration, result string) error {
+	title := strings.ToUpper(hook[:1]) + hook[1:]
+
+	fmt.Println()
+	log.Infof("Running the %s script...", hook)
+
+	writer := newPrefixedLineWriter(stepLogger.Raw(), "["+hook+"] ", scriptHookMaxLines)
+	cmd := command.New("bash", pth)
+	cmd.AppendEnvs(ctx.scriptHookEnvs(result)...)
+	cmd.SetDir(ctx.WorkDir)
+	cmd.SetStdout(writer).SetStderr(writer)
+	printCommand(cmd)
+
+	start := time.Now()
+	done, err := commandRecorder.Start(cmd)
+	if err != nil {
+		return fmt.Errorf("Failed to start the %s script (%s), error: %s", hook, pth, err)
+	}
+
+	var runErr error
+	timedOut := false
+	select {
+	case runErr = <-done:
+	case <-time.After(timeout):
+		timedOut = true
+		if process := cmd.GetCmd().Process; process != nil {
+			if err := syscall.Kill(-process.Pid, syscall.SIGKILL); err != nil && err != syscall.ESRCH {
+				log.Warnf("Failed to stop the %s script, error: %s", hook, err)
+			}
+		}
+		<-done
+	}
+
+	if suppressed := writer.Flush(); suppressed > 0 {
+		log.Warnf("%d lines of the %s script's output are suppressed", suppressed, hook)
+	}
+
+	if timedOut {
+		return fmt.Errorf("%s script (%s) timed out after %s, it was stopped", title, pth, timeout)
+	}
+	if runErr != nil {
+		return fmt.Errorf("%s script (%s) failed with exit code %d", title, pth, exitCodeOf(runErr))
+	}
+	log.Donef("%s script succeeded in %s", title, time.Since(start).Round(time.Millisecond))
+	return nil
+}
+
+// runPreRunScript runs the pre_run_script_path script after the simulator and the app are prepared, right before cucumber,
+// for example to seed a mock backend with the simulator's UDID. A failed script fails the step as an infrastructure failure.
+func (ctx *StepContext) runPreRunScript() error {
+	if err := ctx.runScriptHook(scriptHookPreRun, ctx.PreRunScriptPath, ctx.PreRunScriptTimeout, ""); err != nil {
+		return newNonRetryableStepError(categoryInfrastructure, "%s", err)
+	}
+	return nil
+}
+
+// runPostRunScript runs the post_run_script_path script after the tests, whatever their result, before the cleanups.
+// A failed script fails the step only if fail_on_post_script_error is set.
+func (ctx *StepContext) runPostRunScript(result string) error {
+	if err := ctx.runScriptHook(scriptHookPostRun, ctx.PostRunScriptPath, ctx.PostRunScriptTimeout, result); err != nil {
+		return newStepError(categoryPostRunScript, "%s", err)
+	}
+	return nil
+}
@@ -0,0 +1,22 @@
+package main
+
+import (
+	"regexp"
+)
+
+const maskedValue = "[REDACTED]"
+
+var secretKeyExp = regexp.MustCompile(`(?i)(password|passwd|secret|token|api_?key|credential|auth|webhook_?url)`)
+
+// isSecretKey reports whether an input or env var key is expected to hold a secret value.
+func isSecretKey(key string) bool {
+	return secretKeyExp.MatchString(key)
+}
+
+// maskSecret returns the value to print or export for the given key, secret values are masked.
+func maskSecret(key, value string) string {
+	if value != "" && isSecretKey(key) {
+		return maskedValue
+	}
+	return value
+}
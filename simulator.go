@@ -0,0 +1,501 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/command"
+	"github.com/bitrise-io/go-utils/fileutil"
+	"github.com/bitrise-io/go-utils/log"
+	"github.com/bitrise-io/go-utils/pathutil"
+	"github.com/bitrise-io/go-xcode/simulator"
+	version "github.com/hashicorp/go-version"
+)
+
+// simulatorDeviceWithOsVersionExp matches the device names listed by `instruments -s devices`,
+// for example: `iPhone 8 (12.1)` or `iPhone 8 (12.1) [<UDID>] (Simulator)`.
+var simulatorDeviceWithOsVersionExp = regexp.MustCompile(`^(.+?) \(([0-9]+(?:\.[0-9]+)*)\)(?: \[[^\]]*\])?(?: \(Simulator\))?$`)
+
+// simulatorOsVersionExp matches an OS version input with an optional platform prefix (in any case), like `12.1`, `iOS 12.1` or `ios12.1.4`.
+func simulatorOsVersionExp(platform string) *regexp.Regexp {
+	return regexp.MustCompile(`^(?i:` + regexp.QuoteMeta(platform) + `)?\s*([0-9]+(?:\.[0-9]+){0,2})$`)
+}
+
+// normalizeSimulatorOsVersion returns the OS version input in the `<platform> <version>` form the simulators are looked up by,
+// like `iOS 12.1`, `latest` is returned as it is.
+func normalizeSimulatorOsVersion(value, platform string) (string, error) {
+	value = strings.TrimSpace(value)
+	if strings.EqualFold(value, "latest") {
+		return "latest", nil
+	}
+
+	match := simulatorOsVersionExp(platform).FindStringSubmatch(value)
+	if match == nil {
+		return "", fmt.Errorf("invalid SimulatorOsVersion (%s), should be a version like 12.1 or %s 12.1, or latest", value, platform)
+	}
+	return platform + " " + match[1], nil
+}
+
+// splitSimulatorDevice splits a device name embedding an OS version into the device name and the `<platform> <version>` OS version.
+func splitSimulatorDevice(device, platform string) (name, osVersion string, ok bool) {
+	match := simulatorDeviceWithOsVersionExp.FindStringSubmatch(strings.TrimSpace(device))
+	if len(match) != 3 {
+		return "", "", false
+	}
+	return match[1], platform + " " + match[2], true
+}
+
+// resolveSimulator finds the simulator matching the SimulatorDevice and SimulatorOsVersion inputs.
+func (ctx *StepContext) resolveSimulator() error {
+	fmt.Println()
+	log.Infof("Collecting simulator info...")
+
+	if stepLogger.IsDebug() {
+		simctlListCmd := command.New("xcrun", "simctl", "list", "--json")
+		if out, err := commandRecorder.RunAndReturnTrimmedCombinedOutput(simctlListCmd); err != nil {
+			log.Debugf("Failed to list simulators, output: %s, error: %s", out, err)
+		} else {
+			log.Debugf("$ %s\n%s", simctlListCmd.PrintableCommandArgs(), out)
+		}
+	}
+
+	configs := ctx.Configs
+	runtimes, err := listSimctlRuntimes()
+	if err != nil {
+		return newStepError(categoryInfrastructure, "Failed to get simulator info, error: %s", err)
+	}
+	devices, err := listSimctlDevices()
+	if err != nil {
+		return newStepError(categoryInfrastructure, "Failed to get simulator info, error: %s", err)
+	}
+
+	var matchingRuntimes []SimulatorRuntimeModel
+	if configs.SimulatorOsVersion == "latest" {
+		allowUnavailableDevices := configs.SimulatorSelectionStrategy == simulatorSelectionAlwaysCreateClean
+		runtime, version, err := resolveLatestRuntime(runtimes, devices, configs.SimulatorDevice, platformOrDefault(configs.Platform), allowUnavailableDevices)
+		if err != nil {
+			return newStepError(categoryInfrastructure, "Failed to get simulator info, error: %s", err)
+		}
+		matchingRuntimes = []SimulatorRuntimeModel{runtime}
+		ctx.SimulatorOsVersion = version
+
+		log.Printf("Latest os version: %s", version)
+	} else {
+		matchingRuntimes = osVersionRuntimes(runtimes, configs.SimulatorOsVersion)
+		if len(matchingRuntimes) == 0 {
+			if err := unavailableRuntimeError(runtimes, configs.SimulatorOsVersion); err != nil {
+				return err
+			}
+			return newStepError(categoryInfrastructure, "Failed to get simulator info, error: no simulators found for os version: %s", configs.SimulatorOsVersion)
+		}
+		ctx.SimulatorOsVersion = configs.SimulatorOsVersion
+	}
+
+	candidates := simulatorCandidates(matchingRuntimes, devices, configs.SimulatorDevice, simulatorCreationTime)
+	if len(candidates) == 0 {
+		return newStepError(categoryInfrastructure, "Failed to get simulator info, error: no simulators found for os version: (%s), device name: (%s)", ctx.SimulatorOsVersion, configs.SimulatorDevice)
+	}
+	if configs.PreferBootedSimulator == "yes" && ctx.reuseBootedSimulator(candidates) {
+		return ctx.simulatorResolved()
+	}
+	if configs.SimulatorSelectionStrategy != simulatorSelectionAlwaysCreateClean && !orderSimulatorCandidates(candidates, false)[0].Available {
+		return unavailableSimulatorsError(candidates, configs.SimulatorDevice, ctx.SimulatorOsVersion)
+	}
+	if err := ctx.selectSimulator(candidates); err != nil {
+		return err
+	}
+
+	return ctx.simulatorResolved()
+}
+
+// bootedSimulatorCandidates returns the booted, available candidates ordered by their runtimes from the newest to the oldest,
+// then by the lowest UDID.
+func bootedSimulatorCandidates(candidates []SimulatorCandidateModel) []SimulatorCandidateModel {
+	booted := []SimulatorCandidateModel{}
+	for _, candidate := range candidates {
+		if candidate.Booted() && candidate.Available {
+			booted = append(booted, candidate)
+		}
+	}
+
+	sort.SliceStable(booted, func(i, j int) bool {
+		a, b := booted[i], booted[j]
+		va, errA := version.NewVersion(a.Runtime.Version)
+		vb, errB := version.NewVersion(b.Runtime.Version)
+		if errA == nil && errB == nil && !va.Equal(vb) {
+			return va.GreaterThan(vb)
+		}
+		return a.Device.UDID < b.Device.UDID
+	})
+	return booted
+}
+
+// reuseBootedSimulator uses a booted simulator of the candidates, which are on the runtimes of the SimulatorOsVersion input
+// (with latest on the latest runtime only). From several booted simulators the one with the newest runtime, then with the lowest UDID is used.
+func (ctx *StepContext) reuseBootedSimulator(candidates []SimulatorCandidateModel) bool {
+	booted := bootedSimulatorCandidates(candidates)
+	if len(booted) == 0 {
+		log.Printf("No booted simulator matches (%s, %s)", ctx.Configs.SimulatorDevice, ctx.SimulatorOsVersion)
+		return false
+	}
+
+	if len(booted) > 1 {
+		udids := []string{}
+		for _, candidate := range booted {
+			udids = append(udids, fmt.Sprintf("%s (%s)", candidate.Device.UDID, candidate.Runtime))
+		}
+		log.Printf("%d booted simulators match: %s, using the one with the newest runtime, then the lowest UDID", len(booted), strings.Join(udids, ", "))
+	}
+
+	chosen := booted[0]
+	ctx.Simulator = simulator.InfoModel{Name: chosen.Device.Name, ID: chosen.Device.UDID, Status: chosen.Device.State}
+	ctx.setSimulatorOsVersion(chosen.Runtime)
+	ctx.SimulatorReused = true
+
+	log.Printf("Reusing booted simulator %s", ctx.Simulator.ID)
+
+	return true
+}
+
+// findSimctlDevice returns the device of the UDID and the name of its runtime, like `iOS 12.1`,
+// older simctl versions list the devices by the runtime names.
+func findSimctlDevice(runtimes []SimulatorRuntimeModel, devices simctlDevicesModel, udid string) (SimctlDeviceModel, string, bool) {
+	for runtimeKey, runtimeDevices := range devices.Devices {
+		for _, device := range runtimeDevices {
+			if device.UDID != udid {
+				continue
+			}
+
+			osVersion := runtimeKey
+			for _, runtime := range runtimes {
+				if runtime.Identifier == runtimeKey {
+					osVersion = runtime.Name
+				}
+			}
+			return device, osVersion, true
+		}
+	}
+	return SimctlDeviceModel{}, "", false
+}
+
+// resolveSimulatorByUDID finds a simulator by its UDID, used to reuse the simulator of a previous step run.
+func (ctx *StepContext) resolveSimulatorByUDID(udid string) error {
+	fmt.Println()
+	log.Infof("Collecting simulator info...")
+
+	runtimes, err := listSimctlRuntimes()
+	if err != nil {
+		return newStepError(categoryInfrastructure, "Failed to list simulators, error: %s", err)
+	}
+	devices, err := listSimctlDevices()
+	if err != nil {
+		return newStepError(categoryInfrastructure, "Failed to list simulators, error: %s", err)
+	}
+
+	device, osVersion, found := findSimctlDevice(runtimes, devices, udid)
+	if !found {
+		return newStepError(categoryInfrastructure, "no simulator found with UDID (%s), was the simulator deleted since the prepare run?", udid)
+	}
+
+	ctx.Simulator = simulator.InfoModel{Name: device.Name, ID: device.UDID, Status: device.State}
+	ctx.SimulatorOsVersion = osVersion
+	return ctx.simulatorResolved()
+}
+
+func (ctx *StepContext) simulatorResolved() error {
+	log.Donef("Simulator (%s), id: (%s), status: %s", ctx.Simulator.Name, ctx.Simulator.ID, ctx.Simulator.Status)
+
+	runSummary.Simulator = SimulatorSummaryModel{
+		Name:     ctx.Simulator.Name,
+		UDID:     ctx.Simulator.ID,
+		Platform: platformOrDefault(ctx.Configs.Platform),
+		Runtime:  ctx.SimulatorOsVersion,
+	}
+
+	// exported before the test run, so the outputs are available even if cucumber fails
+	exportOutput(simulatorNameOutputKey, ctx.Simulator.Name)
+	exportOutput(simulatorPlatformOutputKey, runSummary.Simulator.Platform)
+	exportOutput(simulatorOsVersionOutputKey, ctx.SimulatorOsVersion)
+	exportOutput(simulatorUDIDOutputKey, ctx.Simulator.ID)
+
+	runSummary.TestRunName = ctx.testRunName()
+	exportOutput(testRunNameOutputKey, runSummary.TestRunName)
+
+	diagnostics.Add(diagnosticKindSimulatorLog, filepath.Join(pathutil.UserHomeDir(), "Library", "Logs", "CoreSimulator", ctx.Simulator.ID, "system.log"), true)
+
+	if err := ctx.resolveSimulatorRuntime(); err != nil {
+		return err
+	}
+	return ctx.resolveDeviceTarget()
+}
+
+// bootSimulator boots the resolved simulator, an already booted simulator is not an error.
+func (ctx *StepContext) bootSimulator() error {
+	fmt.Println()
+	log.Infof("Booting simulator...")
+
+	cmd := command.New("xcrun", "simctl", "boot", ctx.Simulator.ID)
+	printCommand(cmd)
+
+	out, err := commandRecorder.RunAndReturnTrimmedCombinedOutput(cmd)
+	if err != nil {
+		if strings.Contains(out, "current state: Booted") {
+			log.Printf("Simulator is already booted")
+			return nil
+		}
+		return newStepError(categoryInfrastructure, "Failed to boot simulator (%s), output: %s, error: %s", ctx.Simulator.ID, out, err)
+	}
+
+	log.Donef("Simulator booted")
+	return nil
+}
+
+// installApp installs the app under test onto the booted simulator.
+func (ctx *StepContext) installApp() error {
+	if ctx.AppPath == "" {
+		return nil
+	}
+
+	fmt.Println()
+	log.Infof("Installing app on the simulator...")
+
+	cmd := command.New("xcrun", "simctl", "install", ctx.Simulator.ID, ctx.AppPath)
+	printCommand(cmd)
+
+	if out, err := commandRecorder.RunAndReturnTrimmedCombinedOutput(cmd); err != nil {
+		return newStepError(categoryInfrastructure, "Failed to install app (%s), output: %s, error: %s", ctx.AppPath, out, err)
+	}
+
+	log.Donef("App installed")
+	return nil
+}
+
+// resetSimulator shuts down and erases the simulator, so a retried attempt starts with a clean simulator.
+func (ctx *StepContext) resetSimulator() error {
+	fmt.Println()
+	log.Infof("Resetting simulator...")
+
+	if err := ctx.shutdownSimulator(); err != nil {
+		return err
+	}
+
+	eraseCmd := command.New("xcrun", "simctl", "erase", ctx.Simulator.ID)
+	printCommand(eraseCmd)
+
+	if out, err := commandRecorder.RunAndReturnTrimmedCombinedOutput(eraseCmd); err != nil {
+		return newStepError(categoryInfrastructure, "Failed to erase simulator (%s), output: %s, error: %s", ctx.Simulator.ID, out, err)
+	}
+
+	log.Donef("Simulator reset")
+	return nil
+}
+
+// terminateStaleApp terminates an app instance left running on an already booted simulator by a previous (aborted) run,
+// otherwise calabash might attach to the stale instance. Failures are logged as warnings only.
+func (ctx *StepContext) terminateStaleApp() {
+	if ctx.AppPath == "" || ctx.Simulator.Status != "Booted" {
+		return
+	}
+
+	infoPlist, err := appInfoPlist(ctx.AppPath)
+	if err != nil {
+		log.Warnf("%s, skipping stale app instance check", err)
+		return
+	}
+	bundleID, _ := infoPlist["CFBundleIdentifier"].(string)
+	if bundleID == "" {
+		log.Warnf("CFBundleIdentifier is not set in the app's Info.plist, skipping stale app instance check")
+		return
+	}
+
+	listCmd := command.New("xcrun", "simctl", "spawn", ctx.Simulator.ID, "launchctl", "list")
+	out, err := commandRecorder.RunAndReturnTrimmedCombinedOutput(listCmd)
+	if err != nil {
+		log.Warnf("Failed to list the simulator's running processes, skipping stale app instance check, output: %s, error: %s", out, err)
+		return
+	}
+	// running apps are listed with the UIKitApplication:<bundle id>[<id>] label
+	if !strings.Contains(out, "UIKitApplication:"+bundleID+"[") {
+		return
+	}
+
+	fmt.Println()
+	log.Infof("Terminating stale app instance...")
+
+	terminateCmd := command.New("xcrun", "simctl", "terminate", ctx.Simulator.ID, bundleID)
+	printCommand(terminateCmd)
+
+	if out, err := commandRecorder.RunAndReturnTrimmedCombinedOutput(terminateCmd); err != nil {
+		log.Warnf("Failed to terminate the stale app instance (%s), output: %s, error: %s", bundleID, out, err)
+		return
+	}
+
+	log.Donef("Terminated a stale instance of the app (%s) left running on the simulator", bundleID)
+}
+
+// shutdownSimulator shuts down the simulator, an already shut down simulator is not an error.
+func (ctx *StepContext) shutdownSimulator() error {
+	cmd := command.New("xcrun", "simctl", "shutdown", ctx.Simulator.ID)
+	printCommand(cmd)
+
+	if out, err := commandRecorder.RunAndReturnTrimmedCombinedOutput(cmd); err != nil && !strings.Contains(out, "current state: Shutdown") {
+		return newStepError(categoryInfrastructure, "Failed to shutdown simulator (%s), output: %s, error: %s", ctx.Simulator.ID, out, err)
+	}
+	return nil
+}
+
+// healthySimulatorStates are the simulator states cucumber can launch the app in,
+// a simulator in any other state (like Creating or Shutting Down) is considered stuck.
+var healthySimulatorStates = []string{"Shutdown", "Booting", "Booted"}
+
+// simulatorState returns the current state of the simulator, found is false if simctl does not list it as an available device.
+func simulatorState(udid string) (state string, found bool, err error) {
+	devices, err := listSimctlDevices()
+	if err != nil {
+		return "", false, err
+	}
+
+	for _, runtimeDevices := range devices.Devices {
+		for _, device := range runtimeDevices {
+			if device.UDID == udid {
+				if device.IsAvailable != nil && !*device.IsAvailable {
+					return device.State, false, nil
+				}
+				return device.State, true, nil
+			}
+		}
+	}
+	return "", false, nil
+}
+
+// verifySimulator re-checks the resolved simulator right before the cucumber run, as it might have been deleted, erased
+// or got stuck since it was resolved (the dependency install can take minutes): a deleted simulator is resolved again,
+// a stuck one is recovered.
+func (ctx *StepContext) verifySimulator() error {
+	state, found, err := simulatorState(ctx.Simulator.ID)
+	if err != nil {
+		log.Warnf("Failed to check the simulator's state, error: %s", err)
+		return nil
+	}
+
+	if !found {
+		deletedID := ctx.Simulator.ID
+
+		fmt.Println()
+		log.Warnf("Simulator (%s) no longer exists, resolving the simulator again", deletedID)
+
+		ctx.SimulatorReused = false
+		if err := ctx.resolveSimulator(); err != nil {
+			return err
+		}
+		if ctx.Simulator.ID == deletedID {
+			return newStepError(categoryInfrastructure, "Simulator (%s) is listed, but not available", deletedID)
+		}
+		log.Warnf("DEVICE_TARGET changed from %s to %s", deletedID, ctx.Simulator.ID)
+
+		return ctx.prepareSimulatorPreferences()
+	}
+
+	ctx.Simulator.Status = state
+	if indexInStringSlice(state, healthySimulatorStates) == -1 {
+		return ctx.recoverStuckSimulator(state)
+	}
+	return nil
+}
+
+// recoverStuckSimulator shuts down a simulator stuck in a transitional state.
+func (ctx *StepContext) recoverStuckSimulator(state string) error {
+	fmt.Println()
+	log.Warnf("Simulator (%s) is stuck in state: %s, shutting it down", ctx.Simulator.ID, state)
+
+	if err := ctx.shutdownSimulator(); err != nil {
+		return err
+	}
+	ctx.Simulator.Status = "Shutdown"
+
+	log.Donef("Simulator recovered")
+	return nil
+}
+
+// emptyPlist is written as the global preferences of a simulator, which was never booted.
+const emptyPlist = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict/>
+</plist>
+`
+
+// ensurePlistExists writes an empty plist to the path of a simulator preferences file, which does not exist yet.
+func ensurePlistExists(pth string) error {
+	if exist, err := pathutil.IsPathExists(pth); err != nil {
+		return newStepError(categoryInfrastructure, "Failed to check if simulator preferences exist at (%s), error: %s", pth, err)
+	} else if exist {
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(pth), 0755); err != nil {
+		return newStepError(categoryInfrastructure, "Failed to create simulator preferences dir, error: %s", err)
+	}
+	if err := fileutil.WriteStringToFile(pth, emptyPlist); err != nil {
+		return newStepError(categoryInfrastructure, "Failed to write simulator preferences (%s), error: %s", pth, err)
+	}
+	return nil
+}
+
+// prepareSimulatorPreferences shuts down the simulator, writes the keyboard settings and the language requested
+// by the inputs into its preferences and boots it.
+func (ctx *StepContext) prepareSimulatorPreferences() error {
+	keyboardPrefs := ctx.Configs.keyboardPreferences()
+	if ctx.Locale == "" && len(keyboardPrefs) == 0 {
+		return nil
+	}
+
+	if err := ctx.shutdownSimulator(); err != nil {
+		return err
+	}
+
+	if len(keyboardPrefs) > 0 {
+		if err := ctx.writeKeyboardPreferences(keyboardPrefs); err != nil {
+			return err
+		}
+	}
+	if ctx.Locale != "" {
+		if err := ctx.writeSimulatorLanguage(ctx.Locale); err != nil {
+			return err
+		}
+	}
+
+	return ctx.bootSimulator()
+}
+
+// writeSimulatorLanguage writes the language and the locale into the shut down simulator's global preferences,
+// the locale identifier is accepted in both the `pt-BR` and `pt_BR` forms.
+func (ctx *StepContext) writeSimulatorLanguage(locale string) error {
+	fmt.Println()
+	log.Infof("Setting simulator language to %s...", locale)
+
+	prefsPth := filepath.Join(simulatorPreferencesDir(ctx.Simulator.ID), ".GlobalPreferences.plist")
+	if err := ensurePlistExists(prefsPth); err != nil {
+		return err
+	}
+
+	language := strings.Replace(locale, "_", "-", -1)
+	appleLocale := strings.Replace(locale, "-", "_", -1)
+
+	for _, cmd := range []*command.Model{
+		command.New("plutil", "-replace", "AppleLanguages", "-json", fmt.Sprintf(`["%s"]`, language), prefsPth),
+		command.New("plutil", "-replace", "AppleLocale", "-string", appleLocale, prefsPth),
+	} {
+		printCommand(cmd)
+
+		if out, err := commandRecorder.RunAndReturnTrimmedCombinedOutput(cmd); err != nil {
+			return newStepError(categoryInfrastructure, "Failed to write simulator preferences (%s), output: %s, error: %s", prefsPth, out, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/log"
+	version "github.com/hashicorp/go-version"
+)
+
+// simctlAvailable reports whether simctl lists the runtime or device as available:
+// newer simctl versions list isAvailable, older ones the `(available)` availability.
+func simctlAvailable(isAvailable *bool, availability string) bool {
+	if isAvailable != nil {
+		return *isAvailable
+	}
+	return availability == "" || availability == "(available)"
+}
+
+type latestRuntimeCandidateModel struct {
+	runtime SimulatorRuntimeModel
+	version *version.Version
+}
+
+// latestSimulatorCandidates returns the runtimes of the platform ordered from the newest to the oldest,
+// runtimes of the same version are ordered by their build, the newer build first.
+// A runtime without a valid version is skipped: after an Xcode downgrade simctl lists the newer Xcode's runtimes
+// as unavailable (runtime profile not found), without their versions.
+func latestSimulatorCandidates(runtimes []SimulatorRuntimeModel, platform string) []latestRuntimeCandidateModel {
+	candidates := []latestRuntimeCandidateModel{}
+	for _, runtime := range runtimes {
+		if !isPlatformRuntime(runtime.Name, platform) {
+			continue
+		}
+
+		v, err := version.NewVersion(runtime.Version)
+		if err != nil {
+			log.Debugf("Skipping runtime (%s), its version (%s) can not be parsed: %s", runtime.Identifier, runtime.Version, err)
+			continue
+		}
+		candidates = append(candidates, latestRuntimeCandidateModel{runtime: runtime, version: v})
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if !candidates[i].version.Equal(candidates[j].version) {
+			return candidates[i].version.GreaterThan(candidates[j].version)
+		}
+		return candidates[i].runtime.BuildVersion > candidates[j].runtime.BuildVersion
+	})
+	return candidates
+}
+
+// selectLatestRuntime returns the newest runtime of the platform, which has an available device of the given name,
+// and the `<platform> <major>.<minor>` OS version of the runtime. The runtimes skipped are returned with the reason.
+// A runtime is shadowed by another available runtime of the same version (for example a downloaded and a bundled one), simctl runs the newer build.
+// The devices are listed by their runtime identifiers, older simctl versions list them by the runtime names.
+// If allowUnavailableDevices is set and no runtime has an available device, the newest runtime having an unavailable one is returned,
+// a clean simulator can be created on it.
+func selectLatestRuntime(runtimes []SimulatorRuntimeModel, devices simctlDevicesModel, deviceName, platform string, allowUnavailableDevices bool) (SimulatorRuntimeModel, string, []string, error) {
+	candidates := latestSimulatorCandidates(runtimes, platform)
+
+	osVersion := func(candidate latestRuntimeCandidateModel) string {
+		segments := candidate.version.Segments()
+		return fmt.Sprintf("%s %d.%d", platform, segments[0], segments[1])
+	}
+
+	skipped := []string{}
+	unavailableReasons := []string{}
+	var shadowing, unavailableOnly *latestRuntimeCandidateModel
+	for i, candidate := range candidates {
+		runtime := candidate.runtime
+		if !simctlAvailable(runtime.IsAvailable, runtime.Availability) {
+			skipped = append(skipped, fmt.Sprintf("%s: the runtime is unavailable", runtime))
+			continue
+		}
+		if shadowing != nil && shadowing.version.Equal(candidate.version) {
+			skipped = append(skipped, fmt.Sprintf("%s: shadowed by %s", runtime, shadowing.runtime))
+			continue
+		}
+		shadowing = &candidates[i]
+
+		available, unavailable := 0, 0
+		for _, device := range devices.runtimeDevices(runtime) {
+			if device.Name != deviceName {
+				continue
+			}
+			if simctlAvailable(device.IsAvailable, device.Availability) {
+				available++
+			} else {
+				unavailable++
+				if indexInStringSlice(device.availabilityReason(), unavailableReasons) == -1 {
+					unavailableReasons = append(unavailableReasons, device.availabilityReason())
+				}
+			}
+		}
+
+		if available > 0 {
+			return runtime, osVersion(candidate), skipped, nil
+		}
+		if unavailable > 0 {
+			skipped = append(skipped, fmt.Sprintf("%s: %d %s device(s) unavailable", runtime, unavailable, deviceName))
+			if unavailableOnly == nil {
+				unavailableOnly = &candidates[i]
+			}
+		} else {
+			skipped = append(skipped, fmt.Sprintf("%s: no %s device", runtime, deviceName))
+		}
+	}
+
+	if unavailableOnly != nil {
+		if allowUnavailableDevices {
+			return unavailableOnly.runtime, osVersion(*unavailableOnly), skipped, nil
+		}
+		return SimulatorRuntimeModel{}, "", skipped, fmt.Errorf("no available %s simulator found on any %s runtime, the %s simulators are unavailable (%s): %s",
+			deviceName, platform, deviceName, strings.Join(unavailableReasons, ", "), unavailableSimulatorsHint)
+	}
+	return SimulatorRuntimeModel{}, "", skipped, fmt.Errorf("no available %s simulator found on any %s runtime", deviceName, platform)
+}
+
+// resolveLatestRuntime finds the newest runtime of the platform having an available device of the given name,
+// the runtimes skipped are logged. The newest runtime being skipped is reported, as the tests do not run on the latest OS.
+func resolveLatestRuntime(runtimes []SimulatorRuntimeModel, devices simctlDevicesModel, deviceName, platform string, allowUnavailableDevices bool) (SimulatorRuntimeModel, string, error) {
+	runtime, osVersion, skipped, err := selectLatestRuntime(runtimes, devices, deviceName, platform, allowUnavailableDevices)
+	for _, reason := range skipped {
+		log.Printf("Skipped runtime %s", reason)
+	}
+	if err != nil {
+		return SimulatorRuntimeModel{}, "", err
+	}
+
+	if len(skipped) > 0 {
+		log.Warnf("The newest %s runtime is skipped (%s), the tests run on %s, not on the latest OS", platform, skipped[0], osVersion)
+	}
+	return runtime, osVersion, nil
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/command"
+	"github.com/bitrise-io/go-utils/fileutil"
+	"github.com/bitrise-io/go-utils/log"
+	"github.com/bitrise-io/go-utils/pathutil"
+)
+
+// The stages the simulator preferences are collected at, the subdirs of the run's simulator_preferences dir.
+const (
+	preferencesStageAfterPreparation = "after_preparation"
+	preferencesStageAfterRun         = "after_run"
+)
+
+const (
+	globalPreferencesPlistName = ".GlobalPreferences.plist"
+	// keyboardPlistsPattern matches the keyboard plists, like com.apple.keyboard.preferences.plist,
+	// their set depends on the runtime.
+	keyboardPlistsPattern = "com.apple.[Kk]eyboard*.plist"
+	// preferencesNotFoundFileName lists the plists not found, older runtimes do not write some of them.
+	preferencesNotFoundFileName = "not_found.txt"
+)
+
+// collectedPreferencesPlists returns the names of the simulator's plists to collect: the global and the Settings app preferences
+// (written by the language and the keyboard preparation) and the existing keyboard plists.
+func collectedPreferencesPlists(prefsDir string) []string {
+	names := []string{globalPreferencesPlistName, keyboardPreferencesPlistName}
+	matches, err := filepath.Glob(filepath.Join(prefsDir, keyboardPlistsPattern))
+	if err != nil {
+		return names
+	}
+	for _, match := range matches {
+		if name := filepath.Base(match); indexInStringSlice(name, names) == -1 {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// collectSimulatorPreferences copies the simulator's preferences plists, converted to xml by plutil (the simulator writes binary plists),
+// into the run's simulator_preferences/<stage> dir and adds them to the diagnostics bundle: the plists collected after the preparation
+// and after the run can be compared. The plists are only read, the missing ones are listed in not_found.txt.
+// Failures are logged as warnings only.
+func (ctx *StepContext) collectSimulatorPreferences(stage string) {
+	if ctx.Simulator.ID == "" {
+		return
+	}
+
+	prefsDir := simulatorPreferencesDir(ctx.Simulator.ID)
+	dir := resultsSubdir(resultsPreferencesDirName, ctx.runResultsPath(), stage)
+
+	collected := 0
+	notFound := []string{}
+	for _, name := range collectedPreferencesPlists(prefsDir) {
+		src := filepath.Join(prefsDir, name)
+		if exist, err := pathutil.IsPathExists(src); err != nil || !exist {
+			notFound = append(notFound, name)
+			continue
+		}
+
+		cmd := command.New("plutil", "-convert", "xml1", "-o", "-", src)
+		out, err := commandRecorder.RunAndReturnTrimmedCombinedOutput(cmd)
+		if err != nil {
+			log.Warnf("Failed to convert simulator preferences (%s), output: %s, error: %s", src, out, err)
+			continue
+		}
+
+		pth := filepath.Join(dir, name)
+		if err := fileutil.WriteStringToFile(pth, out+"\n"); err != nil {
+			log.Warnf("Failed to write simulator preferences (%s), error: %s", pth, err)
+			continue
+		}
+		collected++
+	}
+
+	if len(notFound) > 0 {
+		note := fmt.Sprintf("Not found in %s, skipped:\n%s\n", prefsDir, strings.Join(notFound, "\n"))
+		if err := fileutil.WriteStringToFile(filepath.Join(dir, preferencesNotFoundFileName), note); err != nil {
+			log.Warnf("Failed to write (%s), error: %s", filepath.Join(dir, preferencesNotFoundFileName), err)
+		}
+		log.Printf("Simulator preferences not found (%s), skipped: %s", stage, strings.Join(notFound, ", "))
+	}
+	log.Debugf("%d simulator preferences plist(s) collected (%s) to: %s", collected, stage, dir)
+
+	diagnostics.Add(diagnosticKindPreferences, resultsSubdir(resultsPreferencesDirName), true)
+}
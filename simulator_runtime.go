@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/command"
+	"github.com/bitrise-io/go-utils/log"
+)
+
+const simulatorRuntimeOutputKey = "BITRISE_CALABASH_SIMULATOR_RUNTIME"
+
+// SimulatorRuntimeModel is a runtime listed by `xcrun simctl list runtimes --json`.
+type SimulatorRuntimeModel struct {
+	Identifier             string   `json:"identifier"`
+	Name                   string   `json:"name"`
+	Version                string   `json:"version"`
+	BuildVersion           string   `json:"buildversion"`
+	IsAvailable            *bool    `json:"isAvailable"`
+	Availability           string   `json:"availability"`
+	AvailabilityError      string   `json:"availabilityError"`
+	SupportedArchitectures []string `json:"supportedArchitectures"`
+}
+
+// String returns the exact runtime version with its build, like `iOS 12.1.4 (16B91)`,
+// the runtime's name (`iOS 12.1`) does not tell the patch versions apart.
+func (runtime SimulatorRuntimeModel) String() string {
+	platform := "iOS"
+	if fields := strings.Fields(runtime.Name); len(fields) > 0 {
+		platform = fields[0]
+	}
+	return fmt.Sprintf("%s %s (%s)", platform, runtime.Version, runtime.BuildVersion)
+}
+
+type simctlRuntimesModel struct {
+	Runtimes []SimulatorRuntimeModel `json:"runtimes"`
+}
+
+func listSimctlRuntimes() ([]SimulatorRuntimeModel, error) {
+	cmd := command.New("xcrun", "simctl", "list", "runtimes", "--json")
+	out, err := commandRecorder.RunAndReturnTrimmedCombinedOutput(cmd)
+	if err != nil {
+		return nil, fmt.Errorf("%s failed, output: %s, error: %s", cmd.PrintableCommandArgs(), out, err)
+	}
+
+	var runtimes simctlRuntimesModel
+	if err := json.Unmarshal([]byte(out), &runtimes); err != nil {
+		return nil, err
+	}
+	return runtimes.Runtimes, nil
+}
+
+// simulatorRuntime returns the runtime of the simulator, found is false if simctl does not list the simulator or its runtime.
+func simulatorRuntime(udid string) (runtime SimulatorRuntimeModel, found bool, err error) {
+	devices, err := listSimctlDevices()
+	if err != nil {
+		return SimulatorRuntimeModel{}, false, err
+	}
+
+	runtimeIdentifier := ""
+	for identifier, runtimeDevices := range devices.Devices {
+		for _, device := range runtimeDevices {
+			if device.UDID == udid {
+				runtimeIdentifier = identifier
+			}
+		}
+	}
+	if runtimeIdentifier == "" {
+		return SimulatorRuntimeModel{}, false, nil
+	}
+
+	runtimes, err := listSimctlRuntimes()
+	if err != nil {
+		return SimulatorRuntimeModel{}, false, err
+	}
+	for _, runtime := range runtimes {
+		if runtime.Identifier == runtimeIdentifier {
+			return runtime, true, nil
+		}
+	}
+	return SimulatorRuntimeModel{}, false, nil
+}
+
+// resolveSimulatorRuntime logs and records the exact runtime version and build of the resolved simulator,
+// as an OS version input like `12.1` matches the 12.1.x runtime a stack has. If require_exact_os_version is set,
+// the OS version input has to equal the runtime's version.
+func (ctx *StepContext) resolveSimulatorRuntime() error {
+	runtime, found, err := simulatorRuntime(ctx.Simulator.ID)
+	if err != nil {
+		log.Warnf("Failed to look up the simulator's runtime, error: %s", err)
+		return nil
+	}
+	if !found {
+		log.Warnf("Simulator runtime not found for the simulator (%s)", ctx.Simulator.ID)
+		return nil
+	}
+
+	log.Printf("Simulator runtime: %s", runtime)
+	ctx.SimulatorRuntime = runtime
+
+	runSummary.Simulator.RuntimeVersion = runtime.Version
+	runSummary.Simulator.RuntimeBuild = runtime.BuildVersion
+	exportOutput(simulatorRuntimeOutputKey, runtime.String())
+
+	requested := ctx.Configs.SimulatorOsVersion
+	if requested == "latest" {
+		return nil
+	}
+
+	requestedVersion := osVersionNumber(requested)
+	if requestedVersion == runtime.Version {
+		return nil
+	}
+
+	if ctx.Configs.RequireExactOsVersion == "yes" {
+		return newStepError(categoryInvalidInput, "SimulatorOsVersion (%s) resolved to a different runtime: %s, require_exact_os_version is set", requested, runtime)
+	}
+	log.Warnf("SimulatorOsVersion (%s) resolved to a different runtime by prefix: %s", requested, runtime)
+	return nil
+}
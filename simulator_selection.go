@@ -0,0 +1,259 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bitrise-io/go-utils/command"
+	"github.com/bitrise-io/go-utils/log"
+	"github.com/bitrise-io/go-utils/pathutil"
+	"github.com/bitrise-io/go-xcode/simulator"
+)
+
+// Simulator selection strategies, an existing simulator matching the device and the OS version is used,
+// or a clean simulator is created on the runtime of the matching simulators
+const (
+	simulatorSelectionPreferExisting    = "prefer_existing"
+	simulatorSelectionAlwaysCreateClean = "always_create_clean"
+)
+
+var simulatorSelectionStrategies = []string{simulatorSelectionPreferExisting, simulatorSelectionAlwaysCreateClean}
+
+// unavailableSimulatorsHint explains the simulators simctl lists as unavailable, like after an Xcode downgrade:
+// they were created by an other Xcode version, which knows their device type and runtime.
+const unavailableSimulatorsHint = "they belong to a different Xcode version (for example after an Xcode downgrade), " +
+	"set simulator_selection_strategy to " + simulatorSelectionAlwaysCreateClean + " to create the simulator with the selected Xcode, " +
+	"or delete the unavailable simulators with `xcrun simctl delete unavailable`"
+
+// SimulatorCandidateModel is a simulator matching the requested device name and OS version.
+type SimulatorCandidateModel struct {
+	Device    SimctlDeviceModel
+	Runtime   SimulatorRuntimeModel
+	Available bool
+	// Created is the creation time of the simulator's dir, zero if unknown.
+	Created time.Time
+}
+
+// Booted ...
+func (candidate SimulatorCandidateModel) Booted() bool {
+	return candidate.Device.State == "Booted"
+}
+
+// String returns the candidate for the candidate list, like `11111111-... (iOS 12.1 (16B91), Shutdown, available, created 2020-01-02 15:04:05)`.
+func (candidate SimulatorCandidateModel) String() string {
+	availability := "available"
+	if !candidate.Available {
+		availability = "unavailable"
+	}
+	created := "creation time unknown"
+	if !candidate.Created.IsZero() {
+		created = "created " + candidate.Created.UTC().Format("2006-01-02 15:04:05")
+	}
+	return fmt.Sprintf("%s (%s, %s, %s, %s)", candidate.Device.UDID, candidate.Runtime, candidate.Device.State, availability, created)
+}
+
+// simulatorCandidates returns the devices of the given name on the runtimes, the creation time of a device is looked up by the created func.
+func simulatorCandidates(runtimes []SimulatorRuntimeModel, devices simctlDevicesModel, deviceName string, created func(device SimctlDeviceModel) time.Time) []SimulatorCandidateModel {
+	candidates := []SimulatorCandidateModel{}
+	for _, runtime := range runtimes {
+		for _, device := range devices.runtimeDevices(runtime) {
+			if device.Name != deviceName {
+				continue
+			}
+			candidates = append(candidates, SimulatorCandidateModel{
+				Device:    device,
+				Runtime:   runtime,
+				Available: simctlAvailable(device.IsAvailable, device.Availability),
+				Created:   created(device),
+			})
+		}
+	}
+	return candidates
+}
+
+// orderSimulatorCandidates returns the candidates in the order of preference: available over unavailable,
+// booted over shutdown if preferBooted is set, the newest creation time, then the lowest UDID.
+func orderSimulatorCandidates(candidates []SimulatorCandidateModel, preferBooted bool) []SimulatorCandidateModel {
+	ordered := append([]SimulatorCandidateModel{}, candidates...)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		a, b := ordered[i], ordered[j]
+		if a.Available != b.Available {
+			return a.Available
+		}
+		if preferBooted && a.Booted() != b.Booted() {
+			return a.Booted()
+		}
+		if !a.Created.Equal(b.Created) {
+			return a.Created.After(b.Created)
+		}
+		return a.Device.UDID < b.Device.UDID
+	})
+	return ordered
+}
+
+// setSimulatorOsVersion sets the OS version to the name of the chosen simulator's runtime, like `iOS 12.1` for a `12.1.4` or `12` OS version input,
+// as the Instruments-style device targets and the outputs name the runtimes like simctl does.
+func (ctx *StepContext) setSimulatorOsVersion(runtime SimulatorRuntimeModel) {
+	if runtime.Name != "" {
+		ctx.SimulatorOsVersion = runtime.Name
+	}
+}
+
+// simulatorCandidateLines returns the candidate list, the first (chosen) candidate marked with `*`.
+func simulatorCandidateLines(ordered []SimulatorCandidateModel) []string {
+	lines := []string{}
+	for i, candidate := range ordered {
+		marker := " "
+		if i == 0 {
+			marker = "*"
+		}
+		lines = append(lines, fmt.Sprintf("%s %s", marker, candidate))
+	}
+	return lines
+}
+
+// fileCreationTime returns the birth time of the file if the platform reports it (syscall.Stat_t.Birthtimespec on macOS),
+// the modification time otherwise.
+func fileCreationTime(info os.FileInfo) time.Time {
+	sys := reflect.ValueOf(info.Sys())
+	if sys.Kind() == reflect.Ptr && !sys.IsNil() && sys.Elem().Kind() == reflect.Struct {
+		if birth := sys.Elem().FieldByName("Birthtimespec"); birth.IsValid() && birth.Kind() == reflect.Struct {
+			sec, nsec := birth.FieldByName("Sec"), birth.FieldByName("Nsec")
+			if sec.IsValid() && nsec.IsValid() {
+				return time.Unix(sec.Int(), nsec.Int())
+			}
+		}
+	}
+	return info.ModTime()
+}
+
+// simulatorCreationTime returns the creation time of the simulator's dir (the parent of its data dir), zero if it does not exist.
+func simulatorCreationTime(device SimctlDeviceModel) time.Time {
+	dir := filepath.Join(pathutil.UserHomeDir(), "Library", "Developer", "CoreSimulator", "Devices", device.UDID)
+	if device.DataPath != "" {
+		dir = filepath.Dir(device.DataPath)
+	}
+
+	info, err := os.Stat(dir)
+	if err != nil {
+		return time.Time{}
+	}
+	return fileCreationTime(info)
+}
+
+// unavailableSimulatorsError explains that all the simulators matching the device name and the OS version are unavailable.
+func unavailableSimulatorsError(candidates []SimulatorCandidateModel, deviceName, osVersion string) error {
+	reasons := []string{}
+	for _, candidate := range candidates {
+		if reason := candidate.Device.availabilityReason(); indexInStringSlice(reason, reasons) == -1 {
+			reasons = append(reasons, reason)
+		}
+	}
+	return newNonRetryableStepError(categoryInfrastructure, "Failed to get simulator info, error: the %s simulators of %s are unavailable (%s): %s",
+		deviceName, osVersion, strings.Join(reasons, ", "), unavailableSimulatorsHint)
+}
+
+// unavailableRuntimeError explains that the runtime of the OS version is unavailable, nil if simctl does not list it.
+func unavailableRuntimeError(runtimes []SimulatorRuntimeModel, osVersion string) error {
+	for _, runtime := range runtimes {
+		if !runtimeMatchesOsVersion(runtime, osVersion) || simctlAvailable(runtime.IsAvailable, runtime.Availability) {
+			continue
+		}
+
+		reason := runtime.AvailabilityError
+		if reason == "" {
+			reason = "unavailable"
+		}
+		return newNonRetryableStepError(categoryInfrastructure, "Failed to get simulator info, error: the %s runtime is unavailable (%s): "+
+			"it belongs to a different Xcode version (for example after an Xcode downgrade), select an Xcode bundling it with xcode_developer_dir_path, or use an other simulator_os_version",
+			osVersion, reason)
+	}
+	return nil
+}
+
+// runtimeMatchesOsVersion reports whether the runtime is of the `<platform> <version>` OS version: the runtime's version has to start with
+// the OS version's one, like iOS 12.1 and iOS 12.1.4 for a 12.1.4 runtime, as simctl names the runtimes by their major.minor version only.
+func runtimeMatchesOsVersion(runtime SimulatorRuntimeModel, osVersion string) bool {
+	if runtime.Name == osVersion {
+		return true
+	}
+
+	runtimeFields, osVersionFields := strings.Fields(runtime.Name), strings.Fields(osVersion)
+	if len(runtimeFields) == 0 || len(osVersionFields) != 2 || runtimeFields[0] != osVersionFields[0] {
+		return false
+	}
+	version := osVersionFields[1]
+	return runtime.Version == version || strings.HasPrefix(runtime.Version, version+".")
+}
+
+// osVersionRuntimes returns the available runtimes of the `<platform> <version>` OS version, like iOS 12.1 or iOS 12.1.4 for a 12.1.4 runtime.
+func osVersionRuntimes(runtimes []SimulatorRuntimeModel, osVersion string) []SimulatorRuntimeModel {
+	matching := []SimulatorRuntimeModel{}
+	for _, runtime := range runtimes {
+		if runtimeMatchesOsVersion(runtime, osVersion) && simctlAvailable(runtime.IsAvailable, runtime.Availability) {
+			matching = append(matching, runtime)
+		}
+	}
+	return matching
+}
+
+// selectSimulator orders the candidates, prints the candidate list if several simulators match,
+// and uses the first one, or creates a clean simulator on its runtime if simulator_selection_strategy is always_create_clean.
+func (ctx *StepContext) selectSimulator(candidates []SimulatorCandidateModel) error {
+	ordered := orderSimulatorCandidates(candidates, ctx.Configs.PreferBootedSimulator == "yes")
+	if len(ordered) > 1 {
+		log.Printf("%d simulators match (%s, %s), using the first (*) by the order: available, booted (if prefer_booted_simulator is set), the newest, the lowest UDID:", len(ordered), ctx.Configs.SimulatorDevice, ctx.SimulatorOsVersion)
+		for _, line := range simulatorCandidateLines(ordered) {
+			log.Printf("%s", line)
+		}
+	}
+
+	chosen := ordered[0]
+	ctx.setSimulatorOsVersion(chosen.Runtime)
+	if ctx.Configs.SimulatorSelectionStrategy == simulatorSelectionAlwaysCreateClean {
+		return ctx.createCleanSimulator(chosen)
+	}
+
+	ctx.Simulator = simulator.InfoModel{Name: chosen.Device.Name, ID: chosen.Device.UDID, Status: chosen.Device.State}
+	return nil
+}
+
+// createCleanSimulator creates a simulator of the candidate's device type on the candidate's runtime,
+// it is deleted when the step exits.
+func (ctx *StepContext) createCleanSimulator(candidate SimulatorCandidateModel) error {
+	// the device type of an unavailable simulator might be unknown to the selected Xcode, it is looked up by the name
+	deviceType := candidate.Device.DeviceTypeIdentifier
+	if deviceType == "" || !candidate.Available {
+		var err error
+		if deviceType, err = simctlDeviceTypeIdentifier(candidate.Device.Name, ctx.Configs.XcodeDeveloperDirPath); err != nil {
+			return newStepError(categoryInfrastructure, "Failed to look up the device type of (%s), error: %s", candidate.Device.Name, err)
+		}
+	}
+
+	cmd := command.New("xcrun", "simctl", "create", candidate.Device.Name, deviceType, candidate.Runtime.Identifier)
+	printCommand(cmd)
+	out, err := commandRecorder.RunAndReturnTrimmedCombinedOutput(cmd)
+	if err != nil {
+		return newStepError(categoryInfrastructure, "Failed to create a clean simulator, output: %s, error: %s", out, err)
+	}
+
+	lines := strings.Split(out, "\n")
+	udid := strings.TrimSpace(lines[len(lines)-1])
+	log.Printf("Created clean simulator (%s) on %s, simulator_selection_strategy is %s", udid, candidate.Runtime, simulatorSelectionAlwaysCreateClean)
+
+	registerCleanup("clean simulator", func() error {
+		cmd := command.New("xcrun", "simctl", "delete", udid)
+		if out, err := commandRecorder.RunAndReturnTrimmedCombinedOutput(cmd); err != nil {
+			return fmt.Errorf("%s failed, output: %s, error: %s", cmd.PrintableCommandArgs(), out, err)
+		}
+		return nil
+	})
+
+	ctx.Simulator = simulator.InfoModel{Name: candidate.Device.Name, ID: udid, Status: "Shutdown"}
+	return nil
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOrderSimulatorCandidates(t *testing.T) {
+	runtime := SimulatorRuntimeModel{Identifier: "com.apple.CoreSimulator.SimRuntime.iOS-12-1", Name: "iOS 12.1", Version: "12.1"}
+	older := time.Date(2020, 1, 2, 15, 4, 5, 0, time.UTC)
+	newer := older.Add(time.Hour)
+
+	candidate := func(udid, state string, available bool, created time.Time) SimulatorCandidateModel {
+		return SimulatorCandidateModel{Device: SimctlDeviceModel{UDID: udid, Name: "iPhone 8", State: state}, Runtime: runtime, Available: available, Created: created}
+	}
+
+	tests := []struct {
+		name         string
+		candidates   []SimulatorCandidateModel
+		preferBooted bool
+		want         []string
+	}{
+		{
+			name:       "available first",
+			candidates: []SimulatorCandidateModel{candidate("1", "Shutdown", false, newer), candidate("2", "Shutdown", true, older)},
+			want:       []string{"2", "1"},
+		},
+		{
+			name:       "newest creation time",
+			candidates: []SimulatorCandidateModel{candidate("1", "Shutdown", true, older), candidate("2", "Shutdown", true, newer)},
+			want:       []string{"2", "1"},
+		},
+		{
+			name:       "lowest UDID if the creation times are the same or unknown",
+			candidates: []SimulatorCandidateModel{candidate("3", "Shutdown", true, time.Time{}), candidate("2", "Shutdown", true, older), candidate("1", "Shutdown", true, older), candidate("0", "Shutdown", true, time.Time{})},
+			want:       []string{"1", "2", "0", "3"},
+		},
+		{
+			name:       "booted is not preferred",
+			candidates: []SimulatorCandidateModel{candidate("1", "Booted", true, older), candidate("2", "Shutdown", true, newer)},
+			want:       []string{"2", "1"},
+		},
+		{
+			name:         "booted is preferred",
+			candidates:   []SimulatorCandidateModel{candidate("1", "Booted", true, older), candidate("2", "Shutdown", true, newer), candidate("3", "Booted", false, newer)},
+			preferBooted: true,
+			want:         []string{"1", "2", "3"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := []string{}
+			for _, ordered := range orderSimulatorCandidates(tt.candidates, tt.preferBooted) {
+				got = append(got, ordered.Device.UDID)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("orderSimulatorCandidates() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSimulatorCandidateLines(t *testing.T) {
+	runtime := SimulatorRuntimeModel{Identifier: "com.apple.CoreSimulator.SimRuntime.iOS-12-1", Name: "iOS 12.1", Version: "12.1", BuildVersion: "16B91"}
+	ordered := []SimulatorCandidateModel{
+		{Device: SimctlDeviceModel{UDID: "1", State: "Booted"}, Runtime: runtime, Available: true, Created: time.Date(2020, 1, 2, 15, 4, 5, 0, time.UTC)},
+		{Device: SimctlDeviceModel{UDID: "2", State: "Shutdown"}, Runtime: runtime},
+	}
+
+	want := []string{
+		"* 1 (iOS 12.1 (16B91), Booted, available, created 2020-01-02 15:04:05)",
+		"  2 (iOS 12.1 (16B91), Shutdown, unavailable, creation time unknown)",
+	}
+	if got := simulatorCandidateLines(ordered); !reflect.DeepEqual(got, want) {
+		t.Errorf("simulatorCandidateLines() =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestOsVersionRuntimes(t *testing.T) {
+	available, unavailable := true, false
+	runtimes := []SimulatorRuntimeModel{
+		{Identifier: "com.apple.CoreSimulator.SimRuntime.iOS-12-1", Name: "iOS 12.1", Version: "12.1.4", IsAvailable: &available},
+		{Identifier: "com.apple.CoreSimulator.SimRuntime.iOS-12-1-b", Name: "iOS 12.1", Version: "12.1", IsAvailable: &unavailable},
+		{Identifier: "com.apple.CoreSimulator.SimRuntime.iOS-12-10", Name: "iOS 12.10", Version: "12.10", IsAvailable: &available},
+		{Identifier: "com.apple.CoreSimulator.SimRuntime.iOS-11-4", Name: "iOS 11.4", Version: "11.4", IsAvailable: &available},
+		{Identifier: "com.apple.CoreSimulator.SimRuntime.tvOS-12-1", Name: "tvOS 12.1", Version: "12.1", IsAvailable: &available},
+	}
+
+	tests := []struct {
+		osVersion string
+		want      []string
+	}{
+		{osVersion: "iOS 12.1", want: []string{"com.apple.CoreSimulator.SimRuntime.iOS-12-1"}},
+		{osVersion: "iOS 12.1.4", want: []string{"com.apple.CoreSimulator.SimRuntime.iOS-12-1"}},
+		{osVersion: "iOS 12.1.2", want: []string{}},
+		{osVersion: "iOS 12", want: []string{"com.apple.CoreSimulator.SimRuntime.iOS-12-1", "com.apple.CoreSimulator.SimRuntime.iOS-12-10"}},
+		{osVersion: "tvOS 12.1", want: []string{"com.apple.CoreSimulator.SimRuntime.tvOS-12-1"}},
+		{osVersion: "iOS 13.0", want: []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.osVersion, func(t *testing.T) {
+			got := []string{}
+			for _, runtime := range osVersionRuntimes(runtimes, tt.osVersion) {
+				got = append(got, runtime.Identifier)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("osVersionRuntimes() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestBootedSimulatorCandidates(t *testing.T) {
+	ios114 := SimulatorRuntimeModel{Identifier: "com.apple.CoreSimulator.SimRuntime.iOS-11-4", Name: "iOS 11.4", Version: "11.4"}
+	ios121 := SimulatorRuntimeModel{Identifier: "com.apple.CoreSimulator.SimRuntime.iOS-12-1", Name: "iOS 12.1", Version: "12.1"}
+	ios1214 := SimulatorRuntimeModel{Identifier: "com.apple.CoreSimulator.SimRuntime.iOS-12-1-4", Name: "iOS 12.1", Version: "12.1.4"}
+
+	candidate := func(udid, state string, runtime SimulatorRuntimeModel, available bool) SimulatorCandidateModel {
+		return SimulatorCandidateModel{Device: SimctlDeviceModel{UDID: udid, Name: "iPhone 8", State: state}, Runtime: runtime, Available: available}
+	}
+
+	tests := []struct {
+		name       string
+		candidates []SimulatorCandidateModel
+		want       []string
+	}{
+		{
+			name:       "none booted",
+			candidates: []SimulatorCandidateModel{candidate("1", "Shutdown", ios121, true)},
+			want:       []string{},
+		},
+		{
+			name:       "unavailable booted simulator",
+			candidates: []SimulatorCandidateModel{candidate("1", "Booted", ios121, false)},
+			want:       []string{},
+		},
+		{
+			name:       "newest runtime first",
+			candidates: []SimulatorCandidateModel{candidate("1", "Booted", ios121, true), candidate("2", "Booted", ios1214, true), candidate("3", "Shutdown", ios1214, true)},
+			want:       []string{"2", "1"},
+		},
+		{
+			name:       "lowest UDID on the same runtime",
+			candidates: []SimulatorCandidateModel{candidate("3", "Booted", ios114, true), candidate("2", "Booted", ios114, true)},
+			want:       []string{"2", "3"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := []string{}
+			for _, booted := range bootedSimulatorCandidates(tt.candidates) {
+				got = append(got, booted.Device.UDID)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("bootedSimulatorCandidates() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestBootedSimulatorOnLatestRuntime checks that with latest a booted simulator is only reused on the latest runtime,
+// a simulator booted on an older runtime is not.
+func TestBootedSimulatorOnLatestRuntime(t *testing.T) {
+	available := true
+	runtimes := []SimulatorRuntimeModel{
+		{Identifier: "com.apple.CoreSimulator.SimRuntime.iOS-11-4", Name: "iOS 11.4", Version: "11.4", IsAvailable: &available},
+		{Identifier: "com.apple.CoreSimulator.SimRuntime.iOS-12-1", Name: "iOS 12.1", Version: "12.1", IsAvailable: &available},
+	}
+	devices := simctlDevicesModel{Devices: map[string][]SimctlDeviceModel{
+		"com.apple.CoreSimulator.SimRuntime.iOS-11-4": {{UDID: "2", Name: "iPhone 8", State: "Booted", IsAvailable: &available}},
+		"com.apple.CoreSimulator.SimRuntime.iOS-12-1": {{UDID: "4", Name: "iPhone 8", State: "Shutdown", IsAvailable: &available}},
+	}}
+
+	runtime, osVersion, _, err := selectLatestRuntime(runtimes, devices, "iPhone 8", "iOS", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if osVersion != "iOS 12.1" {
+		t.Fatalf("latest os version: %s, expected: iOS 12.1", osVersion)
+	}
+
+	candidates := simulatorCandidates([]SimulatorRuntimeModel{runtime}, devices, "iPhone 8", func(SimctlDeviceModel) time.Time { return time.Time{} })
+	if booted := bootedSimulatorCandidates(candidates); len(booted) != 0 {
+		t.Errorf("booted simulator %s on %s is reused with latest", booted[0].Device.UDID, booted[0].Runtime)
+	}
+}
+
+func TestFindSimctlDevice(t *testing.T) {
+	runtimes := []SimulatorRuntimeModel{{Identifier: "com.apple.CoreSimulator.SimRuntime.iOS-12-1", Name: "iOS 12.1", Version: "12.1"}}
+	devices := simctlDevicesModel{Devices: map[string][]SimctlDeviceModel{
+		"com.apple.CoreSimulator.SimRuntime.iOS-12-1": {{UDID: "4", Name: "iPhone 8", State: "Booted"}},
+		// older simctl versions list the devices by the runtime names
+		"iOS 11.4": {{UDID: "2", Name: "iPhone 8", State: "Shutdown"}},
+	}}
+
+	tests := []struct {
+		udid          string
+		wantFound     bool
+		wantName      string
+		wantOsVersion string
+	}{
+		{udid: "4", wantFound: true, wantName: "iPhone 8", wantOsVersion: "iOS 12.1"},
+		{udid: "2", wantFound: true, wantName: "iPhone 8", wantOsVersion: "iOS 11.4"},
+		{udid: "9", wantFound: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.udid, func(t *testing.T) {
+			device, osVersion, found := findSimctlDevice(runtimes, devices, tt.udid)
+			if found != tt.wantFound || device.Name != tt.wantName || osVersion != tt.wantOsVersion {
+				t.Errorf("findSimctlDevice() = (%s, %s, %v), want (%s, %s, %v)", device.Name, osVersion, found, tt.wantName, tt.wantOsVersion, tt.wantFound)
+			}
+		})
+	}
+}
+
+func TestNormalizeSimulatorOsVersion(t *testing.T) {
+	tests := []struct {
+		value    string
+		platform string
+		want     string
+		wantErr  bool
+	}{
+		{value: "latest", platform: "iOS", want: "latest"},
+		{value: " Latest ", platform: "iOS", want: "latest"},
+		{value: "12.1", platform: "iOS", want: "iOS 12.1"},
+		{value: "12", platform: "iOS", want: "iOS 12"},
+		{value: "12.1.4", platform: "iOS", want: "iOS 12.1.4"},
+		{value: "iOS 12.1", platform: "iOS", want: "iOS 12.1"},
+		{value: "ios12.1", platform: "iOS", want: "iOS 12.1"},
+		{value: "tvOS 12.1", platform: "tvOS", want: "tvOS 12.1"},
+		{value: "tvOS 12.1", platform: "iOS", wantErr: true},
+		{value: "12.1.4.2", platform: "iOS", wantErr: true},
+		{value: "iOS", platform: "iOS", wantErr: true},
+		{value: "", platform: "iOS", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.platform+"/"+tt.value, func(t *testing.T) {
+			got, err := normalizeSimulatorOsVersion(tt.value, tt.platform)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("normalizeSimulatorOsVersion() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("normalizeSimulatorOsVersion() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -12,4 +12,8 @@ rsync -avh --quiet "${THIS_SCRIPT_DIR}/" "${full_package_path}/"
 
 export GOPATH="${tmp_gopath_dir}"
 export GO15VENDOREXPERIMENT=1
-go run "${full_package_path}/main.go"
+
+# the step's package main is split across files, build the package, not main.go alone
+step_bin="${tmp_gopath_dir}/bin/steps-calabash-ios-uitest"
+(cd "${full_package_path}" && go build -o "${step_bin}" .)
+"${step_bin}" "$@"
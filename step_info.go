@@ -0,0 +1,66 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"runtime"
+	"sort"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/command"
+	"github.com/bitrise-io/go-utils/log"
+)
+
+// Version is the step's version, injected at build time:
+// go build -ldflags "-X main.Version=<version>"
+var Version = "dev"
+
+const configHashOutputKey = "BITRISE_CALABASH_CONFIG_HASH"
+
+// configHash returns a short hash of the normalized configuration: the non-empty, non-secret inputs sorted by their key.
+// Empty inputs are left out, so that a new optional input does not change the hash of the existing configurations.
+func configHash(inputs map[string]string) string {
+	lines := []string{}
+	for key, value := range inputs {
+		value = strings.TrimSpace(value)
+		if value == "" || isSecretKey(key) {
+			continue
+		}
+		lines = append(lines, key+"="+value)
+	}
+	sort.Strings(lines)
+
+	sum := sha256.Sum256([]byte(strings.Join(lines, "\n")))
+	return fmt.Sprintf("%x", sum)[:12]
+}
+
+// macOSVersion returns the host's macOS version, unknown if sw_vers is not available.
+func macOSVersion() string {
+	out, err := commandRecorder.RunAndReturnTrimmedCombinedOutput(command.New("sw_vers", "-productVersion"))
+	if err != nil || out == "" {
+		return "unknown"
+	}
+	return out
+}
+
+// printStepHeader prints the step's version, the host info and the configuration hash, which is exported as well,
+// to tell at a glance which step revision ran and whether two builds ran with identical configuration.
+func printStepHeader(configs ConfigsModel) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+
+	hash := configHash(configs.inputValues())
+
+	log.Infof("Calabash iOS UI test step %s", Version)
+	log.Printf("- Go: %s %s/%s", runtime.Version(), runtime.GOOS, runtime.GOARCH)
+	log.Printf("- macOS: %s", macOSVersion())
+	log.Printf("- Hostname: %s", hostname)
+	log.Printf("- Config hash: %s", hash)
+
+	runSummary.StepVersion = Version
+	runSummary.ConfigHash = hash
+	exportOutput(configHashOutputKey, hash)
+}
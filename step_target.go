@@ -0,0 +1,252 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/fileutil"
+	"github.com/bitrise-io/go-utils/log"
+	"github.com/bitrise-io/go-utils/pathutil"
+)
+
+// stepTargetVariables are the envs, which select the simulator and the app calabash tests on.
+var stepTargetVariables = []string{"DEVICE_TARGET", "APP", "APP_BUNDLE_PATH"}
+
+// cucumberProfileFiles are the paths, relative to the work dir, where cucumber looks for the profiles.
+var cucumberProfileFiles = []string{"cucumber.yml", "cucumber.yaml", ".config/cucumber.yml", "config/cucumber.yml"}
+
+var (
+	cucumberProfileNameExp       = regexp.MustCompile(`^([A-Za-z0-9_-]+)\s*:`)
+	cucumberProfileAssignmentExp = regexp.MustCompile(`(?:^|[\s"'])(DEVICE_TARGET|APP|APP_BUNDLE_PATH)=`)
+	supportFileAssignmentExp     = regexp.MustCompile(`ENV\[\s*['"](DEVICE_TARGET|APP|APP_BUNDLE_PATH)['"]\s*\]\s*(\|\|=|=)(?:[^=~]|$)`)
+)
+
+// TargetOverrideModel is an assignment of a step target variable found in the project,
+// which overrides (or conflicts with) the simulator and the app selected by the step.
+type TargetOverrideModel struct {
+	File     string
+	Line     int
+	Variable string
+	// Profile is the cucumber.yml profile of the assignment, empty for a support file.
+	Profile string
+	// Conditional is set for the `||=` assignments, which only apply if the step did not set the variable.
+	Conditional bool
+}
+
+func (override TargetOverrideModel) String() string {
+	location := fmt.Sprintf("%s:%d", override.File, override.Line)
+	switch {
+	case override.Profile != "":
+		return fmt.Sprintf("%s (profile: %s): %s", location, override.Profile, override.Variable)
+	case override.Conditional:
+		return fmt.Sprintf("%s: %s (||=, applies only if the step does not set it)", location, override.Variable)
+	default:
+		return fmt.Sprintf("%s: %s", location, override.Variable)
+	}
+}
+
+// scanFileLines calls fn with every line of the file and its 1 based number.
+func scanFileLines(pth string, fn func(line string, number int)) error {
+	file, err := os.Open(pth)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := file.Close(); err != nil {
+			log.Warnf("Failed to close file (%s), error: %s", pth, err)
+		}
+	}()
+
+	scanner := bufio.NewScanner(file)
+	for number := 1; scanner.Scan(); number++ {
+		fn(scanner.Text(), number)
+	}
+	return scanner.Err()
+}
+
+// scanCucumberProfiles returns the step target variables set by the profiles of the project's cucumber.yml.
+func scanCucumberProfiles(workDir string) ([]TargetOverrideModel, error) {
+	overrides := []TargetOverrideModel{}
+	for _, name := range cucumberProfileFiles {
+		pth := filepath.Join(workDir, name)
+		if exist, err := pathutil.IsPathExists(pth); err != nil {
+			return nil, err
+		} else if !exist {
+			continue
+		}
+
+		profile := ""
+		if err := scanFileLines(pth, func(line string, number int) {
+			if strings.HasPrefix(strings.TrimSpace(line), "#") {
+				return
+			}
+			if match := cucumberProfileNameExp.FindStringSubmatch(line); len(match) == 2 {
+				profile = match[1]
+			}
+			for _, match := range cucumberProfileAssignmentExp.FindAllStringSubmatch(line, -1) {
+				overrides = append(overrides, TargetOverrideModel{File: name, Line: number, Variable: match[1], Profile: profile})
+			}
+		}); err != nil {
+			return nil, err
+		}
+	}
+	return overrides, nil
+}
+
+// scanSupportFiles returns the step target variables assigned by the project's <features dir>/support/*.rb files,
+// the files are reported relative to the work dir.
+func scanSupportFiles(workDir, featuresDir string) ([]TargetOverrideModel, error) {
+	pths, err := filepath.Glob(filepath.Join(featuresDir, "support", "*.rb"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(pths)
+
+	overrides := []TargetOverrideModel{}
+	for _, pth := range pths {
+		name, err := filepath.Rel(workDir, pth)
+		if err != nil {
+			name = pth
+		}
+
+		if err := scanFileLines(pth, func(line string, number int) {
+			if strings.HasPrefix(strings.TrimSpace(line), "#") {
+				return
+			}
+			for _, match := range supportFileAssignmentExp.FindAllStringSubmatch(line, -1) {
+				overrides = append(overrides, TargetOverrideModel{File: name, Line: number, Variable: match[1], Conditional: match[2] == "||="})
+			}
+		}); err != nil {
+			return nil, err
+		}
+	}
+	return overrides, nil
+}
+
+// selectedCucumberProfiles returns the profiles selected by the cucumber options, default if none is selected.
+func selectedCucumberProfiles(options []string) (profiles []string, explicit bool) {
+	for i, option := range options {
+		switch {
+		case (option == "--profile" || option == "-p") && i+1 < len(options):
+			profiles = append(profiles, options[i+1])
+		case strings.HasPrefix(option, "--profile="):
+			profiles = append(profiles, strings.TrimPrefix(option, "--profile="))
+		}
+	}
+	if len(profiles) == 0 {
+		return []string{"default"}, false
+	}
+	return profiles, true
+}
+
+// scanStepTargetOverrides statically scans the project's cucumber.yml and support files for DEVICE_TARGET and APP assignments,
+// which would make cucumber test on a different simulator or app than the one selected by the step.
+func (ctx *StepContext) scanStepTargetOverrides() {
+	fmt.Println()
+	log.Infof("Checking the project for DEVICE_TARGET and APP overrides...")
+
+	profileOverrides, err := scanCucumberProfiles(ctx.WorkDir)
+	if err != nil {
+		log.Warnf("Failed to scan the cucumber profiles, error: %s", err)
+	}
+	supportOverrides, err := scanSupportFiles(ctx.WorkDir, filepath.Join(ctx.WorkDir, ctx.featuresPath()))
+	if err != nil {
+		log.Warnf("Failed to scan the support files, error: %s", err)
+	}
+
+	selectedProfiles, _ := selectedCucumberProfiles(ctx.Options)
+
+	overrides := []TargetOverrideModel{}
+	for _, override := range profileOverrides {
+		// only the selected profiles are applied by cucumber
+		if indexInStringSlice(override.Profile, selectedProfiles) != -1 {
+			overrides = append(overrides, override)
+		}
+	}
+	overrides = append(overrides, supportOverrides...)
+	ctx.TargetOverrides = overrides
+
+	if len(overrides) == 0 {
+		log.Donef("No DEVICE_TARGET or APP override found")
+		return
+	}
+
+	log.Warnf("The project sets the simulator or the app cucumber tests on, the step's selection might be overridden:")
+	for _, override := range overrides {
+		log.Warnf("- %s", override)
+	}
+	if ctx.Configs.EnforceStepTarget != "yes" {
+		log.Warnf("Set enforce_step_target to yes to test on the step's simulator and app")
+	}
+}
+
+// hasTargetOverride reports whether any found override comes from a profile or, if profile is false, from an unconditional support file assignment.
+func (ctx *StepContext) hasTargetOverride(profile bool) bool {
+	for _, override := range ctx.TargetOverrides {
+		if profile && override.Profile != "" {
+			return true
+		}
+		if !profile && override.Profile == "" && !override.Conditional {
+			return true
+		}
+	}
+	return false
+}
+
+// rubySingleQuoted returns the value as a single quoted ruby string literal.
+func rubySingleQuoted(value string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(value) + "'"
+}
+
+// stepTargetArgs returns the cucumber arguments enforcing the step's simulator and app over the found overrides:
+// the profiles are skipped with --no-profile, the support file assignments are reverted by a support file
+// written by the step, which is required after the project's ones.
+func (ctx *StepContext) stepTargetArgs() ([]string, error) {
+	if ctx.Configs.EnforceStepTarget != "yes" || len(ctx.TargetOverrides) == 0 {
+		return nil, nil
+	}
+
+	args := []string{}
+	if ctx.hasTargetOverride(true) {
+		if profiles, explicit := selectedCucumberProfiles(ctx.Options); explicit {
+			log.Warnf("The profile (%s) selected in additional_options sets the step's target, it can not be skipped", strings.Join(profiles, ", "))
+		} else {
+			log.Printf("Skipping the cucumber profiles, they set the step's target (enforce_step_target)")
+			args = append(args, "--no-profile")
+		}
+	}
+
+	if ctx.hasTargetOverride(false) {
+		tmpDir, err := ctx.createTempDir("step_target_support")
+		if err != nil {
+			return nil, newStepError(categoryInfrastructure, "Failed to create tmp dir, error: %s", err)
+		}
+
+		lines := []string{
+			"# Written by the Calabash iOS UI test step (enforce_step_target), required after the project's support files.",
+			"ENV['DEVICE_TARGET'] = " + rubySingleQuoted(ctx.DeviceTarget),
+		}
+		if ctx.AppPath != "" {
+			lines = append(lines,
+				"ENV['APP'] = "+rubySingleQuoted(ctx.AppPath),
+				"ENV['APP_BUNDLE_PATH'] = "+rubySingleQuoted(ctx.AppPath),
+			)
+		}
+
+		pth := filepath.Join(tmpDir, "step_target.rb")
+		if err := fileutil.WriteStringToFile(pth, strings.Join(lines, "\n")+"\n"); err != nil {
+			return nil, newStepError(categoryInfrastructure, "Failed to write support file (%s), error: %s", pth, err)
+		}
+
+		log.Printf("Requiring the step's target after the project's support files (enforce_step_target): %s", pth)
+		// an explicit --require disables cucumber's default requires, so the features dir is required explicitly
+		args = append(args, "--require", ctx.featuresPath(), "--require", pth)
+	}
+
+	return args, nil
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"time"
+
+	"github.com/bitrise-io/go-utils/fileutil"
+	"github.com/bitrise-io/go-utils/log"
+	"github.com/bitrise-io/go-utils/pathutil"
+)
+
+const (
+	runSummaryFormatVersion = "1.13.0"
+	runSummaryFileName      = "calabash_run_summary.json"
+)
+
+// SimulatorSummaryModel ...
+type SimulatorSummaryModel struct {
+	Name           string `json:"name"`
+	UDID           string `json:"udid"`
+	Platform       string `json:"platform,omitempty"`
+	Runtime        string `json:"runtime"`
+	RuntimeVersion string `json:"runtime_version,omitempty"`
+	RuntimeBuild   string `json:"runtime_build,omitempty"`
+}
+
+// VersionsSummaryModel ...
+type VersionsSummaryModel struct {
+	CalabashCucumber string `json:"calabash_cucumber,omitempty"`
+	Cucumber         string `json:"cucumber,omitempty"`
+	UseBundler       bool   `json:"use_bundler"`
+}
+
+// PhaseSummaryModel ...
+type PhaseSummaryModel struct {
+	Name       string `json:"name"`
+	DurationMs int64  `json:"duration_ms"`
+}
+
+// FeatureSummaryModel ...
+type FeatureSummaryModel struct {
+	Name       string              `json:"name"`
+	URI        string              `json:"uri"`
+	DurationMs int64               `json:"duration_ms"`
+	Scenarios  ScenarioCountsModel `json:"scenarios"`
+}
+
+// FailedScenarioSummaryModel ...
+type FailedScenarioSummaryModel struct {
+	Feature    string `json:"feature"`
+	Name       string `json:"name"`
+	Location   string `json:"location"`
+	FailedStep string `json:"failed_step,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// ScenarioSummaryModel ...
+type ScenarioSummaryModel struct {
+	Feature  string `json:"feature"`
+	Name     string `json:"name"`
+	Location string `json:"location"`
+}
+
+func scenarioSummaries(scenarios []ScenarioResultModel) []ScenarioSummaryModel {
+	summaries := []ScenarioSummaryModel{}
+	for _, scenario := range scenarios {
+		summaries = append(summaries, ScenarioSummaryModel{Feature: scenario.Feature, Name: scenario.Name, Location: scenario.Location()})
+	}
+	return summaries
+}
+
+// LanguageSummaryModel ...
+type LanguageSummaryModel struct {
+	Locale    string              `json:"locale"`
+	Result    string              `json:"result"`
+	Scenarios ScenarioCountsModel `json:"scenarios"`
+}
+
+// DeviceSummaryModel ...
+type DeviceSummaryModel struct {
+	Device    string                `json:"device"`
+	Simulator SimulatorSummaryModel `json:"simulator"`
+	Result    string                `json:"result"`
+	Scenarios ScenarioCountsModel   `json:"scenarios"`
+}
+
+// RetrySummaryModel ...
+type RetrySummaryModel struct {
+	Attempts int `json:"attempts"`
+	// FlakyScenarios are the locations of the scenarios failed by an attempt and passed by a later one
+	FlakyScenarios []string `json:"flaky_scenarios"`
+}
+
+// RunSummaryModel is the schema of calabash_run_summary.json,
+// bump runSummaryFormatVersion on every incompatible change.
+type RunSummaryModel struct {
+	FormatVersion         string                       `json:"format_version"`
+	StepVersion           string                       `json:"step_version"`
+	TestRunName           string                       `json:"test_run_name,omitempty"`
+	ConfigHash            string                       `json:"config_hash"`
+	Inputs                map[string]string            `json:"inputs"`
+	Simulator             SimulatorSummaryModel        `json:"simulator"`
+	Versions              VersionsSummaryModel         `json:"versions"`
+	NetworkProfile        string                       `json:"network_profile,omitempty"`
+	TotalDurationMs       int64                        `json:"total_duration_ms"`
+	Phases                []PhaseSummaryModel          `json:"phases"`
+	Scenarios             ScenarioCountsModel          `json:"scenarios"`
+	ScenarioSelection     *ScenarioSelectionModel      `json:"scenario_selection,omitempty"`
+	Features              []FeatureSummaryModel        `json:"features"`
+	Tags                  []TagSummaryModel            `json:"tags"`
+	FailedScenarios       []FailedScenarioSummaryModel `json:"failed_scenarios"`
+	PendingScenarios      []ScenarioSummaryModel       `json:"pending_scenarios"`
+	UndefinedScenarios    []ScenarioSummaryModel       `json:"undefined_scenarios"`
+	Retry                 RetrySummaryModel            `json:"retry"`
+	Languages             []LanguageSummaryModel       `json:"languages,omitempty"`
+	Devices               []DeviceSummaryModel         `json:"devices,omitempty"`
+	FailureClassification string                       `json:"failure_classification,omitempty"`
+	ExitCode              int                          `json:"exit_code"`
+	TempDir               string                       `json:"temp_dir,omitempty"`
+	TempDirArchive        string                       `json:"temp_dir_archive,omitempty"`
+}
+
+// NewRunSummary ...
+func NewRunSummary() *RunSummaryModel {
+	return &RunSummaryModel{
+		FormatVersion:      runSummaryFormatVersion,
+		Inputs:             map[string]string{},
+		Phases:             []PhaseSummaryModel{},
+		Features:           []FeatureSummaryModel{},
+		Tags:               []TagSummaryModel{},
+		FailedScenarios:    []FailedScenarioSummaryModel{},
+		PendingScenarios:   []ScenarioSummaryModel{},
+		UndefinedScenarios: []ScenarioSummaryModel{},
+		Retry: RetrySummaryModel{
+			Attempts:       1,
+			FlakyScenarios: []string{},
+		},
+	}
+}
+
+// SetInputs stores the step inputs, secret values are masked.
+func (summary *RunSummaryModel) SetInputs(inputs map[string]string) {
+	for key, value := range inputs {
+		summary.Inputs[key] = maskSecret(key, value)
+	}
+}
+
+// SetPhases ...
+func (summary *RunSummaryModel) SetPhases(timer *PhaseTimer) {
+	summary.TotalDurationMs = timer.Total().Milliseconds()
+	summary.Phases = []PhaseSummaryModel{}
+	for _, phase := range timer.Phases() {
+		summary.Phases = append(summary.Phases, PhaseSummaryModel{
+			Name:       phase.Name,
+			DurationMs: phase.Duration.Milliseconds(),
+		})
+	}
+}
+
+// SetScenarios ...
+func (summary *RunSummaryModel) SetScenarios(scenarios []ScenarioResultModel) {
+	summary.Scenarios = countScenarios(scenarios)
+	summary.Tags = countTags(scenarios)
+
+	summary.Features = []FeatureSummaryModel{}
+	featureIndexes := map[string]int{}
+	featureScenarios := [][]ScenarioResultModel{}
+	for _, scenario := range scenarios {
+		idx, ok := featureIndexes[scenario.URI]
+		if !ok {
+			idx = len(summary.Features)
+			featureIndexes[scenario.URI] = idx
+			summary.Features = append(summary.Features, FeatureSummaryModel{Name: scenario.Feature, URI: scenario.URI})
+			featureScenarios = append(featureScenarios, nil)
+		}
+		summary.Features[idx].DurationMs += scenario.Duration / int64(time.Millisecond)
+		featureScenarios[idx] = append(featureScenarios[idx], scenario)
+	}
+	for idx := range summary.Features {
+		summary.Features[idx].Scenarios = countScenarios(featureScenarios[idx])
+	}
+
+	summary.FailedScenarios = []FailedScenarioSummaryModel{}
+	for _, scenario := range failedScenarios(scenarios) {
+		summary.FailedScenarios = append(summary.FailedScenarios, FailedScenarioSummaryModel{
+			Feature:    scenario.Feature,
+			Name:       scenario.Name,
+			Location:   scenario.Location(),
+			FailedStep: scenario.FailedStep,
+			Error:      scenario.Error,
+		})
+	}
+
+	summary.PendingScenarios = scenarioSummaries(scenariosWithStatus(scenarios, statusPending))
+	summary.UndefinedScenarios = scenarioSummaries(scenariosWithStatus(scenarios, statusUndefined))
+}
+
+// MarshalIndented ...
+func (summary *RunSummaryModel) MarshalIndented() ([]byte, error) {
+	return json.MarshalIndent(summary, "", "  ")
+}
+
+// WriteToDir writes the summary into the given dir and returns the file's path.
+func (summary *RunSummaryModel) WriteToDir(dir string) (string, error) {
+	b, err := summary.MarshalIndented()
+	if err != nil {
+		return "", err
+	}
+
+	pth := filepath.Join(dir, runSummaryFileName)
+	if err := fileutil.WriteBytesToFile(pth, b); err != nil {
+		return "", err
+	}
+	return pth, nil
+}
+
+// inputValues returns the step inputs by their env key, based on the `env` tags of ConfigsModel.
+func (configs ConfigsModel) inputValues() map[string]string {
+	inputs := map[string]string{}
+
+	value := reflect.ValueOf(configs)
+	for i := 0; i < value.NumField(); i++ {
+		key := value.Type().Field(i).Tag.Get("env")
+		if key == "" {
+			continue
+		}
+		if field := value.Field(i); field.Kind() == reflect.String {
+			inputs[key] = field.String()
+		}
+	}
+	return inputs
+}
+
+var deployDirPath string
+
+// deployDir returns BITRISE_DEPLOY_DIR, or a temporary dir if it is not set.
+func deployDir() string {
+	if deployDirPath != "" {
+		return deployDirPath
+	}
+
+	if dir := os.Getenv("BITRISE_DEPLOY_DIR"); dir != "" {
+		deployDirPath = dir
+		return deployDirPath
+	}
+
+	dir, err := pathutil.NormalizedOSTempDirPath("_calabash_deploy_")
+	if err != nil {
+		log.Warnf("Failed to create tmp dir, error: %s", err)
+		dir = os.TempDir()
+	}
+	log.Warnf("BITRISE_DEPLOY_DIR is not set, using: %s", dir)
+
+	deployDirPath = dir
+	return deployDirPath
+}
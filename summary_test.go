@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestRunSummaryJSON(t *testing.T) {
+	summary := NewRunSummary()
+	summary.StepVersion = "1.2.3"
+	summary.SetInputs(map[string]string{"simulator_device": "iPhone 8", "metrics_webhook_url": "https://example.com/hook?token=abc"})
+	summary.Simulator = SimulatorSummaryModel{Name: "iPhone 8", UDID: "4444", Runtime: "iOS 12.1"}
+	summary.FailedScenarios = append(summary.FailedScenarios, FailedScenarioSummaryModel{Feature: "Login", Name: "Valid login", Location: "features/login.feature:3"})
+	summary.ExitCode = 1
+
+	content, err := summary.MarshalIndented()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var fields map[string]interface{}
+	if err := json.Unmarshal(content, &fields); err != nil {
+		t.Fatal(err)
+	}
+
+	if fields["format_version"] != runSummaryFormatVersion {
+		t.Errorf("format_version: %v, expected: %s", fields["format_version"], runSummaryFormatVersion)
+	}
+	// the consumers iterate the lists, an empty list is [] not null
+	for _, key := range []string{"phases", "features", "tags", "pending_scenarios", "undefined_scenarios"} {
+		if list, ok := fields[key].([]interface{}); !ok || len(list) != 0 {
+			t.Errorf("%s: %v, expected: []", key, fields[key])
+		}
+	}
+	// the optional sections are left out
+	for _, key := range []string{"test_run_name", "scenario_selection", "languages", "devices", "failure_classification", "temp_dir"} {
+		if _, ok := fields[key]; ok {
+			t.Errorf("%s is set: %v, expected to be omitted", key, fields[key])
+		}
+	}
+
+	inputs := fields["inputs"].(map[string]interface{})
+	if inputs["metrics_webhook_url"] != maskedValue {
+		t.Errorf("secret input is not masked: %v", inputs["metrics_webhook_url"])
+	}
+
+	var decoded RunSummaryModel
+	if err := json.Unmarshal(content, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(&decoded, summary) {
+		t.Errorf("decoded summary:\n%+v\nexpected:\n%+v", decoded, *summary)
+	}
+}
+
+func TestRunSummaryWriteToDir(t *testing.T) {
+	dir := t.TempDir()
+
+	pth, err := NewRunSummary().WriteToDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pth != filepath.Join(dir, runSummaryFileName) {
+		t.Errorf("path: %s, expected: %s", pth, filepath.Join(dir, runSummaryFileName))
+	}
+
+	content, err := os.ReadFile(pth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded RunSummaryModel
+	if err := json.Unmarshal(content, &decoded); err != nil {
+		t.Fatalf("invalid summary: %s", err)
+	}
+	if decoded.Retry.Attempts != 1 {
+		t.Errorf("attempts: %d, expected: 1", decoded.Retry.Attempts)
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/fileutil"
+	"github.com/bitrise-io/go-utils/log"
+)
+
+const (
+	tagCountsFileName      = "tag_counts.json"
+	tagCountsPathOutputKey = "BITRISE_CALABASH_TAG_COUNTS_PATH"
+)
+
+var tagOutputKeyInvalidCharExp = regexp.MustCompile(`[^A-Za-z0-9_]`)
+
+// TagSummaryModel is the number of the executed (not skipped), passed and failed scenarios tagged with the tag,
+// the tags of the scenario's feature count as the scenario's tags.
+type TagSummaryModel struct {
+	Tag      string `json:"tag"`
+	Executed int    `json:"executed"`
+	Passed   int    `json:"passed"`
+	Failed   int    `json:"failed"`
+}
+
+// TagCountsModel is the content of tag_counts.json.
+type TagCountsModel struct {
+	Tags []TagSummaryModel `json:"tags"`
+}
+
+// normalizeTag returns the tag with its leading @, like @critical.
+func normalizeTag(tag string) string {
+	return "@" + strings.TrimPrefix(tag, "@")
+}
+
+// tagOutputKeyFragment returns the tag as an output key fragment: the leading @ is removed,
+// the chars not allowed in an env key are replaced by _, for example @smoke-iOS.13 is smoke_iOS_13.
+func tagOutputKeyFragment(tag string) string {
+	fragment := tagOutputKeyInvalidCharExp.ReplaceAllString(strings.TrimPrefix(tag, "@"), "_")
+	return strings.Trim(repeatedUnderscoresExp.ReplaceAllString(fragment, "_"), "_")
+}
+
+// tagOutputKey returns the output key of a per-tag count, for example BITRISE_CALABASH_TAG_critical_PASSED.
+func tagOutputKey(tag, suffix string) string {
+	return "BITRISE_CALABASH_TAG_" + tagOutputKeyFragment(tag) + "_" + suffix
+}
+
+// parseReportTags parses the tags, one per line, empty lines are skipped, the leading @ is optional.
+// Two tags with the same output key fragment, like @smoke-ios and @smoke.ios, are rejected.
+func parseReportTags(value string) ([]string, error) {
+	tags := []string{}
+	fragments := map[string]string{}
+	for i, line := range strings.Split(value, "\n") {
+		tag := strings.TrimSpace(line)
+		if tag == "" {
+			continue
+		}
+		tag = normalizeTag(tag)
+
+		if strings.ContainsAny(tag, " \t") {
+			return nil, fmt.Errorf("invalid ReportTags line %d (%s), should be a single tag, like @critical", i+1, tag)
+		}
+		if indexInStringSlice(tag, tags) != -1 {
+			return nil, fmt.Errorf("invalid ReportTags line %d (%s), duplicated tag", i+1, tag)
+		}
+
+		fragment := tagOutputKeyFragment(tag)
+		if fragment == "" {
+			return nil, fmt.Errorf("invalid ReportTags line %d (%s), contains no letter or digit", i+1, tag)
+		}
+		if other, ok := fragments[fragment]; ok {
+			return nil, fmt.Errorf("invalid ReportTags line %d (%s), exported under the same key as %s (%s)", i+1, tag, other, tagOutputKey(tag, "PASSED"))
+		}
+		fragments[fragment] = tag
+
+		tags = append(tags, tag)
+	}
+	return tags, nil
+}
+
+// countTags returns the scenario counts of every tag of the scenarios, sorted by the tag,
+// a scenario tagged with a tag more than once (on the feature and on the scenario) is counted once for the tag.
+func countTags(scenarios []ScenarioResultModel) []TagSummaryModel {
+	countsByTag := map[string]*TagSummaryModel{}
+	for _, scenario := range scenarios {
+		if scenario.Status == statusSkipped {
+			continue
+		}
+
+		counted := map[string]bool{}
+		for _, tag := range scenario.Tags {
+			if counted[tag] {
+				continue
+			}
+			counted[tag] = true
+
+			counts, ok := countsByTag[tag]
+			if !ok {
+				counts = &TagSummaryModel{Tag: tag}
+				countsByTag[tag] = counts
+			}
+
+			counts.Executed++
+			switch scenario.Status {
+			case statusPassed:
+				counts.Passed++
+			case statusFailed:
+				counts.Failed++
+			}
+		}
+	}
+
+	tags := []TagSummaryModel{}
+	for _, counts := range countsByTag {
+		tags = append(tags, *counts)
+	}
+	sort.Slice(tags, func(i, j int) bool { return tags[i].Tag < tags[j].Tag })
+	return tags
+}
+
+// tagCounts returns the counts of the tag, zero counts if no executed scenario is tagged with it.
+func tagCounts(tags []TagSummaryModel, tag string) TagSummaryModel {
+	for _, counts := range tags {
+		if counts.Tag == tag {
+			return counts
+		}
+	}
+	return TagSummaryModel{Tag: tag}
+}
+
+// exportTagCounts writes the counts of every tag into the summary dir (tag_counts.json),
+// and exports the counts of the report tags, like BITRISE_CALABASH_TAG_critical_PASSED.
+func exportTagCounts(reportTags []string, tags []TagSummaryModel) {
+	b, err := json.MarshalIndent(TagCountsModel{Tags: tags}, "", "  ")
+	if err != nil {
+		log.Warnf("Failed to serialize tag counts, error: %s", err)
+	} else {
+		pth := filepath.Join(resultsSubdir(resultsSummaryDirName), tagCountsFileName)
+		if err := fileutil.WriteBytesToFile(pth, b); err != nil {
+			log.Warnf("Failed to write tag counts, error: %s", err)
+		} else {
+			exportOutput(tagCountsPathOutputKey, pth)
+		}
+	}
+
+	if len(reportTags) == 0 {
+		return
+	}
+
+	fmt.Println()
+	log.Infof("Tag counts:")
+	for _, tag := range reportTags {
+		counts := tagCounts(tags, tag)
+		log.Printf("- %s: %d executed, %d passed, %d failed", tag, counts.Executed, counts.Passed, counts.Failed)
+
+		exportOutput(tagOutputKey(tag, "EXECUTED"), fmt.Sprintf("%d", counts.Executed))
+		exportOutput(tagOutputKey(tag, "PASSED"), fmt.Sprintf("%d", counts.Passed))
+		exportOutput(tagOutputKey(tag, "FAILED"), fmt.Sprintf("%d", counts.Failed))
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/bitrise-io/go-utils/command"
+	"github.com/bitrise-io/go-utils/fileutil"
+	"github.com/bitrise-io/go-utils/log"
+	"github.com/bitrise-io/go-utils/pathutil"
+)
+
+const (
+	testReportsDeployDirEnvKey = "BITRISE_TEST_DEPLOY_DIR"
+	testReportInfoFileName     = "test_info.json"
+)
+
+// TestReportInfoModel is the test_info.json of a test run exported for the Test Reports add-on.
+type TestReportInfoModel struct {
+	Name string `json:"test-name"`
+}
+
+// exportTestReport exports the junit reports of the current run with its screenshots to the Test Reports add-on,
+// if it is enabled (BITRISE_TEST_DEPLOY_DIR is set) and the additional options contain a junit formatter.
+func (ctx *StepContext) exportTestReport() {
+	deployDir := os.Getenv(testReportsDeployDirEnvKey)
+	if deployDir == "" {
+		return
+	}
+
+	var reports []string
+	for _, pth := range ctx.cucumberFormatterOutPaths("junit") {
+		if exist, err := pathutil.IsPathExists(pth); err == nil && exist {
+			reports = append(reports, pth)
+		}
+	}
+	if len(reports) == 0 {
+		return
+	}
+
+	fmt.Println()
+	log.Infof("Exporting test report...")
+
+	name := ctx.testRunName()
+
+	dirName := "calabash_" + resultsDirNameUnsafeCharsExp.ReplaceAllString(ctx.runResultsPath(), "_")
+	if ctx.Configs.TestRunName != "" {
+		// the reports of several steps in the workflow must not overwrite each other
+		dirName = testRunFileName(name) + "_" + resultsDirNameUnsafeCharsExp.ReplaceAllString(ctx.runResultsPath(), "_")
+	}
+	dir := filepath.Join(deployDir, dirName)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		log.Warnf("Failed to create test report dir (%s), error: %s", dir, err)
+		return
+	}
+
+	for _, report := range reports {
+		var err error
+		if isDir, _ := pathutil.IsDirExists(report); isDir {
+			err = command.CopyDir(report, dir, true)
+		} else {
+			err = command.CopyFile(report, filepath.Join(dir, filepath.Base(report)))
+		}
+		if err != nil {
+			log.Warnf("Failed to copy junit report (%s), error: %s", report, err)
+		}
+	}
+	if err := prefixJUnitReports(dir, name); err != nil {
+		log.Warnf("Failed to set the test run name in the junit reports, error: %s", err)
+	}
+
+	// the add-on shows the files next to the junit report as the attachments of the run
+	if count, err := countScreenshots(ctx.screenshotsDir()); err == nil && count > 0 {
+		if err := command.CopyDir(ctx.screenshotsDir(), dir, true); err != nil {
+			log.Warnf("Failed to copy screenshots, error: %s", err)
+		}
+	}
+
+	info, err := json.Marshal(TestReportInfoModel{Name: name})
+	if err == nil {
+		err = fileutil.WriteBytesToFile(filepath.Join(dir, testReportInfoFileName), info)
+	}
+	if err != nil {
+		log.Warnf("Failed to write %s, error: %s", testReportInfoFileName, err)
+		return
+	}
+
+	log.Donef("Test report exported to: %s", dir)
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/fileutil"
+)
+
+const testRunNameOutputKey = "BITRISE_CALABASH_TEST_RUN_NAME"
+
+// junitTestsuiteNameExp matches the start of a junit testsuite's name attribute, like `<testsuite failures="1" name="`.
+var junitTestsuiteNameExp = regexp.MustCompile(`<testsuite\b[^>]*?\sname="`)
+
+var xmlAttributeReplacer = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", `"`, "&quot;")
+
+// testRunName returns the name of the test run in the exported test reports and the summaries,
+// the test_run_name input or `<simulator device> <resolved OS> calabash`, like `iPhone 6 iOS 11.4 calabash`.
+func (ctx *StepContext) testRunName() string {
+	if name := strings.TrimSpace(ctx.Configs.TestRunName); name != "" {
+		return name
+	}
+	return strings.Join(strings.Fields(fmt.Sprintf("%s %s calabash", ctx.Simulator.Name, ctx.SimulatorOsVersion)), " ")
+}
+
+// testRunFileName returns the test run name usable in file and dir names, like `iPhone-6-iOS-11.4-calabash`.
+func testRunFileName(name string) string {
+	return strings.Trim(resultsDirNameUnsafeCharsExp.ReplaceAllString(name, "-"), "-")
+}
+
+// prefixJUnitTestsuiteNames prefixes the name of the junit report's testsuites with the test run name.
+func prefixJUnitTestsuiteNames(content, name string) string {
+	prefix := xmlAttributeReplacer.Replace(name) + " - "
+	return junitTestsuiteNameExp.ReplaceAllStringFunc(content, func(match string) string {
+		return match + prefix
+	})
+}
+
+// prefixJUnitReports prefixes the testsuite names of the junit reports (xml files) in the dir with the test run name.
+func prefixJUnitReports(dir, name string) error {
+	return filepath.Walk(dir, func(pth string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || filepath.Ext(pth) != ".xml" {
+			return nil
+		}
+
+		content, err := fileutil.ReadStringFromFile(pth)
+		if err != nil {
+			return err
+		}
+		return fileutil.WriteStringToFile(pth, prefixJUnitTestsuiteNames(content, name))
+	})
+}
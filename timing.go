@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/bitrise-io/go-utils/log"
+)
+
+const (
+	phaseValidation        = "config/validation"
+	phaseSimulator         = "simulator resolution + preparation"
+	phaseAppPreflight      = "app preflight"
+	phaseDependencyInstall = "gem/bundler install"
+	phaseCucumber          = "cucumber run"
+	phaseReportExport      = "report export"
+	phaseRetryTeardown     = "retry teardown"
+	phaseCleanup           = "cleanup"
+	phaseDiagnostics       = "diagnostics packaging"
+)
+
+// PhaseTimingModel ...
+type PhaseTimingModel struct {
+	Name     string
+	Duration time.Duration
+}
+
+// PhaseTimer measures the duration of the step's consecutive phases.
+type PhaseTimer struct {
+	lock      sync.Mutex
+	startTime time.Time
+
+	current      string
+	currentStart time.Time
+
+	phases []PhaseTimingModel
+}
+
+// NewPhaseTimer ...
+func NewPhaseTimer() *PhaseTimer {
+	return &PhaseTimer{startTime: time.Now()}
+}
+
+// Start closes the currently running phase (if any) and starts a new one.
+func (t *PhaseTimer) Start(name string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.stop()
+
+	t.current = name
+	t.currentStart = time.Now()
+}
+
+// Stop closes the currently running phase.
+func (t *PhaseTimer) Stop() {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.stop()
+}
+
+// Current returns the currently running phase and its duration so far.
+func (t *PhaseTimer) Current() (string, time.Duration) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if t.current == "" {
+		return "", 0
+	}
+	return t.current, time.Since(t.currentStart)
+}
+
+func (t *PhaseTimer) stop() {
+	if t.current == "" {
+		return
+	}
+
+	t.phases = append(t.phases, PhaseTimingModel{
+		Name:     t.current,
+		Duration: time.Since(t.currentStart),
+	})
+	t.current = ""
+}
+
+// Phases returns the finished phases, a phase started multiple times is reported with its summed duration.
+func (t *PhaseTimer) Phases() []PhaseTimingModel {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	phases := []PhaseTimingModel{}
+	indexByName := map[string]int{}
+	for _, phase := range t.phases {
+		if idx, ok := indexByName[phase.Name]; ok {
+			phases[idx].Duration += phase.Duration
+			continue
+		}
+		indexByName[phase.Name] = len(phases)
+		phases = append(phases, phase)
+	}
+	return phases
+}
+
+// Total ...
+func (t *PhaseTimer) Total() time.Duration {
+	return time.Since(t.startTime)
+}
+
+// PrintSummary ...
+func (t *PhaseTimer) PrintSummary() {
+	total := t.Total()
+
+	log.Infof("Phase timings:")
+	log.Printf("%-36s %12s %8s", "Phase", "Duration", "Percent")
+	for _, phase := range t.Phases() {
+		log.Printf("%-36s %12s %7.1f%%", phase.Name, roundDuration(phase.Duration), percentOf(phase.Duration, total))
+	}
+	log.Printf("%-36s %12s %7.1f%%", "total", roundDuration(total), 100.0)
+}
+
+type phaseTimingJSONModel struct {
+	Name       string `json:"name"`
+	DurationMs int64  `json:"duration_ms"`
+}
+
+type phaseTimingsJSONModel struct {
+	TotalMs int64                  `json:"total_ms"`
+	Phases  []phaseTimingJSONModel `json:"phases"`
+}
+
+// JSON returns the phase timings in the format of BITRISE_CALABASH_PHASE_TIMINGS.
+func (t *PhaseTimer) JSON() (string, error) {
+	model := phaseTimingsJSONModel{
+		TotalMs: t.Total().Milliseconds(),
+		Phases:  []phaseTimingJSONModel{},
+	}
+	for _, phase := range t.Phases() {
+		model.Phases = append(model.Phases, phaseTimingJSONModel{
+			Name:       phase.Name,
+			DurationMs: phase.Duration.Milliseconds(),
+		})
+	}
+
+	b, err := json.Marshal(model)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+func roundDuration(d time.Duration) time.Duration {
+	if d > time.Second {
+		return d.Round(100 * time.Millisecond)
+	}
+	return d.Round(time.Millisecond)
+}
+
+func percentOf(part, total time.Duration) float64 {
+	if total <= 0 {
+		return 0
+	}
+	return float64(part) / float64(total) * 100
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/log"
+)
+
+const wipResultOutputKey = "BITRISE_CALABASH_WIP_RESULT"
+
+// cucumberTagExpressionsVersion is the first cucumber version supporting the `not @wip` style tag expressions,
+// the older versions take the legacy `~@wip` style.
+const cucumberTagExpressionsVersion = "3.0.0"
+
+// parseWipTags parses the comma (or newline) separated wip tags, like `@wip, @flaky`.
+func parseWipTags(value string) ([]string, error) {
+	tags := []string{}
+	for _, tag := range strings.FieldsFunc(value, func(r rune) bool { return r == ',' || r == '\n' }) {
+		tag = strings.TrimSpace(tag)
+		if tag == "" {
+			continue
+		}
+		if !strings.HasPrefix(tag, "@") || len(tag) == 1 || strings.ContainsAny(tag, " \t()~") {
+			return nil, fmt.Errorf("invalid WipTags (%s), should be a comma separated list of tags, like @wip", value)
+		}
+		if indexInStringSlice(tag, tags) == -1 {
+			tags = append(tags, tag)
+		}
+	}
+	return tags, nil
+}
+
+// cucumberTagExpressionsSupported reports whether the cucumber version resolved before the run takes the `not @wip` style tag expressions,
+// an unknown version is expected to be a recent one.
+func cucumberTagExpressionsSupported() bool {
+	cucumberVersion := runSummary.Versions.Cucumber
+	if cucumberVersion == "" {
+		return true
+	}
+	supports, err := satisfiesGemRequirement(cucumberVersion, ">= "+cucumberTagExpressionsVersion)
+	return err != nil || supports
+}
+
+// wipExcludeArgs returns the --tags option excluding the wip tags from the main run, the wip scenarios run separately with --wip.
+func (ctx *StepContext) wipExcludeArgs() []string {
+	if len(ctx.WipTags) == 0 {
+		return nil
+	}
+
+	if !cucumberTagExpressionsSupported() {
+		// the legacy --tags options are and-ed
+		args := []string{}
+		for _, tag := range ctx.WipTags {
+			args = append(args, "--tags", "~"+tag)
+		}
+		return args
+	}
+
+	terms := []string{}
+	for _, tag := range ctx.WipTags {
+		terms = append(terms, "not "+tag)
+	}
+	return []string{"--tags", strings.Join(terms, " and ")}
+}
+
+// wipSelectArgs returns the options of the wip run: the scenarios of any wip tag, run with --wip, which fails the run if any of them passes.
+func (ctx *StepContext) wipSelectArgs() []string {
+	if !cucumberTagExpressionsSupported() {
+		return []string{"--wip", "--tags", strings.Join(ctx.WipTags, ",")}
+	}
+	return []string{"--wip", "--tags", strings.Join(ctx.WipTags, " or ")}
+}
+
+// runWipScenarios runs the wip scenarios with --wip after the main run: cucumber fails if any of them passes,
+// a wip scenario passing silently should lose its wip tag. The run's result is exported, its failure fails the step only
+// if fail_on_wip_violations is set. The wip run is skipped if the main run failed for an other reason than failed tests.
+func (ctx *StepContext) runWipScenarios(runErr error) error {
+	fmt.Println()
+	log.Infof("Running the wip scenarios (%s)...", strings.Join(ctx.WipTags, ", "))
+
+	if runErr != nil && failureCategoryOf(runErr) != categoryTestFailure {
+		log.Warnf("Skipping the wip scenarios, the tests failed with a %s failure", failureCategoryOf(runErr).Name)
+		exportOutput(wipResultOutputKey, testResultSkipped)
+		return nil
+	}
+
+	args, envs := ctx.cucumberCommandArgs()
+	envs = append(ctx.cucumberEnvs(), envs...)
+	args = append(args, ctx.cucumberColorArgs()...)
+	envs = append(envs, ctx.cucumberColorEnvs()...)
+	args = append(args, ctx.cucumberBannerArgs()...)
+	envs = append(envs, ctx.cucumberBannerEnvs()...)
+	args = append(args, ctx.wipSelectArgs()...)
+	args = append(args, excludePatternArgs(ctx.ExcludePatterns)...)
+	if ctx.FeaturesDir != "" {
+		args = append(args, ctx.featuresPath())
+	}
+
+	cmd, err := ctx.newCucumberCommand(args)
+	if err != nil {
+		return err
+	}
+	cmd.AppendEnvs(envs...)
+	cmd.SetDir(ctx.WorkDir)
+	cmd.SetStdout(stepLogger.Raw()).SetStderr(stepLogger.Raw())
+	printCommand(cmd)
+	fmt.Println()
+
+	if err := commandRecorder.Run(cmd); err != nil {
+		exportOutput(wipResultOutputKey, testResultFailed)
+
+		wipErr := newStepError(categoryTestFailure, "The wip run (%s) failed with exit code %d: a wip scenario passed (remove its wip tag if it is done), or the run itself failed",
+			strings.Join(ctx.WipTags, ", "), exitCodeOf(err))
+		if ctx.Configs.FailOnWipViolations == "yes" {
+			return wipErr
+		}
+		log.Warnf("%s, fail_on_wip_violations is not set", wipErr)
+		return nil
+	}
+
+	exportOutput(wipResultOutputKey, testResultSucceeded)
+	log.Donef("No wip scenario passed")
+	return nil
+}